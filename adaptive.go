@@ -0,0 +1,185 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// The default error rate above which the backend is unhealthy
+	defaultAdaptiveMaxErrorRate = 0.05
+
+	// The default time to evaluate the health of the backend over
+	defaultAdaptiveInterval = 10 * time.Second
+
+	// The default number of observations an interval needs to be evaluated
+	defaultAdaptiveMinObservations = 10
+
+	// The default factor the limit is tightened by for an unhealthy interval
+	defaultAdaptiveBackoff = 0.5
+
+	// The default amount the factor of the limit is relaxed by for a healthy
+	// interval
+	defaultAdaptiveRecovery = 0.1
+
+	// The default lowest factor the limit is tightened to
+	defaultAdaptiveMinFactor = 0.1
+)
+
+// An AdaptiveController tightens or relaxes the limit of the quotas of the
+// policies using it, see the AdaptiveController option, based on the health
+// of the backend. The requests served by the backend are fed to it with
+// Observe, e.g. by a handler wrapping the backend. The limit is scaled by a
+// factor, tightened multiplicatively for every interval the backend is
+// unhealthy and relaxed additively for every healthy interval, so the
+// throttle sheds load automatically while the backend struggles. Health is
+// observed per instance
+type AdaptiveController struct {
+	*sync.Mutex
+	options *AdaptiveOptions
+	// The factor the limit is scaled by
+	factor float64
+	// The start of the current interval and its observations
+	start    time.Time
+	requests int
+	failures int
+	latency  time.Duration
+}
+
+type AdaptiveOptions struct {
+	// The error rate of requests within an interval above which the backend
+	// is unhealthy
+	// defaults to 0.05
+	MaxErrorRate float64
+
+	// The average latency of requests within an interval above which the
+	// backend is unhealthy
+	// defaults to no latency target
+	MaxLatency time.Duration
+
+	// The time to evaluate the health of the backend over
+	// defaults to 10 seconds
+	Interval time.Duration
+
+	// The number of requests an interval needs to be evaluated. Intervals
+	// with fewer requests leave the limit unchanged
+	// defaults to 10
+	MinObservations int
+
+	// The factor the limit is tightened by for every unhealthy interval
+	// defaults to 0.5
+	Backoff float64
+
+	// The amount the factor of the limit is relaxed by for every healthy
+	// interval, until the limit of the quota is reached again
+	// defaults to 0.1
+	Recovery float64
+
+	// The lowest factor the limit of the quota is tightened to
+	// defaults to 0.1
+	MinFactor float64
+
+	// The clock to evaluate intervals with
+	// defaults to the system clock
+	Clock Clock
+}
+
+// Observe a request served by the backend, with the given latency and if it
+// failed, e.g. with a status code of 500 or above
+func (a *AdaptiveController) Observe(latency time.Duration, failed bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.evaluate(a.options.Clock.Now())
+	a.requests++
+	a.latency += latency
+	if failed {
+		a.failures++
+	}
+}
+
+// Get the factor the limit is currently scaled by, between the minimum
+// factor and 1
+func (a *AdaptiveController) Factor() float64 {
+	a.Lock()
+	defer a.Unlock()
+
+	a.evaluate(a.options.Clock.Now())
+	return a.factor
+}
+
+// Evaluate the intervals past at the given time, adjusting the factor
+func (a *AdaptiveController) evaluate(now time.Time) {
+	elapsed := now.Sub(a.start)
+	if elapsed < a.options.Interval && elapsed >= 0 {
+		return
+	}
+
+	if a.requests >= a.options.MinObservations {
+		if a.healthy() {
+			a.factor += a.options.Recovery
+		} else {
+			a.factor *= a.options.Backoff
+		}
+	}
+
+	if a.factor > 1 {
+		a.factor = 1
+	} else if a.factor < a.options.MinFactor {
+		a.factor = a.options.MinFactor
+	}
+
+	a.start = now
+	a.requests = 0
+	a.failures = 0
+	a.latency = 0
+}
+
+// Check if the backend was healthy within the current interval
+func (a *AdaptiveController) healthy() bool {
+	if float64(a.failures)/float64(a.requests) > a.options.MaxErrorRate {
+		return false
+	}
+
+	return a.options.MaxLatency == 0 || a.latency/time.Duration(a.requests) <= a.options.MaxLatency
+}
+
+// Returns a new adaptive controller with the given options, starting out
+// with the limit of the quota
+func NewAdaptiveController(options ...*AdaptiveOptions) *AdaptiveController {
+	o := &AdaptiveOptions{}
+	if len(options) != 0 {
+		*o = *options[0]
+	}
+	if o.MaxErrorRate == 0 {
+		o.MaxErrorRate = defaultAdaptiveMaxErrorRate
+	}
+	if o.Interval <= 0 {
+		o.Interval = defaultAdaptiveInterval
+	}
+	if o.MinObservations == 0 {
+		o.MinObservations = defaultAdaptiveMinObservations
+	}
+	if o.Backoff == 0 {
+		o.Backoff = defaultAdaptiveBackoff
+	}
+	if o.Recovery == 0 {
+		o.Recovery = defaultAdaptiveRecovery
+	}
+	if o.MinFactor == 0 {
+		o.MinFactor = defaultAdaptiveMinFactor
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+
+	return &AdaptiveController{
+		&sync.Mutex{},
+		o,
+		1,
+		o.Clock.Now(),
+		0,
+		0,
+		0,
+	}
+}
@@ -0,0 +1,90 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+func observe(a *AdaptiveController, requests int, failures int, latency time.Duration) {
+	for i := 0; i < requests; i++ {
+		a.Observe(latency, i < failures)
+	}
+}
+
+func TestAdaptiveControllerErrorRate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := NewAdaptiveController(&AdaptiveOptions{Interval: time.Second, Clock: clock})
+	expectSame(t, a.Factor(), float64(1))
+
+	observe(a, 20, 2, time.Millisecond)
+	clock.Advance(time.Second)
+	expectSame(t, a.Factor(), 0.5)
+
+	// further unhealthy intervals tighten down to the minimum factor
+	for i := 0; i < 5; i++ {
+		observe(a, 20, 2, time.Millisecond)
+		clock.Advance(time.Second)
+	}
+	expectSame(t, a.Factor(), 0.1)
+
+	// healthy intervals relax the limit
+	observe(a, 20, 1, time.Millisecond)
+	clock.Advance(time.Second)
+	expectSame(t, a.Factor() > 0.1, true)
+}
+
+func TestAdaptiveControllerLatency(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := NewAdaptiveController(&AdaptiveOptions{Interval: time.Second, MaxLatency: 100 * time.Millisecond, Clock: clock})
+
+	observe(a, 20, 0, 200*time.Millisecond)
+	clock.Advance(time.Second)
+	expectSame(t, a.Factor(), 0.5)
+
+	observe(a, 20, 0, 50*time.Millisecond)
+	clock.Advance(time.Second)
+	expectSame(t, a.Factor(), 0.6)
+}
+
+func TestAdaptiveControllerMinObservations(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := NewAdaptiveController(&AdaptiveOptions{Interval: time.Second, Clock: clock})
+
+	observe(a, 5, 5, time.Millisecond)
+	clock.Advance(time.Second)
+	expectSame(t, a.Factor(), float64(1))
+}
+
+func TestAdaptivePolicy(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := NewAdaptiveController(&AdaptiveOptions{Interval: time.Second, Clock: clock})
+	policy := Policy(&Quota{Limit: 10, Within: time.Hour}, &Options{
+		Clock:              clock,
+		AdaptiveController: a,
+	})
+
+	expectSame(t, serveMethod(policy, "GET").Header().Get("X-RateLimit-Limit"), "10")
+
+	observe(a, 20, 20, time.Millisecond)
+	clock.Advance(time.Second)
+	expectSame(t, serveMethod(policy, "GET").Header().Get("X-RateLimit-Limit"), "5")
+}
+
+func TestAdaptiveControllerHandler(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	a := NewAdaptiveController(&AdaptiveOptions{Interval: time.Second, Clock: clock})
+	m := martini.Classic()
+	m.Use(a.Handler)
+	m.Any("/test", func(resp http.ResponseWriter) {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	for i := 0; i < 10; i++ {
+		testResponses(t, m, &Expectation{StatusCode: http.StatusServiceUnavailable})
+	}
+	clock.Advance(time.Second)
+	expectSame(t, a.Factor(), 0.5)
+}
@@ -0,0 +1,204 @@
+package throttle
+
+import (
+	"time"
+)
+
+// The algorithm a quota counts accesses with
+type Algorithm int
+
+const (
+	// Accesses are counted in fixed time windows, starting at the first
+	// access of a requester or at boundaries with AlignToWindow. Requesters
+	// may make up to twice the limit around the end of a window
+	FixedWindow Algorithm = iota
+	// Every access is logged with its time, and the limit applies to the
+	// accesses within the time window before every access. Takes memory for
+	// every access within the window in the store
+	SlidingWindowLog
+	// Every requester has a bucket of tokens, refilled with the limit of the
+	// quota per time window and holding up to the burst of the quota. Every
+	// access takes tokens from the bucket, so requesters may exceed the
+	// steady rate briefly, up to the burst
+	TokenBucket
+	// Every requester has a bucket draining at the constant rate of the limit
+	// of the quota per time window, and holding up to the burst of the quota,
+	// one access without a burst. Every access fills the bucket, so accesses
+	// are admitted at the constant rate only, to protect backends needing
+	// smooth traffic
+	LeakyBucket
+)
+
+// The names of the algorithms
+var algorithmNames = map[Algorithm]string{
+	FixedWindow:      "fixed_window",
+	SlidingWindowLog: "sliding_window_log",
+	TokenBucket:      "token_bucket",
+	LeakyBucket:      "leaky_bucket",
+}
+
+// The name of the algorithm, e.g. "sliding_window_log"
+func (a Algorithm) String() string {
+	return algorithmNames[a]
+}
+
+// An access in the log of a count of the SlidingWindowLog algorithm
+type loggedAccess struct {
+	// The time of the access in Unix nanoseconds
+	At int64 `json:"at"`
+	// The cost of the access
+	Cost uint64 `json:"cost"`
+}
+
+// Check if the logged access is within the time window of the given duration
+// before the given time
+func (a loggedAccess) within(now time.Time, duration time.Duration) bool {
+	elapsed := time.Duration(now.UnixNano() - a.At)
+	return elapsed < duration && elapsed > -duration
+}
+
+// Check if the count logs its accesses, i.e. it is a count of the
+// SlidingWindowLog algorithm
+func (r *accessCount) logged() bool {
+	return len(r.Log) > 0
+}
+
+// Get the sum of the costs of the logged accesses within the time window
+// before the given time
+func (r *accessCount) loggedCount(now time.Time) uint64 {
+	count := uint64(0)
+	for _, a := range r.Log {
+		if a.within(now, r.Duration) {
+			count += a.Cost
+		}
+	}
+
+	return count
+}
+
+// Get the start of the time window of the count at the given time, the time
+// of the oldest access within the window for logged counts. The time window
+// resets with the end of the window started then
+func (r *accessCount) StartAt(now time.Time) time.Time {
+	for _, a := range r.Log {
+		if a.within(now, r.Duration) {
+			return time.Unix(0, a.At).UTC()
+		}
+	}
+
+	return r.Start
+}
+
+// Log an access of the given cost at the given time, dropping accesses
+// outside the time window before it
+func (r *accessCount) LogBy(now time.Time, cost uint64) {
+	log := make([]loggedAccess, 0, len(r.Log)+1)
+	r.Count = 0
+	for _, a := range r.Log {
+		if a.within(now, r.Duration) {
+			log = append(log, a)
+			r.Count += a.Cost
+		}
+	}
+
+	r.Log = append(log, loggedAccess{now.UnixNano(), cost})
+	r.Count += cost
+	r.Start = time.Unix(0, r.Log[0].At).UTC()
+}
+
+// Check if the count is a bucket of the TokenBucket or LeakyBucket algorithm.
+// Leaky buckets are counted as token buckets, the tokens taken being the
+// level of the bucket and the refill its drain
+func (r *accessCount) bucket() bool {
+	return r.Refill > 0
+}
+
+// Get the number of tokens taken from the bucket refilled by the given time
+func (r *accessCount) refilled(now time.Time) uint64 {
+	elapsed := now.Sub(r.Start)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	if refilled := uint64(elapsed / r.Refill); refilled < r.Count {
+		return refilled
+	}
+
+	return r.Count
+}
+
+// Take tokens of the given cost from the bucket at the given time, after
+// refilling it. The start moves on by the time of the tokens refilled, so
+// the time towards the next token is kept. A bucket started more than the
+// time to refill it after the given time is refilled entirely
+func (r *accessCount) TakeBy(now time.Time, cost uint64) {
+	refilled := r.refilled(now)
+	if !r.IsFreshAt(now) {
+		r.Count = 0
+		r.Start = now
+	} else {
+		r.Count -= refilled
+		r.Start = r.Start.Add(time.Duration(refilled) * r.Refill)
+	}
+
+	r.Count += cost
+}
+
+// Get the time at which the bucket of the given capacity has a token again,
+// or is full again if it has tokens left
+func (r *accessCount) refillAt(capacity uint64) time.Time {
+	if r.Count >= capacity {
+		return r.Start.Add(time.Duration(r.Count-capacity+1) * r.Refill)
+	}
+
+	return r.Start.Add(time.Duration(r.Count) * r.Refill)
+}
+
+// Get the number of tokens the bucket of the quota holds, its burst, or its
+// limit for token buckets and one for leaky buckets without a burst. The
+// capacity of the other algorithms is the limit and the max burst, the
+// limit only for quotas only counting accesses
+func (q *Quota) capacity() uint64 {
+	switch {
+	case q.Algorithm == TokenBucket && q.Burst > 0, q.Algorithm == LeakyBucket && q.Burst > 0:
+		return q.Burst
+	case q.Algorithm == LeakyBucket && q.Limit > 0:
+		return 1
+	case q.Algorithm == TokenBucket, q.Limit == 0:
+		return q.Limit
+	default:
+		return q.Limit + q.MaxBurst
+	}
+}
+
+// Get the time to refill a token of a bucket of the quota, 0 for quotas
+// using neither the TokenBucket nor the LeakyBucket algorithm or only
+// counting accesses
+func (q *Quota) refill() time.Duration {
+	if (q.Algorithm != TokenBucket && q.Algorithm != LeakyBucket) || q.Limit == 0 {
+		return 0
+	}
+
+	if refill := q.Within / time.Duration(q.Limit); refill > 0 {
+		return refill
+	}
+
+	return 1
+}
+
+// Count an access of the given cost at the given time with the algorithm of
+// the quota of the controller
+func (c *controller) count(counter *accessCount, now time.Time, cost uint64) {
+	switch c.quota.Algorithm {
+	case SlidingWindowLog:
+		counter.LogBy(now, cost)
+	case TokenBucket, LeakyBucket:
+		counter.Refill = c.quota.refill()
+		counter.Duration = time.Duration(c.quota.capacity()) * counter.Refill
+		counter.TakeBy(now, cost)
+	default:
+		c.rollOver(counter, now)
+		c.record(counter, now)
+		counter.IncrementBy(now, cost)
+	}
+}
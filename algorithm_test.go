@@ -0,0 +1,203 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLogBy(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	a := newAccessCount(time.Minute, start)
+
+	a.LogBy(start, 1)
+	a.LogBy(start.Add(30*time.Second), 2)
+	expectSame(t, a.GetCount(start.Add(59*time.Second)), uint64(3))
+	expectSame(t, a.StartAt(start.Add(59*time.Second)), start)
+
+	// the first access leaves the window
+	expectSame(t, a.GetCount(start.Add(time.Minute)), uint64(2))
+	expectSame(t, a.StartAt(start.Add(time.Minute)), start.Add(30*time.Second))
+	expectSame(t, a.IsFreshAt(start.Add(time.Minute)), true)
+	expectSame(t, a.IsFreshAt(start.Add(90*time.Second)), false)
+
+	// accesses outside the window are dropped when logging
+	a.LogBy(start.Add(61*time.Second), 1)
+	expectSame(t, len(a.Log), 2)
+	expectSame(t, a.Count, uint64(3))
+	expectSame(t, a.Start, start.Add(30*time.Second))
+}
+
+func TestSlidingWindowLogKeyId(t *testing.T) {
+	fixed := &Quota{Limit: 10, Within: time.Minute}
+	sliding := &Quota{Limit: 10, Within: time.Minute, Algorithm: SlidingWindowLog}
+
+	expectDifferent(t, HashedKeyId(fixed), HashedKeyId(sliding))
+	expectSame(t, SlidingWindowLog.String(), "sliding_window_log")
+}
+
+func testSlidingWindowLog(t *testing.T, store KeyValueStorer) {
+	start := time.Unix(1000, 0)
+	clock := &fakeClock{now: start}
+	policy := Policy(&Quota{Limit: 2, Within: time.Minute, Algorithm: SlidingWindowLog, AlignToWindow: true}, &Options{
+		Clock: clock,
+		Store: store,
+	})
+	serveAt := func(at time.Duration) *httptest.ResponseRecorder {
+		clock.now = start.Add(at)
+		return serveMethod(policy, "GET")
+	}
+
+	expectStatusCode(t, http.StatusOK, serveAt(50*time.Second).Code)
+	expectStatusCode(t, http.StatusOK, serveAt(55*time.Second).Code)
+
+	// no burst at the boundary of a fixed window
+	resp := serveAt(65 * time.Second)
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Reset"), strconv.FormatInt(start.Add(110*time.Second).Unix(), 10))
+
+	// every access leaves the window on its own
+	expectStatusCode(t, http.StatusOK, serveAt(110*time.Second).Code)
+	expectStatusCode(t, StatusTooManyRequests, serveAt(114*time.Second).Code)
+	resp = serveAt(115 * time.Second)
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	testSlidingWindowLog(t, NewMapStore(nil))
+}
+
+func TestSlidingWindowLogWithoutKeyValueCreator(t *testing.T) {
+	testSlidingWindowLog(t, &countingStore{KeyValueStorer: NewMapStore(nil)})
+}
+
+func TestTakeBy(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	a := newAccessCount(time.Minute, start)
+	a.Refill = 10 * time.Second
+	a.Duration = 30 * time.Second
+
+	a.TakeBy(start, 3)
+	expectSame(t, a.GetCount(start), uint64(3))
+	expectSame(t, a.IsFreshAt(start), true)
+
+	// a token is refilled every 10 seconds
+	expectSame(t, a.GetCount(start.Add(15*time.Second)), uint64(2))
+	expectSame(t, a.refillAt(3), start.Add(10*time.Second))
+	expectSame(t, a.refillAt(4), start.Add(30*time.Second))
+
+	// taking tokens keeps the time towards the next token
+	a.TakeBy(start.Add(15*time.Second), 1)
+	expectSame(t, a.Count, uint64(3))
+	expectSame(t, a.Start, start.Add(10*time.Second))
+	expectSame(t, a.GetCount(start.Add(20*time.Second)), uint64(2))
+
+	// a refilled bucket starts over
+	expectSame(t, a.IsFreshAt(start.Add(40*time.Second)), false)
+	expectSame(t, a.GetCount(start.Add(40*time.Second)), uint64(0))
+	a.TakeBy(start.Add(45*time.Second), 1)
+	expectSame(t, a.Count, uint64(1))
+	expectSame(t, a.Start, start.Add(45*time.Second))
+
+	// the bucket is not refilled while the clock is behind
+	expectSame(t, a.GetCount(start.Add(30*time.Second)), uint64(1))
+	expectSame(t, a.GetCount(start.Add(10*time.Second)), uint64(0))
+}
+
+func TestTokenBucketKeyId(t *testing.T) {
+	bucket := &Quota{Limit: 10, Within: time.Minute, Algorithm: TokenBucket}
+	burst := &Quota{Limit: 10, Within: time.Minute, Algorithm: TokenBucket, Burst: 20}
+
+	expectDifferent(t, HashedKeyId(bucket), HashedKeyId(burst))
+	expectSame(t, TokenBucket.String(), "token_bucket")
+	expectSame(t, bucket.capacity(), uint64(10))
+	expectSame(t, burst.capacity(), uint64(20))
+	expectSame(t, burst.refill(), 6*time.Second)
+	expectSame(t, (&Quota{Limit: 10, Within: time.Minute, Burst: 20}).capacity(), uint64(10))
+}
+
+func testTokenBucket(t *testing.T, store KeyValueStorer) {
+	start := time.Unix(1000, 0)
+	clock := &fakeClock{now: start}
+	policy := Policy(&Quota{Limit: 1, Within: 10 * time.Second, Algorithm: TokenBucket, Burst: 3}, &Options{
+		Clock:      clock,
+		Store:      store,
+		RetryAfter: true,
+	})
+	serveAt := func(at time.Duration) *httptest.ResponseRecorder {
+		clock.now = start.Add(at)
+		return serveMethod(policy, "GET")
+	}
+
+	// the burst is allowed at once
+	for i := 2; i >= 0; i-- {
+		resp := serveAt(0)
+		expectStatusCode(t, http.StatusOK, resp.Code)
+		expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "3")
+		expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), strconv.Itoa(i))
+	}
+
+	// then the steady rate
+	resp := serveAt(5 * time.Second)
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("Retry-After"), "5")
+	expectStatusCode(t, http.StatusOK, serveAt(10*time.Second).Code)
+	expectStatusCode(t, StatusTooManyRequests, serveAt(15*time.Second).Code)
+
+	// the bucket refills up to the burst
+	resp = serveAt(time.Hour)
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "2")
+}
+
+func TestTokenBucket(t *testing.T) {
+	testTokenBucket(t, NewMapStore(nil))
+}
+
+func TestTokenBucketWithoutKeyValueCreator(t *testing.T) {
+	testTokenBucket(t, &countingStore{KeyValueStorer: NewMapStore(nil)})
+}
+
+func TestLeakyBucketCapacity(t *testing.T) {
+	leaky := &Quota{Limit: 10, Within: time.Minute, Algorithm: LeakyBucket}
+
+	expectSame(t, LeakyBucket.String(), "leaky_bucket")
+	expectSame(t, leaky.capacity(), uint64(1))
+	expectSame(t, leaky.refill(), 6*time.Second)
+	expectSame(t, (&Quota{Limit: 10, Within: time.Minute, Algorithm: LeakyBucket, Burst: 3}).capacity(), uint64(3))
+	expectSame(t, (&Quota{Within: time.Minute, Algorithm: LeakyBucket}).capacity(), uint64(0))
+	expectDifferent(t, HashedKeyId(leaky), HashedKeyId(&Quota{Limit: 10, Within: time.Minute, Algorithm: TokenBucket}))
+}
+
+func TestLeakyBucket(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := &fakeClock{now: start}
+	policy := Policy(&Quota{Limit: 6, Within: time.Minute, Algorithm: LeakyBucket}, &Options{
+		Clock:      clock,
+		Store:      NewMapStore(nil),
+		RetryAfter: true,
+	})
+	serveAt := func(at time.Duration) *httptest.ResponseRecorder {
+		clock.now = start.Add(at)
+		return serveMethod(policy, "GET")
+	}
+
+	// one access every 10 seconds, without bursts
+	resp := serveAt(0)
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "1")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+
+	resp = serveAt(time.Second)
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("Retry-After"), "9")
+	expectStatusCode(t, http.StatusOK, serveAt(10*time.Second).Code)
+	expectStatusCode(t, StatusTooManyRequests, serveAt(19*time.Second).Code)
+
+	// an idle bucket does not allow a burst
+	expectStatusCode(t, http.StatusOK, serveAt(time.Hour).Code)
+	expectStatusCode(t, StatusTooManyRequests, serveAt(time.Hour).Code)
+}
@@ -0,0 +1,61 @@
+package throttle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// The replacement of identities which can not be truncated
+const redactedIdentity = "*"
+
+// Anonymize the given key or identity with the AnonymizeFunction, if any
+func (o *Options) anonymize(key string) string {
+	if o.AnonymizeFunction == nil || key == "" {
+		return key
+	}
+
+	return o.AnonymizeFunction(key)
+}
+
+// Returns an anonymize function replacing keys and identities by a keyed
+// hash of them, 16 hexadecimal characters of an HMAC-SHA256 with the given
+// secret. The same requester keeps the same hash, so requesters can still be
+// told apart, but not be recovered without the secret
+func HashIdentities(secret string) func(string) string {
+	return func(key string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(key))
+
+		return hex.EncodeToString(mac.Sum(nil)[:8])
+	}
+}
+
+// Returns an anonymize function truncating the IP address at the end of keys
+// and identities to its network, with the given prefix lengths for IPv4 and
+// IPv6, e.g. "throttle_<quota id>_1.2.3.0/24" for IPv4 networks of 24 bits.
+// Identities other than IP addresses are replaced by "*", keeping the
+// prefix of the key
+func TruncateIdentities(ipv4Bits int, ipv6Bits int) func(string) string {
+	return func(key string) string {
+		prefix, identity := "", key
+		if i := strings.LastIndex(key, keySeparator); i != -1 {
+			prefix, identity = key[:i+len(keySeparator)], key[i+len(keySeparator):]
+		}
+
+		ip := net.ParseIP(identity)
+		if ip == nil {
+			return prefix + redactedIdentity
+		}
+
+		if ipv4 := ip.To4(); ipv4 != nil {
+			network := &net.IPNet{IP: ipv4.Mask(net.CIDRMask(ipv4Bits, 32)), Mask: net.CIDRMask(ipv4Bits, 32)}
+			return prefix + network.String()
+		}
+
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6Bits, 128)), Mask: net.CIDRMask(ipv6Bits, 128)}
+		return prefix + network.String()
+	}
+}
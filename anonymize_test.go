@@ -0,0 +1,45 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHashIdentities(t *testing.T) {
+	hash := HashIdentities("secret")
+
+	expectSame(t, len(hash("1.2.3.4")), 16)
+	expectSame(t, hash("1.2.3.4"), hash("1.2.3.4"))
+	expectDifferent(t, hash("1.2.3.4"), hash("1.2.3.5"))
+	expectDifferent(t, hash("1.2.3.4"), HashIdentities("other")("1.2.3.4"))
+}
+
+func TestTruncateIdentities(t *testing.T) {
+	truncate := TruncateIdentities(24, 48)
+
+	expectSame(t, truncate("1.2.3.4"), "1.2.3.0/24")
+	expectSame(t, truncate("throttle_abc_1.2.3.4"), "throttle_abc_1.2.3.0/24")
+	expectSame(t, truncate("throttle_abc_2001:db8:1:2::1"), "throttle_abc_2001:db8:1::/48")
+	expectSame(t, truncate("throttle_abc_api-key"), "throttle_abc_*")
+	expectSame(t, truncate("api-key"), "*")
+}
+
+func TestAnonymizedEvents(t *testing.T) {
+	var events []*Event
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour, Name: "anonymized events"}, &Options{
+		Store:             NewMapStore(nil),
+		AnonymizeFunction: HashIdentities("secret"),
+		OnEvent: func(e *Event) {
+			events = append(events, e)
+		},
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+
+	expectSame(t, len(events), 2)
+	expectMatches(t, "_1\\.2\\.3\\.4$", events[1].PrivilegedKey())
+	expectSame(t, events[1].Key, HashIdentities("secret")(events[1].PrivilegedKey()))
+	expectSame(t, events[1].Remaining, uint64(0))
+}
@@ -0,0 +1,156 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// KeyValueBatchGetter is an optional interface for the Store Option
+// Stores with a round trip per operation, e.g. Redis, should implement it
+// together with KeyValueCreator, so that a policy reads all keys of a
+// requester in one round trip, and answers the reads for its decision and
+// headers from them. Only reads are batched, the access count is still
+// incremented and written with a round trip each
+type KeyValueBatchGetter interface {
+	// Get the values of the given keys. Keys which do not exist or have
+	// expired are missing from the result
+	GetBatch(keys []string) (map[string][]byte, error)
+}
+
+// A view on a store for a single request, answering reads from the values
+// read in one batch before, and keeping them up to date with the writes of
+// the request
+type batchStore struct {
+	sync.Mutex
+	store   KeyValueStorer
+	creator KeyValueCreator
+	values  map[string][]byte
+	fetched map[string]bool
+}
+
+// Get a key, from the batch if it was part of it
+func (s *batchStore) Get(key string) ([]byte, error) {
+	s.Lock()
+	value, ok := s.values[key]
+	fetched := s.fetched[key]
+	s.Unlock()
+
+	if ok {
+		return value, nil
+	} else if fetched {
+		return nil, MapStoreError("Key " + key + " does not exist")
+	}
+
+	return s.store.Get(key)
+}
+
+// Set a key
+func (s *batchStore) Set(key string, value []byte) error {
+	if err := s.store.Set(key, value); err != nil {
+		return err
+	}
+
+	s.remember(key, value)
+	return nil
+}
+
+// Get a key, or create it with the initial value. Always reads the store, as
+// the value in the batch misses the increments of other instances since
+// the batch was read, which the write of the incremented value would lose
+func (s *batchStore) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	value, created, err := s.creator.GetOrCreate(key, initial, ttl)
+	if err == nil {
+		s.remember(key, value)
+	}
+
+	return value, created, err
+}
+
+// A view on a store for a single request, able to update keys atomically
+type batchUpdaterStore struct {
+	*batchStore
+	updater KeyValueUpdater
+}
+
+// Update a key atomically, always in the store
+func (s *batchUpdaterStore) Update(key string, update func([]byte) ([]byte, error), ttl time.Duration) ([]byte, error) {
+	value, err := s.updater.Update(key, update, ttl)
+	if err == nil {
+		s.remember(key, value)
+	}
+
+	return value, err
+}
+
+// Remember the value of a key for reads later in the request
+func (s *batchStore) remember(key string, value []byte) {
+	s.Lock()
+	s.values[key] = value
+	s.fetched[key] = true
+	s.Unlock()
+}
+
+// Forget the values read in the batch, so later reads of the request read
+// the store again, e.g. after waiting for the quota to free up
+func (s *batchStore) forget() {
+	s.Lock()
+	s.values = map[string][]byte{}
+	s.fetched = map[string]bool{}
+	s.Unlock()
+}
+
+// Forget the values read in the batch of the store of the controller, if
+// its store is a view for a single request
+func (c *controller) forgetBatch() {
+	if s, ok := c.store.(*batchStore); ok {
+		s.forget()
+	}
+}
+
+// Return a view on the store of the controller for a single request, with
+// the given keys read in one batch. Returns the controller unchanged if the
+// store does not support batches or the batch failed
+func (c *controller) batched(keys ...string) *controller {
+	getter, ok := c.store.(KeyValueBatchGetter)
+	if !ok {
+		return c
+	}
+	creator, ok := c.store.(KeyValueCreator)
+	if !ok {
+		return c
+	}
+
+	values, err := getter.GetBatch(keys)
+	if err != nil {
+		return c
+	}
+
+	fetched := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		fetched[key] = true
+	}
+
+	batched := *c
+	store := &batchStore{
+		store:   c.store,
+		creator: creator,
+		values:  values,
+		fetched: fetched,
+	}
+	batched.store = store
+	if updater, ok := c.store.(KeyValueUpdater); ok {
+		batched.store = &batchUpdaterStore{store, updater}
+	}
+
+	return &batched
+}
+
+// Get the keys a policy reads for the given requester
+func (c *controller) requesterKeys(id string) []string {
+	keys := []string{id, c.sideKey(id, "frozen"), c.sideKey(id, "grant")}
+	if c.penalty != nil || c.tracking {
+		keys = append(keys, c.sideKey(id, "violations"))
+	}
+
+	return keys
+}
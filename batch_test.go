@@ -0,0 +1,194 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type roundTripStore struct {
+	*MapStore
+	sync.Mutex
+	roundTrips int
+	latency    time.Duration
+	batches    bool
+}
+
+func (s *roundTripStore) roundTrip() {
+	s.Lock()
+	s.roundTrips++
+	s.Unlock()
+	time.Sleep(s.latency)
+}
+
+func (s *roundTripStore) Get(key string) ([]byte, error) {
+	s.roundTrip()
+	return s.MapStore.Get(key)
+}
+
+func (s *roundTripStore) Set(key string, value []byte) error {
+	s.roundTrip()
+	return s.MapStore.Set(key, value)
+}
+
+func (s *roundTripStore) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	s.roundTrip()
+	return s.MapStore.GetOrCreate(key, initial, ttl)
+}
+
+func (s *roundTripStore) count() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.roundTrips
+}
+
+type batchRoundTripStore struct {
+	*roundTripStore
+}
+
+func (s *batchRoundTripStore) GetBatch(keys []string) (map[string][]byte, error) {
+	s.roundTrip()
+	values := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if value, err := s.MapStore.Get(key); err == nil {
+			values[key] = value
+		}
+	}
+
+	return values, nil
+}
+
+func newRoundTripStore(batches bool, latency time.Duration) KeyValueStorer {
+	store := &roundTripStore{MapStore: NewMapStore(accessCount{}), latency: latency}
+	if batches {
+		return &batchRoundTripStore{store}
+	}
+
+	return store
+}
+
+func roundTrips(store KeyValueStorer) int {
+	if batched, ok := store.(*batchRoundTripStore); ok {
+		return batched.count()
+	}
+
+	return store.(*roundTripStore).count()
+}
+
+func TestBatchedRoundTrips(t *testing.T) {
+	for _, e := range []struct {
+		batches    bool
+		roundTrips int
+	}{
+		{false, 23},
+		{true, 7},
+	} {
+		store := newRoundTripStore(e.batches, 0)
+		policy := Policy(&Quota{
+			Limit:  2,
+			Within: time.Hour,
+		}, &Options{
+			Store:         store,
+			PenaltyPolicy: &ExponentialPenalty{Ban: time.Minute},
+		})
+
+		for i, expected := range []int{http.StatusOK, http.StatusOK, StatusTooManyRequests} {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "1.2.3.4"
+			recorder := httptest.NewRecorder()
+			policy(recorder, req)
+
+			expectStatusCode(t, expected, recorder.Code)
+			expectSame(t, recorder.Header().Get("X-RateLimit-Remaining"), strconv.Itoa(1-i+i/2))
+		}
+
+		expectSame(t, roundTrips(store), e.roundTrips)
+	}
+}
+
+func TestBatchedIncrementReadsStore(t *testing.T) {
+	store := NewMapStore(accessCount{})
+	store.Set("KEY", []byte("1"))
+	batched := &batchStore{
+		store:   store,
+		creator: store,
+		values:  map[string][]byte{"KEY": []byte("1")},
+		fetched: map[string]bool{"KEY": true},
+	}
+
+	// another instance increments the count after the batch was read
+	store.Set("KEY", []byte("2"))
+
+	value, created, err := batched.GetOrCreate("KEY", []byte("0"), time.Hour)
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, created, false)
+	expectSame(t, string(value), "2")
+
+	value, _ = batched.Get("KEY")
+	expectSame(t, string(value), "2")
+}
+
+func TestBatchedShare(t *testing.T) {
+	for _, batches := range []bool{false, true} {
+		policy := Policy(&Quota{
+			Limit:  2,
+			Within: time.Hour,
+			Share:  0.5,
+		}, &Options{
+			Store: newRoundTripStore(batches, 0),
+		})
+
+		for _, e := range []struct {
+			remoteAddr string
+			status     int
+		}{
+			{"1.1.1.1", http.StatusOK},
+			{"1.1.1.1", http.StatusOK},
+			{"1.1.1.1", StatusTooManyRequests},
+			{"2.2.2.2", http.StatusOK},
+			{"2.2.2.2", http.StatusOK},
+			{"3.3.3.3", http.StatusOK},
+			{"3.3.3.3", http.StatusOK},
+			{"1.1.1.1", http.StatusOK},
+			{"1.1.1.1", StatusTooManyRequests},
+		} {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = e.remoteAddr
+			recorder := httptest.NewRecorder()
+			policy(recorder, req)
+
+			expectStatusCode(t, e.status, recorder.Code)
+		}
+	}
+}
+
+func benchmarkRoundTrips(b *testing.B, batches bool) {
+	store := newRoundTripStore(batches, 50*time.Microsecond)
+	policy := Policy(&Quota{
+		Limit:  uint64(b.N) + 1,
+		Within: time.Hour,
+	}, &Options{
+		Store: store,
+	})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policy(httptest.NewRecorder(), req)
+	}
+	b.ReportMetric(float64(roundTrips(store))/float64(b.N), "roundtrips/op")
+}
+
+func BenchmarkPolicyRoundTrips(b *testing.B) {
+	benchmarkRoundTrips(b, false)
+}
+
+func BenchmarkPolicyBatchedRoundTrips(b *testing.B) {
+	benchmarkRoundTrips(b, true)
+}
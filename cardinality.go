@@ -0,0 +1,110 @@
+package throttle
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A CardinalityGuard watches the number of distinct identities of a policy
+// within a time window. More identities than the threshold suggest spoofed
+// identities or a misconfigured identification function, and are reported
+// as an EventCardinalityExceeded, once per window. With a fallback, the
+// policy switches to the coarser identification until a window passes
+// without exceeding the threshold. Identities are counted per instance
+type CardinalityGuard struct {
+	// The number of distinct identities within the time window above which
+	// the guard trips, required
+	Threshold int
+
+	// The time window to count distinct identities in
+	// defaults to the time window of the quota of the policy
+	Within time.Duration
+
+	// The identification function to switch to while the guard is tripped,
+	// e.g. IdentifyBySubnet(24, 48)
+	// defaults to no switching
+	Fallback func(*http.Request) string
+}
+
+// The distinct identities of a policy within the current time window
+type cardinalityTracker struct {
+	*sync.Mutex
+	guard *CardinalityGuard
+	// The time window identities are counted in
+	within time.Duration
+	// The start of the current time window
+	start time.Time
+	// The distinct identities of the window, at most one above the threshold
+	identities map[string]bool
+	// If the threshold was exceeded in the current and the previous window
+	exceeded bool
+	previous bool
+}
+
+// Count the given identity at the given time. Returns if the guard is
+// tripped, and if the identity exceeded the threshold of the window
+func (t *cardinalityTracker) observe(identity string, now time.Time) (bool, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if elapsed := now.Sub(t.start); elapsed >= t.within || elapsed < 0 {
+		t.previous = t.exceeded && elapsed < 2*t.within && elapsed >= 0
+		t.exceeded = false
+		t.start = now
+		t.identities = make(map[string]bool)
+	}
+
+	if t.exceeded || t.identities[identity] {
+		return t.exceeded || t.previous, false
+	}
+
+	t.identities[identity] = true
+	if len(t.identities) <= t.guard.Threshold {
+		return t.previous, false
+	}
+
+	t.exceeded = true
+	return true, true
+}
+
+// Return a new tracker for the given guard, counting within the time window
+// of the guard or the given quota
+func newCardinalityTracker(guard *CardinalityGuard, quota *Quota) *cardinalityTracker {
+	within := guard.Within
+	if within <= 0 {
+		within = quota.Within
+	}
+
+	return &cardinalityTracker{
+		&sync.Mutex{},
+		guard,
+		within,
+		time.Time{},
+		make(map[string]bool),
+		false,
+		false,
+	}
+}
+
+// Count the identity of the request with the cardinality guard of the
+// policy, if any. Returns the identity of the fallback identification while
+// the guard is tripped and the given identity otherwise
+func (p *policy) guardCardinality(req *http.Request, identity string) string {
+	if p.cardinality == nil {
+		return identity
+	}
+
+	tripped, exceeded := p.cardinality.observe(identity, p.options.Clock.Now())
+	if exceeded {
+		p.emit(EventCardinalityExceeded, req, nil, "")
+	}
+
+	if fallback := p.options.CardinalityGuard.Fallback; tripped && fallback != nil {
+		if coarse := fallback(req); coarse != "" {
+			return coarse
+		}
+	}
+
+	return identity
+}
@@ -0,0 +1,73 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCardinalityTracker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	tracker := newCardinalityTracker(&CardinalityGuard{Threshold: 2}, &Quota{Within: time.Minute})
+	observe := func(identity string, at time.Duration) string {
+		tripped, exceeded := tracker.observe(identity, start.Add(at))
+		if exceeded {
+			return "exceeded"
+		} else if tripped {
+			return "tripped"
+		}
+		return ""
+	}
+
+	expectSame(t, observe("a", 0), "")
+	expectSame(t, observe("b", 0), "")
+	expectSame(t, observe("a", time.Second), "")
+	expectSame(t, observe("c", time.Second), "exceeded")
+	expectSame(t, observe("a", 2*time.Second), "tripped")
+
+	// the guard stays tripped for the next window
+	expectSame(t, observe("a", time.Minute), "tripped")
+	expectSame(t, observe("a", 2*time.Minute), "")
+
+	// and not after a silent window
+	observe("b", 2*time.Minute)
+	observe("c", 2*time.Minute)
+	expectSame(t, observe("d", 5*time.Minute), "")
+}
+
+func TestCardinalityGuard(t *testing.T) {
+	var events []EventType
+	policy := Policy(&Quota{Limit: 2, Within: time.Minute}, &Options{
+		Clock:                  &fakeClock{now: time.Unix(1000, 0)},
+		IdentificationFunction: IdentifyByHeader("X-API-Key"),
+		CardinalityGuard: &CardinalityGuard{
+			Threshold: 2,
+			Fallback:  IdentifyBySubnet(24, 48),
+		},
+		OnEvent: func(e *Event) {
+			events = append(events, e.Type)
+		},
+	})
+	serve := func(key string, remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-API-Key", key)
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder
+	}
+
+	expectSame(t, serve("a", "1.2.3.4").Header().Get("X-RateLimit-Remaining"), "1")
+	expectSame(t, serve("b", "1.2.3.5").Header().Get("X-RateLimit-Remaining"), "1")
+
+	// spoofed keys share the bucket of their subnet
+	expectSame(t, serve("c", "1.2.3.6").Header().Get("X-RateLimit-Remaining"), "1")
+	expectSame(t, serve("d", "1.2.3.7").Header().Get("X-RateLimit-Remaining"), "0")
+	expectStatusCode(t, StatusTooManyRequests, serve("a", "1.2.3.4").Code)
+	expectStatusCode(t, http.StatusOK, serve("e", "5.6.7.8").Code)
+
+	expectSame(t, len(events), 7)
+	expectSame(t, events[2], EventCardinalityExceeded)
+	expectSame(t, events[3], EventAllowed)
+}
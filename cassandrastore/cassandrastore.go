@@ -0,0 +1,168 @@
+// Package cassandrastore provides a throttle store for Cassandra and ScyllaDB.
+//
+// Values are kept in a table with a text key and a blob value, expiring via
+// Cassandra TTLs:
+//
+//	CREATE TABLE throttle (key text PRIMARY KEY, value blob)
+//
+// Cassandra counter columns can neither expire nor be set atomically with a
+// condition, so the value is the encoded access count of the throttle instead,
+// and new time windows are created with a lightweight transaction.
+package cassandrastore
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	// The default table name
+	defaultTable = "throttle"
+
+	// The default consistency for reads and writes
+	defaultConsistency = gocql.LocalQuorum
+
+	// The default consistency for lightweight transactions
+	defaultSerialConsistency = gocql.LocalSerial
+)
+
+// A Store keeps throttle state in a Cassandra or ScyllaDB table
+type Store struct {
+	session *gocql.Session
+	options *Options
+}
+
+type Options struct {
+	// The table to keep the values in
+	// defaults to "throttle"
+	Table string
+
+	// The time to live for keys created with Set, existing keys keep their
+	// remaining TTL
+	// defaults to no expiry
+	TTL time.Duration
+
+	// The consistency level for reads
+	// defaults to LOCAL_QUORUM
+	ReadConsistency gocql.Consistency
+
+	// The consistency level for writes
+	// defaults to LOCAL_QUORUM
+	WriteConsistency gocql.Consistency
+
+	// The serial consistency level for creating new time windows
+	// defaults to LOCAL_SERIAL
+	SerialConsistency gocql.SerialConsistency
+}
+
+// Get a key, will return an error if the key does not exist
+func (s *Store) Get(key string) ([]byte, error) {
+	var value []byte
+	err := s.session.Query(`SELECT value FROM `+s.options.Table+` WHERE key = ?`, key).
+		Consistency(s.options.ReadConsistency).
+		Scan(&value)
+
+	return value, err
+}
+
+// Set a key, keeping the remaining TTL of an existing key, so counts created
+// with GetOrCreate still expire with their time window, as every write sets
+// the TTL of the value anew in Cassandra. New keys expire after the
+// configured TTL
+func (s *Store) Set(key string, value []byte) error {
+	ttl := ttlSeconds(s.options.TTL)
+	var remaining *int
+	err := s.session.Query(`SELECT TTL(value) FROM `+s.options.Table+` WHERE key = ?`, key).
+		Consistency(s.options.ReadConsistency).
+		Scan(&remaining)
+	if err == nil && remaining != nil {
+		// at least a second, as a TTL of zero never expires
+		ttl = *remaining
+		if ttl < 1 {
+			ttl = 1
+		}
+	} else if err == nil {
+		ttl = 0
+	} else if err != gocql.ErrNotFound {
+		return err
+	}
+
+	if ttl == 0 {
+		return s.session.Query(`INSERT INTO `+s.options.Table+` (key, value) VALUES (?, ?)`, key, value).
+			Consistency(s.options.WriteConsistency).
+			Exec()
+	}
+
+	return s.session.Query(`INSERT INTO `+s.options.Table+` (key, value) VALUES (?, ?) USING TTL ?`, key, value, ttl).
+		Consistency(s.options.WriteConsistency).
+		Exec()
+}
+
+// Get a key, or set it to the initial value expiring after the given ttl if it
+// does not exist, in a lightweight transaction. Returns true if the initial value was set
+func (s *Store) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	var existingKey string
+	var existing []byte
+	applied, err := s.session.Query(`INSERT INTO `+s.options.Table+` (key, value) VALUES (?, ?) IF NOT EXISTS USING TTL ?`, key, initial, ttlSeconds(ttl)).
+		Consistency(s.options.WriteConsistency).
+		SerialConsistency(s.options.SerialConsistency).
+		ScanCAS(&existingKey, &existing)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if applied {
+		return initial, true, nil
+	}
+
+	return existing, false, nil
+}
+
+// Create the table if it does not exist yet
+func (s *Store) CreateTable() error {
+	return s.session.Query(`CREATE TABLE IF NOT EXISTS ` + s.options.Table + ` (key text PRIMARY KEY, value blob)`).Exec()
+}
+
+// Convert the given ttl to whole seconds, rounding up. Zero means no expiry
+func ttlSeconds(ttl time.Duration) int {
+	seconds := int(ttl / time.Second)
+	if ttl%time.Second > 0 {
+		seconds++
+	}
+
+	return seconds
+}
+
+// Returns a store using the given session
+func New(session *gocql.Session, options ...*Options) *Store {
+	o := &Options{
+		Table:             defaultTable,
+		ReadConsistency:   defaultConsistency,
+		WriteConsistency:  defaultConsistency,
+		SerialConsistency: defaultSerialConsistency,
+	}
+
+	if len(options) != 0 {
+		if options[0].Table != "" {
+			o.Table = options[0].Table
+		}
+		if options[0].TTL != 0 {
+			o.TTL = options[0].TTL
+		}
+		if options[0].ReadConsistency != gocql.Any {
+			o.ReadConsistency = options[0].ReadConsistency
+		}
+		if options[0].WriteConsistency != gocql.Any {
+			o.WriteConsistency = options[0].WriteConsistency
+		}
+		if options[0].SerialConsistency != 0 {
+			o.SerialConsistency = options[0].SerialConsistency
+		}
+	}
+
+	return &Store{
+		session,
+		o,
+	}
+}
@@ -0,0 +1,66 @@
+package cassandrastore
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestTTLSeconds(t *testing.T) {
+	if seconds := ttlSeconds(1500 * time.Millisecond); seconds != 2 {
+		t.Errorf("Expected 2 seconds, but got %d", seconds)
+	}
+	if seconds := ttlSeconds(0); seconds != 0 {
+		t.Errorf("Expected 0 seconds, but got %d", seconds)
+	}
+}
+
+// Runs against the cluster in CASSANDRA_HOSTS with an existing keyspace "throttle_test"
+func TestStore(t *testing.T) {
+	hosts := os.Getenv("CASSANDRA_HOSTS")
+	if hosts == "" {
+		t.Skip("CASSANDRA_HOSTS not set")
+	}
+
+	cluster := gocql.NewCluster(strings.Split(hosts, ",")...)
+	cluster.Keyspace = "throttle_test"
+	session, err := cluster.CreateSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	store := New(session, &Options{TTL: time.Minute, ReadConsistency: gocql.One, WriteConsistency: gocql.One})
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := "test_" + time.Now().Format(time.RFC3339Nano)
+	if _, created, err := store.GetOrCreate(key, []byte("1"), time.Minute); err != nil || !created {
+		t.Errorf("Expected the key to be created, but got %v, %v", created, err)
+	}
+	if value, created, err := store.GetOrCreate(key, []byte("2"), time.Minute); err != nil || created || string(value) != "1" {
+		t.Errorf("Expected the existing value 1, but got %q, %v, %v", value, created, err)
+	}
+	if err := store.Set(key, []byte("3")); err != nil {
+		t.Error(err)
+	}
+	if value, err := store.Get(key); err != nil || string(value) != "3" {
+		t.Errorf("Expected the value 3, but got %q, %v", value, err)
+	}
+
+	// setting a key keeps the ttl it was created with
+	windowKey := key + "_window"
+	store.GetOrCreate(windowKey, []byte("1"), 10*time.Second)
+	if err := store.Set(windowKey, []byte("2")); err != nil {
+		t.Error(err)
+	}
+	var remaining int
+	session.Query(`SELECT TTL(value) FROM throttle WHERE key = ?`, windowKey).Scan(&remaining)
+	if remaining < 1 || remaining > 10 {
+		t.Errorf("Expected the ttl of the window, but got %d", remaining)
+	}
+}
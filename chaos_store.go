@@ -0,0 +1,101 @@
+package throttle
+
+import "time"
+
+// A ChaosStore wraps any key value store and injects latency, errors and
+// corrupt payloads, to test how the throttle behaves when the store misbehaves
+type ChaosStore struct {
+	store   KeyValueStorer
+	options *ChaosStoreOptions
+}
+
+type ChaosStoreOptions struct {
+	// The latency to add to every operation
+	Latency time.Duration
+
+	// The maximum random latency to add on top of the latency
+	Jitter time.Duration
+
+	// The rate of operations failing with an error, between 0 and 1
+	ErrorRate float64
+
+	// The rate of reads returning a corrupt payload, between 0 and 1
+	CorruptionRate float64
+
+	// The random source to decide on jitter, errors and corruption with
+	// defaults to math/rand
+	Random Random
+}
+
+// Error Type for the chaos store
+type ChaosStoreError string
+
+// The Error for the Chaos Store
+func (err ChaosStoreError) Error() string {
+	return "Throttle Chaos Store Error: " + string(err)
+}
+
+// The payload returned for corrupt reads
+var corruptPayload = []byte("\x00corrupt\xff")
+
+// Set a key, may fail or be delayed
+func (s *ChaosStore) Set(key string, value []byte) error {
+	s.delay()
+	if s.happens(s.options.ErrorRate) {
+		return ChaosStoreError("Injected error on set of key " + key)
+	}
+
+	return s.store.Set(key, value)
+}
+
+// Get a key, may fail, return a corrupt payload or be delayed
+func (s *ChaosStore) Get(key string) ([]byte, error) {
+	s.delay()
+	if s.happens(s.options.ErrorRate) {
+		return nil, ChaosStoreError("Injected error on get of key " + key)
+	}
+
+	value, err := s.store.Get(key)
+	if err == nil && s.happens(s.options.CorruptionRate) {
+		return corruptPayload, nil
+	}
+
+	return value, err
+}
+
+// Sleep for the latency and a random jitter
+func (s *ChaosStore) delay() {
+	latency := s.options.Latency
+	if s.options.Jitter > 0 {
+		latency += time.Duration(s.options.Random.Int63n(int64(s.options.Jitter)))
+	}
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+}
+
+// Determine randomly if an event with the given rate happens
+func (s *ChaosStore) happens(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+
+	return s.options.Random.Float64() < rate
+}
+
+// Returns a chaos store wrapping the given store
+func NewChaosStore(store KeyValueStorer, options ...*ChaosStoreOptions) *ChaosStore {
+	o := &ChaosStoreOptions{}
+	if len(options) != 0 {
+		*o = *options[0]
+	}
+	if o.Random == nil {
+		o.Random = systemRandom{}
+	}
+
+	return &ChaosStore{
+		store,
+		o,
+	}
+}
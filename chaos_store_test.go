@@ -0,0 +1,69 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChaosStorePassesThrough(t *testing.T) {
+	store := NewChaosStore(NewMapStore(accessCount{}))
+	if err := store.Set("KEY", []byte("4")); err != nil {
+		t.Error(err)
+	}
+
+	value, err := store.Get("KEY")
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, string(value), "4")
+}
+
+func TestChaosStoreErrors(t *testing.T) {
+	store := NewChaosStore(NewMapStore(accessCount{}), &ChaosStoreOptions{
+		ErrorRate: 1,
+	})
+
+	err := store.Set("KEY", []byte("4"))
+	if err == nil {
+		t.Errorf("Expected an error on set, but got none")
+	} else {
+		expectSame(t, err.Error(), "Throttle Chaos Store Error: Injected error on set of key KEY")
+	}
+
+	if _, err := store.Get("KEY"); err == nil {
+		t.Errorf("Expected an error on get, but got none")
+	}
+}
+
+func TestChaosStoreCorruption(t *testing.T) {
+	mapStore := NewMapStore(accessCount{})
+	mapStore.Set("KEY", []byte("4"))
+	store := NewChaosStore(mapStore, &ChaosStoreOptions{
+		CorruptionRate: 1,
+	})
+
+	value, err := store.Get("KEY")
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, string(value), string(corruptPayload))
+
+	if _, err := store.Get("MISSING"); err == nil {
+		t.Errorf("Expected missing keys to stay missing")
+	}
+}
+
+func TestChaosStoreLatency(t *testing.T) {
+	store := NewChaosStore(NewMapStore(accessCount{}), &ChaosStoreOptions{
+		Latency: 5 * time.Millisecond,
+		Jitter:  5 * time.Millisecond,
+	})
+
+	start := time.Now()
+	store.Set("KEY", []byte("4"))
+	elapsed := time.Since(start)
+
+	if elapsed < 5*time.Millisecond {
+		t.Errorf("Expected a latency of at least 5ms, but was %v", elapsed)
+	}
+}
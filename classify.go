@@ -0,0 +1,129 @@
+package throttle
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The classes of requesters told apart by ClassifyUserAgent
+const (
+	// Requests from browsers
+	ClassBrowser = "browser"
+	// Requests from known crawlers, e.g. of search engines
+	ClassKnownBot = "known-bot"
+	// All other requests, e.g. from scripts and unknown bots
+	ClassUnknown = "unknown"
+)
+
+// The classes of requests told apart by ClassifyMethod
+const (
+	// Requests with safe methods, which do not change state
+	ClassRead = "read"
+	// Requests with all other methods
+	ClassWrite = "write"
+)
+
+// Tokens in the user agents of known crawlers, lower case
+var knownBots = []string{
+	"googlebot",
+	"bingbot",
+	"duckduckbot",
+	"yandexbot",
+	"baiduspider",
+	"applebot",
+	"slurp",
+	"facebookexternalhit",
+	"twitterbot",
+	"linkedinbot",
+}
+
+// Tokens in the user agents of unknown bots and scripts, lower case
+var unknownBots = []string{
+	"bot",
+	"crawler",
+	"spider",
+	"curl",
+	"wget",
+	"python",
+	"java/",
+	"go-http-client",
+}
+
+// A classify function telling browsers, known crawlers and all other
+// requesters apart by the User-Agent header. Returns ClassBrowser,
+// ClassKnownBot or ClassUnknown. The User-Agent header is easily forged,
+// so the quota of known crawlers should be capped as well
+func ClassifyUserAgent(req *http.Request) string {
+	userAgent := strings.ToLower(req.UserAgent())
+
+	for _, bot := range knownBots {
+		if strings.Contains(userAgent, bot) {
+			return ClassKnownBot
+		}
+	}
+
+	for _, bot := range unknownBots {
+		if strings.Contains(userAgent, bot) {
+			return ClassUnknown
+		}
+	}
+
+	if strings.HasPrefix(userAgent, "mozilla/") {
+		return ClassBrowser
+	}
+
+	return ClassUnknown
+}
+
+// Get the quota of the class of the request, or nil if the class has no quota
+func (p *policy) classQuota(req *http.Request) *Quota {
+	if p.options.ClassifyFunction == nil {
+		return nil
+	}
+
+	return p.options.Classes[p.options.ClassifyFunction(req)]
+}
+
+// A classify function telling reads and writes apart by the method of the
+// request. Returns ClassRead for the safe methods GET, HEAD, OPTIONS and
+// TRACE, and ClassWrite for all other methods
+func ClassifyMethod(req *http.Request) string {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return ClassRead
+	default:
+		return ClassWrite
+	}
+}
+
+// Return a copy of the quota named after the given class if it has no name,
+// so quotas of classes with the same limit and time window have their own
+// counters
+func classQuota(quota *Quota, class string) *Quota {
+	named := copyQuota(quota)
+	if named.Name == "" {
+		named.Name = class
+	}
+
+	return named
+}
+
+// Throttle reads and writes with a quota each, counted separately, as
+// classified by ClassifyMethod. The rate limit headers of a response are
+// those of the quota of its request, the X-RateLimit-Policy header names
+// the quota, "read" or "write" for quotas without a name. Takes the same
+// options as Policy, the classify function and classes are replaced
+func ReadWritePolicy(readQuota *Quota, writeQuota *Quota, options ...*Options) func(resp http.ResponseWriter, req *http.Request) {
+	o := newOptions(options)
+	if o.Disabled {
+		return func(resp http.ResponseWriter, req *http.Request) {}
+	}
+
+	o.ClassifyFunction = ClassifyMethod
+	o.Classes = map[string]*Quota{
+		ClassRead:  classQuota(readQuota, ClassRead),
+		ClassWrite: classQuota(writeQuota, ClassWrite),
+	}
+
+	return newLimiter(o.Classes[ClassWrite], o).ServeHTTP
+}
@@ -0,0 +1,110 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func classifyUserAgent(userAgent string) string {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("User-Agent", userAgent)
+
+	return ClassifyUserAgent(req)
+}
+
+func TestClassifyUserAgent(t *testing.T) {
+	expectSame(t, classifyUserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"), ClassBrowser)
+	expectSame(t, classifyUserAgent("Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"), ClassKnownBot)
+	expectSame(t, classifyUserAgent("Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)"), ClassKnownBot)
+	expectSame(t, classifyUserAgent("Mozilla/5.0 (compatible; SomeCrawler/1.0)"), ClassUnknown)
+	expectSame(t, classifyUserAgent("curl/8.4.0"), ClassUnknown)
+	expectSame(t, classifyUserAgent(""), ClassUnknown)
+}
+
+func TestClasses(t *testing.T) {
+	classes := map[string]*Quota{
+		ClassKnownBot: &Quota{Limit: 3, Within: time.Hour, Name: ClassKnownBot},
+		ClassUnknown:  &Quota{Limit: 1, Within: time.Hour, Name: ClassUnknown},
+	}
+	m := setupMartiniWithPolicy(2, time.Hour, &Options{
+		ClassifyFunction: ClassifyUserAgent,
+		Classes:          classes,
+	})
+	classes[ClassKnownBot].Limit = 100
+
+	googlebot := map[string]string{"User-Agent": "Mozilla/5.0 (compatible; Googlebot/2.1)"}
+	browser := map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0"}
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "3",
+		RateLimitRemaining: "2",
+		Headers:            googlebot,
+	}, &Expectation{ // Browsers have no class quota, the quota of the policy is used
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "1",
+		Headers:            browser,
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "1",
+		Headers:            googlebot,
+	})
+}
+
+func TestClassifyMethod(t *testing.T) {
+	for method, class := range map[string]string{
+		"GET":     ClassRead,
+		"HEAD":    ClassRead,
+		"OPTIONS": ClassRead,
+		"POST":    ClassWrite,
+		"PUT":     ClassWrite,
+		"PATCH":   ClassWrite,
+		"DELETE":  ClassWrite,
+	} {
+		req, _ := http.NewRequest(method, "/", nil)
+		expectSame(t, ClassifyMethod(req), class)
+	}
+}
+
+func TestReadWritePolicy(t *testing.T) {
+	policy := ReadWritePolicy(&Quota{Limit: 2, Within: time.Hour}, &Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store: NewMapStore(nil),
+	})
+
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "2")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "1")
+	expectSame(t, resp.Header().Get("X-RateLimit-Policy"), ClassRead)
+
+	resp = serveMethod(policy, "POST")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "1")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+	expectSame(t, resp.Header().Get("X-RateLimit-Policy"), ClassWrite)
+
+	// writes are denied, reads have their own bucket
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "DELETE").Code)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "HEAD").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+}
+
+func TestReadWritePolicySameQuotas(t *testing.T) {
+	quota := &Quota{Limit: 1, Within: time.Hour}
+	policy := ReadWritePolicy(quota, quota, &Options{
+		Store: NewMapStore(nil),
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "POST").Code)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "PUT").Code)
+	expectSame(t, quota.Name, "")
+}
@@ -0,0 +1,94 @@
+package throttle
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Codec is the interface for the Codec Option
+// It encodes values before they are written to the store, and decodes
+// them after they are read from the store
+type Codec interface {
+	// Encode the given value
+	Encode(v interface{}) ([]byte, error)
+	// Decode the given data into the given value
+	Decode(data []byte, v interface{}) error
+}
+
+// The JSONCodec encodes values as stringified JSON, the default codec
+type JSONCodec struct{}
+
+// Encode the given value as JSON
+func (c JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode the given JSON into the given value
+func (c JSONCodec) Decode(data []byte, v interface{}) error {
+	return json.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+}
+
+// The ZlibCodec compresses the values encoded by another codec with zlib,
+// trading CPU for memory or network in the store
+type ZlibCodec struct {
+	// The codec to compress the values of
+	// defaults to the JSONCodec
+	Codec Codec
+	// The compression level, see compress/zlib
+	// defaults to zlib.DefaultCompression
+	Level int
+}
+
+// Encode the given value and compress it
+func (c *ZlibCodec) Encode(v interface{}) ([]byte, error) {
+	encoded, err := c.codec().Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	level := c.Level
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+
+	var buffer bytes.Buffer
+	writer, err := zlib.NewWriterLevel(&buffer, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(encoded); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Decompress the given data and decode it into the given value
+func (c *ZlibCodec) Decode(data []byte, v interface{}) error {
+	reader, err := zlib.NewReader(bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	return c.codec().Decode(decompressed, v)
+}
+
+// The codec to compress the values of
+func (c *ZlibCodec) codec() Codec {
+	if c.Codec == nil {
+		return JSONCodec{}
+	}
+
+	return c.Codec
+}
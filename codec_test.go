@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testCodecRoundTrip(t *testing.T, codec Codec) []byte {
+	start := time.Now().UTC()
+	encoded, err := codec.Encode(&accessCount{3, start, time.Second, nil, 0, 0, nil})
+	if err != nil {
+		t.Error(err)
+	}
+
+	a := &accessCount{}
+	if err := codec.Decode(encoded, a); err != nil {
+		t.Error(err)
+	}
+
+	expectSame(t, a.Count, uint64(3))
+	expectSame(t, a.Start.Equal(start), true)
+	expectSame(t, a.Duration, time.Second)
+
+	return encoded
+}
+
+func TestJSONCodec(t *testing.T) {
+	encoded := testCodecRoundTrip(t, JSONCodec{})
+	expectMatches(t, `^\{"count":3,`, string(encoded))
+}
+
+func TestZlibCodec(t *testing.T) {
+	encoded := testCodecRoundTrip(t, &ZlibCodec{})
+	expectSame(t, encoded[0], byte(0x78))
+}
+
+func TestPolicyWithZlibCodec(t *testing.T) {
+	store := NewMapStore(accessCount{}, &MapStoreOptions{
+		CleaningPeriod: 5 * time.Millisecond,
+		Codec:          &ZlibCodec{},
+	})
+	m := setupMartiniWithPolicy(1, 10*time.Millisecond, &Options{
+		Store: store,
+		Codec: &ZlibCodec{},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+	}, &Expectation{ // The store is cleaned without failing to decode
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+		Wait:               15 * time.Millisecond,
+	})
+}
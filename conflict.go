@@ -0,0 +1,103 @@
+package throttle
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Error Type for policies sharing counters
+type ConflictError string
+
+// The Error for policies sharing counters
+func (err ConflictError) Error() string {
+	return "Throttle Conflict Error: " + string(err)
+}
+
+// The counters of a policy, shared by policies with the same store, key
+// prefix and quota id
+type policyCounters struct {
+	store   KeyValueStorer
+	prefix  string
+	quotaId string
+}
+
+// The registry of the counters of all policies, to detect policies sharing
+// counters by accident, e.g. two stacked policies with quotas of the same
+// limit and time window but no name
+type policyRegistry struct {
+	*sync.Mutex
+	policies map[policyCounters]string
+}
+
+// The registry of all policies
+var policies = &policyRegistry{
+	&sync.Mutex{},
+	make(map[policyCounters]string),
+}
+
+// Register the counters of the given policy. Returns a ConflictError if a
+// policy created before uses the same counters. Policies with stores which
+// can not be compared are not registered
+func (r *policyRegistry) register(p *policy) error {
+	o := p.options
+	if !reflect.TypeOf(o.Store).Comparable() {
+		return nil
+	}
+
+	counters := policyCounters{o.Store, p.prefix, o.KeyIdFunction(p.controller.quota)}
+	name := p.name()
+
+	r.Lock()
+	defer r.Unlock()
+
+	if existing, ok := r.policies[counters]; ok {
+		return ConflictError("Policy " + name + " shares the counters of policy " + existing +
+			" with the key prefix " + counters.prefix + " and the quota id " + counters.quotaId +
+			", use a distinct quota name or key prefix, or the same policy")
+	}
+	r.policies[counters] = name
+
+	return nil
+}
+
+// Release the counters of the given policy, when it was replaced
+func (r *policyRegistry) release(p *policy) {
+	o := p.options
+	if !reflect.TypeOf(o.Store).Comparable() {
+		return
+	}
+
+	counters := policyCounters{o.Store, p.prefix, o.KeyIdFunction(p.controller.quota)}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.policies[counters] == p.name() {
+		delete(r.policies, counters)
+	}
+}
+
+// Get the name of the policy, or of its quota if the policy has no name
+func (p *policy) name() string {
+	if p.options.PolicyName != "" {
+		return p.options.PolicyName
+	} else if p.controller.quota.Name != "" {
+		return p.controller.quota.Name
+	}
+
+	return "without a name"
+}
+
+// Register the counters of the policy, passing conflicts with policies
+// created before to the OnConflict hook, or logging them without a hook
+func (p *policy) register() *policy {
+	if err := policies.register(p); err != nil {
+		if p.options.OnConflict != nil {
+			p.options.OnConflict(err)
+		} else {
+			p.options.Logger.Printf("%v", err)
+		}
+	}
+
+	return p
+}
@@ -0,0 +1,76 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConflictingPolicies(t *testing.T) {
+	store := NewMapStore(nil)
+	var conflicts []error
+	options := &Options{
+		Store: store,
+		OnConflict: func(err error) {
+			conflicts = append(conflicts, err)
+		},
+	}
+
+	Policy(&Quota{Limit: 10, Within: time.Minute, Name: "minutely"}, options)
+	Policy(&Quota{Limit: 1000, Within: time.Hour}, options)
+	expectSame(t, len(conflicts), 0)
+
+	// a stacked policy with the same quota shares the counters
+	Policy(&Quota{Limit: 10, Within: time.Minute, Name: "minutely"}, options)
+	expectSame(t, len(conflicts), 1)
+	expectMatches(t, "^Throttle Conflict Error: Policy minutely shares the counters of policy minutely with the key prefix throttle", conflicts[0].Error())
+
+	// policies with a distinct prefix, or other stores, do not
+	Policy(&Quota{Limit: 10, Within: time.Minute, Name: "minutely"}, &Options{
+		Store:      store,
+		KeyPrefix:  "other",
+		OnConflict: options.OnConflict,
+	})
+	Policy(&Quota{Limit: 10, Within: time.Minute, Name: "minutely"}, &Options{
+		OnConflict: options.OnConflict,
+	})
+	expectSame(t, len(conflicts), 1)
+}
+
+// A store which can not be compared
+type incomparableStore struct {
+	*MapStore
+	keys []string
+}
+
+func TestIncomparableStoresNotRegistered(t *testing.T) {
+	var conflicts []error
+	options := &Options{
+		Store: incomparableStore{NewMapStore(nil), nil},
+		OnConflict: func(err error) {
+			conflicts = append(conflicts, err)
+		},
+	}
+
+	Policy(&Quota{Limit: 10, Within: time.Minute}, options)
+	Policy(&Quota{Limit: 10, Within: time.Minute}, options)
+	expectSame(t, len(conflicts), 0)
+}
+
+func TestSwappedPoliciesReleased(t *testing.T) {
+	var conflicts []error
+	options := &Options{
+		Store: NewMapStore(nil),
+		OnConflict: func(err error) {
+			conflicts = append(conflicts, err)
+		},
+	}
+
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Minute}, options)
+	limiter.Swap(&Quota{Limit: 5, Within: time.Minute}, options)
+	limiter.Swap(&Quota{Limit: 5, Within: time.Hour}, options)
+	expectSame(t, len(conflicts), 0)
+
+	// the released counters may be used by other policies
+	Policy(&Quota{Limit: 10, Within: time.Minute}, options)
+	expectSame(t, len(conflicts), 0)
+}
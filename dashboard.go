@@ -0,0 +1,274 @@
+package throttle
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The default number of top consumers shown per policy
+	defaultDashboardTopConsumers = 10
+	// The default number of recent denials shown
+	defaultDashboardRecentDenials = 20
+)
+
+// A Consumer is a requester with its access count in the current time window
+type Consumer struct {
+	// The identity of the requester, prefixed by the name of the
+	// identification for identifications of the chain
+	ID string
+	// The number of accesses within the time window
+	Count uint64
+	// The identity of the requester before anonymization
+	privilegedID string
+}
+
+// Get the identity of the requester before it was anonymized by the
+// AnonymizeFunction option, see Event.PrivilegedKey
+func (c *Consumer) PrivilegedID() string {
+	return c.privilegedID
+}
+
+// Get the requesters with the most accesses within their current time
+// window for the quota of the policy, at most n. Identities are anonymized
+// by the AnonymizeFunction option. Only supported for policies storing their
+// counters in a MapStore, returns nil for other stores
+func (l *Limiter) TopConsumers(n int) []*Consumer {
+	p, _ := l.current()
+	store, ok := p.options.Store.(*MapStore)
+	if !ok {
+		return nil
+	}
+
+	c := p.controller
+	prefix := makeKey(p.prefix, p.options.KeyIdFunction(c.quota), "")
+	now := c.now()
+
+	var consumers []*Consumer
+	for _, e := range store.Export().Entries {
+		if !strings.HasPrefix(e.Key, prefix) {
+			continue
+		}
+
+		counter := &accessCount{}
+		if err := c.codec.Decode(e.Value, counter); err != nil {
+			continue
+		}
+
+		if count := counter.GetCount(now); count > 0 {
+			id := strings.TrimPrefix(e.Key, prefix)
+			consumers = append(consumers, &Consumer{p.options.anonymize(id), count, id})
+		}
+	}
+
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].Count == consumers[j].Count {
+			return consumers[i].privilegedID < consumers[j].privilegedID
+		}
+		return consumers[i].Count > consumers[j].Count
+	})
+
+	if len(consumers) > n {
+		consumers = consumers[:n]
+	}
+
+	return consumers
+}
+
+// A Dashboard is a minimal HTML page showing the policies added to it with
+// their top consumers, and the recent denials of the policies using its
+// OnEvent hook. Authentication is left to the handlers before the dashboard,
+// it should not be reachable publicly
+type Dashboard struct {
+	*sync.Mutex
+	options  *DashboardOptions
+	policies []*dashboardPolicy
+	denials  []*Event
+}
+
+type DashboardOptions struct {
+	// The number of top consumers shown per policy
+	// defaults to 10
+	TopConsumers int
+
+	// The number of recent denials shown
+	// defaults to 20
+	RecentDenials int
+
+	// If top consumers are shown with their identities before anonymization
+	// by the AnonymizeFunction option, for dashboards only reachable by
+	// those with the privilege to know requesters
+	// defaults to false
+	Privileged bool
+}
+
+// A policy shown on the dashboard
+type dashboardPolicy struct {
+	name    string
+	limiter *Limiter
+}
+
+// The data the dashboard is rendered with
+type dashboardData struct {
+	Time     time.Time
+	Policies []*dashboardPolicyData
+	Denials  []*Event
+}
+
+// The data of a policy shown on the dashboard
+type dashboardPolicyData struct {
+	Name         string
+	Limit        uint64
+	Within       time.Duration
+	Disabled     bool
+	TopConsumers []*Consumer
+}
+
+// Show the given limiter on the dashboard under the given name
+func (d *Dashboard) Add(name string, limiter *Limiter) {
+	d.Lock()
+	d.policies = append(d.policies, &dashboardPolicy{name, limiter})
+	d.Unlock()
+}
+
+// Record denied, banned, challenged, blocked and shed requests, to use as the
+// OnEvent option of the policies
+func (d *Dashboard) OnEvent(e *Event) {
+	if e.Type != EventDenied && e.Type != EventBanned && e.Type != EventChallenged && e.Type != EventBlocked && e.Type != EventShed {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.denials = append(d.denials, e)
+	if len(d.denials) > d.options.RecentDenials {
+		d.denials = d.denials[len(d.denials)-d.options.RecentDenials:]
+	}
+}
+
+// Render the dashboard
+func (d *Dashboard) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(resp, d.data()); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Get the data to render the dashboard with, the most recent denial first
+func (d *Dashboard) data() *dashboardData {
+	d.Lock()
+	policies := append([]*dashboardPolicy{}, d.policies...)
+	denials := make([]*Event, 0, len(d.denials))
+	for i := len(d.denials) - 1; i >= 0; i-- {
+		denials = append(denials, d.denials[i])
+	}
+	d.Unlock()
+
+	data := &dashboardData{
+		Time:    time.Now().UTC(),
+		Denials: denials,
+	}
+
+	for _, policy := range policies {
+		p, disabled := policy.limiter.current()
+		data.Policies = append(data.Policies, &dashboardPolicyData{
+			policy.name,
+			p.controller.quota.Limit,
+			p.controller.quota.Within,
+			disabled,
+			d.topConsumers(policy.limiter),
+		})
+	}
+
+	return data
+}
+
+// Get the top consumers of the given limiter to show, with their identities
+// before anonymization on privileged dashboards
+func (d *Dashboard) topConsumers(limiter *Limiter) []*Consumer {
+	consumers := limiter.TopConsumers(d.options.TopConsumers)
+	if d.options.Privileged {
+		for _, consumer := range consumers {
+			consumer.ID = consumer.privilegedID
+		}
+	}
+
+	return consumers
+}
+
+// Returns a new dashboard
+func NewDashboard(options ...*DashboardOptions) *Dashboard {
+	return &Dashboard{
+		&sync.Mutex{},
+		newDashboardOptions(options),
+		nil,
+		nil,
+	}
+}
+
+// Returns new dashboard options from defaults and given options
+func newDashboardOptions(options []*DashboardOptions) *DashboardOptions {
+	o := &DashboardOptions{
+		defaultDashboardTopConsumers,
+		defaultDashboardRecentDenials,
+		false,
+	}
+
+	if len(options) == 0 {
+		return o
+	}
+
+	if options[0].TopConsumers != 0 {
+		o.TopConsumers = options[0].TopConsumers
+	}
+
+	if options[0].RecentDenials != 0 {
+		o.RecentDenials = options[0].RecentDenials
+	}
+
+	o.Privileged = options[0].Privileged
+
+	return o
+}
+
+// The template of the dashboard
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Throttle</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 1em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Throttle</h1>
+<p>As of {{.Time.Format "2006-01-02 15:04:05 MST"}}</p>
+<h2>Policies</h2>
+{{range .Policies}}
+<h3>{{.Name}}{{if .Disabled}} (disabled){{end}}</h3>
+<p>{{.Limit}} requests within {{.Within}}</p>
+<table>
+<tr><th>Top consumer</th><th>Requests</th></tr>
+{{range .TopConsumers}}<tr><td>{{.ID}}</td><td>{{.Count}}</td></tr>
+{{else}}<tr><td colspan="2">None</td></tr>
+{{end}}</table>
+{{else}}
+<p>No policies</p>
+{{end}}
+<h2>Recent denials</h2>
+<table>
+<tr><th>Time</th><th>Policy</th><th>Decision</th><th>Path</th></tr>
+{{range .Denials}}<tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.Policy}}</td><td>{{.Type}}</td><td>{{.Request.URL.Path}}</td></tr>
+{{else}}<tr><td colspan="4">None</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
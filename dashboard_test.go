@@ -0,0 +1,91 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiterTopConsumers(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  5,
+		Within: time.Hour,
+	})
+
+	for _, remoteAddr := range []string{"1.1.1.1", "2.2.2.2", "2.2.2.2", "3.3.3.3", "3.3.3.3", "3.3.3.3"} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		limiter.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	consumers := limiter.TopConsumers(2)
+	expectSame(t, len(consumers), 2)
+	expectSame(t, *consumers[0], Consumer{"3.3.3.3", 3, "3.3.3.3"})
+	expectSame(t, *consumers[1], Consumer{"2.2.2.2", 2, "2.2.2.2"})
+	expectSame(t, len(limiter.TopConsumers(10)), 3)
+}
+
+func TestDashboard(t *testing.T) {
+	dashboard := NewDashboard(&DashboardOptions{RecentDenials: 1})
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, &Options{
+		PolicyName: "api",
+		OnEvent:    dashboard.OnEvent,
+	})
+	dashboard.Add("api", limiter)
+
+	for _, path := range []string{"/first", "/second", "/third"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.RemoteAddr = "1.2.3.4"
+		limiter.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", "/dashboard", nil)
+	recorder := httptest.NewRecorder()
+	dashboard.ServeHTTP(recorder, req)
+
+	body := recorder.Body.String()
+	expectSame(t, recorder.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	for _, expected := range []string{"<h3>api</h3>", "1 requests within 1h0m0s", "<td>1.2.3.4</td><td>1</td>", "<td>denied</td><td>/third</td>"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the dashboard to contain %s, but got %s", expected, body)
+		}
+	}
+
+	if strings.Contains(body, "/second") {
+		t.Errorf("Expected only the most recent denial")
+	}
+}
+
+func TestDashboardAnonymized(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  2,
+		Within: time.Hour,
+		Name:   "anonymized",
+	}, &Options{
+		AnonymizeFunction: TruncateIdentities(24, 48),
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+	limiter.ServeHTTP(httptest.NewRecorder(), req)
+
+	consumers := limiter.TopConsumers(1)
+	expectSame(t, consumers[0].ID, "1.2.3.0/24")
+	expectSame(t, consumers[0].PrivilegedID(), "1.2.3.4")
+
+	for privileged, expected := range map[bool]string{false: "<td>1.2.3.0/24</td>", true: "<td>1.2.3.4</td>"} {
+		dashboard := NewDashboard(&DashboardOptions{Privileged: privileged})
+		dashboard.Add("api", limiter)
+
+		recorder := httptest.NewRecorder()
+		dashboard.ServeHTTP(recorder, req)
+		if !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("Expected the dashboard to contain %s, but got %s", expected, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package throttle
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// A cached decision on access
+type decision struct {
+	cost   uint64
+	denied bool
+	reused uint64
+}
+
+// A cache reusing the decisions on access for the same key within the same
+// time bucket, collapsing bursts of checks into one store read
+type decisionCache struct {
+	*sync.Mutex
+	bucket    time.Duration
+	current   int64
+	decisions map[string]decision
+}
+
+// Get the decision on an access of the given cost for the given key, if
+// one was made within the current bucket. An allowed decision is reused for
+// accesses of at most the given total cost
+func (c *decisionCache) Get(key string, cost uint64, now time.Time, maxReuse uint64) (bool, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.advance(now)
+	d, ok := c.decisions[key]
+	if !ok || d.cost != cost {
+		return false, false
+	}
+
+	if !d.denied {
+		if d.reused+cost > maxReuse || d.reused+cost < d.reused {
+			return false, false
+		}
+		d.reused += cost
+		c.decisions[key] = d
+	}
+
+	return d.denied, true
+}
+
+// Set the decision on an access of the given cost for the given key
+func (c *decisionCache) Set(key string, cost uint64, now time.Time, denied bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.advance(now)
+	c.decisions[key] = decision{cost, denied, 0}
+}
+
+// Drop all decisions when the bucket of the given time is a new one
+// Has to be called with the lock held
+func (c *decisionCache) advance(now time.Time) {
+	if bucket := now.UnixNano() / int64(c.bucket); bucket != c.current {
+		c.current = bucket
+		c.decisions = make(map[string]decision)
+	}
+}
+
+// Return a new decision cache with the given bucket size
+func newDecisionCache(bucket time.Duration) *decisionCache {
+	return &decisionCache{
+		&sync.Mutex{},
+		bucket,
+		0,
+		make(map[string]decision),
+	}
+}
+
+// Check if the controller denies an access of the given cost for the given
+// id, reusing a decision of the current bucket if the policy caches decisions
+func (p *policy) deniesAccess(controller *controller, id string, cost uint64) bool {
+	if controller.quota.CountOnly() {
+		return false
+	}
+
+	if p.decisions == nil {
+		return controller.DeniesAccess(id, cost)
+	}
+
+	now := controller.now()
+	if denied, ok := p.decisions.Get(id, cost, now, p.maxOvershoot(controller.quota)); ok {
+		return denied
+	}
+
+	denied := controller.DeniesAccess(id, cost)
+	p.decisions.Set(id, cost, now, denied)
+
+	return denied
+}
+
+// Get the number of accesses a requester may exceed the given quota by due
+// to cached decisions, as configured by MaxOvershoot or MaxOvershootPercent
+func (p *policy) maxOvershoot(quota *Quota) uint64 {
+	if p.options.MaxOvershoot > 0 {
+		return p.options.MaxOvershoot
+	} else if p.options.MaxOvershootPercent > 0 {
+		return uint64(float64(quota.Limit) * p.options.MaxOvershootPercent / 100)
+	}
+
+	return math.MaxUint64
+}
+
+// Get the bucket of the decision cache of the policy with the given quota
+// and options. Without a DecisionCache, a bound on the overshoot caches
+// decisions for the time the quota allows that many accesses in on average
+func decisionBucket(quota *Quota, o *Options) time.Duration {
+	if o.DecisionCache > 0 || quota.Limit == 0 {
+		return o.DecisionCache
+	}
+
+	if o.MaxOvershoot > 0 {
+		return time.Duration(float64(quota.Within) * float64(o.MaxOvershoot) / float64(quota.Limit))
+	} else if o.MaxOvershootPercent > 0 {
+		return time.Duration(float64(quota.Within) * o.MaxOvershootPercent / 100)
+	}
+
+	return 0
+}
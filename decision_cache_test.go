@@ -0,0 +1,116 @@
+package throttle
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDecisionCache(t *testing.T) {
+	cache := newDecisionCache(time.Second)
+	now := time.Unix(100, 0)
+
+	if _, ok := cache.Get("KEY", 1, now, math.MaxUint64); ok {
+		t.Errorf("Expected no decision in an empty cache")
+	}
+
+	cache.Set("KEY", 1, now, true)
+	denied, ok := cache.Get("KEY", 1, now.Add(500*time.Millisecond), math.MaxUint64)
+	expectSame(t, ok, true)
+	expectSame(t, denied, true)
+
+	if _, ok := cache.Get("KEY", 2, now, math.MaxUint64); ok {
+		t.Errorf("Expected no decision for another cost")
+	}
+
+	if _, ok := cache.Get("KEY", 1, now.Add(time.Second), math.MaxUint64); ok {
+		t.Errorf("Expected no decision in the next bucket")
+	}
+}
+
+func TestPolicyWithDecisionCache(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Clock:         clock,
+		DecisionCache: time.Second,
+	})
+	serve := func() int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder.Code
+	}
+
+	// the decision of the first access is reused within the bucket
+	expectStatusCode(t, http.StatusOK, serve())
+	expectStatusCode(t, http.StatusOK, serve())
+
+	clock.Advance(time.Second)
+	expectStatusCode(t, StatusTooManyRequests, serve())
+}
+
+func TestDecisionCacheMaxReuse(t *testing.T) {
+	cache := newDecisionCache(time.Second)
+	now := time.Unix(100, 0)
+
+	cache.Set("KEY", 2, now, false)
+	for i := 0; i < 2; i++ {
+		denied, ok := cache.Get("KEY", 2, now, 5)
+		expectSame(t, ok, true)
+		expectSame(t, denied, false)
+	}
+
+	if _, ok := cache.Get("KEY", 2, now, 5); ok {
+		t.Errorf("Expected no decision beyond the reuse bound")
+	}
+
+	// denials are reused without bound, they never overshoot
+	cache.Set("KEY", 2, now, true)
+	for i := 0; i < 5; i++ {
+		denied, ok := cache.Get("KEY", 2, now, 0)
+		expectSame(t, ok, true)
+		expectSame(t, denied, true)
+	}
+}
+
+func TestPolicyWithMaxOvershoot(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Clock:         clock,
+		DecisionCache: time.Second,
+		MaxOvershoot:  1,
+	})
+	serve := func() int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder.Code
+	}
+
+	// the first decision is reused once, then the store is read again
+	expectStatusCode(t, http.StatusOK, serve())
+	expectStatusCode(t, http.StatusOK, serve())
+	expectStatusCode(t, StatusTooManyRequests, serve())
+	expectStatusCode(t, StatusTooManyRequests, serve())
+}
+
+func TestDecisionBucket(t *testing.T) {
+	quota := &Quota{Limit: 1000, Within: time.Minute}
+
+	expectSame(t, decisionBucket(quota, &Options{}), time.Duration(0))
+	expectSame(t, decisionBucket(quota, &Options{DecisionCache: time.Second, MaxOvershoot: 10}), time.Second)
+	expectSame(t, decisionBucket(quota, &Options{MaxOvershoot: 10}), 600*time.Millisecond)
+	expectSame(t, decisionBucket(quota, &Options{MaxOvershootPercent: 1}), 600*time.Millisecond)
+	expectSame(t, decisionBucket(&Quota{Within: time.Minute}, &Options{MaxOvershoot: 10}), time.Duration(0))
+}
+
+func TestMaxOvershootPercent(t *testing.T) {
+	p := newPolicy(&Quota{Limit: 1000, Within: time.Minute}, newOptions([]*Options{{MaxOvershootPercent: 1}}))
+	expectSame(t, p.maxOvershoot(&Quota{Limit: 1000}), uint64(10))
+	expectSame(t, p.maxOvershoot(&Quota{Limit: 50}), uint64(0))
+	expectSame(t, p.decisions.bucket, 600*time.Millisecond)
+}
@@ -0,0 +1,91 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// The registry of the MapStores of policies created without a Store. Every
+// policy keeps a store of its own, so policies with the same quota do not
+// share counters, but all of them are cleaned by a single goroutine
+type defaultStoreRegistry struct {
+	*sync.Mutex
+	options  *MapStoreOptions
+	stores   []*MapStore
+	cleaning bool
+}
+
+// The default stores of all policies
+var defaultStores = &defaultStoreRegistry{
+	&sync.Mutex{},
+	newMapStoreOptions(nil),
+	nil,
+	false,
+}
+
+// Set the options of the MapStores of policies without a Store option, e.g.
+// a shorter CleaningPeriod for policies with short time windows. The
+// CleaningPeriod takes effect after the current period, OnEvict for the
+// stores of policies created afterwards. Values are always encoded with the
+// codec of the policy, and expired by the clock of the policy. Passing nil restores the defaults
+func SetDefaultMapStoreOptions(options *MapStoreOptions) {
+	o := newMapStoreOptions(nil)
+	if options != nil {
+		o = newMapStoreOptions([]*MapStoreOptions{options})
+	}
+
+	defaultStores.Lock()
+	defaultStores.options = o
+	defaultStores.Unlock()
+}
+
+// Return a new store for a policy without a Store option, with values
+// encoded by the given codec and expired by the given clock. Starts the cleaning of the default stores
+// with the first store
+func (r *defaultStoreRegistry) add(codec Codec, clock Clock) *MapStore {
+	r.Lock()
+	defer r.Unlock()
+
+	s := newMapStore(accessCount{}, codec, clock, r.options.OnEvict)
+	r.stores = append(r.stores, s)
+	if !r.cleaning {
+		r.cleaning = true
+		go r.cleanEvery()
+	}
+
+	return s
+}
+
+// Stop cleaning the given store once the policy it was created for is
+// replaced, so its counters can be collected. Stores which are not default
+// stores are ignored
+func (r *defaultStoreRegistry) remove(store *MapStore) {
+	r.Lock()
+	defer r.Unlock()
+
+	for i, s := range r.stores {
+		if s == store {
+			r.stores = append(r.stores[:i], r.stores[i+1:]...)
+			return
+		}
+	}
+}
+
+// Clean all default stores in the cleaning period of the current options
+func (r *defaultStoreRegistry) cleanEvery() {
+	for {
+		r.Lock()
+		period := r.options.CleaningPeriod
+		r.Unlock()
+
+		time.Sleep(period)
+
+		r.Lock()
+		stores := append([]*MapStore{}, r.stores...)
+		r.Unlock()
+
+		for _, s := range stores {
+			s.Clean()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDefaultStores(t *testing.T) {
+	first := newOptions(nil)
+	second := newOptions([]*Options{{Codec: &ZlibCodec{}}})
+
+	expectDifferent(t, first.Store, second.Store)
+	expectSame(t, second.Store.(*MapStore).codec, second.Codec)
+
+	defaultStores.Lock()
+	registered := 0
+	for _, s := range defaultStores.stores {
+		if s == first.Store || s == second.Store {
+			registered++
+		}
+	}
+	cleaning := defaultStores.cleaning
+	defaultStores.Unlock()
+
+	expectSame(t, registered, 2)
+	expectSame(t, cleaning, true)
+}
+
+// Check if the given store is cleaned with the default stores
+func isDefaultStore(store KeyValueStorer) bool {
+	defaultStores.Lock()
+	defer defaultStores.Unlock()
+
+	for _, s := range defaultStores.stores {
+		if s == store {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestDefaultStoresReleased(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour})
+	store := limiter.policy.options.Store
+	expectSame(t, isDefaultStore(store), true)
+
+	// swapping keeps the default store without a new store
+	limiter.Swap(&Quota{Limit: 2, Within: time.Hour}, &Options{})
+	expectSame(t, isDefaultStore(store), true)
+
+	limiter.Swap(&Quota{Limit: 2, Within: time.Hour}, &Options{Store: newMapStore(nil, JSONCodec{}, systemClock{}, nil)})
+	expectSame(t, isDefaultStore(store), false)
+}
+
+func TestDefaultStoresAreIsolated(t *testing.T) {
+	quota := &Quota{Limit: 1, Within: time.Hour}
+	first := Policy(quota)
+	second := Policy(quota)
+
+	expectStatusCode(t, http.StatusOK, serveMethod(first, "GET").Code)
+	expectStatusCode(t, http.StatusOK, serveMethod(second, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(first, "GET").Code)
+}
+
+func TestSetDefaultMapStoreOptions(t *testing.T) {
+	SetDefaultMapStoreOptions(&MapStoreOptions{CleaningPeriod: time.Minute})
+	expectSame(t, defaultStores.options.CleaningPeriod, time.Minute)
+
+	SetDefaultMapStoreOptions(nil)
+	expectSame(t, defaultStores.options.CleaningPeriod, defaultCleaningPeriod)
+}
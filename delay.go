@@ -0,0 +1,82 @@
+package throttle
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// The default time requests are held for in the Delay mode
+	defaultMaxWait = time.Second
+
+	// The default number of requests held at once in the Delay mode
+	defaultQueueDepth = 100
+
+	// The least time a held request waits before the quota is checked again
+	minDelayWait = time.Millisecond
+)
+
+// The handling of requests exceeding the quota, see the Mode option
+type Mode int
+
+const (
+	// Requests exceeding the quota are denied right away
+	Reject Mode = iota
+	// Requests exceeding the quota are held until the quota frees up, and
+	// only denied if it frees up after the MaxWait or the deadline of the
+	// request, or if the queue is full. With the LeakyBucket algorithm,
+	// requests are then released at its constant rate
+	Delay
+)
+
+// Get the controller and key of the quota denying an access of the given
+// cost like deniedBy. In the Delay mode, denied requests are held until the
+// quota frees up and checked again. Requests are not held if the queue is
+// full, and denied once the quota frees up after the max wait, the deadline
+// of the request, or when the request is done
+func (p *policy) held(req *http.Request, c *controller, id string, keys []string, cost uint64) (*controller, string) {
+	denier, key := p.deniedBy(c, id, keys, cost)
+	if denier == nil || p.queue == nil {
+		return denier, key
+	}
+
+	select {
+	case p.queue <- struct{}{}:
+		defer func() { <-p.queue }()
+	default:
+		return denier, key
+	}
+
+	// the deadline is told by the clock of the policy, like the time the
+	// quota frees up at, the deadline of the request is on the system clock
+	ctx := req.Context()
+	now := c.now()
+	deadline := now.Add(p.options.MaxWait)
+	if d, ok := ctx.Deadline(); ok && now.Add(time.Until(d)).Before(deadline) {
+		deadline = now.Add(time.Until(d))
+	}
+
+	for denier != nil {
+		now = denier.now()
+		wait := denier.RetryAt(key).Sub(now)
+		if wait < minDelayWait {
+			wait = minDelayWait
+		}
+		if now.Add(wait).After(deadline) {
+			return denier, key
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return denier, key
+		}
+
+		c.forgetBatch()
+		denier, key = p.deniedBy(c, id, keys, cost)
+	}
+
+	return nil, ""
+}
@@ -0,0 +1,130 @@
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveWithContext(policy func(http.ResponseWriter, *http.Request), ctx context.Context) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = req.WithContext(ctx)
+	req.RemoteAddr = "1.2.3.4"
+	recorder := httptest.NewRecorder()
+	policy(recorder, req)
+	return recorder
+}
+
+func TestDelay(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: 50 * time.Millisecond, Name: "delay"}, &Options{
+		Mode: Delay,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	start := time.Now()
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+	if elapsed := time.Now().Sub(start); elapsed < 25*time.Millisecond {
+		t.Errorf("Expected the request to be held until the quota frees up, held for %v", elapsed)
+	}
+}
+
+func TestDelayWithClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 1, Within: 50 * time.Millisecond, Name: "delay-clock"}, &Options{
+		Mode:    Delay,
+		MaxWait: 100 * time.Millisecond,
+		Clock:   clock,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	// the quota frees up by the clock of the policy
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		clock.Advance(50 * time.Millisecond)
+	}()
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+}
+
+func TestDelayMaxWait(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour, Name: "delay-max-wait"}, &Options{
+		Mode:    Delay,
+		MaxWait: 10 * time.Millisecond,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	start := time.Now()
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	if elapsed := time.Now().Sub(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected the request to be denied right away, held for %v", elapsed)
+	}
+}
+
+func TestDelayRequestDeadline(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: 300 * time.Millisecond, Name: "delay-deadline"}, &Options{
+		Mode: Delay,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	expectStatusCode(t, StatusTooManyRequests, serveWithContext(policy, ctx).Code)
+	if elapsed := time.Now().Sub(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Expected the request to be denied before its deadline, held for %v", elapsed)
+	}
+}
+
+func TestDelayRequestCanceled(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: 300 * time.Millisecond, Name: "delay-canceled"}, &Options{
+		Mode: Delay,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	expectStatusCode(t, StatusTooManyRequests, serveWithContext(policy, ctx).Code)
+	if elapsed := time.Now().Sub(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Expected the request to be denied once done, held for %v", elapsed)
+	}
+}
+
+func TestDelayQueueDepth(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: 100 * time.Millisecond, Name: "delay-queue"}, &Options{
+		Mode:       Delay,
+		QueueDepth: 1,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	held := make(chan int)
+	go func() {
+		held <- serveMethod(policy, "GET").Code
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// the queue is full
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	expectStatusCode(t, http.StatusOK, <-held)
+}
+
+func TestDelayLeakyBucket(t *testing.T) {
+	policy := Policy(&Quota{Limit: 10, Within: 100 * time.Millisecond, Name: "delay-leaky", Algorithm: LeakyBucket}, &Options{
+		Mode: Delay,
+	})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Expected the requests to be released at the rate of the bucket, released within %v", elapsed)
+	}
+}
@@ -0,0 +1,185 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The default number of denials buffered per subscriber
+	defaultDenialFeedBuffer = 64
+	// The default interval of heartbeats keeping idle streams open
+	defaultDenialFeedHeartbeat = 15 * time.Second
+)
+
+// A DenialFeed streams the denials of the policies using its OnEvent hook
+// to its subscribers as JSON lines, or as server-sent events for clients
+// accepting text/event-stream, e.g. for a lightweight real-time monitoring
+// dashboard. Identities of requesters are not included. Authentication is
+// left to the handlers before the feed, it should not be reachable publicly
+type DenialFeed struct {
+	*sync.Mutex
+	options     *DenialFeedOptions
+	subscribers map[chan []byte]bool
+}
+
+type DenialFeedOptions struct {
+	// The number of denials buffered per subscriber. Denials are dropped for
+	// subscribers too slow to keep up, so requests never wait for the feed
+	// defaults to 64
+	Buffer int
+
+	// The interval to send heartbeats in while no denials happen, so proxies
+	// keep idle streams open
+	// defaults to 15 seconds
+	Heartbeat time.Duration
+}
+
+// A denial as streamed by the feed
+type denialFeedEntry struct {
+	Time      time.Time `json:"time"`
+	Policy    string    `json:"policy"`
+	Type      string    `json:"type"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Limit     uint64    `json:"limit"`
+	Remaining uint64    `json:"remaining"`
+}
+
+// Stream denied, banned, challenged, blocked and shed requests to the subscribers,
+// to use as the OnEvent option of the policies
+func (f *DenialFeed) OnEvent(e *Event) {
+	if e.Type != EventDenied && e.Type != EventBanned && e.Type != EventChallenged && e.Type != EventBlocked && e.Type != EventShed {
+		return
+	}
+
+	entry := &denialFeedEntry{
+		Time:      e.Time,
+		Policy:    e.Policy,
+		Type:      e.Type.String(),
+		Limit:     e.Limit,
+		Remaining: e.Remaining,
+	}
+	if e.Request != nil {
+		entry.Method = e.Request.Method
+		entry.Path = e.Request.URL.Path
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber <- line:
+		default:
+		}
+	}
+}
+
+// Stream denials to the requester until the request is done. Responds with
+// 500 Internal Server Error if the response writer cannot be flushed
+func (f *DenialFeed) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		http.Error(resp, "Streaming Unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	if events {
+		resp.Header().Set(contentTypeHeader, "text/event-stream")
+	} else {
+		resp.Header().Set(contentTypeHeader, "application/x-ndjson")
+	}
+	resp.Header().Set("Cache-Control", "no-cache")
+
+	subscriber := f.subscribe()
+	defer f.unsubscribe(subscriber)
+
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(f.options.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case line := <-subscriber:
+			if events {
+				resp.Write([]byte("data: "))
+			}
+			resp.Write(line)
+			if events {
+				resp.Write([]byte("\n\n"))
+			} else {
+				resp.Write([]byte("\n"))
+			}
+		case <-heartbeat.C:
+			if events {
+				resp.Write([]byte(":\n\n"))
+			} else {
+				resp.Write([]byte("\n"))
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// Add a subscriber to the feed
+func (f *DenialFeed) subscribe() chan []byte {
+	subscriber := make(chan []byte, f.options.Buffer)
+
+	f.Lock()
+	f.subscribers[subscriber] = true
+	f.Unlock()
+
+	return subscriber
+}
+
+// Remove a subscriber from the feed
+func (f *DenialFeed) unsubscribe(subscriber chan []byte) {
+	f.Lock()
+	delete(f.subscribers, subscriber)
+	f.Unlock()
+}
+
+// Returns a new denial feed
+func NewDenialFeed(options ...*DenialFeedOptions) *DenialFeed {
+	return &DenialFeed{
+		&sync.Mutex{},
+		newDenialFeedOptions(options),
+		make(map[chan []byte]bool),
+	}
+}
+
+// Returns new denial feed options from defaults and given options
+func newDenialFeedOptions(options []*DenialFeedOptions) *DenialFeedOptions {
+	o := &DenialFeedOptions{
+		defaultDenialFeedBuffer,
+		defaultDenialFeedHeartbeat,
+	}
+
+	if len(options) == 0 {
+		return o
+	}
+
+	if options[0].Buffer != 0 {
+		o.Buffer = options[0].Buffer
+	}
+
+	if options[0].Heartbeat != 0 {
+		o.Heartbeat = options[0].Heartbeat
+	}
+
+	return o
+}
@@ -0,0 +1,92 @@
+package throttle
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDenialFeed(t *testing.T) {
+	feed := NewDenialFeed()
+	server := httptest.NewServer(feed)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	expectSame(t, resp.Header.Get("Content-Type"), "application/x-ndjson")
+
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		PolicyName: "api",
+		OnEvent:    feed.OnEvent,
+	})
+	serveMethod(policy, "GET")
+	serveMethod(policy, "POST")
+
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		t.Fatalf("Expected a JSON line, got %s", line)
+	}
+	expectSame(t, entry["policy"], "api")
+	expectSame(t, entry["type"], "denied")
+	expectSame(t, entry["method"], "POST")
+	expectSame(t, entry["path"], "/")
+	expectSame(t, entry["limit"], float64(1))
+	expectSame(t, entry["remaining"], float64(0))
+}
+
+func TestDenialFeedEvents(t *testing.T) {
+	feed := NewDenialFeed(&DenialFeedOptions{Heartbeat: time.Millisecond})
+	server := httptest.NewServer(feed)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	expectSame(t, resp.Header.Get("Content-Type"), "text/event-stream")
+
+	reader := bufio.NewReader(resp.Body)
+	heartbeat, _ := reader.ReadString('\n')
+	expectSame(t, heartbeat, ":\n")
+}
+
+func TestDenialFeedDropsForSlowSubscribers(t *testing.T) {
+	feed := NewDenialFeed(&DenialFeedOptions{Buffer: 1})
+	subscriber := feed.subscribe()
+
+	// requests never wait for the subscriber
+	feed.OnEvent(&Event{Type: EventDenied})
+	feed.OnEvent(&Event{Type: EventDenied})
+	feed.OnEvent(&Event{Type: EventAllowed})
+	expectSame(t, len(subscriber), 1)
+
+	feed.unsubscribe(subscriber)
+	expectSame(t, len(feed.subscribers), 0)
+}
+
+func TestDenialFeedWithoutFlusher(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+	NewDenialFeed().ServeHTTP(&unflushableWriter{recorder}, req)
+
+	expectStatusCode(t, http.StatusInternalServerError, recorder.Code)
+}
+
+// A response writer which cannot be flushed
+type unflushableWriter struct {
+	http.ResponseWriter
+}
@@ -0,0 +1,146 @@
+package throttle
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// The default time a merge of dual-stack identities lasts
+const defaultDualStackTTL = 24 * time.Hour
+
+// A DualStackMerge merges the IPv4 and IPv6 identities of a client into one
+// bucket once both are observed with a common authenticated identity, e.g.
+// the user of a session, so dual-stack clients do not get twice the quota.
+// The merged bucket is the bucket of the IPv4 identity, also used for
+// requests of the IPv6 identity without the authenticated identity until the
+// merge expires. Merges are kept in the store of the policy
+type DualStackMerge struct {
+	// The function returning the authenticated identity of the request, or
+	// an empty string for anonymous requests, required
+	Function func(*http.Request) string
+
+	// The time a merge lasts after the last request with the authenticated
+	// identity
+	// defaults to 24 hours
+	TTL time.Duration
+}
+
+// The identities observed with an authenticated identity, will be stored in
+// the key value store
+type dualStackIdentities struct {
+	IPv4    string    `json:"ipv4,omitempty"`
+	IPv6    string    `json:"ipv6,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// A merge of an IPv6 identity into an IPv4 identity, will be stored in the
+// key value store
+type dualStackMerge struct {
+	Identity string    `json:"identity"`
+	Until    time.Time `json:"until"`
+}
+
+// Get the time a merge lasts
+func (m *DualStackMerge) ttl() time.Duration {
+	if m.TTL <= 0 {
+		return defaultDualStackTTL
+	}
+
+	return m.TTL
+}
+
+// The key of the identities observed with the given authenticated identity
+func (p *policy) dualStackKey(authenticated string) string {
+	return makeKey(p.prefix, "dualstack", authenticated)
+}
+
+// The key of the merge of the given IPv6 identity
+func (p *policy) mergedKey(identity string) string {
+	return makeKey(p.prefix, "merged", identity)
+}
+
+// Get the value of the given key decoded into the given value. Returns false
+// if the key does not exist
+func (p *policy) getDualStack(key string, value interface{}) bool {
+	c := p.controller
+	valueBytes, err := c.store.Get(key)
+	if err != nil {
+		return false
+	}
+
+	if err := c.codec.Decode(valueBytes, value); err != nil {
+		panic(err.Error())
+	}
+
+	return true
+}
+
+// Set the given key to the encoded value, will write to the store
+func (p *policy) setDualStack(key string, value interface{}) {
+	c := p.controller
+	marshalled, err := c.codec.Encode(value)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	if err := c.store.Set(key, marshalled); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Get the identity of the bucket of the request with the given identity with
+// the DualStackMerge option. Requests with an authenticated identity record
+// their identity for the IP family of the request, and merge the identities
+// once both families are recorded. Returns the given identity if it is not
+// merged
+func (p *policy) mergeDualStack(req *http.Request, identity string) string {
+	merge := p.options.DualStackMerge
+	if merge == nil {
+		return identity
+	}
+
+	ip := net.ParseIP(defaultIdentify(req))
+	if ip == nil {
+		return identity
+	}
+
+	now := p.options.Clock.Now().UTC()
+	authenticated := merge.Function(req)
+	if authenticated == "" {
+		m := &dualStackMerge{}
+		if p.getDualStack(p.mergedKey(identity), m) && now.Before(m.Until) {
+			return m.Identity
+		}
+
+		return identity
+	}
+
+	key := p.dualStackKey(authenticated)
+	identities := &dualStackIdentities{}
+	if p.getDualStack(key, identities) && now.Sub(identities.Updated) >= merge.ttl() {
+		identities = &dualStackIdentities{}
+	}
+
+	observed := &identities.IPv6
+	if ip.To4() != nil {
+		observed = &identities.IPv4
+	}
+
+	// refresh the identities and the merge at most every tenth of the ttl
+	if *observed != identity || now.Sub(identities.Updated) >= merge.ttl()/10 {
+		*observed = identity
+		identities.Updated = now
+		p.setDualStack(key, identities)
+
+		if identities.IPv4 != "" && identities.IPv6 != "" && identities.IPv4 != identities.IPv6 {
+			p.setDualStack(p.mergedKey(identities.IPv6), &dualStackMerge{identities.IPv4, now.Add(merge.ttl())})
+		}
+	}
+
+	if identities.IPv4 != "" {
+		return identities.IPv4
+	}
+
+	return identity
+}
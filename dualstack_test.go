@@ -0,0 +1,76 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveFrom(policy func(http.ResponseWriter, *http.Request), remoteAddr string, user string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	recorder := httptest.NewRecorder()
+	policy(recorder, req)
+	return recorder
+}
+
+func identifyUser(req *http.Request) string {
+	return req.Header.Get("X-User")
+}
+
+func TestDualStackMerge(t *testing.T) {
+	policy := Policy(&Quota{Limit: 3, Within: time.Hour, Name: "dualstack"}, &Options{
+		DualStackMerge: &DualStackMerge{Function: identifyUser},
+	})
+
+	expectSame(t, serveFrom(policy, "1.2.3.4", "alice").Header().Get("X-RateLimit-Remaining"), "2")
+	expectSame(t, serveFrom(policy, "[2001:db8::1]:1234", "alice").Header().Get("X-RateLimit-Remaining"), "1")
+	// the IPv6 identity stays merged without the authenticated identity
+	expectSame(t, serveFrom(policy, "[2001:db8::1]:1234", "").Header().Get("X-RateLimit-Remaining"), "0")
+	expectStatusCode(t, StatusTooManyRequests, serveFrom(policy, "[2001:db8::1]:1234", "").Code)
+
+	// other clients are not merged
+	expectSame(t, serveFrom(policy, "[2001:db8::2]:1234", "").Header().Get("X-RateLimit-Remaining"), "2")
+	expectSame(t, serveFrom(policy, "[2001:db8::3]:1234", "bob").Header().Get("X-RateLimit-Remaining"), "2")
+}
+
+func TestDualStackMergeExpires(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 3, Within: time.Minute, Name: "dualstack-ttl"}, &Options{
+		Clock:          clock,
+		DualStackMerge: &DualStackMerge{Function: identifyUser, TTL: time.Hour},
+	})
+
+	serveFrom(policy, "1.2.3.4", "alice")
+	serveFrom(policy, "[2001:db8::1]:1234", "alice")
+	expectSame(t, serveFrom(policy, "[2001:db8::1]:1234", "").Header().Get("X-RateLimit-Remaining"), "0")
+
+	clock.Advance(2 * time.Hour)
+	expectSame(t, serveFrom(policy, "[2001:db8::1]:1234", "").Header().Get("X-RateLimit-Remaining"), "2")
+	// stale identities are not merged
+	expectSame(t, serveFrom(policy, "[2001:db8::1]:1234", "alice").Header().Get("X-RateLimit-Remaining"), "1")
+}
+
+func TestDualStackKeySchema(t *testing.T) {
+	store := NewMapStore(nil)
+	limiter := NewLimiter(&Quota{Limit: 3, Within: time.Hour}, &Options{
+		Store:          store,
+		DualStackMerge: &DualStackMerge{Function: identifyUser},
+	})
+	serveFrom(limiter.ServeHTTP, "1.2.3.4", "alice")
+	serveFrom(limiter.ServeHTTP, "[2001:db8::1]:1234", "alice")
+
+	schema := limiter.KeySchema()
+	expectSame(t, schema.Key("dualstack").Template, "throttle_dualstack_{authenticated}")
+	if _, err := store.Get(strings.Replace(schema.Key("dualstack").Template, "{authenticated}", "alice", 1)); err != nil {
+		t.Errorf("Expected the dual-stack identities in the store")
+	}
+	if _, err := store.Get(schema.Key("merged").Key("2001:db8::1")); err != nil {
+		t.Errorf("Expected the merge in the store")
+	}
+}
@@ -0,0 +1,103 @@
+package throttle
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// Error Type for the configuration
+type ConfigError string
+
+// The Error for the Configuration
+func (err ConfigError) Error() string {
+	return "Throttle Config Error: " + string(err)
+}
+
+// The scopes by their names in the environment
+var scopes = map[string]Scope{
+	"global": GlobalScope,
+	"local":  LocalScope,
+	"region": RegionScope,
+}
+
+// Build a quota and options from environment variables with the given
+// prefix, so deployments can tune throttling without code changes. With
+// the prefix "THROTTLE", the following variables are read:
+//
+//	THROTTLE_LIMIT         the limit of the quota, required
+//	THROTTLE_WITHIN        the time window of the quota, e.g. "1m", required
+//	THROTTLE_STATUS_CODE   the status code for throttled requests
+//	THROTTLE_MESSAGE       the message for throttled requests
+//	THROTTLE_RETRY_AFTER   if a Retry-After header is added, e.g. "true"
+//	THROTTLE_KEY_PREFIX    the key prefix in the store
+//	THROTTLE_DISABLED      if the throttle is disabled, e.g. "true"
+//	THROTTLE_STORE_URL     the url of the store, see NewStoreFromURL
+//	THROTTLE_SCOPE         the scope of the counters, "global", "local" or "region"
+//	THROTTLE_REGION        the region of the instance, e.g. "eu-west-1"
+//	THROTTLE_SKIP          the rule for requests not throttled, see CompileSkipRule
+//
+// Variables which are not set keep their defaults
+func OptionsFromEnv(prefix string) (*Quota, *Options, error) {
+	env := func(name string) string {
+		return os.Getenv(prefix + "_" + name)
+	}
+
+	quota := &Quota{}
+	options := &Options{}
+
+	limit, err := strconv.ParseUint(env("LIMIT"), 10, 64)
+	if err != nil {
+		return nil, nil, ConfigError("Invalid " + prefix + "_LIMIT: " + err.Error())
+	}
+	quota.Limit = limit
+
+	if quota.Within, err = time.ParseDuration(env("WITHIN")); err != nil {
+		return nil, nil, ConfigError("Invalid " + prefix + "_WITHIN: " + err.Error())
+	}
+
+	if value := env("STATUS_CODE"); value != "" {
+		if options.StatusCode, err = strconv.Atoi(value); err != nil {
+			return nil, nil, ConfigError("Invalid " + prefix + "_STATUS_CODE: " + err.Error())
+		}
+	}
+
+	options.Message = env("MESSAGE")
+	options.KeyPrefix = env("KEY_PREFIX")
+
+	if value := env("RETRY_AFTER"); value != "" {
+		if options.RetryAfter, err = strconv.ParseBool(value); err != nil {
+			return nil, nil, ConfigError("Invalid " + prefix + "_RETRY_AFTER: " + err.Error())
+		}
+	}
+
+	if value := env("DISABLED"); value != "" {
+		if options.Disabled, err = strconv.ParseBool(value); err != nil {
+			return nil, nil, ConfigError("Invalid " + prefix + "_DISABLED: " + err.Error())
+		}
+	}
+
+	if value := env("SCOPE"); value != "" {
+		scope, ok := scopes[value]
+		if !ok {
+			return nil, nil, ConfigError("Invalid " + prefix + "_SCOPE: " + value)
+		}
+		options.Scope = scope
+	}
+
+	options.Region = env("REGION")
+
+	if value := env("SKIP"); value != "" {
+		if options.SkipAccessCheck, err = CompileSkipRule(value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if value := env("STORE_URL"); value != "" {
+		if options.Store, err = NewStoreFromURL(value); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return quota, options, nil
+}
@@ -0,0 +1,74 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv("TEST_THROTTLE_LIMIT", "10")
+	t.Setenv("TEST_THROTTLE_WITHIN", "1m")
+	t.Setenv("TEST_THROTTLE_STATUS_CODE", "503")
+	t.Setenv("TEST_THROTTLE_MESSAGE", "Slow down")
+	t.Setenv("TEST_THROTTLE_RETRY_AFTER", "true")
+	t.Setenv("TEST_THROTTLE_STORE_URL", "map://")
+	t.Setenv("TEST_THROTTLE_SCOPE", "region")
+	t.Setenv("TEST_THROTTLE_REGION", "eu-west-1")
+	t.Setenv("TEST_THROTTLE_SKIP", `req.path == "/health"`)
+
+	quota, options, err := OptionsFromEnv("TEST_THROTTLE")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectSame(t, quota.Limit, uint64(10))
+	expectSame(t, quota.Within, time.Minute)
+	expectSame(t, options.StatusCode, http.StatusServiceUnavailable)
+	expectSame(t, options.Message, "Slow down")
+	expectSame(t, options.RetryAfter, true)
+	expectSame(t, options.Disabled, false)
+	expectSame(t, options.KeyPrefix, "")
+	expectSame(t, options.Scope, RegionScope)
+	expectSame(t, options.Region, "eu-west-1")
+
+	if _, ok := options.Store.(*MapStore); !ok {
+		t.Errorf("Expected a map store, but got %T", options.Store)
+	}
+
+	req, _ := http.NewRequest("GET", "/health", nil)
+	expectSame(t, options.SkipAccessCheck(req), true)
+}
+
+func TestOptionsFromEnvErrors(t *testing.T) {
+	if _, _, err := OptionsFromEnv("TEST_THROTTLE"); err == nil {
+		t.Errorf("Expected an error without a limit")
+	}
+
+	t.Setenv("TEST_THROTTLE_LIMIT", "10")
+	t.Setenv("TEST_THROTTLE_WITHIN", "a minute")
+	_, _, err := OptionsFromEnv("TEST_THROTTLE")
+	if err == nil {
+		t.Errorf("Expected an error for an invalid duration")
+	} else {
+		expectMatches(t, "^Throttle Config Error: Invalid TEST_THROTTLE_WITHIN", err.Error())
+	}
+
+	t.Setenv("TEST_THROTTLE_WITHIN", "1m")
+	t.Setenv("TEST_THROTTLE_STORE_URL", "unknown://")
+	if _, _, err := OptionsFromEnv("TEST_THROTTLE"); err == nil {
+		t.Errorf("Expected an error for an unsupported store")
+	}
+
+	t.Setenv("TEST_THROTTLE_STORE_URL", "")
+	t.Setenv("TEST_THROTTLE_SCOPE", "planet")
+	if _, _, err := OptionsFromEnv("TEST_THROTTLE"); err == nil {
+		t.Errorf("Expected an error for an unknown scope")
+	}
+
+	t.Setenv("TEST_THROTTLE_SCOPE", "")
+	t.Setenv("TEST_THROTTLE_SKIP", `req.path ==`)
+	if _, _, err := OptionsFromEnv("TEST_THROTTLE"); err == nil {
+		t.Errorf("Expected an error for an invalid skip rule")
+	}
+}
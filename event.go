@@ -0,0 +1,145 @@
+package throttle
+
+import (
+	"net/http"
+	"time"
+)
+
+// The type of an event
+type EventType int
+
+const (
+	// Access was allowed
+	EventAllowed EventType = iota
+	// Access was denied for exceeding the quota
+	EventDenied
+	// Access was denied to a banned requester
+	EventBanned
+	// Access was denied with a challenge
+	EventChallenged
+	// The request was rejected for a malformed identity
+	EventRejected
+	// The request bypassed the throttle, e.g. by SkipAccessCheck
+	EventBypassed
+	// A corrupt access count was replaced, see the RepairCorrupt option.
+	// Followed by the event of the decision on the request
+	EventRepaired
+	// The distinct identities within a time window exceeded the threshold of
+	// the CardinalityGuard, once per window. Followed by the event of the
+	// decision on the request
+	EventCardinalityExceeded
+	// Access was denied to a requester of a network of the deny list
+	EventBlocked
+	// The request was shed by a LoadSheddingPolicy while the server was
+	// overloaded
+	EventShed
+)
+
+// The names of the event types
+var eventTypeNames = map[EventType]string{
+	EventAllowed:             "allowed",
+	EventDenied:              "denied",
+	EventBanned:              "banned",
+	EventChallenged:          "challenged",
+	EventRejected:            "rejected",
+	EventBypassed:            "bypassed",
+	EventRepaired:            "repaired",
+	EventCardinalityExceeded: "cardinality_exceeded",
+	EventBlocked:             "blocked",
+	EventShed:                "shed",
+}
+
+// The name of the event type, e.g. "denied"
+func (t EventType) String() string {
+	return eventTypeNames[t]
+}
+
+// An Event is a decision of a policy, passed to the OnEvent hook
+type Event struct {
+	// The type of the event
+	Type EventType
+	// The name of the policy, see the PolicyName option
+	Policy string
+	// The key of the requester in the store, empty for bypassed and rejected requests
+	Key string
+	// The time of the event
+	Time time.Time
+	// The request
+	Request *http.Request
+	// The limit of the quota of the requester, zero for bypassed and
+	// rejected requests
+	Limit uint64
+	// The remaining limit of the requester after the decision
+	Remaining uint64
+	// The key of the requester before anonymization
+	privilegedKey string
+}
+
+// Get the key of the requester before it was anonymized by the
+// AnonymizeFunction option, e.g. for audit trails with the privilege to
+// know requesters. Use Key wherever the event may be seen by others
+func (e *Event) PrivilegedKey() string {
+	return e.privilegedKey
+}
+
+// Pass an event to the OnEvent hook, if any, and return it. The controller
+// is nil for requests that were not identified. Events of allowed requests
+// are only made for the hook, the remaining limit only for the hook and
+// martini denials
+func (p *policy) emit(t EventType, req *http.Request, controller *controller, key string) *Event {
+	if p.options.OnEvent == nil && t == EventAllowed {
+		return nil
+	}
+
+	e := &Event{
+		Type:          t,
+		Policy:        p.options.PolicyName,
+		Key:           p.options.anonymize(key),
+		Time:          p.options.Clock.Now().UTC(),
+		Request:       req,
+		privilegedKey: key,
+	}
+
+	if controller != nil {
+		e.Limit = controller.quota.capacity()
+		if p.options.OnEvent != nil || p.denials {
+			e.Remaining = controller.RemainingLimit(key)
+		}
+	}
+
+	if p.options.OnEvent != nil {
+		p.options.OnEvent(e)
+	}
+
+	return e
+}
+
+// Return the function repairing corrupt access counts for controllers with
+// the options, or nil if they are not repaired
+func (o *Options) repairHook() func(key string, err error) {
+	if !o.RepairCorrupt {
+		return nil
+	}
+
+	return func(key string, err error) {
+		o.Logger.Printf("Repairing corrupt access count %s: %v", o.anonymize(key), err)
+		if o.OnEvent != nil {
+			o.OnEvent(&Event{
+				Type:          EventRepaired,
+				Policy:        o.PolicyName,
+				Key:           o.anonymize(key),
+				Time:          o.Clock.Now().UTC(),
+				privilegedKey: key,
+			})
+		}
+	}
+}
+
+// Let the request bypass the throttle, optionally marking the response
+func (p *policy) bypass(resp http.ResponseWriter, req *http.Request) *Event {
+	if p.options.BypassedHeader {
+		resp.Header().Set("X-RateLimit-Bypassed", "true")
+	}
+
+	return p.emit(EventBypassed, req, nil, "")
+}
@@ -0,0 +1,84 @@
+package throttle
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	sync.Mutex
+	types []EventType
+}
+
+func (r *eventRecorder) record(e *Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.types = append(r.types, e.Type)
+}
+
+func TestEvents(t *testing.T) {
+	recorder := &eventRecorder{}
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{
+		OnEvent: recorder.record,
+		SkipAccessCheck: func(req *http.Request) bool {
+			return req.Header.Get("X-Health-Check") != ""
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	}, &Expectation{
+		StatusCode:      http.StatusOK,
+		Headers:         map[string]string{"X-Health-Check": "1"},
+		ResponseHeaders: map[string]string{"X-RateLimit-Bypassed": ""},
+	})
+
+	expectSame(t, len(recorder.types), 3)
+	expectSame(t, recorder.types[0], EventAllowed)
+	expectSame(t, recorder.types[1], EventDenied)
+	expectSame(t, recorder.types[2], EventBypassed)
+	expectSame(t, EventDenied.String(), "denied")
+}
+
+func TestBypassedHeader(t *testing.T) {
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{
+		BypassedHeader: true,
+		SkipAccessCheck: func(req *http.Request) bool {
+			return true
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:      http.StatusOK,
+		ResponseHeaders: map[string]string{"X-RateLimit-Bypassed": "true", "X-RateLimit-Limit": ""},
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+}
+
+func TestEventRemaining(t *testing.T) {
+	var events []*Event
+	m := setupMartiniWithPolicy(2, time.Hour, &Options{
+		OnEvent: func(e *Event) {
+			events = append(events, e)
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+
+	expectSame(t, len(events), 3)
+	for i, remaining := range []uint64{1, 0, 0} {
+		expectSame(t, events[i].Limit, uint64(2))
+		expectSame(t, events[i].Remaining, remaining)
+	}
+}
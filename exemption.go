@@ -0,0 +1,137 @@
+package throttle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The default name of the exemption cookie
+	defaultExemptionCookieName = "throttle_exemption"
+
+	// The default time an exemption is valid for
+	defaultExemptionTTL = 24 * time.Hour
+)
+
+// An Exemption relaxes the limits for verified browsers, e.g. after a
+// completed CAPTCHA or a login, by a signed cookie. The cookie is bound to
+// the IP of the requester, so it can not be shared by a fleet of bots
+type Exemption struct {
+	// The secret to sign cookies with, required. Policies panic on creation
+	// without it, as anyone could sign cookies with an empty secret
+	Secret []byte
+
+	// The name of the cookie
+	// defaults to "throttle_exemption"
+	CookieName string
+
+	// The time an exemption is valid for
+	// defaults to 24 hours
+	TTL time.Duration
+
+	// The quota for exempted requesters
+	// defaults to no throttling of exempted requesters
+	Quota *Quota
+
+	// The function used to find the IP the cookie is bound to. Behind a
+	// proxy, use a function reading the IP from a header only the proxy sets
+	// defaults to the remote address of the connection, never trusting headers
+	// the client can set, like X-Forwarded-For
+	IdentificationFunction func(*http.Request) string
+
+	// The clock to issue cookies with, policies check cookies with their
+	// Clock option
+	// defaults to the system clock
+	Clock Clock
+}
+
+// Issue an exemption to the requester by setting the signed cookie
+func (e *Exemption) Issue(resp http.ResponseWriter, req *http.Request) {
+	ttl := e.TTL
+	if ttl == 0 {
+		ttl = defaultExemptionTTL
+	}
+
+	expires := e.now().Add(ttl)
+	value := strconv.FormatInt(expires.Unix(), 10)
+
+	http.SetCookie(resp, &http.Cookie{
+		Name:     e.cookieName(),
+		Value:    value + "." + e.sign(value, e.identify(req)),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Check if the requester has a valid exemption. Without a secret, no
+// requester is exempt
+func (e *Exemption) IsExempt(req *http.Request) bool {
+	return e.isExemptAt(req, e.now())
+}
+
+// Check if the requester has a valid exemption at the given time
+func (e *Exemption) isExemptAt(req *http.Request, now time.Time) bool {
+	if len(e.Secret) == 0 {
+		return false
+	}
+
+	cookie, err := req.Cookie(e.cookieName())
+	if err != nil {
+		return false
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		return false
+	}
+
+	return hmac.Equal([]byte(parts[1]), []byte(e.sign(parts[0], e.identify(req))))
+}
+
+// Get the current time of the clock
+func (e *Exemption) now() time.Time {
+	if e.Clock == nil {
+		return time.Now()
+	}
+
+	return e.Clock.Now()
+}
+
+// Get the IP of the requester the cookie is bound to
+func (e *Exemption) identify(req *http.Request) string {
+	if e.IdentificationFunction != nil {
+		return e.IdentificationFunction(req)
+	}
+
+	return identifyConnection(req)
+}
+
+// Sign the given expiry for the given ip
+func (e *Exemption) sign(expires string, ip string) string {
+	mac := hmac.New(sha256.New, e.Secret)
+	mac.Write([]byte(expires + "|" + ip))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The name of the cookie
+func (e *Exemption) cookieName() string {
+	if e.CookieName == "" {
+		return defaultExemptionCookieName
+	}
+
+	return e.CookieName
+}
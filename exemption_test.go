@@ -0,0 +1,126 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func issueExemption(e *Exemption, ip string) *http.Cookie {
+	req, _ := http.NewRequest("GET", "/captcha", nil)
+	req.RemoteAddr = ip
+	recorder := httptest.NewRecorder()
+	e.Issue(recorder, req)
+
+	return recorder.Result().Cookies()[0]
+}
+
+func exemptRequest(ip string, cookie *http.Cookie) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = ip
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	return req
+}
+
+func TestExemption(t *testing.T) {
+	e := &Exemption{Secret: []byte("secret")}
+	cookie := issueExemption(e, "1.2.3.4")
+
+	expectSame(t, cookie.Name, "throttle_exemption")
+	expectSame(t, cookie.HttpOnly, true)
+	expectSame(t, e.IsExempt(exemptRequest("1.2.3.4", cookie)), true)
+	expectSame(t, e.IsExempt(exemptRequest("1.2.3.4", nil)), false)
+
+	// bound to the ip
+	expectSame(t, e.IsExempt(exemptRequest("5.6.7.8", cookie)), false)
+
+	// signed with the secret
+	expectSame(t, (&Exemption{Secret: []byte("other")}).IsExempt(exemptRequest("1.2.3.4", cookie)), false)
+
+	forged := *cookie
+	forged.Value = "9999999999" + forged.Value[len("9999999999"):]
+	expectSame(t, e.IsExempt(exemptRequest("1.2.3.4", &forged)), false)
+}
+
+func TestExemptionIgnoresForwardedFor(t *testing.T) {
+	e := &Exemption{Secret: []byte("secret")}
+	cookie := issueExemption(e, "1.2.3.4")
+
+	// a bot holding the cookie can not claim the ip in a header
+	req := exemptRequest("5.6.7.8", cookie)
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	expectSame(t, e.IsExempt(req), false)
+
+	proxied := &Exemption{
+		Secret: []byte("secret"),
+		IdentificationFunction: func(req *http.Request) string {
+			return req.Header.Get("X-Real-IP")
+		},
+	}
+	req = exemptRequest("10.0.0.1", nil)
+	req.Header.Set("X-Real-IP", "1.2.3.4")
+	recorder := httptest.NewRecorder()
+	proxied.Issue(recorder, req)
+	req.AddCookie(recorder.Result().Cookies()[0])
+	expectSame(t, proxied.IsExempt(req), true)
+}
+
+func TestPolicyWithExemption(t *testing.T) {
+	e := &Exemption{Secret: []byte("secret")}
+	relaxed := &Exemption{Secret: []byte("secret"), Quota: &Quota{Limit: 5, Within: time.Hour}}
+	cookie := issueExemption(e, "1.2.3.4")
+
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{Exemption: e})
+	relaxedPolicy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{Exemption: relaxed})
+
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		policy(recorder, exemptRequest("1.2.3.4", cookie))
+		expectStatusCode(t, http.StatusOK, recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	relaxedPolicy(recorder, exemptRequest("1.2.3.4", cookie))
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "5")
+
+	recorder = httptest.NewRecorder()
+	relaxedPolicy(recorder, exemptRequest("1.2.3.4", nil))
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "1")
+}
+
+func TestExemptionUsesClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	e := &Exemption{Secret: []byte("secret"), TTL: time.Hour, Clock: clock}
+	cookie := issueExemption(e, "1.2.3.4")
+	policy := Policy(&Quota{Limit: 1, Within: 24 * time.Hour}, &Options{Exemption: e, Clock: clock})
+
+	expectSame(t, cookie.Expires.Unix(), int64(1000+3600))
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		policy(recorder, exemptRequest("1.2.3.4", cookie))
+		expectStatusCode(t, http.StatusOK, recorder.Code)
+	}
+
+	clock.Advance(time.Hour)
+	expectSame(t, e.IsExempt(exemptRequest("1.2.3.4", cookie)), false)
+	for _, code := range []int{http.StatusOK, StatusTooManyRequests} {
+		recorder := httptest.NewRecorder()
+		policy(recorder, exemptRequest("1.2.3.4", cookie))
+		expectStatusCode(t, code, recorder.Code)
+	}
+}
+
+func TestExemptionWithoutSecret(t *testing.T) {
+	e := &Exemption{}
+	expectSame(t, e.IsExempt(exemptRequest("1.2.3.4", issueExemption(e, "1.2.3.4"))), false)
+
+	defer func() {
+		expectSame(t, recover(), "Throttle Config Error: An Exemption requires a Secret to sign cookies with")
+	}()
+
+	Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{Exemption: e})
+}
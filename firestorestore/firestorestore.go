@@ -0,0 +1,221 @@
+// Package firestorestore provides a throttle store for Google Cloud Firestore,
+// for serverless deployments where running Redis adds operational overhead.
+//
+// Every key is a document in a collection, holding the value and the time the
+// value expires. Expired documents are ignored, and can be removed by a
+// Firestore TTL policy on the "expires" field.
+package firestorestore
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// The default collection name
+	defaultCollection = "throttle"
+
+	// The default timeout for operations
+	defaultTimeout = time.Second
+)
+
+// A Store keeps throttle state in a Firestore collection
+type Store struct {
+	client  *firestore.Client
+	options *Options
+}
+
+type Options struct {
+	// The collection to keep the documents in
+	// defaults to "throttle"
+	Collection string
+
+	// The time to live for keys created with Set, existing keys keep their
+	// expiry
+	// defaults to no expiry
+	TTL time.Duration
+
+	// The timeout for every operation
+	// defaults to 1 second
+	Timeout time.Duration
+}
+
+// Error Type for the store
+type StoreError string
+
+// The Error for the Store
+func (err StoreError) Error() string {
+	return "Throttle Firestore Store Error: " + string(err)
+}
+
+// A document holding a value
+type document struct {
+	Value   []byte    `firestore:"value"`
+	Expires time.Time `firestore:"expires,omitempty"`
+}
+
+// Check if the document has expired
+func (d *document) isExpired(now time.Time) bool {
+	return !d.Expires.IsZero() && !now.Before(d.Expires)
+}
+
+// Get a key, will return an error if the key does not exist or has expired
+func (s *Store) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+
+	snapshot, err := s.doc(key).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &document{}
+	if err := snapshot.DataTo(d); err != nil {
+		return nil, err
+	}
+	if d.isExpired(time.Now()) {
+		return nil, StoreError("Key " + key + " does not exist")
+	}
+
+	return d.Value, nil
+}
+
+// Set a key in a transaction. Keys which exist keep their expiry, e.g. the
+// time window of an access count; new keys expire after the configured TTL
+func (s *Store) Set(key string, value []byte) error {
+	_, err := s.Update(key, func([]byte) ([]byte, error) {
+		return value, nil
+	}, s.options.TTL)
+
+	return err
+}
+
+// Set a key to the value the given function returns for its value, or for
+// nil if it does not exist or has expired, in a transaction. Firestore runs
+// the function again if the document changes concurrently. Keys which exist
+// keep their expiry, new keys expire after the given ttl. Returns the value set
+func (s *Store) Update(key string, update func([]byte) ([]byte, error), ttl time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+
+	var value []byte
+	ref := s.doc(key)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing, err := current(tx, ref)
+		if err != nil {
+			return err
+		}
+
+		d := newDocument(nil, ttl)
+		var previous []byte
+		if existing != nil {
+			d.Expires, previous = existing.Expires, existing.Value
+		}
+		if d.Value, err = update(previous); err != nil {
+			return err
+		}
+
+		value = d.Value
+		return tx.Set(ref, d)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// Get a key, or set it to the initial value expiring after the given ttl if it
+// does not exist or has expired, in a transaction. Returns true if the initial value was set
+func (s *Store) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+
+	var value []byte
+	var created bool
+	ref := s.doc(key)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing, err := current(tx, ref)
+		if err != nil {
+			return err
+		} else if existing != nil {
+			value, created = existing.Value, false
+			return nil
+		}
+
+		value, created = initial, true
+		return tx.Set(ref, newDocument(initial, ttl))
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, created, nil
+}
+
+// Get the document of the given reference in the given transaction, or nil
+// if it does not exist or has expired
+func current(tx *firestore.Transaction, ref *firestore.DocumentRef) (*document, error) {
+	snapshot, err := tx.Get(ref)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	d := &document{}
+	if err := snapshot.DataTo(d); err != nil {
+		return nil, err
+	} else if d.isExpired(time.Now()) {
+		return nil, nil
+	}
+
+	return d, nil
+}
+
+// The document for the given key. Keys are escaped, since document ids may not contain slashes
+func (s *Store) doc(key string) *firestore.DocumentRef {
+	return s.client.Collection(s.options.Collection).Doc(url.PathEscape(key))
+}
+
+// Return a new document with the given value, expiring after the given ttl
+func newDocument(value []byte, ttl time.Duration) *document {
+	d := &document{
+		Value: value,
+	}
+	if ttl > 0 {
+		d.Expires = time.Now().Add(ttl)
+	}
+
+	return d
+}
+
+// Returns a store using the given client
+func New(client *firestore.Client, options ...*Options) *Store {
+	o := &Options{
+		Collection: defaultCollection,
+		Timeout:    defaultTimeout,
+	}
+
+	if len(options) != 0 {
+		if options[0].Collection != "" {
+			o.Collection = options[0].Collection
+		}
+		if options[0].TTL != 0 {
+			o.TTL = options[0].TTL
+		}
+		if options[0].Timeout != 0 {
+			o.Timeout = options[0].Timeout
+		}
+	}
+
+	return &Store{
+		client,
+		o,
+	}
+}
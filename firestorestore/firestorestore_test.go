@@ -0,0 +1,81 @@
+package firestorestore
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/martini-contrib/throttle"
+)
+
+func TestDocumentExpiry(t *testing.T) {
+	now := time.Now()
+
+	if (&document{}).isExpired(now) {
+		t.Errorf("Expected documents without expiry to never expire")
+	}
+	if !newDocument(nil, time.Millisecond).isExpired(now.Add(time.Second)) {
+		t.Errorf("Expected the document to be expired")
+	}
+}
+
+// Runs against the emulator in FIRESTORE_EMULATOR_HOST
+func TestStore(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST not set")
+	}
+
+	client, err := firestore.NewClient(context.Background(), "throttle-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	store := New(client)
+	key := "test/" + time.Now().Format(time.RFC3339Nano)
+	if _, err := store.Get(key); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+	if _, created, err := store.GetOrCreate(key, []byte("1"), time.Minute); err != nil || !created {
+		t.Errorf("Expected the key to be created, but got %v, %v", created, err)
+	}
+	if value, created, err := store.GetOrCreate(key, []byte("2"), time.Minute); err != nil || created || string(value) != "1" {
+		t.Errorf("Expected the existing value 1, but got %q, %v, %v", value, created, err)
+	}
+	if err := store.Set(key, []byte("3")); err != nil {
+		t.Error(err)
+	}
+	if value, err := store.Get(key); err != nil || string(value) != "3" {
+		t.Errorf("Expected the value 3, but got %q, %v", value, err)
+	}
+
+	expires := func() time.Time {
+		snapshot, err := store.doc(key).Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &document{}
+		snapshot.DataTo(d)
+		return d.Expires
+	}
+	if expires().IsZero() {
+		t.Errorf("Expected Set to keep the expiry of the key")
+	}
+	if value, err := store.Update(key, func(value []byte) ([]byte, error) {
+		return append(value, '4'), nil
+	}, time.Minute); err != nil || string(value) != "34" {
+		t.Errorf("Expected the updated value 34, but got %q, %v", value, err)
+	}
+}
+
+func TestStoreInterfaces(t *testing.T) {
+	var store throttle.KeyValueStorer = New(nil)
+	if _, ok := store.(throttle.KeyValueCreator); !ok {
+		t.Errorf("Expected the store to create keys atomically")
+	}
+	if _, ok := store.(throttle.KeyValueUpdater); !ok {
+		t.Errorf("Expected the store to update keys atomically")
+	}
+}
@@ -0,0 +1,93 @@
+package throttle
+
+import (
+	"encoding/json"
+	"html"
+	"time"
+)
+
+// Formatter is the interface for the Formatter Option
+// It formats the messages of denied, banned and rejected requests as the
+// body of the response
+type Formatter interface {
+	// The content type of the formatted messages
+	ContentType() string
+	// Format the given message. The data is nil for rejected requests
+	Format(message string, data *MessageData) []byte
+}
+
+// The PlainTextFormatter writes messages as they are, the default formatter
+type PlainTextFormatter struct{}
+
+// The content type of plain text
+func (f PlainTextFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Format the message as plain text
+func (f PlainTextFormatter) Format(message string, data *MessageData) []byte {
+	return []byte(message)
+}
+
+// The JSONFormatter writes messages as a JSON object with the message and
+// the limits of the quota, e.g.
+// {"message":"Too Many Requests","limit":10,"remaining":0,"retry_after":30,"reset_at":"..."}
+type JSONFormatter struct{}
+
+// The body written by the JSONFormatter
+type jsonMessage struct {
+	Message    string     `json:"message"`
+	Limit      *uint64    `json:"limit,omitempty"`
+	Remaining  *uint64    `json:"remaining,omitempty"`
+	RetryAfter *int64     `json:"retry_after,omitempty"`
+	ResetAt    *time.Time `json:"reset_at,omitempty"`
+}
+
+// The content type of JSON
+func (f JSONFormatter) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+// Format the message and data as JSON
+func (f JSONFormatter) Format(message string, data *MessageData) []byte {
+	m := &jsonMessage{Message: message}
+	if data != nil {
+		resetAt := data.ResetAt.UTC()
+		m.Limit = &data.Limit
+		m.Remaining = &data.Remaining
+		m.RetryAfter = &data.RetryAfter
+		m.ResetAt = &resetAt
+	}
+
+	formatted, err := json.Marshal(m)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return formatted
+}
+
+// The HTMLFormatter writes messages as a minimal HTML page, for policies
+// in front of pages viewed in browsers
+type HTMLFormatter struct{}
+
+// The content type of HTML
+func (f HTMLFormatter) ContentType() string {
+	return "text/html; charset=utf-8"
+}
+
+// Format the message as an HTML page, escaping the message
+func (f HTMLFormatter) Format(message string, data *MessageData) []byte {
+	escaped := html.EscapeString(message)
+	return []byte("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + escaped + "</title>\n</head>\n<body>\n<h1>" + escaped + "</h1>\n</body>\n</html>\n")
+}
+
+// Get the content type of the messages of the policy, the ContentType
+// option or the content type of the formatter
+func (p *policy) contentType() string {
+	if p.options.ContentType != "" {
+		return p.options.ContentType
+	}
+
+	return p.options.Formatter.ContentType()
+}
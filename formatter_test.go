@@ -0,0 +1,72 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlainTextFormatter(t *testing.T) {
+	f := PlainTextFormatter{}
+	expectSame(t, f.ContentType(), "text/plain; charset=utf-8")
+	expectSame(t, string(f.Format("Too Many Requests", &MessageData{})), "Too Many Requests")
+}
+
+func TestJSONFormatter(t *testing.T) {
+	f := JSONFormatter{}
+	expectSame(t, f.ContentType(), "application/json; charset=utf-8")
+
+	resetAt := time.Unix(100, 0)
+	formatted := f.Format("Too Many Requests", &MessageData{
+		Limit:      10,
+		Remaining:  0,
+		RetryAfter: 30,
+		ResetAt:    resetAt,
+	})
+
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(formatted, &m); err != nil {
+		t.Fatalf("Expected JSON, got %s", formatted)
+	}
+	expectSame(t, m["message"], "Too Many Requests")
+	expectSame(t, m["limit"], float64(10))
+	expectSame(t, m["remaining"], float64(0))
+	expectSame(t, m["retry_after"], float64(30))
+	expectSame(t, m["reset_at"], resetAt.UTC().Format(time.RFC3339))
+
+	// rejected requests have no data
+	expectSame(t, string(f.Format("Missing Identity", nil)), `{"message":"Missing Identity"}`)
+}
+
+func TestHTMLFormatter(t *testing.T) {
+	f := HTMLFormatter{}
+	expectSame(t, f.ContentType(), "text/html; charset=utf-8")
+
+	formatted := string(f.Format("<Slow down>", &MessageData{}))
+	expectSame(t, strings.Contains(formatted, "<h1>&lt;Slow down&gt;</h1>"), true)
+}
+
+func TestPolicyWithFormatter(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Formatter: JSONFormatter{},
+	})
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("Content-Type"), "application/json; charset=utf-8")
+	expectSame(t, strings.HasPrefix(resp.Body.String(), `{"message":"Too Many Requests","limit":1,`), true)
+}
+
+func TestContentTypeOption(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Formatter:   JSONFormatter{},
+		ContentType: "application/problem+json",
+	})
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	resp := serveMethod(policy, "GET")
+	expectSame(t, resp.Header().Get("Content-Type"), "application/problem+json")
+}
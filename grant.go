@@ -0,0 +1,97 @@
+package throttle
+
+import (
+	"time"
+)
+
+// A grant of extra capacity to a requester, will be stored in the key value store
+type grant struct {
+	Extra uint64    `json:"extra"`
+	Until time.Time `json:"until"`
+	// The time the grant is removed from the store, when it ends
+	Expires time.Time `json:"expires"`
+}
+
+// Get the extra capacity granted to the given id at the given time
+func (g *grant) ExtraAt(now time.Time) uint64 {
+	if now.Before(g.Until) {
+		return g.Extra
+	}
+
+	return 0
+}
+
+// Get the grant of the given id
+func (c *controller) GetGrant(id string) *grant {
+	g := &grant{}
+	if grantBytes, err := c.store.Get(c.sideKey(id, "grant")); err == nil {
+		if err := c.codec.Decode(grantBytes, g); err != nil {
+			panic(err.Error())
+		}
+	}
+
+	return g
+}
+
+// Set the grant of the given id, expiring when it ends, will write to the
+// store
+func (c *controller) SetGrant(id string, g *grant) {
+	g.Expires = c.now()
+	if g.Until.After(g.Expires) {
+		g.Expires = g.Until
+	}
+
+	marshalled, err := c.codec.Encode(g)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	err = c.store.Set(c.sideKey(id, "grant"), marshalled)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// Add the given extra capacity to the grant of the given id, lasting for at
+// least the given time. Extra capacity of a grant still in effect is kept
+func (c *controller) AddGrant(id string, extra uint64, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	g := c.GetGrant(id)
+	g.Extra = g.ExtraAt(now) + extra
+	if until := now.Add(ttl); until.After(g.Until) {
+		g.Until = until
+	}
+	c.SetGrant(id, g)
+}
+
+// Return a controller with the limit of the quota raised by the extra
+// capacity granted to the given id, or the controller if nothing is granted
+func (c *controller) granted(id string) *controller {
+	extra := c.GetGrant(id).ExtraAt(c.now())
+	if extra == 0 {
+		return c
+	}
+
+	raised := *c.quota
+	raised.Limit += extra
+	if raised.Burst != 0 {
+		raised.Burst += extra
+	}
+
+	return c.withQuota(&raised)
+}
+
+// Grant extra capacity to the requester with the given identity, as
+// returned by the identification function or chain, for the given time,
+// e.g. after a customer bought a burst pack. The extra capacity is added to
+// the limit of the quota of the policy and the quotas of the identification
+// chain, and reflected in the rate limit headers. Grants add up while in
+// effect, and are kept on Reset
+func (l *Limiter) Grant(id string, extra uint64, ttl time.Duration) {
+	l.eachKey(id, func(c *controller, key string) {
+		c.AddGrant(key, extra, ttl)
+	})
+}
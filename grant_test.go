@@ -0,0 +1,66 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterGrant(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Clock: clock,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(limiter.ServeHTTP, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(limiter.ServeHTTP, "GET").Code)
+
+	limiter.Grant("1.2.3.4", 2, time.Minute)
+	expectSame(t, limiter.Status("1.2.3.4").Limit, uint64(3))
+	expectSame(t, limiter.Status("1.2.3.4").Remaining, uint64(2))
+
+	resp := serveMethod(limiter.ServeHTTP, "GET")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "3")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "1")
+	expectStatusCode(t, http.StatusOK, serveMethod(limiter.ServeHTTP, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(limiter.ServeHTTP, "GET").Code)
+
+	// grants in effect add up
+	limiter.Grant("1.2.3.4", 1, time.Minute)
+	expectSame(t, limiter.Status("1.2.3.4").Limit, uint64(4))
+	expectStatusCode(t, http.StatusOK, serveMethod(limiter.ServeHTTP, "GET").Code)
+
+	// the grant ends after its time
+	clock.Advance(time.Minute)
+	expectSame(t, limiter.Status("1.2.3.4").Limit, uint64(1))
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(limiter.ServeHTTP, "GET").Code)
+}
+
+func TestLimiterGrantKeptOnReset(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour})
+
+	limiter.Grant("1.2.3.4", 2, time.Hour)
+	limiter.Reset("1.2.3.4")
+	expectSame(t, limiter.Status("1.2.3.4").Limit, uint64(3))
+}
+
+func TestLimiterGrantOutsideIdentities(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		IdentificationFunction: IdentifyByHeader("X-User"),
+	})
+	serveAs := func(user string) int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		recorder := httptest.NewRecorder()
+		limiter.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	limiter.Grant("victim", 2, time.Hour)
+
+	// the count of this identity does not overwrite the grant of the victim
+	expectStatusCode(t, http.StatusOK, serveAs("victim_grant"))
+	expectSame(t, limiter.Status("victim").Limit, uint64(3))
+}
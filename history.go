@@ -0,0 +1,83 @@
+package throttle
+
+import "time"
+
+// The count of a previous time window, will be stored in the access count
+type windowCount struct {
+	Start time.Time `json:"start"`
+	Count uint64    `json:"count"`
+}
+
+// The WindowUsage is the number of accesses of a requester within a previous
+// time window, see Limiter.History
+type WindowUsage struct {
+	// The time the time window started
+	Start time.Time
+	// The number of accesses within the time window
+	Count uint64
+}
+
+// Check if the controller keeps the counts of previous time windows, with
+// the History option and the FixedWindow algorithm
+func (c *controller) keepsHistory() bool {
+	return c.history > 0 && c.quota.Algorithm == FixedWindow
+}
+
+// Get the number of time windows after the current one access counts are
+// kept for, to accumulate the rollover or for the history
+func (c *controller) retainedWindows() uint64 {
+	windows := c.rolloverWindows()
+	if c.keepsHistory() && uint64(c.history) > windows {
+		windows = uint64(c.history)
+	}
+
+	return windows
+}
+
+// Record the count of the time window of the counter in its history before
+// it starts a new time window at the given time, keeping the counts of the
+// most recent time windows only. Time windows without accesses are not
+// recorded
+func (c *controller) record(counter *accessCount, now time.Time) {
+	if !c.keepsHistory() || counter.Count == 0 || counter.IsFreshAt(now) {
+		return
+	}
+
+	counter.History = append(counter.History, windowCount{counter.Start, counter.Count})
+	if excess := len(counter.History) - c.history; excess > 0 {
+		counter.History = append([]windowCount{}, counter.History[excess:]...)
+	}
+}
+
+// Get the usage of the previous time windows of the requester with the given
+// identity, as returned by the identification function, for the quota of the
+// policy, oldest first. Keeps the counts of the number of time windows of the
+// History option, e.g. to display trends on an API usage page. The current
+// time window is part of the Status. Time windows without accesses are
+// missing, and the history is dropped on Reset
+func (l *Limiter) History(id string) []WindowUsage {
+	p, _ := l.current()
+	c := p.controller
+	if !c.keepsHistory() {
+		return nil
+	}
+
+	key := makeKey(p.prefix, p.options.KeyIdFunction(c.quota), p.limiterIdentity(id))
+	counter := c.GetAccessCount(key)
+
+	// the time window of a stale count is part of the history already
+	history := counter.History
+	if !counter.IsFreshAt(c.now()) && counter.Count > 0 {
+		history = append(append([]windowCount{}, history...), windowCount{counter.Start, counter.Count})
+	}
+	if len(history) > c.history {
+		history = history[len(history)-c.history:]
+	}
+
+	usage := make([]WindowUsage, len(history))
+	for i, w := range history {
+		usage[i] = WindowUsage{w.Start, w.Count}
+	}
+
+	return usage
+}
@@ -0,0 +1,63 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	start := clock.Now().UTC()
+	// the key outlives the windows of the history, which the store expires
+	// by the clock
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Minute}, &Options{
+		Clock:   clock,
+		History: 2,
+		KeyTTL:  time.Hour,
+	})
+
+	expectSame(t, len(limiter.History("1.2.3.4")), 0)
+
+	for _, requests := range []int{3, 1, 2} {
+		for i := 0; i < requests; i++ {
+			serveMethod(limiter.ServeHTTP, "GET")
+		}
+		clock.Advance(time.Minute)
+	}
+
+	// the most recent windows, including the stale current one
+	history := limiter.History("1.2.3.4")
+	expectSame(t, len(history), 2)
+	expectSame(t, history[0].Start, start.Add(time.Minute))
+	expectSame(t, history[0].Count, uint64(1))
+	expectSame(t, history[1].Start, start.Add(2*time.Minute))
+	expectSame(t, history[1].Count, uint64(2))
+
+	serveMethod(limiter.ServeHTTP, "GET")
+	history = limiter.History("1.2.3.4")
+	expectSame(t, len(history), 2)
+	expectSame(t, history[1].Count, uint64(2))
+	expectSame(t, limiter.Status("1.2.3.4").Count, uint64(1))
+}
+
+func TestHistoryWithoutOption(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Minute}, &Options{
+		Clock: clock,
+	})
+
+	serveMethod(limiter.ServeHTTP, "GET")
+	clock.Advance(time.Minute)
+	serveMethod(limiter.ServeHTTP, "GET")
+
+	if history := limiter.History("1.2.3.4"); history != nil {
+		t.Errorf("Expected no history, got %v", history)
+	}
+}
+
+func TestHistoryTTL(t *testing.T) {
+	o := newOptions([]*Options{{History: 4}})
+	expectSame(t, newController(&Quota{Limit: 3, Within: time.Minute}, o).ttl(), 5*time.Minute)
+	expectSame(t, newController(&Quota{Limit: 3, Within: time.Minute, Rollover: 30}, o).ttl(), 11*time.Minute)
+	expectSame(t, newController(&Quota{Limit: 3, Within: time.Minute, Algorithm: SlidingWindowLog}, o).ttl(), time.Minute)
+}
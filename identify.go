@@ -0,0 +1,173 @@
+package throttle
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The behavior for requesters the identification function returns an empty
+// identity for
+type EmptyIdentityMode int
+
+const (
+	// Requesters with an empty identity share one bucket
+	EmptyIdentityShared EmptyIdentityMode = iota
+	// Requesters with an empty identity bypass the throttle
+	EmptyIdentityBypass
+	// Requesters with an empty identity are denied with 400 Bad Request
+	EmptyIdentityDeny
+	// Requesters with an empty identity are identified by their connection,
+	// the remote address including the port
+	EmptyIdentityConnection
+)
+
+// The default identifier function. Identifies a client by IP
+func defaultIdentify(req *http.Request) string {
+	if forwardedFor := req.Header.Get(forwardedForHeader); forwardedFor != "" {
+		if ip := normalizeIP(forwardedFor); ip != "" {
+			return ip
+		}
+	}
+
+	return identifyConnection(req)
+}
+
+// Identify a client by the remote address of the connection only, ignoring
+// headers the client can set
+func identifyConnection(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	if ip := normalizeIP(host); ip != "" {
+		return ip
+	}
+	return host
+}
+
+// Returns an identifier function identifying a client by the network of its
+// IP, with the given prefix lengths for IPv4 and IPv6, e.g. "1.2.3.0/24" for
+// IPv4 networks of 24 bits. Clients without a valid IP are identified as by
+// the default identification
+func IdentifyBySubnet(ipv4Bits int, ipv6Bits int) func(*http.Request) string {
+	return func(req *http.Request) string {
+		identity := defaultIdentify(req)
+		ip := net.ParseIP(identity)
+		if ip == nil {
+			return identity
+		}
+
+		if ipv4 := ip.To4(); ipv4 != nil {
+			network := &net.IPNet{IP: ipv4.Mask(net.CIDRMask(ipv4Bits, 32)), Mask: net.CIDRMask(ipv4Bits, 32)}
+			return network.String()
+		}
+
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6Bits, 128)), Mask: net.CIDRMask(ipv6Bits, 128)}
+		return network.String()
+	}
+}
+
+// Returns an identifier function identifying a client by the value of the
+// given header, e.g. an API key. Returns an empty string if the header is missing
+func IdentifyByHeader(header string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		return req.Header.Get(header)
+	}
+}
+
+// Returns an identifier function identifying a client by the username of
+// basic auth credentials. Anonymous requests are identified by IP, prefixed
+// by "anonymous:" so they never share a bucket with a user, as usernames of
+// basic auth cannot contain colons. The password is not checked, so the
+// policy has to run after the handler authenticating the user
+func IdentifyByBasicAuthUser() func(*http.Request) string {
+	return func(req *http.Request) string {
+		if user, _, ok := req.BasicAuth(); ok && user != "" {
+			return user
+		}
+
+		return "anonymous:" + defaultIdentify(req)
+	}
+}
+
+// An identifier function identifying a client by the verified client
+// certificate of a mutual TLS connection: the common name of its subject,
+// or its first DNS, URI or email subject alternative name. Returns an empty
+// string without a verified client certificate, as subjects of unverified
+// certificates can be chosen freely
+func IdentifyByClientCertificate(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return certificateSubject(req.TLS.VerifiedChains[0][0])
+}
+
+// An identifier function identifying a client by the SHA-256 fingerprint of
+// the client certificate of a mutual TLS connection, in hex. The client
+// proves to hold the key of the certificate, so the certificate does not
+// have to be verified. Returns an empty string without a client certificate
+func IdentifyByClientCertificateFingerprint(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get the common name of the subject of the given certificate, or its first
+// subject alternative name
+func certificateSubject(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+
+	return ""
+}
+
+// Return the identity of the requester with the given identity on the given
+// host, as counted with the KeyByHost option, e.g. to reset the requester on
+// one domain with a Limiter. Hosts are compared without port and case
+func HostIdentity(host string, id string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return makeKey(strings.ToLower(host), id)
+}
+
+// Normalize the given IP, so the same client always has the same identity:
+// Brackets and zones are removed from IPv6 literals, and IPv4-mapped IPv6
+// addresses are returned as IPv4. Returns an empty string for invalid IPs
+func normalizeIP(ip string) string {
+	ip = strings.TrimSpace(ip)
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	if zone := strings.IndexByte(ip, '%'); zone != -1 {
+		ip = ip[:zone]
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		return ipv4.String()
+	}
+
+	return parsed.String()
+}
@@ -0,0 +1,273 @@
+package throttle
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func identifyRemoteAddr(remoteAddr string, forwardedFor string) string {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+
+	return defaultIdentify(req)
+}
+
+func TestDefaultIdentify(t *testing.T) {
+	expectSame(t, identifyRemoteAddr("1.2.3.4:5000", ""), "1.2.3.4")
+	expectSame(t, identifyRemoteAddr("1.2.3.4", ""), "1.2.3.4")
+	expectSame(t, identifyRemoteAddr("1.2.3.4:5000", "2.3.4.5"), "2.3.4.5")
+	expectSame(t, identifyRemoteAddr("1.2.3.4:5000", "not an ip"), "1.2.3.4")
+}
+
+func TestDefaultIdentifyIPv6(t *testing.T) {
+	expectSame(t, identifyRemoteAddr("[2001:db8::1]:5000", ""), "2001:db8::1")
+	expectSame(t, identifyRemoteAddr("[2001:DB8:0::1]:5000", ""), "2001:db8::1")
+	expectSame(t, identifyRemoteAddr("2001:db8::1", ""), "2001:db8::1")
+	expectSame(t, identifyRemoteAddr("[2001:db8::1]", ""), "2001:db8::1")
+	expectSame(t, identifyRemoteAddr("[fe80::1%eth0]:5000", ""), "fe80::1")
+	expectSame(t, identifyRemoteAddr("[::ffff:1.2.3.4]:5000", ""), "1.2.3.4")
+	expectSame(t, identifyRemoteAddr("1.2.3.4:5000", "[2001:db8::1]"), "2001:db8::1")
+	expectSame(t, identifyRemoteAddr("1.2.3.4:5000", "::ffff:2.3.4.5"), "2.3.4.5")
+}
+
+func TestIdentifyByHeader(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", "secret")
+
+	expectSame(t, IdentifyByHeader("X-API-Key")(req), "secret")
+	expectSame(t, IdentifyByHeader("X-Other")(req), "")
+}
+
+func TestIdentifyByBasicAuthUser(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4:5000"
+	expectSame(t, IdentifyByBasicAuthUser()(req), "anonymous:1.2.3.4")
+
+	req.SetBasicAuth("alice", "secret")
+	expectSame(t, IdentifyByBasicAuthUser()(req), "alice")
+
+	req.SetBasicAuth("", "secret")
+	expectSame(t, IdentifyByBasicAuthUser()(req), "anonymous:1.2.3.4")
+}
+
+func TestPolicyByBasicAuthUser(t *testing.T) {
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{
+		IdentificationFunction: IdentifyByBasicAuthUser(),
+	})
+	alice := map[string]string{"Authorization": "Basic YWxpY2U6c2VjcmV0"}
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    alice,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+		Headers:    alice,
+	}, &Expectation{ // Anonymous requests from the same IP have a bucket of their own
+		StatusCode: http.StatusOK,
+	})
+}
+
+func TestMalformedIdentityIdentifiedByIP(t *testing.T) {
+	m := setupMartiniWithPolicy(1, 20*time.Millisecond, &Options{
+		IdentificationFunction: IdentifyByHeader("X-API-Key"),
+		IdentityFormat:         regexp.MustCompile(`^[a-f0-9]{8}$`),
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-API-Key": "garbage1"},
+	}, &Expectation{ // Malformed identities share the bucket of the IP
+		StatusCode: StatusTooManyRequests,
+		Headers:    map[string]string{"X-API-Key": "garbage2"},
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-API-Key": "deadbeef"},
+	})
+}
+
+func TestMalformedIdentityRejected(t *testing.T) {
+	m := setupMartiniWithPolicy(1, 20*time.Millisecond, &Options{
+		IdentificationChain: []*Identification{
+			&Identification{
+				Name:     "key",
+				Function: IdentifyByHeader("X-API-Key"),
+			},
+		},
+		IdentityMaxLength:         8,
+		RejectMalformedIdentities: true,
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusBadRequest,
+		Body:       "Malformed Identity",
+		Headers:    map[string]string{"X-API-Key": "much-too-long"},
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-API-Key": "short"},
+	})
+}
+
+func serveEmptyIdentities(t *testing.T, mode EmptyIdentityMode, statusCodes ...int) int {
+	empty := 0
+	policy := Policy(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, &Options{
+		IdentificationFunction: IdentifyByHeader("X-API-Key"),
+		EmptyIdentity:          mode,
+		OnEmptyIdentity: func(req *http.Request) {
+			empty++
+		},
+		Store: NewMapStore(accessCount{}),
+	})
+
+	for i, statusCode := range statusCodes {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4:500" + string(rune('0'+i))
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+
+		expectStatusCode(t, statusCode, recorder.Code)
+	}
+
+	return empty
+}
+
+func TestEmptyIdentityShared(t *testing.T) {
+	empty := serveEmptyIdentities(t, EmptyIdentityShared, http.StatusOK, StatusTooManyRequests)
+	expectSame(t, empty, 2)
+}
+
+func TestEmptyIdentityBypass(t *testing.T) {
+	empty := serveEmptyIdentities(t, EmptyIdentityBypass, http.StatusOK, http.StatusOK, http.StatusOK)
+	expectSame(t, empty, 3)
+}
+
+func TestEmptyIdentityDeny(t *testing.T) {
+	empty := serveEmptyIdentities(t, EmptyIdentityDeny, http.StatusBadRequest, http.StatusBadRequest)
+	expectSame(t, empty, 2)
+}
+
+func TestEmptyIdentityConnection(t *testing.T) {
+	empty := serveEmptyIdentities(t, EmptyIdentityConnection, http.StatusOK, http.StatusOK)
+	expectSame(t, empty, 2)
+}
+
+func TestEmptyIdentityNotObservedForIdentifiedRequests(t *testing.T) {
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{
+		IdentificationFunction: IdentifyByHeader("X-API-Key"),
+		EmptyIdentity:          EmptyIdentityDeny,
+		OnEmptyIdentity: func(req *http.Request) {
+			t.Errorf("Expected no empty identity")
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-API-Key": "secret"},
+	})
+}
+
+func TestIdentifyByClientCertificate(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	named := &x509.Certificate{Raw: []byte("named"), Subject: pkix.Name{CommonName: "billing"}}
+	unnamed := &x509.Certificate{Raw: []byte("unnamed"), URIs: []*url.URL{spiffe}}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	expectSame(t, IdentifyByClientCertificate(req), "")
+	expectSame(t, IdentifyByClientCertificateFingerprint(req), "")
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{named}}
+	expectSame(t, IdentifyByClientCertificate(req), "")
+	expectSame(t, len(IdentifyByClientCertificateFingerprint(req)), 64)
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{named}}
+	expectSame(t, IdentifyByClientCertificate(req), "billing")
+
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{unnamed},
+		VerifiedChains:   [][]*x509.Certificate{{unnamed}},
+	}
+	expectSame(t, IdentifyByClientCertificate(req), "spiffe://example.org/billing")
+	expectDifferent(t, IdentifyByClientCertificateFingerprint(req), "")
+}
+
+func TestHostIdentity(t *testing.T) {
+	expectSame(t, HostIdentity("example.com", "1.2.3.4"), "example.com_1.2.3.4")
+	expectSame(t, HostIdentity("Example.COM:8080", "1.2.3.4"), "example.com_1.2.3.4")
+}
+
+func TestKeyByHost(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		KeyByHost: true,
+	})
+	serveHost := func(host string) int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		req.Host = host
+		recorder := httptest.NewRecorder()
+		limiter.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	expectStatusCode(t, http.StatusOK, serveHost("a.example.com"))
+	expectStatusCode(t, StatusTooManyRequests, serveHost("a.example.com"))
+	expectStatusCode(t, http.StatusOK, serveHost("b.example.com"))
+	expectStatusCode(t, StatusTooManyRequests, serveHost("A.example.com:443"))
+
+	limiter.Reset(HostIdentity("a.example.com", "1.2.3.4"))
+	expectStatusCode(t, http.StatusOK, serveHost("a.example.com"))
+	expectStatusCode(t, StatusTooManyRequests, serveHost("b.example.com"))
+}
+
+func TestKeyByHostLimiter(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		KeyByHost:           true,
+		IdentityCompression: &IdentityCompression{MinLength: 4},
+	})
+	serveHost := func(host string) int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		req.Host = host
+		recorder := httptest.NewRecorder()
+		limiter.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	id := HostIdentity("a.example.com", "1.2.3.4")
+	expectStatusCode(t, http.StatusOK, serveHost("a.example.com"))
+	expectSame(t, limiter.Status(id).Remaining, uint64(0))
+
+	limiter.Reset(id)
+	expectSame(t, limiter.Status(id).Remaining, uint64(1))
+	expectStatusCode(t, http.StatusOK, serveHost("a.example.com"))
+
+	limiter.Reset(id)
+	limiter.Freeze(id, time.Now().Add(time.Hour))
+	expectStatusCode(t, StatusTooManyRequests, serveHost("a.example.com"))
+	expectStatusCode(t, http.StatusOK, serveHost("b.example.com"))
+}
+
+func TestIdentifyBySubnet(t *testing.T) {
+	identify := IdentifyBySubnet(24, 48)
+	for remoteAddr, expected := range map[string]string{
+		"1.2.3.4:1234":         "1.2.3.0/24",
+		"[2001:db8:1:2::1]:80": "2001:db8:1::/48",
+		"::ffff:1.2.3.4":       "1.2.3.0/24",
+		"pipe":                 "pipe",
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		expectSame(t, identify(req), expected)
+	}
+}
@@ -0,0 +1,140 @@
+package throttle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// The default length above which identities are hashed in keys
+	defaultCompressionMinLength = 32
+
+	// The default number of hexadecimal characters of the hashes
+	defaultCompressionHashLength = 16
+
+	// The prefix of hashed identities in keys, telling them apart from
+	// identities which are not hashed
+	hashedIdentityPrefix = "~"
+)
+
+// An IdentityCompression replaces long identities, e.g. emails or the
+// subjects of JWTs, by a short hash of them in the keys of the store,
+// keeping keys small. Hashes are prefixed by "~", and identities which are
+// not hashed but start with "~" by another "~". Hashes of distinct
+// identities may collide and share counters, unless collisions are
+// detected, at the cost of a read of the store for every request
+type IdentityCompression struct {
+	// The length above which identities are hashed
+	// defaults to 32
+	MinLength int
+
+	// The number of hexadecimal characters of the hashes of the default hash
+	// function, at most 64
+	// defaults to 16, hashes of 64 bits
+	HashLength int
+
+	// The function hashing identities, its hashes must not start with "~"
+	// defaults to a truncated SHA-256 hash in hexadecimal characters
+	Hash func(string) string
+
+	// If collisions are detected. The identity of every hash is kept in the
+	// store as long as the counts of the quota of the policy, and identities
+	// with the hash of another identity are not hashed
+	// defaults to false
+	DetectCollisions bool
+}
+
+// The identity of a hash, will be stored in the key value store
+type hashedIdentity struct {
+	Identity string `json:"identity"`
+}
+
+// Get the length above which identities are hashed
+func (c *IdentityCompression) minLength() int {
+	if c.MinLength <= 0 {
+		return defaultCompressionMinLength
+	}
+
+	return c.MinLength
+}
+
+// Hash the given identity with the hash function
+func (c *IdentityCompression) hash(identity string) string {
+	if c.Hash != nil {
+		return c.Hash(identity)
+	}
+
+	length := c.HashLength
+	if length <= 0 {
+		length = defaultCompressionHashLength
+	} else if length > 2*sha256.Size {
+		length = 2 * sha256.Size
+	}
+
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:])[:length]
+}
+
+// The key of the identity of the given hash
+func (p *policy) hashedIdentityKey(hash string) string {
+	return makeKey(p.prefix, "identity", hash)
+}
+
+// Get the identity of the given identity in keys with the IdentityCompression
+// option, hashed if it is longer than the minimum length. With collision
+// detections, identities whose hash is taken by another identity are not
+// hashed
+func (p *policy) compress(identity string) string {
+	compression := p.options.IdentityCompression
+	if compression == nil {
+		return identity
+	} else if len(identity) <= compression.minLength() {
+		return escapeIdentity(identity)
+	}
+
+	hash := hashedIdentityPrefix + compression.hash(identity)
+	if !compression.DetectCollisions {
+		return hash
+	}
+
+	c := p.controller
+	key := p.hashedIdentityKey(hash)
+	marshalled, err := c.codec.Encode(&hashedIdentity{identity})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var existingBytes []byte
+	created := false
+	if creator, ok := c.store.(KeyValueCreator); ok {
+		existingBytes, created, err = creator.GetOrCreate(key, marshalled, c.ttl())
+	} else if existingBytes, err = c.store.Get(key); err != nil {
+		existingBytes, created, err = marshalled, true, c.store.Set(key, marshalled)
+	}
+	if err != nil {
+		panic(err.Error())
+	} else if created {
+		return hash
+	}
+
+	existing := &hashedIdentity{}
+	if err := c.codec.Decode(existingBytes, existing); err != nil {
+		panic(err.Error())
+	}
+	if existing.Identity != identity {
+		return escapeIdentity(identity)
+	}
+
+	return hash
+}
+
+// Escape the given identity which is not hashed, so an identity starting
+// with "~" never takes the key of a hash
+func escapeIdentity(identity string) string {
+	if strings.HasPrefix(identity, hashedIdentityPrefix) {
+		return hashedIdentityPrefix + identity
+	}
+
+	return identity
+}
@@ -0,0 +1,87 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const longIdentity = "someone.with.a.long.address@example.com"
+
+func serveAs(policy func(http.ResponseWriter, *http.Request), user string) int {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+	req.Header.Set("X-User", user)
+	recorder := httptest.NewRecorder()
+	policy(recorder, req)
+	return recorder.Code
+}
+
+func TestIdentityCompressionHash(t *testing.T) {
+	c := &IdentityCompression{}
+	expectSame(t, len(c.hash(longIdentity)), 16)
+	expectSame(t, c.hash(longIdentity), c.hash(longIdentity))
+	expectDifferent(t, c.hash(longIdentity), c.hash(longIdentity+"."))
+
+	expectSame(t, len((&IdentityCompression{HashLength: 8}).hash(longIdentity)), 8)
+	expectSame(t, len((&IdentityCompression{HashLength: 100}).hash(longIdentity)), 64)
+	expectSame(t, (&IdentityCompression{Hash: strings.ToUpper}).hash("abc"), "ABC")
+}
+
+func TestIdentityCompression(t *testing.T) {
+	store := NewMapStore(nil)
+	quota := &Quota{Limit: 1, Within: time.Hour, Name: "compressed"}
+	limiter := NewLimiter(quota, &Options{
+		Store:                  store,
+		IdentificationFunction: identifyUser,
+		IdentityCompression:    &IdentityCompression{},
+	})
+
+	expectSame(t, serveAs(limiter.ServeHTTP, longIdentity), http.StatusOK)
+	expectSame(t, serveAs(limiter.ServeHTTP, longIdentity), StatusTooManyRequests)
+	expectSame(t, serveAs(limiter.ServeHTTP, "short"), http.StatusOK)
+
+	hashed := makeKey(defaultKeyPrefix, HashedKeyId(quota), "~"+(&IdentityCompression{}).hash(longIdentity))
+	if _, err := store.Get(hashed); err != nil {
+		t.Errorf("Expected the count of the hashed identity in the store")
+	}
+	if _, err := store.Get(makeKey(defaultKeyPrefix, HashedKeyId(quota), "short")); err != nil {
+		t.Errorf("Expected the count of the short identity in the store")
+	}
+
+	// the limiter takes identities as identified
+	expectSame(t, limiter.Status(longIdentity).Remaining, uint64(0))
+	limiter.Reset(longIdentity)
+	expectSame(t, serveAs(limiter.ServeHTTP, longIdentity), http.StatusOK)
+}
+
+func TestIdentityCompressionEscapes(t *testing.T) {
+	policy := newPolicy(&Quota{Limit: 1, Within: time.Hour}, newOptions([]*Options{{
+		IdentityCompression: &IdentityCompression{},
+	}}))
+	hash := policy.compress(longIdentity)
+
+	// an identity looking like a hash does not share the counters of the hash
+	expectSame(t, policy.compress(hash), "~"+hash)
+	expectSame(t, policy.compress("~short"), "~~short")
+	expectSame(t, policy.compress("short"), "short")
+}
+
+func TestIdentityCompressionCollisions(t *testing.T) {
+	collidingHash := func(identity string) string {
+		return "collision"
+	}
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour, Name: "colliding"}, &Options{
+		Store:                  NewMapStore(nil),
+		IdentificationFunction: identifyUser,
+		IdentityCompression:    &IdentityCompression{MinLength: 4, Hash: collidingHash, DetectCollisions: true},
+	})
+
+	expectSame(t, serveAs(limiter.ServeHTTP, "first identity"), http.StatusOK)
+	// the second identity does not share the counts of the first
+	expectSame(t, serveAs(limiter.ServeHTTP, "second identity"), http.StatusOK)
+	expectSame(t, serveAs(limiter.ServeHTTP, "first identity"), StatusTooManyRequests)
+	expectSame(t, limiter.KeySchema().Key("identity").Template, "throttle_identity_{hash}")
+}
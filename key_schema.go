@@ -0,0 +1,160 @@
+package throttle
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The separator of the parts of keys
+const keySeparator = "_"
+
+// The placeholder for the identity of the requester in key templates
+const identityPlaceholder = "{identity}"
+
+// The values stored by a policy, as encoded by the JSONCodec
+const (
+	accessCountValue = `{"count":<uint64>,"start":"<RFC 3339 time>","duration":<nanoseconds>,"log":[{"at":<Unix nanoseconds>,"cost":<uint64>}],"refill":<nanoseconds>,"credit":<uint64>,"history":[{"start":"<RFC 3339 time>","count":<uint64>}]}`
+	freezeValue      = `{"until":"<RFC 3339 time>","expires":"<RFC 3339 time>"}`
+	grantValue       = `{"extra":<uint64>,"until":"<RFC 3339 time>","expires":"<RFC 3339 time>"}`
+	violationsValue  = `{"count":<uint64>,"score":<float64>,"last":"<RFC 3339 time>","banned_until":"<RFC 3339 time>","expires":"<RFC 3339 time>"}`
+	scoreValue       = `{"score":<float64>,"updated":"<RFC 3339 time>","expires":"<RFC 3339 time>"}`
+	notesValue       = `{"notes":[{"text":"<string>","link":"<string>","author":"<string>","time":"<RFC 3339 time>"}]}`
+	dualStackValue   = `{"ipv4":"<identity>","ipv6":"<identity>","updated":"<RFC 3339 time>"}`
+	identityValue    = `{"identity":"<string>"}`
+	mergedValue      = `{"identity":"<identity>","until":"<RFC 3339 time>"}`
+	tenantValue      = `{"Limit":<uint64>,"Within":<nanoseconds>,"Name":"<string>","Share":<float64>,"AlignToWindow":<bool>,"AlignZone":"<string>","Algorithm":<int>,"Burst":<uint64>,"MaxBurst":<uint64>,"Rollover":<uint64>}`
+)
+
+// A KeySchema describes how a policy lays out its keys and values in the
+// store, so services not written in Go, e.g. Lua scripts on the edge or
+// admin scripts, can read and write the same keys. Encode it as JSON to hand
+// it to them
+type KeySchema struct {
+	// The prefix of all keys of the policy, including the instance id or the
+	// region of the scope
+	Prefix string `json:"prefix"`
+	// The separator of the parts of keys
+	Separator string `json:"separator"`
+	// The id of the quota of the policy in keys, see the KeyIdFunction option
+	QuotaId string `json:"quota_id"`
+	// The codec of the values, "json", "zlib+json" for the ZlibCodec, or the
+	// Go type of other codecs
+	Codec string `json:"codec"`
+	// If identities are prefixed by the host of the request and the
+	// separator, see the KeyByHost option
+	KeyByHost bool `json:"key_by_host"`
+	// The keys of the policy
+	Keys []*KeyLayout `json:"keys"`
+}
+
+// A KeyLayout describes a key of a policy and its value
+type KeyLayout struct {
+	// The name of the key, e.g. "count", "count:<identification>" for
+	// identifications of the chain or "global:<reservation>" for reservations
+	// of the global quota
+	Name string `json:"name"`
+	// The key, with "{identity}" in place of the identity of the requester
+	// for keys of requesters, "{tenant}" in place of the tenant for the
+	// quotas of tenants, "{authenticated}" in place of the authenticated
+	// identity for dual-stack merges and "{hash}" in place of the hash of
+	// compressed identities. Identities are hashed with IdentityCompression
+	Template string `json:"template"`
+	// The value of the key as encoded by the JSONCodec, with placeholders
+	// in angle brackets. Times are in UTC. The log of access counts is only
+	// present for the SlidingWindowLog algorithm, the refill only for the
+	// TokenBucket and LeakyBucket algorithms
+	Value string `json:"value"`
+}
+
+// Return the key for the requester with the given identity
+func (l *KeyLayout) Key(identity string) string {
+	return strings.Replace(l.Template, identityPlaceholder, identity, -1)
+}
+
+// Return the layout with the given name, or nil if the policy has no such key
+func (s *KeySchema) Key(name string) *KeyLayout {
+	for _, layout := range s.Keys {
+		if layout.Name == name {
+			return layout
+		}
+	}
+
+	return nil
+}
+
+// Get the name of the given codec in the key schema
+func codecName(codec Codec) string {
+	switch c := codec.(type) {
+	case JSONCodec:
+		return "json"
+	case *ZlibCodec:
+		return "zlib+" + codecName(c.codec())
+	default:
+		return fmt.Sprintf("%T", codec)
+	}
+}
+
+// Get the layout of the keys and values of the limiter in the store
+func (l *Limiter) KeySchema() *KeySchema {
+	p, _ := l.current()
+	o := p.options
+	key := makeKey(p.prefix, o.KeyIdFunction(p.controller.quota), identityPlaceholder)
+
+	schema := &KeySchema{
+		Prefix:    p.prefix,
+		Separator: keySeparator,
+		QuotaId:   o.KeyIdFunction(p.controller.quota),
+		Codec:     codecName(o.Codec),
+		KeyByHost: o.KeyByHost,
+		Keys: []*KeyLayout{
+			{"count", key, accessCountValue},
+			{"frozen", p.controller.sideKey(key, "frozen"), freezeValue},
+			{"grant", p.controller.sideKey(key, "grant"), grantValue},
+			{"violations", p.controller.sideKey(key, "violations"), violationsValue},
+			{"notes", p.notesKey(identityPlaceholder), notesValue},
+		},
+	}
+
+	for i, identification := range o.IdentificationChain {
+		chained := makeKey(p.prefix, o.KeyIdFunction(p.chain[i].quota), identification.Name, identityPlaceholder)
+		schema.Keys = append(schema.Keys, &KeyLayout{"count:" + identification.Name, chained, accessCountValue})
+	}
+
+	for _, window := range p.windows {
+		windowed := makeKey(p.prefix, o.KeyIdFunction(window.quota), identityPlaceholder)
+		schema.Keys = append(schema.Keys, &KeyLayout{"window:" + o.KeyIdFunction(window.quota), windowed, accessCountValue})
+	}
+
+	if o.ReputationScore != nil {
+		schema.Keys = append(schema.Keys, &KeyLayout{"score", p.scoreKey(identityPlaceholder), scoreValue})
+	}
+
+	if c := o.IdentityCompression; c != nil && c.DetectCollisions {
+		schema.Keys = append(schema.Keys, &KeyLayout{"identity", p.hashedIdentityKey("{hash}"), identityValue})
+	}
+
+	if o.DualStackMerge != nil {
+		schema.Keys = append(schema.Keys,
+			&KeyLayout{"dualstack", p.dualStackKey("{authenticated}"), dualStackValue},
+			&KeyLayout{"merged", p.mergedKey(identityPlaceholder), mergedValue},
+		)
+	}
+
+	if p.controller.quota.Share > 0 {
+		total := makeKey(p.prefix, "total", o.KeyIdFunction(p.controller.quota))
+		schema.Keys = append(schema.Keys, &KeyLayout{"total", total, accessCountValue})
+	}
+
+	if p.global != nil {
+		schema.Keys = append(schema.Keys, &KeyLayout{"global", p.global.public.key, accessCountValue})
+		for i, pool := range p.global.reserved {
+			schema.Keys = append(schema.Keys, &KeyLayout{"global:" + o.Reservations[i].Name, pool.key, accessCountValue})
+		}
+	}
+
+	if o.TenantFunction != nil {
+		schema.Keys = append(schema.Keys, &KeyLayout{"tenant", tenantKey(o.KeyPrefix, "{tenant}"), tenantValue})
+	}
+
+	return schema
+}
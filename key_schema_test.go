@@ -0,0 +1,58 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCodecName(t *testing.T) {
+	expectSame(t, codecName(JSONCodec{}), "json")
+	expectSame(t, codecName(&ZlibCodec{}), "zlib+json")
+	expectSame(t, codecName(&ZlibCodec{Codec: &ZlibCodec{}}), "zlib+zlib+json")
+}
+
+func TestKeySchema(t *testing.T) {
+	store := NewMapStore(nil)
+	limiter := NewLimiter(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store:           store,
+		KeyPrefix:       "api",
+		ReputationScore: &ReputationScore{},
+		IdentificationChain: []*Identification{
+			{Name: "key", Function: IdentifyByHeader("X-API-Key"), Quota: &Quota{Limit: 5, Within: time.Hour}},
+		},
+	})
+	serveMethod(limiter.ServeHTTP, "GET")
+	serveMethod(limiter.ServeHTTP, "GET")
+	limiter.Freeze("1.2.3.4", time.Now().Add(time.Hour))
+
+	schema := limiter.KeySchema()
+	expectSame(t, schema.Prefix, "api")
+	expectSame(t, schema.Separator, "_")
+	expectSame(t, schema.Codec, "json")
+	expectSame(t, schema.Key("count").Template, "api_"+schema.QuotaId+"_{identity}")
+	expectSame(t, schema.Key("violations").Template, "api_violations_"+schema.QuotaId+"_{identity}")
+	expectSame(t, schema.Key("total"), (*KeyLayout)(nil))
+
+	// the keys of the schema are the keys of the store
+	for _, name := range []string{"count", "frozen", "score"} {
+		if _, err := store.Get(schema.Key(name).Key("1.2.3.4")); err != nil {
+			t.Errorf("Expected the %s key %s in the store", name, schema.Key(name).Key("1.2.3.4"))
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+	req.Header.Set("X-API-Key", "secret")
+	limiter.ServeHTTP(httptest.NewRecorder(), req)
+	value, err := store.Get(schema.Key("count:key").Key("secret"))
+	if err != nil {
+		t.Fatalf("Expected the count of the chain in the store")
+	}
+
+	count := map[string]interface{}{}
+	json.Unmarshal(value, &count)
+	expectSame(t, count["count"], float64(1))
+}
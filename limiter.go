@@ -0,0 +1,208 @@
+package throttle
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Limiter is a throttling policy the application can act on, e.g. to
+// reset a requester after a solved challenge. The options of a limiter are
+// copied when it is created, use SetQuota and SetDisabled to change it
+type Limiter struct {
+	*sync.RWMutex
+	policy   *policy
+	disabled bool
+}
+
+// The Status of a requester within the current time window
+type Status struct {
+	// The number of accesses within the time window
+	Count uint64
+	// The limit of the quota, including the credit
+	Limit uint64
+	// The credit carried over into the time window, see Quota.Rollover
+	Credit uint64
+	// The remaining limit
+	Remaining uint64
+	// The time the time window started
+	WindowStart time.Time
+	// The time at which access is allowed again
+	ResetAt time.Time
+	// The time the freeze of the requester ends, the zero time if not frozen
+	FrozenUntil time.Time
+}
+
+// Throttle the request, see Policy
+func (l *Limiter) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	p, disabled := l.current()
+	if disabled {
+		return
+	}
+
+	p.serve(resp, req, nil)
+}
+
+// Replace the quota of the limiter. Counters of the previous quota are kept
+// in the store, but not used by the new quota
+func (l *Limiter) SetQuota(quota *Quota) {
+	l.Lock()
+	l.policy = l.policy.withQuota(quota)
+	l.Unlock()
+}
+
+// Replace the quota and options of the limiter at once, e.g. to tighten a
+// limit without a restart. Requests in flight finish with the previous
+// configuration. When the new quota counts like the previous quota, with the
+// same time window, alignment and algorithm, the new quota keeps using the
+// counters of the previous quota and requesters keep their usage. Otherwise
+// the counters of the previous quota are kept in the store, but not used by
+// the new quota. Takes the same arguments as Policy, but keeps the store of
+// the previous options when the new options have no Store
+func (l *Limiter) Swap(quota *Quota, options ...*Options) {
+	o := mergeOptions(options)
+
+	l.Lock()
+	defer l.Unlock()
+
+	previous := l.policy
+	if o.Store == nil {
+		o.Store = previous.options.Store
+	}
+	if quota.countsLike(previous.controller.quota) {
+		swapped := *quota
+		previousId := previous.options.KeyIdFunction(previous.controller.quota)
+		keyId := o.KeyIdFunction
+		o.KeyIdFunction = func(q *Quota) string {
+			if *q == swapped {
+				return previousId
+			}
+
+			return keyId(q)
+		}
+	}
+
+	policies.release(previous)
+	if store, ok := previous.options.Store.(*MapStore); ok && o.Store != KeyValueStorer(store) {
+		defaultStores.remove(store)
+	}
+	l.policy = newPolicy(quota, o).register()
+	l.disabled = o.Disabled
+}
+
+// Disable or enable the limiter
+func (l *Limiter) SetDisabled(disabled bool) {
+	l.Lock()
+	l.disabled = disabled
+	l.Unlock()
+}
+
+// Get the current policy and if the limiter is disabled
+func (l *Limiter) current() (*policy, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	return l.policy, l.disabled
+}
+
+// Reset the access counts and violations of the requester with the given
+// identity, as returned by the identification function or chain, for the
+// quota of the policy, the quotas of the identification chain and the windows
+func (l *Limiter) Reset(id string) {
+	l.eachKey(id, func(c *controller, key string) {
+		c.Reset(key)
+	})
+
+	p, _ := l.current()
+	for _, window := range p.windows {
+		window.Reset(makeKey(p.prefix, p.options.KeyIdFunction(window.quota), p.limiterIdentity(id)))
+	}
+}
+
+// Freeze the requester with the given identity until the given time, for
+// the quota of the policy and the quotas of the identification chain.
+// Frozen requesters are denied regardless of their access counts, their
+// counts and violations are kept
+func (l *Limiter) Freeze(id string, until time.Time) {
+	l.eachKey(id, func(c *controller, key string) {
+		c.SetFrozenUntil(key, until)
+	})
+}
+
+// Unfreeze the requester with the given identity, see Freeze
+func (l *Limiter) Unfreeze(id string) {
+	l.eachKey(id, func(c *controller, key string) {
+		c.SetFrozenUntil(key, time.Time{})
+	})
+}
+
+// Get the identity in keys of the given identity passed to the limiter, as
+// admit does. With the KeyByHost option the identity is a HostIdentity, and
+// only the part after the host is compressed
+func (p *policy) limiterIdentity(id string) string {
+	if p.options.KeyByHost {
+		if parts := strings.SplitN(id, keySeparator, 2); len(parts) == 2 {
+			return HostIdentity(parts[0], p.compress(parts[1]))
+		}
+	}
+
+	return p.compress(id)
+}
+
+// Call the given function with the controller and key of the requester with
+// the given identity for the quota of the policy and each identification
+func (l *Limiter) eachKey(id string, f func(c *controller, key string)) {
+	p, _ := l.current()
+	o := p.options
+	id = p.limiterIdentity(id)
+
+	f(p.controller, makeKey(p.prefix, o.KeyIdFunction(p.controller.quota), id))
+	for i, identification := range o.IdentificationChain {
+		c := p.chain[i]
+		f(c, makeKey(p.prefix, o.KeyIdFunction(c.quota), identification.Name, id))
+	}
+}
+
+// Get the status of the requester with the given identity, as returned by
+// the identification function, for the quota of the policy. Use it to
+// display live consumption, e.g. on an API usage page
+func (l *Limiter) Status(id string) Status {
+	p, _ := l.current()
+	c := p.controller
+	key := makeKey(p.prefix, p.options.KeyIdFunction(c.quota), p.limiterIdentity(id))
+	c = c.granted(key).rolledOver(key)
+	counter := c.GetAccessCount(key)
+	status := Status{
+		Count:       counter.GetCount(c.now()),
+		Limit:       c.quota.capacity(),
+		Credit:      c.credit,
+		Remaining:   c.RemainingLimit(key),
+		WindowStart: counter.StartAt(c.now()),
+		ResetAt:     c.RetryAt(key),
+	}
+
+	if until := c.FrozenUntil(key); c.now().Before(until) {
+		status.Remaining = 0
+		status.ResetAt = until
+		status.FrozenUntil = until
+	}
+
+	return status
+}
+
+// Return a new limiter for the given quota and options, to use as a
+// policy for martini with m.Use(limiter.ServeHTTP). Takes the same
+// arguments as Policy
+func NewLimiter(quota *Quota, options ...*Options) *Limiter {
+	return newLimiter(quota, newOptions(options))
+}
+
+// Return a new limiter for the given quota and options
+func newLimiter(quota *Quota, o *Options) *Limiter {
+	return &Limiter{
+		&sync.RWMutex{},
+		newPolicy(quota, o).register(),
+		o.Disabled,
+	}
+}
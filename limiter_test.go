@@ -0,0 +1,344 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+func setupMartiniWithLimiter(limiter *Limiter) *martini.ClassicMartini {
+	m := martini.Classic()
+	m.Use(limiter.ServeHTTP)
+	m.Any("/test", func() int {
+		return http.StatusOK
+	})
+
+	return m
+}
+
+func TestLimiterReset(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+
+	limiter.Reset("1.2.3.4")
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "0",
+	})
+}
+
+func TestChallengeHandler(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, &Options{
+		ChallengeAfter: 1,
+		ChallengeHandler: func(resp http.ResponseWriter, req *http.Request) {
+			http.Redirect(resp, req, "/captcha", http.StatusSeeOther)
+		},
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	}, &Expectation{
+		StatusCode:      http.StatusSeeOther,
+		RateLimitLimit:  "1",
+		ResponseHeaders: map[string]string{"Location": "/captcha"},
+	})
+
+	// a solved challenge resets the requester
+	limiter.Reset("1.2.3.4")
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+}
+
+func TestLimiterStatus(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  3,
+		Within: time.Hour,
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	status := limiter.Status("1.2.3.4")
+	expectSame(t, status.Count, uint64(0))
+	expectSame(t, status.Remaining, uint64(3))
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+
+	status = limiter.Status("1.2.3.4")
+	expectSame(t, status.Count, uint64(2))
+	expectSame(t, status.Limit, uint64(3))
+	expectSame(t, status.Remaining, uint64(1))
+	expectSame(t, status.ResetAt, status.WindowStart.Add(time.Hour))
+}
+
+func TestLimiterSetQuota(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	testResponses(t, m, &Expectation{
+		StatusCode:     http.StatusOK,
+		RateLimitLimit: "1",
+	})
+
+	limiter.SetQuota(&Quota{
+		Limit:  5,
+		Within: time.Hour,
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "5",
+		RateLimitRemaining: "4",
+	})
+}
+
+func TestLimiterSetDisabled(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	limiter.SetDisabled(true)
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+
+	limiter.SetDisabled(false)
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+}
+
+func TestLimiterCopiesOptions(t *testing.T) {
+	quota := &Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}
+	chainQuota := &Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}
+	options := &Options{
+		IdentificationChain: []*Identification{
+			{
+				Name: "key",
+				Function: func(req *http.Request) string {
+					return req.Header.Get("X-Key")
+				},
+				Quota: chainQuota,
+			},
+		},
+	}
+	m := setupMartiniWithLimiter(NewLimiter(quota, options))
+
+	quota.Limit = 10
+	chainQuota.Limit = 10
+	options.IdentificationChain[0].Name = "changed"
+
+	testResponses(t, m, &Expectation{
+		StatusCode:     http.StatusOK,
+		RateLimitLimit: "1",
+	}, &Expectation{
+		StatusCode:     http.StatusOK,
+		RateLimitLimit: "1",
+		Headers:        map[string]string{"X-Key": "abc"},
+	})
+}
+
+func TestLimiterFreeze(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  3,
+		Within: time.Minute,
+	}, &Options{
+		RetryAfter: true,
+	})
+	m := setupMartiniWithLimiter(limiter)
+	until := time.Now().Add(time.Hour)
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+
+	limiter.Freeze("1.2.3.4", until)
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitLimit:     "3",
+		RateLimitRemaining: "0",
+		RateLimitReset:     until.Unix(),
+		RetryAfter:         "3600",
+	})
+
+	status := limiter.Status("1.2.3.4")
+	expectSame(t, status.Count, uint64(1))
+	expectSame(t, status.Remaining, uint64(0))
+	expectSame(t, status.FrozenUntil.Unix(), until.Unix())
+
+	limiter.Unfreeze("1.2.3.4")
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "1",
+	})
+	expectSame(t, limiter.Status("1.2.3.4").FrozenUntil.IsZero(), true)
+}
+
+func TestLimiterFreezeExpires(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limiter := NewLimiter(&Quota{
+		Limit:  3,
+		Within: time.Hour,
+	}, &Options{
+		Clock: clock,
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	limiter.Freeze("1.2.3.4", clock.Now().Add(time.Minute))
+	testResponses(t, m, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+
+	clock.Advance(time.Minute)
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "2",
+	})
+}
+
+func TestLimiterFreezeOutsideIdentities(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 3, Within: time.Hour}, &Options{
+		IdentificationFunction: IdentifyByHeader("X-User"),
+	})
+	serveAs := func(user string) int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		recorder := httptest.NewRecorder()
+		limiter.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	limiter.Freeze("victim", time.Now().Add(time.Hour))
+
+	// the count of this identity does not overwrite the freeze of the victim
+	expectStatusCode(t, http.StatusOK, serveAs("victim_frozen"))
+	expectStatusCode(t, StatusTooManyRequests, serveAs("victim"))
+}
+
+func TestLimiterSwap(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  3,
+		Within: time.Hour,
+	}, &Options{
+		Store: newMapStore(nil, JSONCodec{}, systemClock{}, nil),
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "2",
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "1",
+	})
+
+	// tightening the limit keeps the usage
+	limiter.Swap(&Quota{
+		Limit:  2,
+		Within: time.Hour,
+	}, limiter.policy.options)
+
+	testResponses(t, m, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+	expectSame(t, limiter.Status("1.2.3.4").Count, uint64(2))
+
+	// a new time window starts with new counters
+	limiter.Swap(&Quota{
+		Limit:  2,
+		Within: time.Minute,
+	}, limiter.policy.options)
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "1",
+	})
+}
+
+func TestLimiterSwapKeepsStore(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  3,
+		Within: time.Hour,
+	})
+	store := limiter.policy.options.Store
+	m := setupMartiniWithLimiter(limiter)
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "2",
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "1",
+	})
+
+	// new options without a store keep the counters of the previous store
+	limiter.Swap(&Quota{
+		Limit:  2,
+		Within: time.Hour,
+	}, &Options{})
+
+	expectSame(t, limiter.policy.options.Store, store)
+	testResponses(t, m, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+}
+
+func TestLimiterSwapOptions(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	})
+	limiter.Swap(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, &Options{
+		Disabled: true,
+	})
+
+	expectSame(t, limiter.policy.options.Disabled, true)
+	_, disabled := limiter.current()
+	expectSame(t, disabled, true)
+}
@@ -0,0 +1,155 @@
+package throttle
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// The default time samples of load signals are reused for
+	defaultLoadShedInterval = time.Second
+
+	// The message to return for requests shed while the server is overloaded
+	overloadedMessage = "Service Unavailable"
+)
+
+// A LoadSampler samples a signal of the load of the process or the system,
+// e.g. the CPU usage read from the operating system. Samplers are called at
+// most once per interval of the load shedding
+type LoadSampler func() float64
+
+// Sample the number of goroutines of the process
+func GoroutineSampler() float64 {
+	return float64(runtime.NumGoroutine())
+}
+
+// Sample the bytes of heap objects allocated by the process. Reading the
+// memory statistics stops the world briefly
+func HeapSampler() float64 {
+	stats := &runtime.MemStats{}
+	runtime.ReadMemStats(stats)
+	return float64(stats.HeapAlloc)
+}
+
+// A LoadLimit is the highest value of a signal of the load the server
+// accepts requests at
+type LoadLimit struct {
+	// The sampler of the signal, e.g. GoroutineSampler, required
+	Sampler LoadSampler
+
+	// The value of the signal above which requests are shed
+	Max float64
+}
+
+// LoadShedding sheds requests while any signal of the load of the process or
+// the system is above its limit, see LoadSheddingPolicy
+type LoadShedding struct {
+	// The limits of the signals
+	Limits []*LoadLimit
+
+	// The time samples of the signals are reused for
+	// defaults to 1 second
+	Interval time.Duration
+}
+
+// The decisions of a LoadSheddingPolicy from samples of the signals of the
+// load
+type loadShedder struct {
+	*sync.Mutex
+	shedding *LoadShedding
+	interval time.Duration
+	clock    Clock
+	// The time of the last samples, and if a signal was above its limit then
+	sampled      time.Time
+	isOverloaded bool
+}
+
+// Return a new load shedder for the given load shedding telling the time
+// with the given clock
+func newLoadShedder(shedding *LoadShedding, clock Clock) *loadShedder {
+	interval := shedding.Interval
+	if interval <= 0 {
+		interval = defaultLoadShedInterval
+	}
+
+	return &loadShedder{&sync.Mutex{}, shedding, interval, clock, time.Time{}, false}
+}
+
+// Check if any signal is above its limit, sampling the signals again once
+// the interval has passed
+func (s *loadShedder) overloaded() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.clock.Now()
+	if !s.sampled.IsZero() && now.Sub(s.sampled) < s.interval {
+		return s.isOverloaded
+	}
+
+	s.sampled = now
+	s.isOverloaded = false
+	for _, limit := range s.shedding.Limits {
+		if limit.Sampler() > limit.Max {
+			s.isOverloaded = true
+			break
+		}
+	}
+
+	return s.isOverloaded
+}
+
+// Get the time the signals are sampled again
+func (s *loadShedder) resampleAt() time.Time {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.sampled.Add(s.interval)
+}
+
+// A load shedding Policy, rejecting requests with 503 Service Unavailable
+// while the server itself is overloaded, i.e. any signal of the load of the
+// process or the system is above its limit, regardless of the requester.
+// Stack it before the throttling policies so an overloaded server does not
+// count requests it cannot serve. Of the options, the deny and allow lists,
+// SkipAccessCheck, the response format, RetryAfter, PolicyName, OnEvent
+// and Disabled apply; shed requests are reported as EventShed
+func LoadSheddingPolicy(shedding *LoadShedding, options ...*Options) func(resp http.ResponseWriter, req *http.Request) {
+	// without a quota, no default store is needed
+	o := mergeOptions(options)
+	if o.Disabled {
+		return func(resp http.ResponseWriter, req *http.Request) {}
+	}
+
+	// a policy without a quota, writing the responses and emitting the events
+	p := &policy{options: o}
+	shedder := newLoadShedder(shedding, o.Clock)
+
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if o.DenyList != nil && o.DenyList.ContainsRequest(req) {
+			p.block(resp, req)
+			return
+		}
+
+		if o.AllowList != nil && o.AllowList.ContainsRequest(req) || o.SkipAccessCheck != nil && o.SkipAccessCheck(req) {
+			p.bypass(resp, req)
+			return
+		}
+
+		if !shedder.overloaded() {
+			return
+		}
+
+		headers := resp.Header()
+		if o.PolicyName != "" {
+			headers[policyHeader] = []string{o.PolicyName}
+		}
+		if o.RetryAfter {
+			headers[retryAfterHeader] = []string{strconv.FormatInt(secondsUntil(shedder.resampleAt(), o.Clock.Now()), 10)}
+		}
+		p.writeBody(resp, req, http.StatusServiceUnavailable, overloadedMessage, nil)
+		p.emit(EventShed, req, nil, "")
+	}
+}
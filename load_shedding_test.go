@@ -0,0 +1,105 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoadSheddingPolicy(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	load := 10.0
+	samples := 0
+	var events []*Event
+	policy := LoadSheddingPolicy(&LoadShedding{
+		Limits: []*LoadLimit{{
+			Sampler: func() float64 {
+				samples++
+				return load
+			},
+			Max: 50,
+		}},
+	}, &Options{
+		Clock:      clock,
+		RetryAfter: true,
+		OnEvent: func(e *Event) {
+			events = append(events, e)
+		},
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	// samples are reused within the interval
+	load = 100
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	expectSame(t, samples, 1)
+
+	clock.Advance(time.Second)
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, http.StatusServiceUnavailable, resp.Code)
+	expectSame(t, resp.Body.String(), "Service Unavailable")
+	expectSame(t, resp.Header().Get("Retry-After"), "1")
+	expectSame(t, samples, 2)
+	expectSame(t, len(events), 1)
+	expectSame(t, events[0].Type, EventShed)
+	expectSame(t, events[0].Type.String(), "shed")
+
+	load = 10
+	clock.Advance(time.Second)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+}
+
+func TestLoadSheddingPolicySkip(t *testing.T) {
+	policy := LoadSheddingPolicy(&LoadShedding{
+		Limits: []*LoadLimit{{Sampler: GoroutineSampler, Max: 0}},
+	}, &Options{
+		SkipAccessCheck: func(req *http.Request) bool {
+			return req.Method == "OPTIONS"
+		},
+	})
+
+	expectStatusCode(t, http.StatusServiceUnavailable, serveMethod(policy, "GET").Code)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "OPTIONS").Code)
+}
+
+func TestLoadSheddingPolicyWithoutStore(t *testing.T) {
+	defaultStores.Lock()
+	stores := len(defaultStores.stores)
+	defaultStores.Unlock()
+
+	LoadSheddingPolicy(&LoadShedding{
+		Limits: []*LoadLimit{{Sampler: GoroutineSampler, Max: 0}},
+	})
+
+	defaultStores.Lock()
+	expectSame(t, len(defaultStores.stores), stores)
+	defaultStores.Unlock()
+}
+
+func TestShedRequestsAreRecorded(t *testing.T) {
+	dashboard := NewDashboard()
+	feed := NewDenialFeed()
+	policy := LoadSheddingPolicy(&LoadShedding{
+		Limits: []*LoadLimit{{Sampler: GoroutineSampler, Max: 0}},
+	}, &Options{
+		OnEvent: func(e *Event) {
+			dashboard.OnEvent(e)
+			feed.OnEvent(e)
+		},
+	})
+
+	updates := feed.subscribe()
+	serveMethod(policy, "GET")
+
+	expectSame(t, len(dashboard.denials), 1)
+	expectSame(t, len(updates), 1)
+}
+
+func TestLoadSamplers(t *testing.T) {
+	if GoroutineSampler() < 1 {
+		t.Errorf("Expected at least one goroutine")
+	}
+	if HeapSampler() <= 0 {
+		t.Errorf("Expected allocated heap objects")
+	}
+}
@@ -2,7 +2,6 @@ package throttle
 
 import (
 	"bytes"
-	"encoding/json"
 	"reflect"
 	"sync"
 	"time"
@@ -15,17 +14,49 @@ const (
 // A very simple implementation of a key value store (a concurrent safe map)
 type MapStore struct {
 	*sync.RWMutex
-	data    map[string][]byte
-	binding FreshnessInformer
+	data     map[string][]byte
+	expiries map[string]time.Time
+	binding  FreshnessInformer
+	codec    Codec
+	clock    Clock
+	onEvict  func(key string, value []byte)
 }
 
 type FreshnessInformer interface {
 	IsFresh() bool
 }
 
+// A binding telling if it is fresh at a given time, so cleaning tells the
+// time with the clock of the store
+type freshnessAtInformer interface {
+	IsFreshAt(now time.Time) bool
+}
+
+// A binding deciding itself which values cleaning evicts at the given time,
+// instead of every value which is not fresh, e.g. to keep the values of
+// other kinds stored beside it
+type evictionInformer interface {
+	evictableAt(now time.Time) bool
+}
+
 type MapStoreOptions struct {
 	// The period to clean the store in
 	CleaningPeriod time.Duration
+
+	// The codec the values in the store are encoded with
+	// defaults to stringified JSON
+	Codec Codec
+
+	// The clock to expire keys and values with, e.g. the Clock of the
+	// policies using the store
+	// defaults to the system clock
+	Clock Clock
+
+	// The function called with the key and value of every key removed when
+	// cleaning the store, after it is removed, e.g. to archive final counts.
+	// Called from the cleaning goroutine, it should not block for long
+	// defaults to no callback
+	OnEvict func(key string, value []byte)
 }
 
 // Error Type for the key value store
@@ -45,17 +76,35 @@ func (s *MapStore) Set(key string, value []byte) error {
 	return nil
 }
 
+// Get a key, or set it to the initial value if it does not exist or has expired
+// Returns true if the initial value was set
+func (s *MapStore) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if value, ok := s.data[key]; ok && !s.isExpired(key) {
+		return value, false, nil
+	}
+
+	s.data[key] = initial
+	s.expiries[key] = s.clock.Now().Add(ttl)
+
+	return initial, true, nil
+}
+
 // Delete a key
 func (s *MapStore) Delete(key string) {
 	s.Lock()
 	delete(s.data, key)
+	delete(s.expiries, key)
 	s.Unlock()
 }
 
-// Get a key, will return an error if the key does not exist
+// Get a key, will return an error if the key does not exist or has expired
 func (s *MapStore) Get(key string) (value []byte, err error) {
 	s.RLock()
 	value, ok := s.data[key]
+	ok = ok && !s.isExpired(key)
 	s.RUnlock()
 	if !ok {
 		err = MapStoreError("Key " + key + " does not exist")
@@ -65,37 +114,109 @@ func (s *MapStore) Get(key string) (value []byte, err error) {
 	}
 }
 
-// Read the data into the given binding
+// Read the data into a new value of the type of the binding
 func (s *MapStore) Read(key string) (FreshnessInformer, error) {
 	byteArray, err := s.Get(key)
 	if err != nil {
 		return nil, err
 	}
 
-	byteBufferString := bytes.NewBuffer(byteArray)
-	var arbitraryStructure interface{}
-	if err := json.NewDecoder(byteBufferString).Decode(&arbitraryStructure); err != nil {
+	return s.decode(byteArray)
+}
+
+// Decode the given value into a new value of the type of the binding
+func (s *MapStore) decode(byteArray []byte) (FreshnessInformer, error) {
+	value := reflect.New(reflect.TypeOf(s.binding))
+	if err := s.codec.Decode(byteArray, value.Interface()); err != nil {
 		return nil, err
 	}
 
-	for k, v := range arbitraryStructure.(map[string]interface{}) {
-		if field := reflect.ValueOf(s.binding).FieldByName(k); field.IsValid() && field.CanSet() {
-			field.Set(reflect.ValueOf(v))
-		}
-	}
+	return value.Elem().Interface().(FreshnessInformer), nil
+}
 
-	return s.binding, err
+// Check if the given key has expired, has to be called with the lock held
+func (s *MapStore) isExpired(key string) bool {
+	expiry, ok := s.expiries[key]
+	return ok && !s.clock.Now().Before(expiry)
 }
 
 // Clean the store from expired values
 func (s *MapStore) Clean() {
+	// the keys at the start, so cleaning does not range over the map while
+	// requests write to it
+	s.RLock()
+	keys := make([]string, 0, len(s.data))
 	for key := range s.data {
-		value, err := s.Read(key)
-		if err == nil && !value.IsFresh() {
-			s.Delete(key)
-		} else if err != nil {
-			panic(err)
+		keys = append(keys, key)
+	}
+	s.RUnlock()
+
+	for _, key := range keys {
+		s.RLock()
+		value, ok := s.data[key]
+		expired := s.isExpired(key)
+		s.RUnlock()
+		if !ok {
+			continue
 		}
+
+		if expired {
+			s.evict(key, value, true)
+			continue
+		}
+
+		if s.evictable(value) {
+			s.evict(key, value, false)
+		}
+	}
+}
+
+// The expiry values of any kind may carry, e.g. the violations and scores a
+// policy keeps beside its access counts
+type valueExpiry struct {
+	Expires time.Time `json:"expires"`
+}
+
+// Check if cleaning evicts the given value. Values carrying an expiry are
+// evicted once it has passed, others as the binding tells
+func (s *MapStore) evictable(value []byte) bool {
+	expiry := &valueExpiry{}
+	if err := s.codec.Decode(value, expiry); err == nil && !expiry.Expires.IsZero() {
+		return !s.clock.Now().Before(expiry.Expires)
+	}
+
+	// without a binding, only expired keys can be told apart
+	if s.binding == nil {
+		return false
+	}
+
+	// values which do not decode into the binding are not its to tell
+	informer, err := s.decode(value)
+	if err != nil {
+		return false
+	} else if evictable, ok := informer.(evictionInformer); ok {
+		return evictable.evictableAt(s.clock.Now().UTC())
+	} else if fresh, ok := informer.(freshnessAtInformer); ok {
+		return !fresh.IsFreshAt(s.clock.Now().UTC())
+	}
+
+	return !informer.IsFresh()
+}
+
+// Remove a key when cleaning, unless it was written or recreated since its
+// value was read, calling the eviction callback after
+func (s *MapStore) evict(key string, value []byte, expired bool) {
+	s.Lock()
+	current, ok := s.data[key]
+	evicted := ok && bytes.Equal(current, value) && (!expired || s.isExpired(key))
+	if evicted {
+		delete(s.data, key)
+		delete(s.expiries, key)
+	}
+	s.Unlock()
+
+	if evicted && s.onEvict != nil {
+		s.onEvict(key, value)
 	}
 }
 
@@ -113,23 +234,34 @@ func (s *MapStore) CleanEvery(cleaningPeriod time.Duration) {
 
 // Returns a simple key value store
 func NewMapStore(binding FreshnessInformer, options ...*MapStoreOptions) *MapStore {
-	s := &MapStore{
-		&sync.RWMutex{},
-		make(map[string][]byte),
-		binding,
-	}
-
 	o := newMapStoreOptions(options)
 
+	s := newMapStore(binding, o.Codec, o.Clock, o.OnEvict)
 	go s.CleanEvery(o.CleaningPeriod)
 
 	return s
 }
 
+// Returns a simple key value store without cleaning it
+func newMapStore(binding FreshnessInformer, codec Codec, clock Clock, onEvict func(key string, value []byte)) *MapStore {
+	return &MapStore{
+		&sync.RWMutex{},
+		make(map[string][]byte),
+		make(map[string]time.Time),
+		binding,
+		codec,
+		clock,
+		onEvict,
+	}
+}
+
 // Returns new map store options from defaults and given options
 func newMapStoreOptions(options []*MapStoreOptions) *MapStoreOptions {
 	o := &MapStoreOptions{
 		defaultCleaningPeriod,
+		JSONCodec{},
+		systemClock{},
+		nil,
 	}
 
 	if len(options) == 0 {
@@ -140,5 +272,17 @@ func newMapStoreOptions(options []*MapStoreOptions) *MapStoreOptions {
 		o.CleaningPeriod = options[0].CleaningPeriod
 	}
 
+	if options[0].Codec != nil {
+		o.Codec = options[0].Codec
+	}
+
+	if options[0].Clock != nil {
+		o.Clock = options[0].Clock
+	}
+
+	if options[0].OnEvict != nil {
+		o.OnEvict = options[0].OnEvict
+	}
+
 	return o
 }
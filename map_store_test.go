@@ -22,7 +22,7 @@ func TestSet(t *testing.T) {
 	store.Set("KEY", []byte("4"))
 	value, err := store.Get("KEY")
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 
 	expectSame(t, string(value), "4")
@@ -33,6 +33,7 @@ func TestGet(t *testing.T) {
 	store := NewMapStore(accessCount{})
 
 	wg := &sync.WaitGroup{}
+	mutex := &sync.Mutex{}
 	var values []string
 	store.Set("KEY", []byte(strconv.FormatInt(int64(50), 10)))
 
@@ -42,9 +43,11 @@ func TestGet(t *testing.T) {
 			sleepRandom()
 			value, err := store.Get("KEY")
 			if err != nil {
-				t.Errorf(err.Error())
+				t.Error(err)
 			}
+			mutex.Lock()
 			values = append(values, string(value))
+			mutex.Unlock()
 			wg.Done()
 		}()
 	}
@@ -60,14 +63,19 @@ func TestRead(t *testing.T) {
 	store := NewMapStore(accessCount{})
 
 	wg := &sync.WaitGroup{}
+	mutex := &sync.Mutex{}
 	var values []bool
 	marshalled, err := json.Marshal(accessCount{
 		64,
 		time.Now(),
 		10 * time.Millisecond,
+		nil,
+		0,
+		0,
+		nil,
 	})
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 	store.Set("KEY", marshalled)
 
@@ -77,9 +85,11 @@ func TestRead(t *testing.T) {
 			value, err := store.Read("KEY")
 			time.Sleep(10 * time.Millisecond)
 			if err != nil {
-				t.Errorf(err.Error())
+				t.Error(err)
 			}
+			mutex.Lock()
 			values = append(values, value.IsFresh())
+			mutex.Unlock()
 			wg.Done()
 		}()
 	}
@@ -115,17 +125,21 @@ func TestDelete(t *testing.T) {
 
 func TestCleaning(t *testing.T) {
 	store := NewMapStore(accessCount{}, &MapStoreOptions{
-		5 * time.Millisecond,
+		CleaningPeriod: 5 * time.Millisecond,
 	})
 
 	marshalled, err := json.Marshal(accessCount{
 		64,
 		time.Now(),
 		10 * time.Millisecond,
+		nil,
+		0,
+		0,
+		nil,
 	})
 
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Error(err)
 	}
 
 	wg := &sync.WaitGroup{}
@@ -138,7 +152,7 @@ func TestCleaning(t *testing.T) {
 	}
 
 	wg.Wait()
-	time.Sleep(11 * time.Millisecond)
+	time.Sleep(25 * time.Millisecond)
 
 	for i := 0; i < 5; i++ {
 		value, err := store.Get("KEY" + strconv.FormatInt(int64(i), 10))
@@ -150,3 +164,205 @@ func TestCleaning(t *testing.T) {
 
 	}
 }
+
+func TestCleaningKeepsFreshCounts(t *testing.T) {
+	store := NewMapStore(accessCount{}, &MapStoreOptions{
+		CleaningPeriod: time.Hour,
+	})
+
+	fresh, _ := json.Marshal(newAccessCount(time.Minute, time.Now().UTC()))
+	stale, _ := json.Marshal(newAccessCount(time.Minute, time.Now().UTC().Add(-2*time.Minute)))
+	store.Set("FRESH", fresh)
+	store.Set("STALE", stale)
+	store.Clean()
+
+	if _, err := store.Get("FRESH"); err != nil {
+		t.Errorf("Expected the fresh count to be kept")
+	}
+	if _, err := store.Get("STALE"); err == nil {
+		t.Errorf("Expected the stale count to be evicted")
+	}
+
+	value, err := store.Read("FRESH")
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, value.IsFresh(), true)
+}
+
+func TestMapStoreUsesClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := NewMapStore(accessCount{}, &MapStoreOptions{
+		CleaningPeriod: time.Hour,
+		Clock:          clock,
+	})
+
+	counted, _ := json.Marshal(newAccessCount(time.Minute, clock.Now().UTC()))
+	store.Set("COUNT", counted)
+	store.GetOrCreate("EXPIRING", []byte("1"), time.Minute)
+	store.Clean()
+	if _, err := store.Get("COUNT"); err != nil {
+		t.Errorf("Expected the count fresh by the clock to be kept")
+	}
+	if _, err := store.Get("EXPIRING"); err != nil {
+		t.Errorf("Expected the key to expire by the clock")
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := store.Get("EXPIRING"); err == nil {
+		t.Errorf("Expected the key to expire by the clock")
+	}
+	store.Clean()
+	if _, err := store.Get("COUNT"); err == nil {
+		t.Errorf("Expected the count stale by the clock to be evicted")
+	}
+}
+
+func TestCleaningKeepsOtherValues(t *testing.T) {
+	store := NewMapStore(accessCount{}, &MapStoreOptions{
+		CleaningPeriod: time.Hour,
+	})
+	now := time.Now().UTC()
+	carried := newAccessCount(time.Minute, now.Add(-2*time.Minute))
+	carried.Credit = 3
+
+	values := map[string]interface{}{
+		"VIOLATIONS": &Violations{Count: 3, Last: now, BannedUntil: now.Add(time.Hour)},
+		"FROZEN":     &freeze{now.Add(time.Hour), now.Add(time.Hour)},
+		"SCORE":      newScore(5, now, time.Hour),
+		"GRANT":      &grant{10, now.Add(time.Hour), now.Add(time.Hour)},
+		"CREDIT":     carried,
+		"IDENTITY":   "1.2.3.4",
+	}
+	for key, value := range values {
+		marshalled, _ := json.Marshal(value)
+		store.Set(key, marshalled)
+	}
+	store.Clean()
+
+	for key := range values {
+		if _, err := store.Get(key); err != nil {
+			t.Errorf("Expected %v to be kept", key)
+		}
+	}
+}
+
+func TestCleaningConcurrently(t *testing.T) {
+	store := NewMapStore(accessCount{}, &MapStoreOptions{
+		CleaningPeriod: time.Hour,
+	})
+	stale, _ := json.Marshal(newAccessCount(time.Minute, time.Now().UTC().Add(-2*time.Minute)))
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func(k int) {
+			for j := 0; j < 100; j++ {
+				store.Set("KEY"+strconv.Itoa(k*100+j), stale)
+			}
+			wg.Done()
+		}(i)
+		go func() {
+			store.Clean()
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+	store.Clean()
+	expectSame(t, len(store.data), 0)
+}
+
+func TestOnEvict(t *testing.T) {
+	evicted := map[string]string{}
+	store := NewMapStore(nil, &MapStoreOptions{
+		CleaningPeriod: time.Hour,
+		OnEvict: func(key string, value []byte) {
+			evicted[key] = string(value)
+		},
+	})
+
+	store.Set("KEPT", []byte("kept"))
+	store.GetOrCreate("EXPIRED", []byte("expired"), -time.Second)
+	store.Clean()
+
+	expectSame(t, len(evicted), 1)
+	expectSame(t, evicted["EXPIRED"], "expired")
+	if _, err := store.Get("KEPT"); err != nil {
+		t.Errorf("Expected the key without expiry to be kept")
+	}
+}
+
+func TestGetOrCreate(t *testing.T) {
+	store := NewMapStore(accessCount{})
+
+	value, created, err := store.GetOrCreate("KEY", []byte("1"), 10*time.Millisecond)
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, created, true)
+	expectSame(t, string(value), "1")
+
+	store.Set("KEY", []byte("2"))
+	value, created, _ = store.GetOrCreate("KEY", []byte("1"), 10*time.Millisecond)
+	expectSame(t, created, false)
+	expectSame(t, string(value), "2")
+
+	time.Sleep(10 * time.Millisecond)
+	if _, err := store.Get("KEY"); err == nil {
+		t.Errorf("Expected the key to be expired")
+	}
+
+	value, created, _ = store.GetOrCreate("KEY", []byte("1"), 10*time.Millisecond)
+	expectSame(t, created, true)
+	expectSame(t, string(value), "1")
+}
+
+func TestGetOrCreateConcurrently(t *testing.T) {
+	store := NewMapStore(accessCount{})
+	wg := &sync.WaitGroup{}
+	created := make(chan bool, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			_, c, _ := store.GetOrCreate("KEY", []byte("1"), time.Minute)
+			created <- c
+			wg.Done()
+		}()
+	}
+
+	wg.Wait()
+	close(created)
+
+	count := 0
+	for c := range created {
+		if c {
+			count++
+		}
+	}
+	expectSame(t, count, 1)
+}
+
+func TestCleaningEvictsExpiredValues(t *testing.T) {
+	store := NewMapStore(accessCount{}, &MapStoreOptions{
+		CleaningPeriod: time.Hour,
+	})
+	past := time.Now().UTC().Add(-time.Minute)
+
+	values := map[string]interface{}{
+		"FROZEN": &freeze{past, past},
+		"GRANT":  &grant{10, past, past},
+	}
+	for key, value := range values {
+		marshalled, _ := json.Marshal(value)
+		store.Set(key, marshalled)
+	}
+	store.Clean()
+
+	for key := range values {
+		if _, err := store.Get(key); err == nil {
+			t.Errorf("Expected %v to be evicted", key)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// An Override adjusts the throttling of a single request. Map it into the
+// martini context in a handler running before a MartiniPolicy, e.g. to let
+// a checkout flow skip the throttle
+type Override struct {
+	// If the request is not throttled at all
+	Skip bool
+
+	// The quota to use for the request instead of the quota of the policy
+	// defaults to the quota of the policy
+	Quota *Quota
+
+	// The number of accesses the request counts as
+	// defaults to the cost of the CostFunction
+	Cost uint64
+}
+
+// The type of overrides in the martini context
+var overrideType = reflect.TypeOf(Override{})
+
+// A Denial is mapped into the martini context by MartiniPolicy,
+// MartiniInjectedPolicy and LatencyBudgetPolicy when a request is not
+// admitted, i.e. denied, banned, challenged or rejected, in addition to
+// writing the response. Handlers before the policy, e.g. access logs and
+// error handlers, find it with DenialOf after calling c.Next()
+type Denial struct {
+	// The event of the request, see Event
+	*Event
+	// The status code of the response
+	StatusCode int
+}
+
+// The status code of the response to the denied request
+func (d *Denial) Status() int {
+	return d.StatusCode
+}
+
+// The Error for denied requests, e.g. for error handlers
+func (d *Denial) Error() string {
+	return "Throttle Denial: Request " + d.Type.String() + " with status " + strconv.Itoa(d.StatusCode)
+}
+
+// The type of denials in the martini context
+var denialType = reflect.TypeOf(&Denial{})
+
+// Get the override mapped into the martini context, or nil if there is none
+func overrideOf(c martini.Context) *Override {
+	if value := c.Get(overrideType); value.IsValid() {
+		o := value.Interface().(Override)
+		return &o
+	}
+
+	return nil
+}
+
+// Get the denial mapped into the martini context by a policy, or nil if the
+// request was not denied
+func DenialOf(c martini.Context) *Denial {
+	if value := c.Get(denialType); value.IsValid() {
+		return value.Interface().(*Denial)
+	}
+
+	return nil
+}
+
+// Map a denial for the given event into the martini context, unless the
+// request bypassed the throttle
+func mapDenial(c martini.Context, resp http.ResponseWriter, e *Event) {
+	if e == nil || e.Type == EventBypassed {
+		return
+	}
+
+	d := &Denial{e, http.StatusOK}
+	if rw, ok := resp.(martini.ResponseWriter); ok && rw.Status() != 0 {
+		d.StatusCode = rw.Status()
+	}
+
+	c.Map(d)
+}
+
+// A throttling Policy for martini, honoring an Override mapped into the
+// martini context by an upstream handler. Takes the same arguments as Policy
+func MartiniPolicy(quota *Quota, options ...*Options) func(c martini.Context, resp http.ResponseWriter, req *http.Request) {
+	o := newOptions(options)
+	if o.Disabled {
+		return func(c martini.Context, resp http.ResponseWriter, req *http.Request) {}
+	}
+
+	p := newPolicy(quota, o).register()
+	p.denials = true
+
+	return func(c martini.Context, resp http.ResponseWriter, req *http.Request) {
+		override := overrideOf(c)
+
+		mapDenial(c, resp, p.serve(resp, req, override))
+	}
+}
+
+// An Injection identifies and skips the requests of a MartiniInjectedPolicy
+// with martini handlers, called with the services mapped into the martini
+// context, e.g. a session or an auth service, instead of closing over globals
+type Injection struct {
+	// A handler returning the identity of the request as its first value,
+	// e.g. func(s sessions.Session) string
+	// defaults to the IdentificationFunction
+	Identify martini.Handler
+
+	// A handler returning true as its first value for requests not to
+	// throttle, e.g. func(u *User) bool
+	// defaults to throttling all requests
+	Skip martini.Handler
+}
+
+// The context key of the identity returned by the Identify handler of an
+// injection
+type injectedIdentityKey struct{}
+
+// Check that the given handler of an injection is a function returning a
+// value of the given kind first
+func validateInjected(name string, handler martini.Handler, kind reflect.Kind) {
+	if handler == nil {
+		return
+	}
+
+	t := reflect.TypeOf(handler)
+	if t.Kind() != reflect.Func || t.NumOut() == 0 || t.Out(0).Kind() != kind {
+		panic(ConfigError("Invalid Injection " + name + ": expected a function returning a " + kind.String() + " first").Error())
+	}
+}
+
+// Invoke the given handler of an injection with the services of the martini
+// context, returning its first value
+func invokeInjected(c martini.Context, handler martini.Handler) reflect.Value {
+	values, err := c.Invoke(handler)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return values[0]
+}
+
+// A throttling Policy for martini like MartiniPolicy, identifying and
+// skipping requests with the handlers of the injection, called with the
+// services of the martini context. Identities returned by the Identify
+// handler take the place of the IdentificationFunction, after the
+// IdentificationChain. Invalid handlers panic when the policy is created
+func MartiniInjectedPolicy(quota *Quota, injection *Injection, options ...*Options) martini.Handler {
+	validateInjected("Identify", injection.Identify, reflect.String)
+	validateInjected("Skip", injection.Skip, reflect.Bool)
+
+	o := newOptions(options)
+	if o.Disabled {
+		return func(c martini.Context, resp http.ResponseWriter, req *http.Request) {}
+	}
+
+	if injection.Identify != nil {
+		identify := o.IdentificationFunction
+		o.IdentificationFunction = func(req *http.Request) string {
+			if identity, ok := req.Context().Value(injectedIdentityKey{}).(string); ok {
+				return identity
+			}
+
+			return identify(req)
+		}
+	}
+
+	p := newPolicy(quota, o).register()
+	p.denials = true
+
+	return func(c martini.Context, resp http.ResponseWriter, req *http.Request) {
+		override := overrideOf(c)
+
+		if injection.Skip != nil && invokeInjected(c, injection.Skip).Bool() {
+			override = &Override{Skip: true}
+		} else if injection.Identify != nil {
+			identity := invokeInjected(c, injection.Identify).String()
+			req = req.WithContext(context.WithValue(req.Context(), injectedIdentityKey{}, identity))
+		}
+
+		mapDenial(c, resp, p.serve(resp, req, override))
+	}
+}
+
+// A LatencyBudget is the total time handlers may spend on the requests of a
+// single requester within a time window
+type LatencyBudget struct {
+	// The total handler time allowed
+	Budget time.Duration
+	// The time window for the budget
+	Within time.Duration
+}
+
+// Return the quota counting the latency budget in milliseconds
+func (b *LatencyBudget) quota() *Quota {
+	return &Quota{
+		Limit:  uint64(b.Budget / time.Millisecond),
+		Within: b.Within,
+		Name:   "latency",
+	}
+}
+
+// Return the cost of a request taking the given time, in milliseconds
+// rounded up
+func latencyCost(elapsed time.Duration) uint64 {
+	cost := uint64(elapsed / time.Millisecond)
+	if elapsed%time.Millisecond > 0 || cost == 0 {
+		cost++
+	}
+
+	return cost
+}
+
+// A throttling Policy for martini limiting the total time the following
+// handlers spend on the requests of a requester within a time window. Requests
+// are denied once the budget is used up. Protects against requesters sending
+// few but expensive requests. The rate limit headers and message data count
+// the budget in milliseconds. The options and an Override apply as for
+// MartiniPolicy, with the quotas counting milliseconds and the cost of a
+// request weighting the time it takes
+func LatencyBudgetPolicy(budget *LatencyBudget, options ...*Options) func(c martini.Context, resp http.ResponseWriter, req *http.Request) {
+	o := newOptions(options)
+	if o.Disabled {
+		return func(c martini.Context, resp http.ResponseWriter, req *http.Request) {}
+	}
+
+	p := newPolicy(budget.quota(), o).register()
+	p.denials = true
+
+	return func(c martini.Context, resp http.ResponseWriter, req *http.Request) {
+		quota, cost, e := p.precheck(resp, req, overrideOf(c))
+		if e != nil {
+			mapDenial(c, resp, e)
+			return
+		}
+
+		controller, id, e := p.admit(resp, req, quota, cost)
+		if e != nil {
+			mapDenial(c, resp, e)
+			return
+		}
+
+		p.setHeaders(resp, req, controller, id)
+		start := controller.now()
+		defer func() {
+			controller.RegisterAccess(id, cost*latencyCost(controller.now().Sub(start)))
+			p.emit(EventAllowed, req, controller, id)
+		}()
+
+		c.Next()
+	}
+}
+
+// A martini handler adding an identity memo to the request, so policies
+// with a memoized identification function identify each request once. Use
+// it before the policies, see Memoize
+func IdentityMemo(c martini.Context, req *http.Request) {
+	c.Map(WithIdentityMemo(req))
+}
+
+// A martini handler observing the requests served by the following handlers
+// with the adaptive controller, counting responses with a status code of 500
+// or above as failed. Use it before the handlers of the backend, e.g.
+// m.Use(adaptive.Handler)
+func (a *AdaptiveController) Handler(c martini.Context, resp http.ResponseWriter) {
+	start := a.options.Clock.Now()
+	c.Next()
+
+	failed := false
+	if rw, ok := resp.(martini.ResponseWriter); ok {
+		failed = rw.Status() >= http.StatusInternalServerError
+	}
+	a.Observe(a.options.Clock.Now().Sub(start), failed)
+}
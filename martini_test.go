@@ -0,0 +1,299 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+func setupMartiniWithOverride(limit uint64, within time.Duration, override func(req *http.Request) *Override, options ...*Options) *martini.ClassicMartini {
+	m := martini.Classic()
+
+	m.Use(func(c martini.Context, req *http.Request) {
+		if o := override(req); o != nil {
+			c.Map(*o)
+		}
+	})
+	m.Use(MartiniPolicy(&Quota{
+		Limit:  limit,
+		Within: within,
+	}, options...))
+
+	m.Any("/test", func() int {
+		return http.StatusOK
+	})
+
+	return m
+}
+
+func TestMartiniPolicyWithoutOverride(t *testing.T) {
+	m := setupMartiniWithOverride(1, time.Hour, func(req *http.Request) *Override {
+		return nil
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "0",
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+}
+
+func TestOverrideSkip(t *testing.T) {
+	m := setupMartiniWithOverride(1, time.Hour, func(req *http.Request) *Override {
+		return &Override{Skip: req.Header.Get("X-Checkout") != ""}
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-Checkout": "1"},
+	})
+}
+
+func TestOverrideQuota(t *testing.T) {
+	m := setupMartiniWithOverride(1, time.Hour, func(req *http.Request) *Override {
+		return &Override{Quota: &Quota{Limit: 3, Within: time.Hour}}
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "3",
+		RateLimitRemaining: "2",
+	})
+}
+
+func TestOverrideCost(t *testing.T) {
+	m := setupMartiniWithOverride(5, time.Hour, func(req *http.Request) *Override {
+		return &Override{Cost: 3}
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "2",
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitRemaining: "2",
+	})
+}
+
+func TestOverrideCostOverCostFunction(t *testing.T) {
+	m := setupMartiniWithOverride(5, time.Hour, func(req *http.Request) *Override {
+		return &Override{Cost: 3}
+	}, &Options{
+		CostFunction: func(req *http.Request) uint64 {
+			return 2
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "2",
+	})
+}
+
+func TestLatencyCost(t *testing.T) {
+	expectSame(t, latencyCost(0), uint64(1))
+	expectSame(t, latencyCost(time.Millisecond), uint64(1))
+	expectSame(t, latencyCost(1500*time.Microsecond), uint64(2))
+}
+
+func TestLatencyBudgetPolicy(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	m := martini.Classic()
+	m.Use(LatencyBudgetPolicy(&LatencyBudget{
+		Budget: 100 * time.Millisecond,
+		Within: time.Hour,
+	}, &Options{
+		Clock: clock,
+	}))
+	m.Any("/test", func() int {
+		clock.Advance(60 * time.Millisecond)
+		return http.StatusOK
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "100",
+		RateLimitRemaining: "100",
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "40",
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitRemaining: "0",
+	})
+}
+
+func TestLatencyBudgetPolicyChecks(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	m := martini.Classic()
+	m.Use(func(c martini.Context, req *http.Request) {
+		if req.Header.Get("X-Skip") != "" {
+			c.Map(Override{Skip: true})
+		}
+	})
+	m.Use(LatencyBudgetPolicy(&LatencyBudget{
+		Budget: 100 * time.Millisecond,
+		Within: time.Hour,
+	}, &Options{
+		Clock:    clock,
+		DenyList: NewNetworkList("5.6.7.8"),
+		CostFunction: func(req *http.Request) uint64 {
+			return 2
+		},
+	}))
+	m.Any("/test", func() int {
+		clock.Advance(30 * time.Millisecond)
+		return http.StatusOK
+	})
+	serve := func(remoteAddr string, skip bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = remoteAddr
+		if skip {
+			req.Header.Set("X-Skip", "1")
+		}
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	expectStatusCode(t, http.StatusForbidden, serve("5.6.7.8:1234", false).Code)
+	expectStatusCode(t, http.StatusOK, serve("1.2.3.4:1234", false).Code)
+	expectSame(t, serve("1.2.3.4:1234", true).Header().Get("X-RateLimit-Remaining"), "")
+	expectSame(t, serve("1.2.3.4:1234", false).Header().Get("X-RateLimit-Remaining"), "40")
+}
+
+func TestMartiniPolicyMapsDenial(t *testing.T) {
+	var denials []*Denial
+	m := martini.Classic()
+	m.Use(func(c martini.Context) {
+		c.Next()
+		denials = append(denials, DenialOf(c))
+	})
+	m.Use(MartiniPolicy(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, &Options{
+		PolicyName: "api",
+	}))
+	m.Any("/test", func() int {
+		return http.StatusOK
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+
+	expectSame(t, len(denials), 2)
+	if denials[0] != nil {
+		t.Errorf("Expected no denial for an allowed request")
+	}
+	expectSame(t, denials[1].Type, EventDenied)
+	expectSame(t, denials[1].Policy, "api")
+	expectSame(t, denials[1].Limit, uint64(1))
+	expectSame(t, denials[1].Status(), StatusTooManyRequests)
+	expectSame(t, denials[1].Error(), "Throttle Denial: Request denied with status 429")
+}
+
+func TestLatencyBudgetPolicyMapsDenial(t *testing.T) {
+	var denial *Denial
+	m := martini.Classic()
+	m.Use(func(c martini.Context) {
+		c.Next()
+		denial = DenialOf(c)
+	})
+	m.Use(LatencyBudgetPolicy(&LatencyBudget{
+		Budget: time.Millisecond,
+		Within: time.Hour,
+	}))
+	m.Any("/test", func() int {
+		return http.StatusOK
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+
+	expectSame(t, denial.Type, EventDenied)
+	expectSame(t, denial.Status(), StatusTooManyRequests)
+}
+
+type testSession struct {
+	user  string
+	admin bool
+}
+
+func setupMartiniWithInjection(injection *Injection) *martini.ClassicMartini {
+	m := martini.Classic()
+	m.Use(func(c martini.Context, req *http.Request) {
+		c.Map(&testSession{req.Header.Get("X-User"), req.Header.Get("X-Admin") != ""})
+	})
+	m.Use(MartiniInjectedPolicy(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, injection))
+	m.Any("/test", func() int {
+		return http.StatusOK
+	})
+
+	return m
+}
+
+func TestMartiniInjectedPolicyIdentify(t *testing.T) {
+	m := setupMartiniWithInjection(&Injection{
+		Identify: func(s *testSession) string {
+			return s.user
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-User": "alice"},
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+		Headers:    map[string]string{"X-User": "alice"},
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-User": "bob"},
+	})
+}
+
+func TestMartiniInjectedPolicySkip(t *testing.T) {
+	m := setupMartiniWithInjection(&Injection{
+		Skip: func(s *testSession) bool {
+			return s.admin
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-Admin": "1"},
+	})
+}
+
+func TestMartiniInjectedPolicyInvalidHandler(t *testing.T) {
+	defer func() {
+		expectSame(t, recover(), "Throttle Config Error: Invalid Injection Identify: expected a function returning a string first")
+	}()
+
+	MartiniInjectedPolicy(&Quota{Limit: 1, Within: time.Hour}, &Injection{
+		Identify: func(s *testSession) bool {
+			return s.admin
+		},
+	})
+}
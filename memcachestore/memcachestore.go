@@ -0,0 +1,173 @@
+// Package memcachestore provides a throttle store for memcached, creating new
+// time windows atomically with add and an expiration.
+//
+// Memcached can not keep the expiration of a key when it is overwritten, so
+// values written with Set expire after the Expiration of the options, which
+// should be at least the longest quota window.
+//
+// Importing the package registers the memcached url scheme with
+// throttle.NewStoreFromURL:
+//
+//	import _ "github.com/martini-contrib/throttle/memcachestore"
+//
+//	store, err := throttle.NewStoreFromURL("memcached://10.0.0.1:11211,10.0.0.2:11211")
+package memcachestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/martini-contrib/throttle"
+)
+
+const (
+	// The maximum length of memcached keys
+	maxKeyLength = 250
+
+	// Expirations longer than this are unix timestamps in memcached
+	maxRelativeExpiration = 30 * 24 * time.Hour
+)
+
+// A Store keeps throttle state in memcached
+type Store struct {
+	client  *memcache.Client
+	options *Options
+}
+
+type Options struct {
+	// The expiration of values written with Set
+	// defaults to no expiration, evicting values when memcached runs out of memory
+	Expiration time.Duration
+}
+
+// Get a key, will return an error if the key does not exist
+func (s *Store) Get(key string) ([]byte, error) {
+	item, err := s.client.Get(escapeKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return item.Value, nil
+}
+
+// Get the values of the given keys with one request per server, see
+// throttle.KeyValueBatchGetter. Missing keys are missing from the result
+func (s *Store) GetBatch(keys []string) (map[string][]byte, error) {
+	escaped := make([]string, len(keys))
+	for i, key := range keys {
+		escaped[i] = escapeKey(key)
+	}
+
+	items, err := s.client.GetMulti(escaped)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte, len(items))
+	for i, key := range keys {
+		if item, ok := items[escaped[i]]; ok {
+			values[key] = item.Value
+		}
+	}
+
+	return values, nil
+}
+
+// Set a key
+func (s *Store) Set(key string, value []byte) error {
+	return s.client.Set(&memcache.Item{
+		Key:        escapeKey(key),
+		Value:      value,
+		Expiration: expiration(s.options.Expiration),
+	})
+}
+
+// Check if the server is reachable, see throttle.StorePinger
+func (s *Store) Ping() error {
+	return s.client.Ping()
+}
+
+// Get a key, or create it with the initial value expiring after the given ttl
+// if it does not exist. Returns true if the initial value was set
+func (s *Store) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	escaped := escapeKey(key)
+	for {
+		err := s.client.Add(&memcache.Item{
+			Key:        escaped,
+			Value:      initial,
+			Expiration: expiration(ttl),
+		})
+		if err == nil {
+			return initial, true, nil
+		}
+		if !errors.Is(err, memcache.ErrNotStored) {
+			return nil, false, err
+		}
+
+		item, err := s.client.Get(escaped)
+		if err == nil {
+			return item.Value, false, nil
+		}
+		// The key expired in between, try to create it again
+		if !errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, false, err
+		}
+	}
+}
+
+// Return the memcached expiration for the given ttl, in seconds rounded up,
+// or as a unix timestamp for ttls longer than 30 days
+func expiration(ttl time.Duration) int32 {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl > maxRelativeExpiration {
+		return int32(time.Now().Add(ttl).Unix())
+	}
+
+	return int32((ttl + time.Second - 1) / time.Second)
+}
+
+// Escape the given key for use in memcached. Keys with whitespace or control
+// characters, or longer than 250 bytes, are replaced by a hash
+func escapeKey(key string) string {
+	if len(key) <= maxKeyLength && !strings.ContainsFunc(key, func(r rune) bool {
+		return r <= ' ' || r == 0x7f
+	}) {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "throttle_" + hex.EncodeToString(sum[:])
+}
+
+// Returns a store using the given client
+func New(client *memcache.Client, options ...*Options) *Store {
+	o := &Options{}
+	if len(options) != 0 {
+		*o = *options[0]
+	}
+
+	return &Store{
+		client,
+		o,
+	}
+}
+
+// Return a store for an url like "memcached://10.0.0.1:11211,10.0.0.2:11211"
+func newFromURL(u *url.URL) (throttle.KeyValueStorer, error) {
+	if u.Host == "" {
+		return nil, throttle.ConfigError("Missing memcached servers in " + u.Redacted())
+	}
+
+	return New(memcache.New(strings.Split(u.Host, ",")...)), nil
+}
+
+func init() {
+	throttle.RegisterStoreScheme("memcached", newFromURL)
+}
@@ -0,0 +1,82 @@
+package memcachestore
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martini-contrib/throttle"
+)
+
+func TestEscapeKey(t *testing.T) {
+	if escaped := escapeKey("throttle_100_1.2.3.4"); escaped != "throttle_100_1.2.3.4" {
+		t.Errorf("Expected valid keys to be kept, but got %q", escaped)
+	}
+
+	for _, key := range []string{"throttle_100_with space", strings.Repeat("a", 251)} {
+		if escaped := escapeKey(key); len(escaped) > maxKeyLength || strings.Contains(escaped, " ") {
+			t.Errorf("Expected %q to be escaped, but got %q", key, escaped)
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	if e := expiration(1500 * time.Millisecond); e != 2 {
+		t.Errorf("Expected an expiration of 2 seconds, but got %d", e)
+	}
+	if e := expiration(60 * 24 * time.Hour); int64(e) < time.Now().Unix() {
+		t.Errorf("Expected a unix timestamp for long expirations, but got %d", e)
+	}
+}
+
+func TestRegistersScheme(t *testing.T) {
+	store, err := throttle.NewStoreFromURL("memcached://localhost:11211")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*Store); !ok {
+		t.Errorf("Expected a memcached store, but got %T", store)
+	}
+	if _, ok := store.(throttle.StorePinger); !ok {
+		t.Errorf("Expected the store to be pingable")
+	}
+	if _, ok := store.(throttle.KeyValueBatchGetter); !ok {
+		t.Errorf("Expected the store to get batches")
+	}
+
+	if _, err := throttle.NewStoreFromURL("memcached://"); err == nil {
+		t.Errorf("Expected an error without servers")
+	}
+}
+
+// Runs against the servers in MEMCACHED_URL
+func TestStore(t *testing.T) {
+	url := os.Getenv("MEMCACHED_URL")
+	if url == "" {
+		t.Skip("MEMCACHED_URL not set")
+	}
+
+	kv, err := throttle.NewStoreFromURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := kv.(*Store)
+	key := "throttle_test_" + time.Now().Format(time.RFC3339Nano)
+	if _, created, err := store.GetOrCreate(key, []byte("1"), time.Minute); err != nil || !created {
+		t.Errorf("Expected the key to be created, but got %v, %v", created, err)
+	}
+	if value, created, err := store.GetOrCreate(key, []byte("2"), time.Minute); err != nil || created || string(value) != "1" {
+		t.Errorf("Expected the existing value 1, but got %q, %v, %v", value, created, err)
+	}
+	if err := store.Set(key, []byte("3")); err != nil {
+		t.Error(err)
+	}
+	if value, err := store.Get(key); err != nil || string(value) != "3" {
+		t.Errorf("Expected the value 3, but got %q, %v", value, err)
+	}
+	if values, err := store.GetBatch([]string{key, key + "_missing"}); err != nil || len(values) != 1 || string(values[key]) != "3" {
+		t.Errorf("Expected a batch of the value 3, but got %q, %v", values, err)
+	}
+}
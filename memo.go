@@ -0,0 +1,64 @@
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// The context key of the identity memo
+type identityMemoKey struct{}
+
+// The identities computed for a request, by memoized identification function
+type identityMemo struct {
+	*sync.Mutex
+	identities map[*memoizedIdentification]string
+}
+
+// A memoized identification function
+type memoizedIdentification struct {
+	function func(*http.Request) string
+}
+
+// Identify the requester, reusing the identity computed for the request
+// if the request has an identity memo
+func (m *memoizedIdentification) identify(req *http.Request) string {
+	memo, ok := req.Context().Value(identityMemoKey{}).(*identityMemo)
+	if !ok {
+		return m.function(req)
+	}
+
+	memo.Lock()
+	defer memo.Unlock()
+
+	if identity, ok := memo.identities[m]; ok {
+		return identity
+	}
+
+	identity := m.function(req)
+	memo.identities[m] = identity
+
+	return identity
+}
+
+// Memoize the given identification function, so stacked policies sharing
+// it identify each request once, e.g. when parsing JWTs. The identity is
+// kept in the identity memo of the request, see WithIdentityMemo and
+// IdentityMemo. Without an identity memo, the function is called every time
+func Memoize(function func(*http.Request) string) func(*http.Request) string {
+	m := &memoizedIdentification{function}
+	return m.identify
+}
+
+// Return a copy of the request with an identity memo in its context, for
+// use with Memoize
+func WithIdentityMemo(req *http.Request) *http.Request {
+	if _, ok := req.Context().Value(identityMemoKey{}).(*identityMemo); ok {
+		return req
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), identityMemoKey{}, &identityMemo{
+		&sync.Mutex{},
+		make(map[*memoizedIdentification]string),
+	}))
+}
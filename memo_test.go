@@ -0,0 +1,56 @@
+package throttle
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+func TestMemoize(t *testing.T) {
+	var calls int32
+	identify := Memoize(func(req *http.Request) string {
+		atomic.AddInt32(&calls, 1)
+		return req.Header.Get("X-Key")
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Key", "abc")
+
+	// without a memo, the function is called every time
+	identify(req)
+	identify(req)
+	expectSame(t, atomic.LoadInt32(&calls), int32(2))
+
+	memoized := WithIdentityMemo(req)
+	expectSame(t, identify(memoized), "abc")
+	expectSame(t, identify(memoized), "abc")
+	expectSame(t, atomic.LoadInt32(&calls), int32(3))
+	expectSame(t, WithIdentityMemo(memoized), memoized)
+}
+
+func TestMemoizeWithStackedPolicies(t *testing.T) {
+	var calls int32
+	options := &Options{
+		IdentificationFunction: Memoize(func(req *http.Request) string {
+			atomic.AddInt32(&calls, 1)
+			return "1.2.3.4"
+		}),
+	}
+
+	m := martini.Classic()
+	m.Use(IdentityMemo)
+	m.Use(Policy(&Quota{Limit: 10, Within: time.Hour}, options))
+	m.Use(Policy(&Quota{Limit: 100, Within: 24 * time.Hour}, options))
+	m.Any("/test", func() int {
+		return http.StatusOK
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+
+	expectSame(t, atomic.LoadInt32(&calls), int32(1))
+}
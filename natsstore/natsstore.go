@@ -0,0 +1,122 @@
+// Package natsstore provides a throttle store for NATS JetStream key value
+// buckets, so teams already running NATS can share throttle state with it.
+//
+// New time windows are created with a per-key TTL, which needs a bucket with
+// LimitMarkerTTL set (NATS 2.11 or later). Values written with Set expire with
+// the MaxAge of the bucket, which should be at least the longest quota window:
+//
+//	kv, err := js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+//		Bucket:         "throttle",
+//		TTL:            time.Hour,
+//		LimitMarkerTTL: time.Minute,
+//	})
+//	store := natsstore.New(kv)
+package natsstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// The default timeout for operations
+	defaultTimeout = time.Second
+)
+
+// A Store keeps throttle state in a JetStream key value bucket
+type Store struct {
+	kv      jetstream.KeyValue
+	options *Options
+}
+
+type Options struct {
+	// The timeout for every operation
+	// defaults to 1 second
+	Timeout time.Duration
+}
+
+// Get a key, will return an error if the key does not exist
+func (s *Store) Get(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+
+	entry, err := s.kv.Get(ctx, escapeKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.Value(), nil
+}
+
+// Set a key
+func (s *Store) Set(key string, value []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+
+	_, err := s.kv.Put(ctx, escapeKey(key), value)
+	return err
+}
+
+// Get a key, or create it with the initial value expiring after the given ttl
+// if it does not exist. Returns true if the initial value was set
+func (s *Store) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.options.Timeout)
+	defer cancel()
+
+	escaped := escapeKey(key)
+	for {
+		_, err := s.kv.Create(ctx, escaped, initial, jetstream.KeyTTL(ttl))
+		if err == nil {
+			return initial, true, nil
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			return nil, false, err
+		}
+
+		entry, err := s.kv.Get(ctx, escaped)
+		if err == nil {
+			return entry.Value(), false, nil
+		}
+		// The key expired in between, try to create it again
+		if !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, false, err
+		}
+	}
+}
+
+// Escape the given key for use in a bucket. Keys may only contain letters,
+// digits, dashes and underscores here, everything else is escaped as =XX
+func escapeKey(key string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '-' || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "=%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
+
+// Returns a store using the given key value bucket
+func New(kv jetstream.KeyValue, options ...*Options) *Store {
+	o := &Options{
+		Timeout: defaultTimeout,
+	}
+
+	if len(options) != 0 && options[0].Timeout != 0 {
+		o.Timeout = options[0].Timeout
+	}
+
+	return &Store{
+		kv,
+		o,
+	}
+}
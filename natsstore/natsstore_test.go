@@ -0,0 +1,65 @@
+package natsstore
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestEscapeKey(t *testing.T) {
+	for key, expected := range map[string]string{
+		"throttle_100_1-2":              "throttle_100_1-2",
+		"throttle_100_1.2.3.4":          "throttle_100_1=2E2=2E3=2E4",
+		"throttle_100_2001:db8::1":      "throttle_100_2001=3Adb8=3A=3A1",
+		"throttle_100_key=with/slashes": "throttle_100_key=3Dwith=2Fslashes",
+	} {
+		if escaped := escapeKey(key); escaped != expected {
+			t.Errorf("Expected %q to be escaped as %q, but was %q", key, expected, escaped)
+		}
+	}
+}
+
+// Runs against the server in NATS_URL
+func TestStore(t *testing.T) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		t.Skip("NATS_URL not set")
+	}
+
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+		Bucket:         "throttle_test",
+		LimitMarkerTTL: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(kv)
+	key := "test_" + time.Now().Format(time.RFC3339Nano)
+	if _, created, err := store.GetOrCreate(key, []byte("1"), time.Minute); err != nil || !created {
+		t.Errorf("Expected the key to be created, but got %v, %v", created, err)
+	}
+	if value, created, err := store.GetOrCreate(key, []byte("2"), time.Minute); err != nil || created || string(value) != "1" {
+		t.Errorf("Expected the existing value 1, but got %q, %v, %v", value, created, err)
+	}
+	if err := store.Set(key, []byte("3")); err != nil {
+		t.Error(err)
+	}
+	if value, err := store.Get(key); err != nil || string(value) != "3" {
+		t.Errorf("Expected the value 3, but got %q, %v", value, err)
+	}
+}
@@ -0,0 +1,483 @@
+package throttle
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The message to return for requesters of networks of the deny list
+const blockedMessage = "Forbidden"
+
+// Error Type for network lists
+type NetworkListError string
+
+// The Error for network lists
+func (err NetworkListError) Error() string {
+	return "Throttle Network List Error: " + string(err)
+}
+
+// A NetworkList is a list of IP networks, e.g. the networks of partners to
+// allow or the networks of a threat intelligence feed to deny, see the
+// AllowList and DenyList options. Networks are compiled into a radix tree,
+// so a lookup takes at most one step per bit of the address, regardless of
+// the number of networks. Every change compiles a new tree, swapped in
+// atomically, so lookups never wait for imports or reloads and never see a
+// partial list. Add networks in bulk, as every change takes the time to
+// compile the whole list. The zero value is an empty list
+type NetworkList struct {
+	// Serializes changes, lookups do not take it
+	sync.Mutex
+	// The current *networkTree
+	tree atomic.Value
+}
+
+// A compiled radix tree of networks, never changed once compiled
+type networkTree struct {
+	ipv4 *networkNode
+	ipv6 *networkNode
+	// The number of networks, without networks within other networks
+	size int
+}
+
+// A node of the radix tree of a network list, branching on the next bit of
+// the address
+type networkNode struct {
+	children [2]*networkNode
+	// If the network ending at the node is in the list, covering all
+	// addresses with the bits up to the node
+	included bool
+}
+
+// Add the network with the given prefix of the address to the tree. Returns
+// false if the network was covered by the tree already
+func (n *networkNode) add(address net.IP, bits int) bool {
+	for i := 0; i < bits; i++ {
+		if n.included {
+			return false
+		}
+
+		bit := address[i/8] >> uint(7-i%8) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &networkNode{}
+		}
+		n = n.children[bit]
+	}
+
+	if n.included {
+		return false
+	}
+
+	n.included = true
+	// networks within the network are covered now
+	n.children = [2]*networkNode{}
+
+	return true
+}
+
+// Check if the given address is within a network of the tree
+func (n *networkNode) contains(address net.IP) bool {
+	for i := 0; n != nil; i++ {
+		if n.included {
+			return true
+		} else if i == len(address)*8 {
+			return false
+		}
+
+		n = n.children[address[i/8]>>uint(7-i%8)&1]
+	}
+
+	return false
+}
+
+// Count the networks of the tree below the node
+func (n *networkNode) count() int {
+	if n.included {
+		return 1
+	}
+
+	count := 0
+	for _, child := range n.children {
+		if child != nil {
+			count += child.count()
+		}
+	}
+
+	return count
+}
+
+// Collect the networks of the tree below the node at the given depth of the
+// given address into the given networks
+func (n *networkNode) collect(address net.IP, depth int, size int, networks *[]*net.IPNet) {
+	if n.included {
+		*networks = append(*networks, &net.IPNet{IP: append(net.IP{}, address...), Mask: net.CIDRMask(depth, size)})
+		return
+	}
+
+	for bit, child := range n.children {
+		if child == nil {
+			continue
+		}
+
+		address[depth/8] |= byte(bit) << uint(7-depth%8)
+		child.collect(address, depth+1, size, networks)
+		address[depth/8] &^= 1 << uint(7-depth%8)
+	}
+}
+
+// Compile a tree of the networks of the given tree, if any, and the given
+// networks. Returns the tree and the number of the given networks which were
+// not covered by other networks
+func compileNetworkTree(base *networkTree, networks []*net.IPNet) (*networkTree, int) {
+	t := &networkTree{&networkNode{}, &networkNode{}, 0}
+	if base != nil {
+		for _, network := range base.networks() {
+			t.add(network)
+		}
+	}
+
+	added := 0
+	for _, network := range networks {
+		if t.add(network) {
+			added++
+		}
+	}
+	t.size = t.ipv4.count() + t.ipv6.count()
+
+	return t, added
+}
+
+// Get the networks of the tree
+func (t *networkTree) networks() []*net.IPNet {
+	var networks []*net.IPNet
+	t.ipv4.collect(make(net.IP, net.IPv4len), 0, 32, &networks)
+	t.ipv6.collect(make(net.IP, net.IPv6len), 0, 128, &networks)
+
+	return networks
+}
+
+// Add the given network to the tree being compiled. Returns false if the
+// network was covered by the tree already
+func (t *networkTree) add(network *net.IPNet) bool {
+	bits, _ := network.Mask.Size()
+	if ipv4 := network.IP.To4(); ipv4 != nil && len(network.Mask) == net.IPv4len {
+		return t.ipv4.add(ipv4, bits)
+	}
+
+	return t.ipv6.add(network.IP.To16(), bits)
+}
+
+// Check if the given IP address is within a network of the tree
+func (t *networkTree) contains(ip net.IP) bool {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return t.ipv4.contains(ipv4)
+	} else if ipv6 := ip.To16(); ipv6 != nil {
+		return t.ipv6.contains(ipv6)
+	}
+
+	return false
+}
+
+// Parse the given network, in CIDR notation or a single IP address
+func parseNetwork(network string) (*net.IPNet, error) {
+	network = strings.TrimSpace(network)
+	if !strings.Contains(network, "/") {
+		ip := net.ParseIP(network)
+		if ip == nil {
+			return nil, NetworkListError("Invalid network " + strconv.Quote(network))
+		}
+
+		if ipv4 := ip.To4(); ipv4 != nil {
+			return &net.IPNet{IP: ipv4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, parsed, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, NetworkListError("Invalid network " + strconv.Quote(network))
+	}
+
+	return parsed, nil
+}
+
+// Parse the given networks, or return the error of the first invalid network
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	parsed := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		n, err := parseNetwork(network)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, n)
+	}
+
+	return parsed, nil
+}
+
+// An empty tree, for lists without networks
+var emptyNetworkTree = &networkTree{&networkNode{}, &networkNode{}, 0}
+
+// Get the current tree of the list
+func (l *NetworkList) current() *networkTree {
+	if tree, ok := l.tree.Load().(*networkTree); ok {
+		return tree
+	}
+
+	return emptyNetworkTree
+}
+
+// Add the given parsed networks to the list. Returns the number of networks
+// which were not covered by the list already
+func (l *NetworkList) add(networks []*net.IPNet) int {
+	l.Lock()
+	defer l.Unlock()
+
+	tree, added := compileNetworkTree(l.current(), networks)
+	l.tree.Store(tree)
+
+	return added
+}
+
+// Replace the networks of the list by the given parsed networks. Returns the
+// number of networks in the list. Compiles with the lock held, so changes
+// made meanwhile are not lost by storing a tree compiled before them
+func (l *NetworkList) replace(networks []*net.IPNet) int {
+	l.Lock()
+	defer l.Unlock()
+
+	tree, _ := compileNetworkTree(nil, networks)
+	l.tree.Store(tree)
+
+	return tree.size
+}
+
+// Add the given networks, in CIDR notation or single IP addresses, to the
+// list. Returns an error without adding any network if a network is invalid
+func (l *NetworkList) Add(networks ...string) error {
+	parsed, err := parseNetworks(networks)
+	if err != nil {
+		return err
+	}
+
+	l.add(parsed)
+	return nil
+}
+
+// Replace the networks of the list by the given networks, in CIDR notation
+// or single IP addresses. Returns an error keeping the networks of the list
+// if a network is invalid
+func (l *NetworkList) Replace(networks ...string) error {
+	parsed, err := parseNetworks(networks)
+	if err != nil {
+		return err
+	}
+
+	l.replace(parsed)
+	return nil
+}
+
+// Remove all networks from the list
+func (l *NetworkList) Clear() {
+	l.replace(nil)
+}
+
+// Get the number of networks in the list. Networks within other networks
+// of the list are not counted
+func (l *NetworkList) Len() int {
+	return l.current().size
+}
+
+// Check if the given IP address is within a network of the list
+func (l *NetworkList) Contains(ip net.IP) bool {
+	return l.current().contains(ip)
+}
+
+// Check if the IP of the remote address of the request is within a network
+// of the list. The X-Forwarded-For header is ignored, as clients can set it
+func (l *NetworkList) ContainsRequest(req *http.Request) bool {
+	ip := net.ParseIP(identifyConnection(req))
+	return ip != nil && l.Contains(ip)
+}
+
+// Read the networks of the CSV in the given reader, one network per record
+// in the first column, skipping a header row
+func readNetworksCSV(r io.Reader) ([]*net.IPNet, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var networks []*net.IPNet
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, NetworkListError(err.Error())
+		}
+
+		network, err := parseNetwork(record[0])
+		if err != nil && line == 0 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Read the networks of the JSON array of strings in the given reader
+func readNetworksJSON(r io.Reader) ([]*net.IPNet, error) {
+	var networks []string
+	if err := json.NewDecoder(r).Decode(&networks); err != nil {
+		return nil, NetworkListError(err.Error())
+	}
+
+	return parseNetworks(networks)
+}
+
+// Read the networks of the file at the given path, as JSON for files ending
+// in ".json" and as CSV otherwise
+func readNetworksFile(path string) ([]*net.IPNet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, NetworkListError(err.Error())
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return readNetworksJSON(file)
+	}
+
+	return readNetworksCSV(file)
+}
+
+// Import the networks of the CSV in the given reader into the list, one
+// network per record in the first column. Empty lines, lines starting with
+// "#" and a header row are skipped, further columns ignored, e.g. the
+// source of a network in threat intelligence feeds. Returns the number of
+// networks added, or an error without adding any network
+func (l *NetworkList) ImportCSV(r io.Reader) (int, error) {
+	networks, err := readNetworksCSV(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.add(networks), nil
+}
+
+// Import the networks of the JSON array of strings in the given reader into
+// the list. Returns the number of networks added, or an error without adding
+// any network
+func (l *NetworkList) ImportJSON(r io.Reader) (int, error) {
+	networks, err := readNetworksJSON(r)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.add(networks), nil
+}
+
+// Import the networks of the file at the given path into the list, as JSON
+// for files ending in ".json" and as CSV otherwise, see ImportCSV
+func (l *NetworkList) ImportFile(path string) (int, error) {
+	networks, err := readNetworksFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.add(networks), nil
+}
+
+// Replace the networks of the list by the networks of the file at the given
+// path, e.g. after a threat intelligence feed was updated, see ImportFile.
+// Lookups use the previous networks until the new networks are compiled.
+// Returns the number of networks in the list, or an error keeping the
+// networks of the list
+func (l *NetworkList) ReloadFile(path string) (int, error) {
+	networks, err := readNetworksFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.replace(networks), nil
+}
+
+// The response of the admin handler of a network list
+type networkListImport struct {
+	// The number of networks added
+	Imported int `json:"imported"`
+	// The number of networks in the list
+	Total int `json:"total"`
+}
+
+// Import an uploaded list of networks, as JSON for requests with an
+// application/json content type and as CSV otherwise. POST adds the networks
+// to the list, PUT replaces the list. Responds with the number of networks
+// imported and in the list as JSON, with 400 Bad Request for invalid lists
+// and with 405 Method Not Allowed for other methods. Authentication is left
+// to the handlers before it, it should not be reachable publicly
+func (l *NetworkList) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" && req.Method != "PUT" {
+		resp.Header().Set("Allow", "POST, PUT")
+		http.Error(resp, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var networks []*net.IPNet
+	var err error
+	if strings.HasPrefix(req.Header.Get(contentTypeHeader), "application/json") {
+		networks, err = readNetworksJSON(req.Body)
+	} else {
+		networks, err = readNetworksCSV(req.Body)
+	}
+	if err != nil {
+		http.Error(resp, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := &networkListImport{}
+	if req.Method == "PUT" {
+		result.Imported = l.replace(networks)
+	} else {
+		result.Imported = l.add(networks)
+	}
+	result.Total = l.Len()
+
+	resp.Header().Set(contentTypeHeader, "application/json")
+	if err := json.NewEncoder(resp).Encode(result); err != nil {
+		panic(err.Error())
+	}
+}
+
+// Deny access to a requester of a network of the deny list
+func (p *policy) block(resp http.ResponseWriter, req *http.Request) *Event {
+	p.delayDenial(req)
+	p.writeBody(resp, req, http.StatusForbidden, blockedMessage, nil)
+
+	return p.emit(EventBlocked, req, nil, "")
+}
+
+// Returns a new network list with the given networks, in CIDR notation or
+// single IP addresses. Panics for invalid networks, use Add for networks
+// which are not known to be valid
+func NewNetworkList(networks ...string) *NetworkList {
+	l := &NetworkList{}
+	if err := l.Add(networks...); err != nil {
+		panic(err.Error())
+	}
+
+	return l
+}
@@ -0,0 +1,216 @@
+package throttle
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetworkList(t *testing.T) {
+	list := NewNetworkList("10.0.0.0/8", "192.168.1.1", "2001:db8::/32")
+
+	expectSame(t, list.Len(), 3)
+	expectSame(t, list.Contains(net.ParseIP("10.1.2.3")), true)
+	expectSame(t, list.Contains(net.ParseIP("11.1.2.3")), false)
+	expectSame(t, list.Contains(net.ParseIP("192.168.1.1")), true)
+	expectSame(t, list.Contains(net.ParseIP("192.168.1.2")), false)
+	expectSame(t, list.Contains(net.ParseIP("2001:db8:1::1")), true)
+	expectSame(t, list.Contains(net.ParseIP("2001:db9::1")), false)
+	expectSame(t, list.Contains(net.ParseIP("::ffff:10.1.2.3")), true)
+
+	// networks within networks of the list are covered already
+	list.Add("10.1.0.0/16")
+	expectSame(t, list.Len(), 3)
+
+	err := list.Add("172.16.0.0/12", "not a network")
+	expectSame(t, err.Error(), `Throttle Network List Error: Invalid network "not a network"`)
+	expectSame(t, list.Contains(net.ParseIP("172.16.0.1")), false)
+
+	list.Clear()
+	expectSame(t, list.Len(), 0)
+	expectSame(t, list.Contains(net.ParseIP("10.1.2.3")), false)
+}
+
+func TestNetworkListNarrowedByWiderNetwork(t *testing.T) {
+	list := NewNetworkList("10.1.0.0/16", "10.2.0.0/16")
+	list.Add("10.0.0.0/8")
+
+	expectSame(t, list.Contains(net.ParseIP("10.3.0.1")), true)
+
+	networks := list.current().networks()
+	expectSame(t, len(networks), 1)
+	expectSame(t, list.Len(), 1)
+	expectSame(t, networks[0].String(), "10.0.0.0/8")
+}
+
+func TestNetworkListReplace(t *testing.T) {
+	list := NewNetworkList("1.2.3.4")
+	previous := list.current()
+
+	expectSame(t, list.Replace("5.6.7.8", "2001:db8::/32"), nil)
+	expectSame(t, list.Len(), 2)
+	expectSame(t, list.Contains(net.ParseIP("1.2.3.4")), false)
+	expectSame(t, list.Contains(net.ParseIP("5.6.7.8")), true)
+
+	// compiled trees are never changed
+	expectSame(t, previous.contains(net.ParseIP("1.2.3.4")), true)
+	expectSame(t, previous.contains(net.ParseIP("5.6.7.8")), false)
+
+	expectDifferent(t, list.Replace("invalid"), nil)
+	expectSame(t, list.Len(), 2)
+}
+
+func TestNetworkListLookupsDuringChanges(t *testing.T) {
+	list := NewNetworkList("10.0.0.0/8")
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			list.Add("192.168." + strconv.Itoa(i) + ".0/24")
+			list.Replace("10.0.0.0/8", "192.168.0.0/16")
+		}
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			expectSame(t, list.Contains(net.ParseIP("10.1.2.3")), true)
+		}
+	}
+}
+
+func TestNetworkListZeroValue(t *testing.T) {
+	list := &NetworkList{}
+
+	expectSame(t, list.Len(), 0)
+	expectSame(t, list.Contains(net.ParseIP("10.1.2.3")), false)
+
+	list.Add("10.0.0.0/8")
+	expectSame(t, list.Contains(net.ParseIP("10.1.2.3")), true)
+}
+
+func TestNetworkListImportCSV(t *testing.T) {
+	list := NewNetworkList()
+	imported, err := list.ImportCSV(strings.NewReader("network,source\n# a comment\n1.2.3.0/24,feed\n\n5.6.7.8, feed\n"))
+
+	expectSame(t, err, nil)
+	expectSame(t, imported, 2)
+	expectSame(t, list.Contains(net.ParseIP("1.2.3.4")), true)
+	expectSame(t, list.Contains(net.ParseIP("5.6.7.8")), true)
+
+	_, err = list.ImportCSV(strings.NewReader("9.9.9.9\ninvalid\n"))
+	expectDifferent(t, err, nil)
+	expectSame(t, list.Contains(net.ParseIP("9.9.9.9")), false)
+}
+
+func TestNetworkListImportFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "throttle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "deny.json")
+	ioutil.WriteFile(path, []byte(`["1.2.3.0/24", "2001:db8::1"]`), 0600)
+
+	list := NewNetworkList()
+	imported, err := list.ImportFile(path)
+	expectSame(t, err, nil)
+	expectSame(t, imported, 2)
+	expectSame(t, list.Contains(net.ParseIP("2001:db8::1")), true)
+
+	_, err = list.ImportFile(filepath.Join(dir, "missing.csv"))
+	expectDifferent(t, err, nil)
+
+	// a reload replaces the list, an invalid file keeps it
+	ioutil.WriteFile(path, []byte(`["5.6.7.8"]`), 0600)
+	total, err := list.ReloadFile(path)
+	expectSame(t, err, nil)
+	expectSame(t, total, 1)
+	expectSame(t, list.Contains(net.ParseIP("1.2.3.4")), false)
+
+	ioutil.WriteFile(path, []byte(`["5.6.7.8", "invalid"]`), 0600)
+	_, err = list.ReloadFile(path)
+	expectDifferent(t, err, nil)
+	expectSame(t, list.Contains(net.ParseIP("5.6.7.8")), true)
+}
+
+func TestNetworkListUpload(t *testing.T) {
+	list := NewNetworkList("1.1.1.1")
+	upload := func(method string, contentType string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/admin/deny", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		list.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	resp := upload("POST", "text/csv", "2.2.2.0/24\n")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Body.String(), "{\"imported\":1,\"total\":2}\n")
+
+	resp = upload("PUT", "application/json", `["3.3.3.3"]`)
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Body.String(), "{\"imported\":1,\"total\":1}\n")
+	expectSame(t, list.Contains(net.ParseIP("1.1.1.1")), false)
+
+	expectStatusCode(t, http.StatusBadRequest, upload("PUT", "application/json", `["invalid"]`).Code)
+	expectSame(t, list.Len(), 1)
+	expectStatusCode(t, http.StatusMethodNotAllowed, upload("GET", "", "").Code)
+}
+
+func TestAllowAndDenyLists(t *testing.T) {
+	var events []EventType
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store:     NewMapStore(nil),
+		AllowList: NewNetworkList("1.2.3.0/24"),
+		DenyList:  NewNetworkList("1.2.3.4"),
+		OnEvent: func(e *Event) {
+			events = append(events, e.Type)
+		},
+	})
+	serveFrom := func(remoteAddr string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder
+	}
+
+	resp := serveFrom("1.2.3.4:1234")
+	expectStatusCode(t, http.StatusForbidden, resp.Code)
+	expectSame(t, resp.Body.String(), "Forbidden")
+
+	expectStatusCode(t, http.StatusOK, serveFrom("1.2.3.5:1234").Code)
+	expectStatusCode(t, http.StatusOK, serveFrom("1.2.3.5:1234").Code)
+	expectStatusCode(t, http.StatusOK, serveFrom("5.6.7.8:1234").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveFrom("5.6.7.8:1234").Code)
+
+	expected := []EventType{EventBlocked, EventBypassed, EventBypassed, EventAllowed, EventDenied}
+	expectSame(t, len(events), len(expected))
+	for i, e := range expected {
+		expectSame(t, events[i], e)
+	}
+	expectSame(t, EventBlocked.String(), "blocked")
+}
+
+func TestListsIgnoreForwardedFor(t *testing.T) {
+	list := NewNetworkList("1.2.3.0/24")
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "5.6.7.8:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	expectSame(t, list.ContainsRequest(req), false)
+
+	req.RemoteAddr = "1.2.3.4:1234"
+	req.Header.Set("X-Forwarded-For", "5.6.7.8")
+	expectSame(t, list.ContainsRequest(req), true)
+}
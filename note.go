@@ -0,0 +1,128 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// A Note explains an operator action on a requester, e.g. the reason for a
+// freeze and a link to the ticket, for the next operator to understand it
+type Note struct {
+	// The text of the note
+	Text string `json:"text"`
+	// A link to further information, e.g. a ticket
+	Link string `json:"link,omitempty"`
+	// The author of the note
+	Author string `json:"author,omitempty"`
+	// The time the note was added, set when the note is added
+	Time time.Time `json:"time"`
+}
+
+// The notes of a requester, will be stored in the key value store
+type notes struct {
+	Notes []*Note `json:"notes"`
+}
+
+// Get the key of the notes of the requester with the given identity
+func (p *policy) notesKey(id string) string {
+	return makeKey(p.prefix, "notes", id)
+}
+
+// Get the notes of the requester with the given identity, oldest first
+func (l *Limiter) Notes(id string) []*Note {
+	p, _ := l.current()
+	return p.getNotes(id).Notes
+}
+
+// Add a note to the requester with the given identity, as returned by the
+// identification function or chain. The notes are kept in the store of the
+// policy next to the counters, and are kept on Reset
+func (l *Limiter) AddNote(id string, note *Note) {
+	p, _ := l.current()
+	c := p.controller
+
+	c.Lock()
+	defer c.Unlock()
+
+	n := p.getNotes(id)
+	added := *note
+	added.Time = c.now()
+	n.Notes = append(n.Notes, &added)
+	p.setNotes(id, n)
+}
+
+// Remove all notes of the requester with the given identity
+func (l *Limiter) ClearNotes(id string) {
+	p, _ := l.current()
+	c := p.controller
+
+	c.Lock()
+	defer c.Unlock()
+
+	p.setNotes(id, &notes{})
+}
+
+// Get the notes by id
+func (p *policy) getNotes(id string) *notes {
+	n := &notes{}
+	if notesBytes, err := p.options.Store.Get(p.notesKey(id)); err == nil {
+		if err := p.options.Codec.Decode(notesBytes, n); err != nil {
+			panic(err.Error())
+		}
+	}
+
+	return n
+}
+
+// Set the notes by id, will write to the store
+func (p *policy) setNotes(id string, n *notes) {
+	marshalled, err := p.options.Codec.Encode(n)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	err = p.options.Store.Set(p.notesKey(id), marshalled)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// A notes handler
+// Responds to GET requests with the notes of the requester given in the id
+// query parameter as JSON, adds the note given as JSON in the body of POST
+// requests and removes all notes on DELETE requests. Authentication is left
+// to the handlers before, the handler should not be reachable publicly
+func NotesHandler(limiter *Limiter) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			http.Error(resp, "Missing id", http.StatusBadRequest)
+			return
+		}
+
+		switch req.Method {
+		case "GET":
+		case "POST":
+			note := &Note{}
+			if err := json.NewDecoder(req.Body).Decode(note); err != nil || note.Text == "" {
+				http.Error(resp, "Invalid note", http.StatusBadRequest)
+				return
+			}
+			limiter.AddNote(id, note)
+		case "DELETE":
+			limiter.ClearNotes(id)
+		default:
+			http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		n := limiter.Notes(id)
+		if n == nil {
+			n = []*Note{}
+		}
+
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(n)
+	}
+}
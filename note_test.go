@@ -0,0 +1,70 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiterNotes(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, &Options{
+		Clock: clock,
+	})
+
+	expectSame(t, len(limiter.Notes("1.2.3.4")), 0)
+
+	limiter.AddNote("1.2.3.4", &Note{Text: "Frozen for scraping", Link: "https://tickets/123"})
+	clock.Advance(time.Minute)
+	limiter.AddNote("1.2.3.4", &Note{Text: "Unfrozen after contact", Author: "ops"})
+	limiter.Reset("1.2.3.4")
+
+	notes := limiter.Notes("1.2.3.4")
+	expectSame(t, len(notes), 2)
+	expectSame(t, notes[0].Text, "Frozen for scraping")
+	expectSame(t, notes[0].Link, "https://tickets/123")
+	expectSame(t, notes[1].Author, "ops")
+	expectSame(t, notes[1].Time.Sub(notes[0].Time), time.Minute)
+	expectSame(t, len(limiter.Notes("2.3.4.5")), 0)
+
+	limiter.ClearNotes("1.2.3.4")
+	expectSame(t, len(limiter.Notes("1.2.3.4")), 0)
+}
+
+func TestNotesHandler(t *testing.T) {
+	limiter := NewLimiter(&Quota{
+		Limit:  1,
+		Within: time.Hour,
+	})
+	handler := NotesHandler(limiter)
+
+	serve := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		recorder := httptest.NewRecorder()
+		handler(recorder, req)
+		return recorder
+	}
+
+	expectStatusCode(t, http.StatusBadRequest, serve("GET", "/notes", "").Code)
+	expectStatusCode(t, http.StatusBadRequest, serve("POST", "/notes?id=key", "{}").Code)
+	expectSame(t, strings.TrimSpace(serve("GET", "/notes?id=key", "").Body.String()), "[]")
+
+	recorder := serve("POST", "/notes?id=key", `{"text":"Raised limit","link":"https://tickets/456"}`)
+	expectStatusCode(t, http.StatusOK, recorder.Code)
+
+	var notes []*Note
+	if err := json.NewDecoder(recorder.Body).Decode(&notes); err != nil {
+		t.Error(err)
+	}
+	expectSame(t, len(notes), 1)
+	expectSame(t, notes[0].Text, "Raised limit")
+
+	expectSame(t, strings.TrimSpace(serve("DELETE", "/notes?id=key", "").Body.String()), "[]")
+	expectStatusCode(t, http.StatusMethodNotAllowed, serve("PUT", "/notes?id=key", "").Code)
+}
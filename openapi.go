@@ -0,0 +1,162 @@
+package throttle
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// An OpenAPIResponse is the OpenAPI 3 response object of a response written
+// by a policy. Encode it as JSON, or YAML, into the responses of the
+// operations behind the policy in the spec of the API
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Headers     map[string]*OpenAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+// An OpenAPIHeader is the OpenAPI 3 header object of a header written by a
+// policy
+type OpenAPIHeader struct {
+	Description string         `json:"description"`
+	Schema      *OpenAPISchema `json:"schema"`
+}
+
+// An OpenAPIMediaType is the OpenAPI 3 media type object of the body of a
+// response written by a policy
+type OpenAPIMediaType struct {
+	Schema  *OpenAPISchema `json:"schema"`
+	Example interface{}    `json:"example,omitempty"`
+}
+
+// An OpenAPISchema is the OpenAPI 3 schema object of a header or body
+type OpenAPISchema struct {
+	Type       string                    `json:"type"`
+	Format     string                    `json:"format,omitempty"`
+	Properties map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required   []string                  `json:"required,omitempty"`
+}
+
+// The schema of the bodies written by the JSONFormatter
+var jsonMessageSchema = &OpenAPISchema{
+	Type: "object",
+	Properties: map[string]*OpenAPISchema{
+		"message":     {Type: "string"},
+		"limit":       {Type: "integer", Format: "int64"},
+		"remaining":   {Type: "integer", Format: "int64"},
+		"retry_after": {Type: "integer", Format: "int64"},
+		"reset_at":    {Type: "string", Format: "date-time"},
+	},
+	Required: []string{"message"},
+}
+
+// Get the header object of the X-RateLimit-Reset header in the reset format
+// of the policy
+func (p *policy) openAPIResetHeader() *OpenAPIHeader {
+	switch p.options.ResetFormat {
+	case ResetUnixMilliseconds:
+		return &OpenAPIHeader{"The Unix time in milliseconds at which the quota resets", &OpenAPISchema{Type: "integer", Format: "int64"}}
+	case ResetDeltaSeconds:
+		return &OpenAPIHeader{"The seconds until the quota resets, with millisecond precision", &OpenAPISchema{Type: "number"}}
+	default:
+		return &OpenAPIHeader{"The Unix time in seconds at which the quota resets", &OpenAPISchema{Type: "integer", Format: "int64"}}
+	}
+}
+
+// Get the header objects of the headers of responses of the policy denying
+// access, with the Retry-After headers if the given option is set
+func (p *policy) openAPIHeaders(retryAfter bool) map[string]*OpenAPIHeader {
+	headers := map[string]*OpenAPIHeader{
+		limitHeader:     {"The limit of the quota", &OpenAPISchema{Type: "integer", Format: "int64"}},
+		remainingHeader: {"The number of accesses remaining within the quota", &OpenAPISchema{Type: "integer", Format: "int64"}},
+		resetHeader:     p.openAPIResetHeader(),
+	}
+
+	if p.options.PolicyName != "" || p.controller.quota.Name != "" {
+		headers[policyHeader] = &OpenAPIHeader{"The name of the policy", &OpenAPISchema{Type: "string"}}
+	}
+
+	if retryAfter {
+		headers[retryAfterHeader] = &OpenAPIHeader{"The seconds to wait before retrying", &OpenAPISchema{Type: "integer", Format: "int64"}}
+		if p.options.ResetFormat != ResetUnixSeconds {
+			headers[retryAfterMsHeader] = &OpenAPIHeader{"The milliseconds to wait before retrying", &OpenAPISchema{Type: "integer", Format: "int64"}}
+		}
+	}
+
+	return headers
+}
+
+// Get the media type objects of the body of responses of the policy with the
+// given message, without an example for messages with template actions
+func (p *policy) openAPIContent(message string, templated bool) map[string]*OpenAPIMediaType {
+	if p.options.OmitBody {
+		return nil
+	}
+
+	media := &OpenAPIMediaType{Schema: &OpenAPISchema{Type: "string"}}
+	if _, ok := p.options.Formatter.(JSONFormatter); ok {
+		media.Schema = jsonMessageSchema
+		if !templated {
+			media.Example = map[string]string{"message": message}
+		}
+	} else if !templated {
+		media.Example = string(p.options.Formatter.Format(message, nil))
+	}
+
+	return map[string]*OpenAPIMediaType{p.contentType(): media}
+}
+
+// Get the response object of responses of the policy with the given access
+// message
+func (p *policy) openAPIMessage(description string, msg *accessMessage, retryAfter bool) *OpenAPIResponse {
+	return &OpenAPIResponse{
+		description,
+		p.openAPIHeaders(retryAfter),
+		p.openAPIContent(msg.Message, msg.Template != nil),
+	}
+}
+
+// Get the OpenAPI 3 response objects of the responses the limiter writes
+// instead of the response of the app, by status code: the denial of requests
+// exceeding the quota, and if configured the ban, the denial of requests of
+// the deny list and the rejection of malformed or missing identities. Keep
+// the spec of an API in sync with the configuration of its policy by
+// generating them
+func (l *Limiter) OpenAPIResponses() map[string]*OpenAPIResponse {
+	p, _ := l.current()
+	o := p.options
+	responses := map[string]*OpenAPIResponse{
+		strconv.Itoa(p.denyMessage.StatusCode): p.openAPIMessage("The quota is exceeded", p.denyMessage, o.RetryAfter),
+	}
+
+	if o.PenaltyPolicy != nil || o.ReputationProvider != nil {
+		code := strconv.Itoa(p.banMessage.StatusCode)
+		if denied, ok := responses[code]; ok {
+			denied.Description = "The quota is exceeded, or the requester is banned"
+			if o.BanRetryAfter && !o.RetryAfter {
+				denied.Headers = p.openAPIHeaders(true)
+			}
+		} else {
+			responses[code] = p.openAPIMessage("The requester is banned", p.banMessage, o.BanRetryAfter)
+		}
+	}
+
+	forbidden := strconv.Itoa(http.StatusForbidden)
+	if _, ok := responses[forbidden]; !ok && o.DenyList != nil {
+		responses[forbidden] = &OpenAPIResponse{
+			"The network of the requester is denied",
+			nil,
+			p.openAPIContent(blockedMessage, false),
+		}
+	}
+
+	badRequest := strconv.Itoa(http.StatusBadRequest)
+	if _, ok := responses[badRequest]; !ok && (o.RejectMalformedIdentities || o.EmptyIdentity == EmptyIdentityDeny) {
+		responses[badRequest] = &OpenAPIResponse{
+			"The identity of the requester is malformed or missing",
+			nil,
+			p.openAPIContent(malformedIdentityMessage, false),
+		}
+	}
+
+	return responses
+}
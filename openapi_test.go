@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestOpenAPIResponses(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Minute, Name: "api"}, &Options{
+		RetryAfter: true,
+		Message:    "Slow down",
+	})
+
+	responses := limiter.OpenAPIResponses()
+	expectSame(t, len(responses), 1)
+	denied := responses["429"]
+	expectSame(t, denied.Description, "The quota is exceeded")
+	for _, header := range []string{limitHeader, remainingHeader, resetHeader, policyHeader, retryAfterHeader} {
+		expectDifferent(t, denied.Headers[header], (*OpenAPIHeader)(nil))
+	}
+	expectSame(t, denied.Headers[retryAfterMsHeader], (*OpenAPIHeader)(nil))
+	expectSame(t, denied.Content["text/plain; charset=utf-8"].Example, "Slow down")
+
+	encoded, err := json.Marshal(responses)
+	expectSame(t, err, nil)
+	expectMatches(t, `"429":\{"description":"The quota is exceeded","headers":\{`, string(encoded))
+}
+
+func TestOpenAPIResponsesWithOptions(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Second}, &Options{
+		Formatter:                 JSONFormatter{},
+		ResetFormat:               ResetDeltaSeconds,
+		PenaltyPolicy:             &FixedPenalty{Ban: time.Minute},
+		BanStatusCode:             503,
+		BanRetryAfter:             true,
+		DenyList:                  NewNetworkList("10.0.0.0/8"),
+		RejectMalformedIdentities: true,
+	})
+
+	responses := limiter.OpenAPIResponses()
+	expectSame(t, len(responses), 4)
+	expectSame(t, responses["429"].Headers[policyHeader], (*OpenAPIHeader)(nil))
+	expectSame(t, responses["429"].Headers[retryAfterHeader], (*OpenAPIHeader)(nil))
+	expectSame(t, responses["429"].Headers[resetHeader].Schema.Type, "number")
+	expectSame(t, responses["429"].Content["application/json; charset=utf-8"].Schema, jsonMessageSchema)
+	expectDifferent(t, responses["503"].Headers[retryAfterMsHeader], (*OpenAPIHeader)(nil))
+	expectSame(t, responses["403"].Headers == nil, true)
+	expectSame(t, responses["400"].Description, "The identity of the requester is malformed or missing")
+}
+
+func TestOpenAPIResponsesWithoutBody(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Minute}, &Options{
+		OmitBody:      true,
+		PenaltyPolicy: &FixedPenalty{Ban: time.Minute},
+	})
+
+	responses := limiter.OpenAPIResponses()
+	expectSame(t, len(responses), 1)
+	expectSame(t, responses["429"].Description, "The quota is exceeded, or the requester is banned")
+	expectSame(t, responses["429"].Content == nil, true)
+}
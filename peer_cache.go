@@ -0,0 +1,389 @@
+package throttle
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The default time peers keep values in memory
+	defaultPeerCacheTTL = time.Second
+
+	// The default number of points per peer on the hash ring
+	defaultPeerCacheReplicas = 50
+
+	// The default path peers serve values under
+	defaultPeerCachePath = "/_throttle/peercache/"
+
+	// The default timeout for requests to peers
+	defaultPeerCacheTimeout = 100 * time.Millisecond
+
+	// The header peers send the shared secret in
+	peerCacheSecretHeader = "X-Throttle-Peer-Secret"
+)
+
+// A PeerCache wraps an authoritative key value store. Reads of a key are
+// served from the memory of the peer owning the key on a consistent hash ring,
+// reducing load on the authoritative store for hot identities. Writes and
+// increments always go to the authoritative store, and written values are
+// passed on to the owning peer.
+// Every peer has to serve the PeerCache under the configured path. Without a
+// Secret, anyone reaching the path can read and overwrite the cached counts,
+// so it must then only be reachable by the peers
+type PeerCache struct {
+	*sync.Mutex
+	store     KeyValueStorer
+	options   *PeerCacheOptions
+	ring      *hashRing
+	client    *http.Client
+	entries   map[string]*peerCacheEntry
+	lastSweep time.Time
+	// The values being sent to other peers
+	puts *sync.WaitGroup
+}
+
+type PeerCacheOptions struct {
+	// The base URLs of all peers, including this one, e.g. "http://10.0.0.1:3000"
+	Peers []string
+
+	// The base URL of this peer, has to be one of the peers
+	Self string
+
+	// The time peers keep values in memory. Reads may be stale for up to this time
+	// defaults to 1 second
+	TTL time.Duration
+
+	// The number of points per peer on the hash ring
+	// defaults to 50
+	Replicas int
+
+	// The path to serve values to other peers under
+	// defaults to "/_throttle/peercache/"
+	Path string
+
+	// The timeout for requests to peers, after which the authoritative store is read
+	// defaults to 100 milliseconds
+	Timeout time.Duration
+
+	// The secret shared by all peers, sent with every request to a peer.
+	// Requests without it are refused with 403 Forbidden
+	// defaults to no secret, accepting all requests
+	Secret string
+
+	// The clock to expire values in memory with, e.g. the Clock of the
+	// policies using the cache
+	// defaults to the system clock
+	Clock Clock
+}
+
+// Error Type for the peer cache
+type PeerCacheError string
+
+// The Error for the Peer Cache
+func (err PeerCacheError) Error() string {
+	return "Throttle Peer Cache Error: " + string(err)
+}
+
+// A value kept in memory by the owning peer
+type peerCacheEntry struct {
+	value   []byte
+	found   bool
+	expires time.Time
+}
+
+// Get a key from the peer owning it, falling back to the authoritative store
+// if the peer can not be reached
+func (c *PeerCache) Get(key string) ([]byte, error) {
+	owner := c.ring.Get(key)
+	if owner == c.options.Self {
+		return c.getLocal(key)
+	}
+
+	value, err, reached := c.getRemote(owner, key)
+	if !reached {
+		return c.store.Get(key)
+	}
+
+	return value, err
+}
+
+// Set a key in the authoritative store
+func (c *PeerCache) Set(key string, value []byte) error {
+	if err := c.store.Set(key, value); err != nil {
+		return err
+	}
+
+	c.remember(key, value)
+	return nil
+}
+
+// Get a key, or set it to the initial value if it does not exist, always
+// reading from the authoritative store so increments are never lost
+func (c *PeerCache) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	if creator, ok := c.store.(KeyValueCreator); ok {
+		value, created, err := creator.GetOrCreate(key, initial, ttl)
+		if created {
+			c.remember(key, value)
+		}
+		return value, created, err
+	}
+
+	if value, err := c.store.Get(key); err == nil {
+		return value, false, nil
+	}
+
+	if err := c.Set(key, initial); err != nil {
+		return nil, false, err
+	}
+
+	return initial, true, nil
+}
+
+// Serve values owned by this peer to other peers, and accept values
+// written by other peers
+func (c *PeerCache) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if c.options.Secret != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get(peerCacheSecretHeader)), []byte(c.options.Secret)) != 1 {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key, err := url.PathUnescape(strings.TrimPrefix(req.URL.EscapedPath(), c.options.Path))
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if req.Method == "PUT" {
+		value, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		c.Lock()
+		c.entries[key] = c.newEntry(value, true)
+		c.Unlock()
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	value, err := c.getLocal(key)
+	if err != nil {
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Write(value)
+}
+
+// Get a key from memory, reading it from the authoritative store if it is not in memory
+func (c *PeerCache) getLocal(key string) ([]byte, error) {
+	now := c.options.Clock.Now()
+
+	c.Lock()
+	c.sweep(now)
+	e, ok := c.entries[key]
+	c.Unlock()
+
+	if !ok || !now.Before(e.expires) {
+		value, err := c.store.Get(key)
+		e = c.newEntry(value, err == nil)
+
+		c.Lock()
+		c.entries[key] = e
+		c.Unlock()
+	}
+
+	if !e.found {
+		return nil, PeerCacheError("Key " + key + " does not exist")
+	}
+
+	return e.value, nil
+}
+
+// Get a key from the given peer. Returns false if the peer could not be reached
+func (c *PeerCache) getRemote(peer string, key string) ([]byte, error, bool) {
+	req, err := c.newRequest("GET", peer, key, nil)
+	if err != nil {
+		return nil, err, false
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err, false
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		value, err := ioutil.ReadAll(resp.Body)
+		return value, err, err == nil
+	case http.StatusNotFound:
+		return nil, PeerCacheError("Key " + key + " does not exist"), true
+	}
+
+	return nil, PeerCacheError("Peer " + peer + " responded with " + resp.Status), false
+}
+
+// Keep a written value in the memory of the peer owning the key. Values
+// owned by other peers are sent in the background, so writes made with the
+// lock of a policy held do not wait for the peer
+func (c *PeerCache) remember(key string, value []byte) {
+	owner := c.ring.Get(key)
+	if owner == c.options.Self {
+		c.Lock()
+		c.entries[key] = c.newEntry(value, true)
+		c.Unlock()
+		return
+	}
+
+	c.puts.Add(1)
+	go c.put(owner, key, value)
+}
+
+// Send a written value to the given peer. Errors are ignored, the owner then
+// serves the previous value until it expires
+func (c *PeerCache) put(peer string, key string, value []byte) {
+	defer c.puts.Done()
+
+	req, err := c.newRequest("PUT", peer, key, bytes.NewReader(value))
+	if err != nil {
+		return
+	}
+
+	if resp, err := c.client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// Return a new request for the given key to the given peer, with the secret
+func (c *PeerCache) newRequest(method string, peer string, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, peer+c.options.Path+url.PathEscape(key), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.options.Secret != "" {
+		req.Header.Set(peerCacheSecretHeader, c.options.Secret)
+	}
+
+	return req, nil
+}
+
+// Return a new entry expiring after the ttl
+func (c *PeerCache) newEntry(value []byte, found bool) *peerCacheEntry {
+	return &peerCacheEntry{
+		value,
+		found,
+		c.options.Clock.Now().Add(c.options.TTL),
+	}
+}
+
+// Remove expired entries, at most once per ttl. Has to be called with the lock held
+func (c *PeerCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.options.TTL {
+		return
+	}
+
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
+
+// A consistent hash ring of peers
+type hashRing struct {
+	points []uint32
+	peers  map[uint32]string
+}
+
+// Get the peer owning the given key
+func (r *hashRing) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+
+	return r.peers[r.points[i]]
+}
+
+// Return a new hash ring with the given number of points per peer
+func newHashRing(peers []string, replicas int) *hashRing {
+	r := &hashRing{
+		peers: make(map[uint32]string),
+	}
+
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			r.points = append(r.points, point)
+			r.peers[point] = peer
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+
+	return r
+}
+
+// Returns a peer cache wrapping the given authoritative store
+func NewPeerCache(store KeyValueStorer, options *PeerCacheOptions) *PeerCache {
+	o := &PeerCacheOptions{
+		Peers:    options.Peers,
+		Self:     options.Self,
+		TTL:      defaultPeerCacheTTL,
+		Replicas: defaultPeerCacheReplicas,
+		Path:     defaultPeerCachePath,
+		Timeout:  defaultPeerCacheTimeout,
+		Secret:   options.Secret,
+		Clock:    systemClock{},
+	}
+
+	if options.TTL != 0 {
+		o.TTL = options.TTL
+	}
+	if options.Replicas != 0 {
+		o.Replicas = options.Replicas
+	}
+	if options.Path != "" {
+		o.Path = options.Path
+	}
+	if options.Timeout != 0 {
+		o.Timeout = options.Timeout
+	}
+	if options.Clock != nil {
+		o.Clock = options.Clock
+	}
+
+	return &PeerCache{
+		&sync.Mutex{},
+		store,
+		o,
+		newHashRing(o.Peers, o.Replicas),
+		&http.Client{Timeout: o.Timeout},
+		make(map[string]*peerCacheEntry),
+		o.Clock.Now(),
+		&sync.WaitGroup{},
+	}
+}
@@ -0,0 +1,194 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingStore struct {
+	KeyValueStorer
+	gets int32
+}
+
+func (s *countingStore) Get(key string) ([]byte, error) {
+	atomic.AddInt32(&s.gets, 1)
+	return s.KeyValueStorer.Get(key)
+}
+
+func newPeerCaches(t *testing.T, store KeyValueStorer) (*PeerCache, *PeerCache) {
+	return newPeerCachesWithSecret(t, store, "")
+}
+
+func newPeerCachesWithSecret(t *testing.T, store KeyValueStorer, secret string) (*PeerCache, *PeerCache) {
+	var first, second *PeerCache
+	firstServer := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		first.ServeHTTP(resp, req)
+	}))
+	secondServer := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		second.ServeHTTP(resp, req)
+	}))
+	t.Cleanup(firstServer.Close)
+	t.Cleanup(secondServer.Close)
+
+	peers := []string{firstServer.URL, secondServer.URL}
+	first = NewPeerCache(store, &PeerCacheOptions{Peers: peers, Self: firstServer.URL, TTL: time.Minute, Secret: secret})
+	second = NewPeerCache(store, &PeerCacheOptions{Peers: peers, Self: secondServer.URL, TTL: time.Minute, Secret: secret})
+
+	return first, second
+}
+
+func TestHashRing(t *testing.T) {
+	ring := newHashRing([]string{"a", "b", "c"}, defaultPeerCacheReplicas)
+	owners := map[string]int{}
+
+	for i := 0; i < 300; i++ {
+		key := makeKey("key", string(rune('a'+i%26)), string(rune('a'+i/26)))
+		owner := ring.Get(key)
+		expectSame(t, ring.Get(key), owner)
+		owners[owner]++
+	}
+
+	expectSame(t, len(owners), 3)
+}
+
+func TestPeerCacheServesReadsFromOwner(t *testing.T) {
+	store := &countingStore{KeyValueStorer: NewMapStore(accessCount{})}
+	first, second := newPeerCaches(t, store)
+
+	if err := first.Set("KEY", []byte("4")); err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		for _, cache := range []*PeerCache{first, second} {
+			value, err := cache.Get("KEY")
+			if err != nil {
+				t.Error(err)
+			}
+			expectSame(t, string(value), "4")
+		}
+	}
+
+	if gets := atomic.LoadInt32(&store.gets); gets > 1 {
+		t.Errorf("Expected at most one read from the authoritative store, but got %d", gets)
+	}
+
+	if _, err := second.Get("MISSING"); err == nil {
+		t.Errorf("Expected missing keys to stay missing")
+	}
+}
+
+func TestPeerCacheFallsBackToStore(t *testing.T) {
+	store := NewMapStore(accessCount{})
+	store.Set("KEY", []byte("4"))
+	cache := NewPeerCache(store, &PeerCacheOptions{
+		Peers: []string{"http://127.0.0.1:1"},
+		Self:  "http://127.0.0.1:2",
+	})
+
+	value, err := cache.Get("KEY")
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, string(value), "4")
+}
+
+func TestPeerCacheUsesClock(t *testing.T) {
+	store := &countingStore{KeyValueStorer: NewMapStore(accessCount{})}
+	store.Set("KEY", []byte("4"))
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := NewPeerCache(store, &PeerCacheOptions{
+		Peers: []string{"http://127.0.0.1:2"},
+		Self:  "http://127.0.0.1:2",
+		TTL:   time.Minute,
+		Clock: clock,
+	})
+
+	cache.Get("KEY")
+	clock.Advance(59 * time.Second)
+	cache.Get("KEY")
+	expectSame(t, atomic.LoadInt32(&store.gets), int32(1))
+
+	clock.Advance(time.Second)
+	cache.Get("KEY")
+	expectSame(t, atomic.LoadInt32(&store.gets), int32(2))
+}
+
+func TestPeerCacheSecret(t *testing.T) {
+	store := &countingStore{KeyValueStorer: NewMapStore(accessCount{})}
+	first, second := newPeerCachesWithSecret(t, store, "secret")
+	store.Set("KEY", []byte("4"))
+
+	for _, cache := range []*PeerCache{first, second} {
+		value, err := cache.Get("KEY")
+		if err != nil {
+			t.Error(err)
+		}
+		expectSame(t, string(value), "4")
+	}
+	if gets := atomic.LoadInt32(&store.gets); gets > 1 {
+		t.Errorf("Expected the peers to accept each other, but got %d reads from the store", gets)
+	}
+
+	for _, secret := range []string{"", "wrong"} {
+		req, _ := http.NewRequest("PUT", defaultPeerCachePath+"KEY", strings.NewReader("0"))
+		req.Header.Set(peerCacheSecretHeader, secret)
+		recorder := httptest.NewRecorder()
+		first.ServeHTTP(recorder, req)
+		expectStatusCode(t, http.StatusForbidden, recorder.Code)
+	}
+}
+
+func TestPeerCacheSetDoesNotWaitForPeer(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cache := NewPeerCache(NewMapStore(accessCount{}), &PeerCacheOptions{
+		Peers:   []string{server.URL},
+		Self:    "http://127.0.0.1:2",
+		Timeout: time.Minute,
+	})
+
+	start := time.Now()
+	if err := cache.Set("KEY", []byte("4")); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the set not to wait for the peer, but took %v", elapsed)
+	}
+}
+
+func TestPolicyWithPeerCache(t *testing.T) {
+	first, second := newPeerCaches(t, NewMapStore(accessCount{}))
+	quota := &Quota{
+		Limit:  2,
+		Within: time.Hour,
+	}
+
+	for _, e := range []struct {
+		cache  *PeerCache
+		status int
+	}{
+		{first, http.StatusOK},
+		{second, http.StatusOK},
+		{first, StatusTooManyRequests},
+		{second, StatusTooManyRequests},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		recorder := httptest.NewRecorder()
+		Policy(quota, &Options{Store: e.cache})(recorder, req)
+		first.puts.Wait()
+		second.puts.Wait()
+
+		expectStatusCode(t, e.status, recorder.Code)
+	}
+}
@@ -0,0 +1,190 @@
+package throttle
+
+import (
+	"math"
+	"time"
+)
+
+// The default time violations are kept after the last violation or the end
+// of the ban
+const defaultViolationsTTL = 24 * time.Hour
+
+// PenaltyPolicy is the interface for the PenaltyPolicy Option
+// It decides if and for how long a requester is banned after a violation of
+// the quota, which allows for custom logic like consulting an external
+// reputation service
+type PenaltyPolicy interface {
+	// Return the ban duration for the requester with the given id, or zero to
+	// not ban the requester. The violations include the current violation,
+	// and may be modified by the penalty policy to keep state
+	Penalize(id string, violations *Violations, now time.Time) time.Duration
+}
+
+// The behavior for denied requests, see the DeniedRetry option
+type DeniedRetryMode int
+
+const (
+	// Denied and banned requests are not counted, they neither increment the
+	// count of the requester nor refresh its time window or extend its ban.
+	// Denials only register a violation for the penalty policy
+	DeniedRetryIgnored DeniedRetryMode = iota
+	// Every denied attempt extends the lockout of the requester. Requesters
+	// denied for exceeding the quota are denied until a time window after
+	// their last attempt, or until a token after it for buckets, and banned
+	// requesters are banned for their ban duration after their last attempt.
+	// Freezes are not extended
+	DeniedRetryExtendsLockout
+)
+
+// Violations of the quota for a single identified user.
+// Will be stored in the key value store next to the access count
+type Violations struct {
+	// The number of violations
+	Count uint64 `json:"count"`
+	// A score for use by penalty policies
+	Score float64 `json:"score"`
+	// The time of the previous violation
+	Last time.Time `json:"last"`
+	// The time the current ban ends
+	BannedUntil time.Time `json:"banned_until"`
+	// The time the violations are forgotten, see Options.ViolationsTTL
+	Expires time.Time `json:"expires"`
+}
+
+// Extend the lockout of the count to the given time: the time window of
+// the count restarts at the given time with the same count, the accesses of
+// a log are moved to the given time, and the refill of a bucket restarts at
+// the given time with the tokens taken then
+func (r *accessCount) ExtendTo(now time.Time) {
+	if r.logged() {
+		for i, a := range r.Log {
+			if a.within(now, r.Duration) {
+				r.Log[i].At = now.UnixNano()
+			}
+		}
+		r.Start = r.StartAt(now)
+		return
+	} else if r.bucket() {
+		r.Count -= r.refilled(now)
+	}
+
+	r.Start = now
+}
+
+// Extend the lockout of the given id denied for exceeding the quota to the
+// current time
+func (c *controller) ExtendLockout(id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	counter := c.GetAccessCount(id)
+	if !counter.IsFreshAt(c.now()) {
+		return
+	}
+
+	counter.ExtendTo(c.now())
+	c.SetAccessCount(id, counter)
+}
+
+// Extend the ban of the given id to its ban duration after the current time
+func (c *controller) ExtendBan(id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	violations := c.GetViolations(id)
+	if !now.Before(violations.BannedUntil) || violations.Last.After(now) {
+		return
+	}
+
+	violations.BannedUntil = now.Add(violations.BannedUntil.Sub(violations.Last))
+	violations.Last = now
+	c.SetViolations(id, violations)
+}
+
+// A FixedPenalty bans requesters for a fixed duration on every violation
+type FixedPenalty struct {
+	// The duration of the ban
+	Ban time.Duration
+}
+
+// Penalize with the fixed ban duration
+func (p *FixedPenalty) Penalize(id string, violations *Violations, now time.Time) time.Duration {
+	return p.Ban
+}
+
+// An ExponentialPenalty bans requesters for a duration growing exponentially
+// with every violation
+type ExponentialPenalty struct {
+	// The duration of the ban for the first violation
+	Ban time.Duration
+	// The factor the ban duration grows by with every further violation
+	// defaults to 2
+	Factor float64
+	// The maximum duration of a ban
+	// defaults to no maximum
+	MaxBan time.Duration
+	// The time after which violations are forgiven when no further violation occurs
+	// defaults to never forgiving violations
+	Forgive time.Duration
+}
+
+// Penalize with a ban duration growing exponentially with the violation count
+func (p *ExponentialPenalty) Penalize(id string, violations *Violations, now time.Time) time.Duration {
+	if p.Forgive != 0 && !violations.Last.IsZero() && now.Sub(violations.Last) > p.Forgive {
+		violations.Count = 1
+	}
+
+	factor := p.Factor
+	if factor == 0 {
+		factor = 2
+	}
+
+	ban := float64(p.Ban) * math.Pow(factor, float64(violations.Count-1))
+	if p.MaxBan != 0 && ban > float64(p.MaxBan) {
+		return p.MaxBan
+	}
+
+	// the longest ban a duration holds, instead of overflowing after many violations
+	if ban >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(ban)
+}
+
+// A ScorePenalty adds to a score on every violation, and bans requesters
+// once the score reaches a threshold. The score decays over time
+type ScorePenalty struct {
+	// The score for every violation
+	// defaults to 1
+	Points float64
+	// The score at which requesters are banned
+	Threshold float64
+	// The time it takes for the score to decay to half its value
+	// defaults to no decay
+	HalfLife time.Duration
+	// The duration of the ban
+	Ban time.Duration
+}
+
+// Penalize with a ban once the score of the violations reaches the threshold
+func (p *ScorePenalty) Penalize(id string, violations *Violations, now time.Time) time.Duration {
+	if p.HalfLife != 0 && !violations.Last.IsZero() {
+		halfLives := float64(now.Sub(violations.Last)) / float64(p.HalfLife)
+		violations.Score = violations.Score * math.Pow(0.5, halfLives)
+	}
+
+	points := p.Points
+	if points == 0 {
+		points = 1
+	}
+	violations.Score += points
+
+	if violations.Score >= p.Threshold {
+		violations.Score = 0
+		return p.Ban
+	}
+
+	return 0
+}
@@ -0,0 +1,234 @@
+package throttle
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFixedPenalty(t *testing.T) {
+	p := &FixedPenalty{Ban: time.Minute}
+	now := time.Now().UTC()
+
+	for i := uint64(1); i < 4; i++ {
+		expectSame(t, p.Penalize("id", &Violations{Count: i}, now), time.Minute)
+	}
+}
+
+func TestExponentialPenalty(t *testing.T) {
+	p := &ExponentialPenalty{
+		Ban:     time.Second,
+		MaxBan:  5 * time.Second,
+		Forgive: time.Hour,
+	}
+	now := time.Now().UTC()
+
+	expectSame(t, p.Penalize("id", &Violations{Count: 1}, now), time.Second)
+	expectSame(t, p.Penalize("id", &Violations{Count: 2, Last: now}, now), 2*time.Second)
+	expectSame(t, p.Penalize("id", &Violations{Count: 3, Last: now}, now), 4*time.Second)
+	expectSame(t, p.Penalize("id", &Violations{Count: 4, Last: now}, now), 5*time.Second)
+
+	forgiven := &Violations{Count: 4, Last: now.Add(-2 * time.Hour)}
+	expectSame(t, p.Penalize("id", forgiven, now), time.Second)
+	expectSame(t, forgiven.Count, uint64(1))
+}
+
+func TestExponentialPenaltyOverflow(t *testing.T) {
+	p := &ExponentialPenalty{Ban: time.Second}
+	now := time.Now().UTC()
+
+	expectSame(t, p.Penalize("id", &Violations{Count: 40, Last: now}, now), time.Duration(math.MaxInt64))
+	expectSame(t, p.Penalize("id", &Violations{Count: 1000, Last: now}, now), time.Duration(math.MaxInt64))
+}
+
+func TestScorePenalty(t *testing.T) {
+	p := &ScorePenalty{
+		Threshold: 2,
+		HalfLife:  time.Minute,
+		Ban:       time.Minute,
+	}
+	now := time.Now().UTC()
+	violations := &Violations{}
+
+	expectSame(t, p.Penalize("id", violations, now), time.Duration(0))
+	violations.Last = now
+	expectSame(t, p.Penalize("id", violations, now), time.Minute)
+	expectSame(t, violations.Score, float64(0))
+
+	decayed := &Violations{Score: 1, Last: now.Add(-time.Minute)}
+	expectSame(t, p.Penalize("id", decayed, now), time.Duration(0))
+	expectSame(t, decayed.Score, 1.5)
+}
+
+func TestPolicyWithPenalty(t *testing.T) {
+	m := setupMartiniWithPolicy(1, 10*time.Millisecond, &Options{
+		PenaltyPolicy: &FixedPenalty{Ban: 40 * time.Millisecond},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+	}, &Expectation{ // The window is reset, but the ban is still in place
+		StatusCode:         StatusTooManyRequests,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+		Wait:               15 * time.Millisecond,
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+		Wait:               30 * time.Millisecond,
+	})
+}
+
+func TestPolicyWithPenaltyAndBanResponse(t *testing.T) {
+	m := setupMartiniWithPolicy(1, 10*time.Millisecond, &Options{
+		PenaltyPolicy: &FixedPenalty{Ban: 1500 * time.Millisecond},
+		BanStatusCode: http.StatusForbidden,
+		BanMessage:    "Banned",
+		BanRetryAfter: true,
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{ // The violation is throttled as usual
+		StatusCode: StatusTooManyRequests,
+		Body:       "Too Many Requests",
+	}, &Expectation{
+		StatusCode:         http.StatusForbidden,
+		Body:               "Banned",
+		RateLimitRemaining: "0",
+		RetryAfter:         "2",
+	})
+}
+
+func TestExtendTo(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	now := start.Add(30 * time.Second)
+
+	window := &accessCount{2, start, time.Minute, nil, 0, 0, nil}
+	window.ExtendTo(now)
+	expectSame(t, window.Start, now)
+	expectSame(t, window.GetCount(now.Add(50*time.Second)), uint64(2))
+
+	log := &accessCount{2, start, time.Minute, []loggedAccess{{start.UnixNano(), 1}, {start.Add(10 * time.Second).UnixNano(), 1}}, 0, 0, nil}
+	log.ExtendTo(now)
+	expectSame(t, log.Start, now)
+	expectSame(t, log.GetCount(now.Add(50*time.Second)), uint64(2))
+
+	bucket := &accessCount{3, start, 3 * 20 * time.Second, nil, 20 * time.Second, 0, nil}
+	bucket.ExtendTo(now.Add(5 * time.Second))
+	expectSame(t, bucket.Count, uint64(2))
+	expectSame(t, bucket.Start, now.Add(5*time.Second))
+}
+
+func TestDeniedRetryIgnored(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 1, Within: time.Minute}, &Options{Clock: clock})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	clock.Advance(50 * time.Second)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	clock.Advance(10 * time.Second)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+}
+
+func TestDeniedRetryExtendsLockout(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	// the key outlives the extended lockout, which the store expires by the
+	// clock
+	policy := Policy(&Quota{Limit: 1, Within: time.Minute}, &Options{
+		Clock:       clock,
+		DeniedRetry: DeniedRetryExtendsLockout,
+		KeyTTL:      time.Hour,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	clock.Advance(50 * time.Second)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	clock.Advance(50 * time.Second)
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Reset"), strconv.FormatInt(clock.Now().Add(time.Minute).Unix(), 10))
+	clock.Advance(time.Minute)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+}
+
+func TestDeniedRetryExtendsBan(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 1, Within: time.Second}, &Options{
+		Clock:         clock,
+		PenaltyPolicy: &FixedPenalty{Ban: time.Minute},
+		DeniedRetry:   DeniedRetryExtendsLockout,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	clock.Advance(50 * time.Second)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	clock.Advance(50 * time.Second)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	clock.Advance(time.Minute)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+}
+
+func TestViolationsOutsideIdentities(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	var events []EventType
+	policy := Policy(&Quota{Limit: 1, Within: time.Minute}, &Options{
+		Store:                  NewMapStore(nil),
+		Clock:                  clock,
+		PenaltyPolicy:          &FixedPenalty{Ban: time.Hour},
+		IdentificationFunction: IdentifyByHeader("X-User"),
+		OnEvent: func(e *Event) {
+			events = append(events, e.Type)
+		},
+	})
+	serveAs := func(user string) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		policy(httptest.NewRecorder(), req)
+	}
+
+	serveAs("victim")
+	serveAs("victim")
+
+	// the count of this identity does not overwrite the violations of the
+	// victim
+	serveAs("victim_violations")
+	clock.Advance(2 * time.Minute)
+	serveAs("victim")
+
+	expected := []EventType{EventAllowed, EventDenied, EventAllowed, EventBanned}
+	expectSame(t, len(events), len(expected))
+	for i, e := range expected {
+		expectSame(t, events[i], e)
+	}
+}
+
+func TestViolationsExpire(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := NewMapStore(nil)
+	c := newController(&Quota{Limit: 1, Within: time.Minute}, mergeOptions([]*Options{{
+		Store:         store,
+		Clock:         clock,
+		PenaltyPolicy: &FixedPenalty{Ban: time.Minute},
+		ViolationsTTL: time.Hour,
+	}}))
+
+	c.RegisterViolation("id")
+	expectSame(t, c.GetViolations("id").Expires, clock.Now().UTC().Add(time.Minute+time.Hour))
+
+	clock.Advance(time.Hour)
+	expectSame(t, c.GetViolations("id").Count, uint64(1))
+	clock.Advance(time.Minute)
+	expectSame(t, c.GetViolations("id").Count, uint64(0))
+}
@@ -0,0 +1,108 @@
+package throttle
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// The default interval of pings retried in the background
+	defaultStorePingInterval = 5 * time.Second
+)
+
+// StorePinger is an optional interface for the Store Option
+// Stores reached over the network should implement it, so that a policy
+// can verify the connectivity to the store when it is created
+type StorePinger interface {
+	// Check if the store is reachable
+	Ping() error
+}
+
+// The behavior of a policy when the store does not answer the ping on
+// creation of the policy
+type StorePingMode int
+
+const (
+	// The store is not pinged
+	StorePingDisabled StorePingMode = iota
+	// The policy panics on creation if the store does not answer
+	StorePingFailFast
+	// The error is logged, and all requests bypass the throttle
+	StorePingFailOpen
+	// The error is logged, requests bypass the throttle and the store is
+	// pinged in the background until it answers
+	StorePingRetry
+)
+
+// Error Type for failed store pings
+type StorePingError string
+
+// The Error for failed store pings
+func (err StorePingError) Error() string {
+	return "Throttle Store Ping Error: " + string(err)
+}
+
+// The availability of the store of a policy, as told by pings
+type storeStatus struct {
+	available int32
+}
+
+// Check if the store answered the last ping
+func (s *storeStatus) Available() bool {
+	return atomic.LoadInt32(&s.available) == 1
+}
+
+// Set the availability of the store
+func (s *storeStatus) set(available bool) {
+	if available {
+		atomic.StoreInt32(&s.available, 1)
+	} else {
+		atomic.StoreInt32(&s.available, 0)
+	}
+}
+
+// Ping the store in the given interval until it answers
+func (s *storeStatus) retry(pinger StorePinger, interval time.Duration, o *Options) {
+	for range time.Tick(interval) {
+		if err := pinger.Ping(); err == nil {
+			o.Logger.Printf("Store is reachable, throttling requests")
+			s.set(true)
+			return
+		}
+	}
+}
+
+// Ping the store of the given options as configured by the StorePing
+// option. Returns nil if the store is not pinged, panics if the store does
+// not answer and the policy fails fast
+func pingStore(o *Options) *storeStatus {
+	pinger, ok := o.Store.(StorePinger)
+	if !ok || o.StorePing == StorePingDisabled {
+		return nil
+	}
+
+	s := &storeStatus{}
+	err := pinger.Ping()
+	if err == nil {
+		s.set(true)
+		return s
+	}
+
+	switch o.StorePing {
+	case StorePingFailFast:
+		panic(StorePingError("Store is not reachable: " + err.Error()))
+	case StorePingFailOpen:
+		o.Logger.Printf("Store is not reachable, requests bypass the throttle: %v", err)
+	case StorePingRetry:
+		o.Logger.Printf("Store is not reachable, requests bypass the throttle until it is: %v", err)
+		go s.retry(pinger, o.StorePingInterval, o)
+	}
+
+	return s
+}
+
+// Check if the store of the policy is available. Stores that are not
+// pinged are always available
+func (p *policy) storeAvailable() bool {
+	return p.store == nil || p.store.Available()
+}
@@ -0,0 +1,102 @@
+package throttle
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pingableStore struct {
+	*MapStore
+	sync.Mutex
+	err error
+}
+
+func (s *pingableStore) Ping() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
+
+func (s *pingableStore) setErr(err error) {
+	s.Lock()
+	s.err = err
+	s.Unlock()
+}
+
+func TestStorePingAnswered(t *testing.T) {
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{
+		Store:     &pingableStore{MapStore: NewMapStore(accessCount{})},
+		StorePing: StorePingFailFast,
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+}
+
+func TestStorePingFailFast(t *testing.T) {
+	defer func() {
+		if err, ok := recover().(StorePingError); !ok || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("Expected a store ping error, but got %v", err)
+		}
+	}()
+
+	Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store:     &pingableStore{MapStore: NewMapStore(accessCount{}), err: errors.New("connection refused")},
+		StorePing: StorePingFailFast,
+	})
+}
+
+func TestStorePingFailOpen(t *testing.T) {
+	var logged bytes.Buffer
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{
+		Store:          &pingableStore{MapStore: NewMapStore(accessCount{}), err: errors.New("connection refused")},
+		StorePing:      StorePingFailOpen,
+		Logger:         log.New(&logged, "", 0),
+		BypassedHeader: true,
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:      http.StatusOK,
+		ResponseHeaders: map[string]string{"X-RateLimit-Bypassed": "true"},
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+
+	if !strings.Contains(logged.String(), "connection refused") {
+		t.Errorf("Expected the ping error to be logged, but got %q", logged.String())
+	}
+}
+
+func TestStorePingRetry(t *testing.T) {
+	store := &pingableStore{MapStore: NewMapStore(accessCount{}), err: errors.New("connection refused")}
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{
+		Store:             store,
+		StorePing:         StorePingRetry,
+		StorePingInterval: 5 * time.Millisecond,
+		Logger:            log.New(&bytes.Buffer{}, "", 0),
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+
+	store.setErr(nil)
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+		Wait:       50 * time.Millisecond,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+}
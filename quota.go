@@ -0,0 +1,216 @@
+package throttle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The Quota is Request Rates per Time for a given policy
+type Quota struct {
+	// The Request Limit. A limit of 0 without a share never denies access,
+	// accesses are only counted, see CountOnly
+	Limit uint64
+	// The time window for the request Limit
+	Within time.Duration
+	// The name of the quota, distinguishes the keys of quotas with the same
+	// limit and time window
+	Name string
+	// The maximum share of all requests to the policy within the time window
+	// a single requester may make, between 0 and 1. The Limit is then the
+	// number of requests always allowed, regardless of the total
+	// defaults to no share
+	Share float64
+	// If time windows start at wall-clock boundaries of their duration in
+	// UTC, e.g. every minute on the minute or every hour on the hour, instead
+	// of at the first access of a requester. All requesters then share the
+	// same reset time
+	// defaults to false
+	AlignToWindow bool
+	// The time zone of the wall clock aligned time windows start at
+	// boundaries of, e.g. "America/New_York" for daily windows starting at
+	// midnight in New York, to match billing periods. Time windows keep
+	// their duration across daylight saving time changes
+	// defaults to UTC
+	AlignZone string
+	// The algorithm to count accesses with, FixedWindow, SlidingWindowLog,
+	// TokenBucket or LeakyBucket. Fixed windows of quotas with AlignToWindow
+	// are aligned, other algorithms are never aligned
+	// defaults to FixedWindow
+	Algorithm Algorithm
+	// The number of tokens a bucket of the TokenBucket or LeakyBucket
+	// algorithm holds, the most requests a requester may make at once.
+	// Buckets are refilled, or drained, with the limit per time window, the
+	// steady rate. Other algorithms ignore it
+	// defaults to the limit for token buckets and 1 for leaky buckets
+	Burst uint64
+	// The number of requests tolerated above the limit within a time window
+	// of the FixedWindow and SlidingWindowLog algorithms before requests are
+	// throttled, reflected in the X-RateLimit-Limit and X-RateLimit-Remaining
+	// headers. Buckets take their Burst instead
+	// defaults to no burst
+	MaxBurst uint64
+	// The most unused requests of earlier time windows carried over into the
+	// next time window as credit of the FixedWindow algorithm, raising the
+	// limit of the window, e.g. for plans advertising credits. Idle time
+	// windows carry over their entire limit. Other algorithms ignore it
+	// defaults to no rollover
+	Rollover uint64
+}
+
+// The id of the quota in keys, see HashedKeyId
+func (q *Quota) KeyId() string {
+	return HashedKeyId(q)
+}
+
+// A fixed-width id of the quota, made of a hash of its limit, time window and
+// name, and the share and algorithm when set
+func HashedKeyId(q *Quota) string {
+	id := strconv.FormatUint(q.Limit, 10) + "/" + strconv.FormatInt(int64(q.Within), 10) + "/" + q.Name
+	if q.Share != 0 {
+		id += "/" + strconv.FormatFloat(q.Share, 'g', -1, 64)
+	}
+	if q.Algorithm != FixedWindow {
+		id += "/" + q.Algorithm.String()
+	}
+	if q.Burst != 0 {
+		id += "/" + strconv.FormatUint(q.Burst, 10)
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// The id of the quota in keys before ids were hashed, the time window
+// divided by the limit. Use it to keep existing keys. Count-only quotas
+// had no keys before, their id is the time window followed by "/0"
+func LegacyKeyId(q *Quota) string {
+	if q.Limit == 0 {
+		return strconv.FormatInt(int64(q.Within), 10) + "/0"
+	}
+
+	return strconv.FormatInt(int64(q.Within)/int64(q.Limit), 10)
+}
+
+// Check if the quota only counts accesses without ever denying access, a
+// quota with a limit of 0 and no share. Use it to collect usage data with
+// the rate limit headers and events, without enforcing a limit
+func (q *Quota) CountOnly() bool {
+	return q.Limit == 0 && q.Share == 0
+}
+
+// Check if the quota counts accesses like the given quota, so counters of
+// one are valid for the other regardless of the limit
+func (q *Quota) countsLike(other *Quota) bool {
+	return q.Within == other.Within && q.AlignToWindow == other.AlignToWindow && q.Algorithm == other.Algorithm
+}
+
+// The time zones of aligned time windows by their names
+var alignZones = struct {
+	*sync.Mutex
+	locations map[string]*time.Location
+}{
+	&sync.Mutex{},
+	map[string]*time.Location{},
+}
+
+// Get the time zone aligned time windows of the quota start at boundaries
+// of. Returns UTC and an error for unknown time zones
+func (q *Quota) alignLocation() (*time.Location, error) {
+	if q.AlignZone == "" {
+		return time.UTC, nil
+	}
+
+	alignZones.Lock()
+	defer alignZones.Unlock()
+
+	if location, ok := alignZones.locations[q.AlignZone]; ok {
+		return location, nil
+	}
+
+	location, err := time.LoadLocation(q.AlignZone)
+	if err != nil {
+		return time.UTC, ConfigError("Invalid AlignZone " + strconv.Quote(q.AlignZone) + ": " + err.Error())
+	}
+	alignZones.locations[q.AlignZone] = location
+
+	return location, nil
+}
+
+// Return a copy of the quota with the limit scaled by the given factor.
+// A limit is never scaled below 1
+func (q *Quota) Scale(f float64) *Quota {
+	scaled := *q
+	scaled.Limit = uint64(float64(q.Limit) * f)
+	if scaled.Limit == 0 && q.Limit != 0 {
+		scaled.Limit = 1
+	}
+	scaled.Burst = uint64(float64(q.Burst) * f)
+	if scaled.Burst == 0 && q.Burst != 0 {
+		scaled.Burst = 1
+	}
+	scaled.MaxBurst = uint64(float64(q.MaxBurst) * f)
+
+	return &scaled
+}
+
+// Return a copy of the quota with the limit raised to the share of the
+// given total number of requests
+func (q *Quota) shareOf(total uint64) *Quota {
+	shared := *q
+	if limit := uint64(float64(total) * q.Share); limit > q.Limit {
+		shared.Limit = limit
+	}
+
+	return &shared
+}
+
+// Return the stricter of the quota and the other quota, i.e. the one
+// allowing the lower rate of requests
+func (q *Quota) Min(other *Quota) *Quota {
+	if other == nil {
+		return q
+	}
+
+	if float64(other.Limit)*float64(q.Within) < float64(q.Limit)*float64(other.Within) {
+		return other
+	}
+
+	return q
+}
+
+// A PolicySet is a set of named quotas, e.g. one per plan
+type PolicySet map[string]*Quota
+
+// Return a new policy set with the limits of all quotas scaled by the given factor
+func (s PolicySet) Scale(f float64) PolicySet {
+	scaled := make(PolicySet, len(s))
+	for name, quota := range s {
+		scaled[name] = quota.Scale(f)
+	}
+
+	return scaled
+}
+
+// Return a new policy set with every quota capped to the given quota
+func (s PolicySet) Min(other *Quota) PolicySet {
+	capped := make(PolicySet, len(s))
+	for name, quota := range s {
+		capped[name] = quota.Min(other)
+	}
+
+	return capped
+}
+
+// Return a policy for every quota in the set, using the given options
+func (s PolicySet) Policies(options ...*Options) map[string]func(resp http.ResponseWriter, req *http.Request) {
+	policies := make(map[string]func(resp http.ResponseWriter, req *http.Request), len(s))
+	for name, quota := range s {
+		policies[name] = Policy(quota, options...)
+	}
+
+	return policies
+}
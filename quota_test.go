@@ -0,0 +1,272 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQuotaKeyId(t *testing.T) {
+	q := &Quota{Limit: 10, Within: time.Second}
+	expectSame(t, len(q.KeyId()), 16)
+	expectSame(t, q.KeyId(), HashedKeyId(&Quota{Limit: 10, Within: time.Second}))
+	expectSame(t, LegacyKeyId(q), "100000000")
+
+	// quotas with the same rate do not share keys
+	expectDifferent(t, q.KeyId(), (&Quota{Limit: 100, Within: 10 * time.Second}).KeyId())
+	expectDifferent(t, q.KeyId(), (&Quota{Limit: 10, Within: time.Second, Name: "search"}).KeyId())
+}
+
+func TestLegacyKeyIdOption(t *testing.T) {
+	store := NewMapStore(accessCount{})
+	quota := &Quota{Limit: 10, Within: time.Second}
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+
+	Policy(quota, &Options{Store: store, KeyIdFunction: LegacyKeyId})(httptest.NewRecorder(), req)
+	if _, err := store.Get("throttle_100000000_1.2.3.4"); err != nil {
+		t.Error(err)
+	}
+
+	Policy(quota, &Options{Store: store})(httptest.NewRecorder(), req)
+	if _, err := store.Get(makeKey("throttle", quota.KeyId(), "1.2.3.4")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCountOnlyQuota(t *testing.T) {
+	expectSame(t, (&Quota{Limit: 0, Within: time.Hour}).CountOnly(), true)
+	expectSame(t, (&Quota{Limit: 1, Within: time.Hour}).CountOnly(), false)
+	expectSame(t, (&Quota{Limit: 0, Within: time.Hour, Share: 0.1}).CountOnly(), false)
+	expectSame(t, LegacyKeyId(&Quota{Limit: 0, Within: time.Second}), "1000000000/0")
+}
+
+func TestCountOnlyPolicy(t *testing.T) {
+	var events []*Event
+	limiter := NewLimiter(&Quota{
+		Limit:  0,
+		Within: time.Hour,
+	}, &Options{
+		OnEvent: func(e *Event) {
+			events = append(events, e)
+		},
+	})
+	m := setupMartiniWithLimiter(limiter)
+
+	testResponses(t, m, &Expectation{
+		StatusCode:      http.StatusOK,
+		RateLimitLimit:  "0",
+		ResponseHeaders: map[string]string{"X-RateLimit-Remaining": ""},
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+	})
+
+	expectSame(t, limiter.Status("1.2.3.4").Count, uint64(3))
+	expectSame(t, len(events), 3)
+	expectSame(t, events[2].Type, EventAllowed)
+}
+
+func TestAlignToWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 10, 0, 30, 0, time.UTC)}
+	limiter := NewLimiter(&Quota{
+		Limit:         2,
+		Within:        time.Minute,
+		AlignToWindow: true,
+	}, &Options{
+		Clock: clock,
+	})
+	serve := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		recorder := httptest.NewRecorder()
+		limiter.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// the window started on the minute, not at the first access
+	resp := serve()
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Reset"), strconv.FormatInt(time.Date(2020, 1, 1, 10, 1, 0, 0, time.UTC).Unix(), 10))
+	expectSame(t, limiter.Status("1.2.3.4").WindowStart, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC))
+
+	clock.Advance(20 * time.Second)
+	expectStatusCode(t, http.StatusOK, serve().Code)
+	expectStatusCode(t, StatusTooManyRequests, serve().Code)
+
+	// the next window starts on the next minute
+	clock.Advance(10 * time.Second)
+	resp = serve()
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Reset"), strconv.FormatInt(time.Date(2020, 1, 1, 10, 2, 0, 0, time.UTC).Unix(), 10))
+	expectSame(t, limiter.Status("1.2.3.4").Count, uint64(1))
+}
+
+func TestAlignZone(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 10, 0, 0, 0, newYork)}
+	limiter := NewLimiter(&Quota{
+		Limit:         1,
+		Within:        24 * time.Hour,
+		AlignToWindow: true,
+		AlignZone:     "America/New_York",
+	}, &Options{
+		Clock: clock,
+		Store: NewMapStore(nil),
+	})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+
+	// the daily window started at midnight in New York
+	resp := httptest.NewRecorder()
+	limiter.ServeHTTP(resp, req)
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Reset"), strconv.FormatInt(time.Date(2020, 1, 2, 0, 0, 0, 0, newYork).Unix(), 10))
+	expectSame(t, limiter.Status("1.2.3.4").WindowStart, time.Date(2020, 1, 1, 0, 0, 0, 0, newYork).UTC())
+}
+
+func TestAlignZoneWithHalfHourOffset(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	controller := newController(&Quota{Limit: 1, Within: time.Hour, AlignToWindow: true, AlignZone: "Asia/Kolkata"}, newOptions(nil))
+	expectSame(t, controller.windowStart(time.Date(2020, 1, 1, 10, 45, 0, 0, kolkata).UTC()), time.Date(2020, 1, 1, 10, 0, 0, 0, kolkata).UTC())
+}
+
+func TestInvalidAlignZone(t *testing.T) {
+	defer func() {
+		expectMatches(t, "^Throttle Config Error: Invalid AlignZone \"Nowhere/Place\"", recover().(string))
+	}()
+
+	Policy(&Quota{Limit: 1, Within: time.Hour, AlignToWindow: true, AlignZone: "Nowhere/Place"})
+}
+
+func TestQuotaScale(t *testing.T) {
+	q := &Quota{Limit: 10, Within: time.Minute}
+
+	expectSame(t, q.Scale(0.5).Limit, uint64(5))
+	expectSame(t, q.Scale(0.5).Within, time.Minute)
+	expectSame(t, q.Scale(2).Limit, uint64(20))
+	expectSame(t, q.Scale(0.01).Limit, uint64(1))
+	expectSame(t, q.Limit, uint64(10))
+}
+
+func TestQuotaMin(t *testing.T) {
+	perSecond := &Quota{Limit: 10, Within: time.Second}
+	perMinute := &Quota{Limit: 300, Within: time.Minute}
+
+	expectSame(t, perSecond.Min(perMinute), perMinute)
+	expectSame(t, perMinute.Min(perSecond), perMinute)
+	expectSame(t, perSecond.Min(nil), perSecond)
+}
+
+func TestPolicySet(t *testing.T) {
+	plans := PolicySet{
+		"free": &Quota{Limit: 10, Within: time.Minute},
+		"pro":  &Quota{Limit: 1000, Within: time.Minute},
+	}
+
+	scaled := plans.Scale(0.5)
+	expectSame(t, scaled["free"].Limit, uint64(5))
+	expectSame(t, scaled["pro"].Limit, uint64(500))
+
+	capped := plans.Min(&Quota{Limit: 100, Within: time.Minute})
+	expectSame(t, capped["free"].Limit, uint64(10))
+	expectSame(t, capped["pro"].Limit, uint64(100))
+
+	policies := plans.Policies(&Options{Store: NewMapStore(accessCount{})})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4:5000"
+	recorder := httptest.NewRecorder()
+	policies["free"](recorder, req)
+
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "10")
+}
+
+func TestQuotaShare(t *testing.T) {
+	q := &Quota{Limit: 2, Within: time.Minute, Share: 0.5}
+
+	expectSame(t, q.shareOf(0).Limit, uint64(2))
+	expectSame(t, q.shareOf(10).Limit, uint64(5))
+	expectDifferent(t, q.KeyId(), (&Quota{Limit: 2, Within: time.Minute}).KeyId())
+}
+
+func TestPolicyWithShare(t *testing.T) {
+	policy := Policy(&Quota{Limit: 2, Within: time.Hour, Share: 0.5})
+	serve := func(ip string) int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder.Code
+	}
+
+	// the limit is always allowed
+	expectStatusCode(t, http.StatusOK, serve("1.1.1.1"))
+	expectStatusCode(t, http.StatusOK, serve("1.1.1.1"))
+	expectStatusCode(t, StatusTooManyRequests, serve("1.1.1.1"))
+
+	for _, ip := range []string{"2.2.2.2", "3.3.3.3", "4.4.4.4", "5.5.5.5", "6.6.6.6"} {
+		expectStatusCode(t, http.StatusOK, serve(ip))
+		expectStatusCode(t, http.StatusOK, serve(ip))
+	}
+
+	// a single requester may make half of all requests
+	for i := 0; i < 11; i++ {
+		expectStatusCode(t, http.StatusOK, serve("7.7.7.7"))
+	}
+	expectStatusCode(t, StatusTooManyRequests, serve("7.7.7.7"))
+}
+
+func TestShareTotalOutsideIdentities(t *testing.T) {
+	policy := Policy(&Quota{Limit: 2, Within: time.Hour, Share: 0.5}, &Options{
+		IdentificationFunction: IdentifyByHeader("X-User"),
+	})
+	serveAs := func(user string) int {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder.Code
+	}
+
+	for _, user := range []string{"a", "b", "c", "d", "e"} {
+		serveAs(user)
+		serveAs(user)
+	}
+
+	// the count of this identity is its own, not the total
+	expectStatusCode(t, http.StatusOK, serveAs("total"))
+}
+
+func TestMaxBurst(t *testing.T) {
+	policy := Policy(&Quota{Limit: 2, Within: time.Hour, MaxBurst: 1}, &Options{
+		Store: NewMapStore(nil),
+	})
+
+	for i := 2; i >= 0; i-- {
+		resp := serveMethod(policy, "GET")
+		expectStatusCode(t, http.StatusOK, resp.Code)
+		expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "3")
+		expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), strconv.Itoa(i))
+	}
+
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+}
+
+func TestMaxBurstCapacity(t *testing.T) {
+	expectSame(t, (&Quota{Limit: 10, MaxBurst: 5}).capacity(), uint64(15))
+	expectSame(t, (&Quota{Limit: 10, MaxBurst: 5, Algorithm: SlidingWindowLog}).capacity(), uint64(15))
+	expectSame(t, (&Quota{Limit: 10, MaxBurst: 5, Algorithm: TokenBucket}).capacity(), uint64(10))
+	expectSame(t, (&Quota{Limit: 0, MaxBurst: 5}).capacity(), uint64(0))
+	expectSame(t, (&Quota{Limit: 10, MaxBurst: 5}).Scale(0.5).capacity(), uint64(7))
+}
@@ -0,0 +1,96 @@
+package throttle
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+)
+
+// Random is the interface for the Random Option, the source of all
+// randomness of the throttle, e.g. jitter. Implementations have to be
+// concurrent-safe
+type Random interface {
+	// Return a random number in [0.0, 1.0)
+	Float64() float64
+	// Return a random number in [0, n)
+	Int63n(n int64) int64
+}
+
+// The random source of math/rand, the default random source
+type systemRandom struct{}
+
+// Return a random number in [0.0, 1.0)
+func (r systemRandom) Float64() float64 {
+	return rand.Float64()
+}
+
+// Return a random number in [0, n)
+func (r systemRandom) Int63n(n int64) int64 {
+	return rand.Int63n(n)
+}
+
+// A random source seeded with a fixed seed
+type seededRandom struct {
+	*sync.Mutex
+	random *rand.Rand
+}
+
+// Return a random number in [0.0, 1.0)
+func (r *seededRandom) Float64() float64 {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.random.Float64()
+}
+
+// Return a random number in [0, n)
+func (r *seededRandom) Int63n(n int64) int64 {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.random.Int63n(n)
+}
+
+// Returns a concurrent-safe random source seeded with the given seed, so
+// tests relying on randomness are reproducible
+func NewSeededRandom(seed int64) Random {
+	return &seededRandom{
+		&sync.Mutex{},
+		rand.New(rand.NewSource(seed)),
+	}
+}
+
+// CryptoRandom is a random source reading from crypto/rand, for users who
+// do not want jitter to be predictable. Panics if crypto/rand fails
+type CryptoRandom struct{}
+
+// Return a random number in [0.0, 1.0)
+func (r CryptoRandom) Float64() float64 {
+	return float64(r.uint64()>>11) / (1 << 53)
+}
+
+// Return a random number in [0, n)
+func (r CryptoRandom) Int63n(n int64) int64 {
+	if n <= 0 {
+		panic("invalid argument to Int63n")
+	}
+
+	// reject values above the largest multiple of n to avoid a modulo bias
+	max := uint64(1<<63) - uint64(1<<63)%uint64(n)
+	for {
+		if v := r.uint64() >> 1; v < max {
+			return int64(v % uint64(n))
+		}
+	}
+}
+
+// Read a random uint64
+func (r CryptoRandom) uint64() uint64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err.Error())
+	}
+
+	return binary.BigEndian.Uint64(b[:])
+}
@@ -0,0 +1,49 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeededRandomIsReproducible(t *testing.T) {
+	a, b := NewSeededRandom(42), NewSeededRandom(42)
+
+	for i := 0; i < 10; i++ {
+		expectSame(t, a.Float64(), b.Float64())
+		expectSame(t, a.Int63n(100), b.Int63n(100))
+	}
+}
+
+func TestCryptoRandom(t *testing.T) {
+	r := CryptoRandom{}
+
+	for i := 0; i < 100; i++ {
+		if f := r.Float64(); f < 0 || f >= 1 {
+			t.Errorf("Expected %v to be in [0, 1)", f)
+		}
+		if n := r.Int63n(10); n < 0 || n >= 10 {
+			t.Errorf("Expected %v to be in [0, 10)", n)
+		}
+	}
+}
+
+func TestChaosStoreWithSeededRandom(t *testing.T) {
+	errors := func() []bool {
+		store := NewChaosStore(NewMapStore(accessCount{}), &ChaosStoreOptions{
+			ErrorRate: 0.5,
+			Jitter:    time.Microsecond,
+			Random:    NewSeededRandom(7),
+		})
+
+		var errors []bool
+		for i := 0; i < 20; i++ {
+			errors = append(errors, store.Set("KEY", []byte("4")) != nil)
+		}
+		return errors
+	}
+
+	first, second := errors(), errors()
+	for i := range first {
+		expectSame(t, first[i], second[i])
+	}
+}
@@ -0,0 +1,59 @@
+package redisstore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/martini-contrib/throttle"
+)
+
+func TestRegistersSchemes(t *testing.T) {
+	store, err := throttle.NewStoreFromURL("redis://localhost:6379/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*Store); !ok {
+		t.Errorf("Expected a redis store, but got %T", store)
+	}
+	if _, ok := store.(throttle.StorePinger); !ok {
+		t.Errorf("Expected the store to be pingable")
+	}
+	if _, ok := store.(throttle.KeyValueBatchGetter); !ok {
+		t.Errorf("Expected the store to get batches")
+	}
+}
+
+// Runs against the server in REDIS_URL
+func TestStore(t *testing.T) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		t.Skip("REDIS_URL not set")
+	}
+
+	kv, err := throttle.NewStoreFromURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := kv.(*Store)
+	key := "throttle_test_" + time.Now().Format(time.RFC3339Nano)
+	if _, created, err := store.GetOrCreate(key, []byte("1"), time.Minute); err != nil || !created {
+		t.Errorf("Expected the key to be created, but got %v, %v", created, err)
+	}
+	if value, created, err := store.GetOrCreate(key, []byte("2"), time.Minute); err != nil || created || string(value) != "1" {
+		t.Errorf("Expected the existing value 1, but got %q, %v, %v", value, created, err)
+	}
+	if err := store.Set(key, []byte("3")); err != nil {
+		t.Error(err)
+	}
+	if value, err := store.Get(key); err != nil || string(value) != "3" {
+		t.Errorf("Expected the value 3, but got %q, %v", value, err)
+	}
+	if values, err := store.GetBatch([]string{key, key + "_missing"}); err != nil || len(values) != 1 || string(values[key]) != "3" {
+		t.Errorf("Expected a batch of the value 3, but got %q, %v", values, err)
+	}
+	if _, err := store.Get(key + "_missing"); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+}
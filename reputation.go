@@ -0,0 +1,140 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// The default time to cache reputations for
+	defaultReputationTTL = 5 * time.Minute
+
+	// The default time to wait for a reputation lookup
+	defaultReputationTimeout = 50 * time.Millisecond
+)
+
+// ReputationProvider is the interface for the ReputationProvider Option
+// This should allow for integration with IP reputation services and threat feeds
+type ReputationProvider interface {
+	// Return the reputation of the requester with the given IP, the remote
+	// address of its connection
+	Reputation(ip string) (Reputation, error)
+}
+
+// A Reputation of a requester, as given by a ReputationProvider
+type Reputation struct {
+	// If access is denied to the requester regardless of the quota
+	Deny bool
+	// The factor to scale the limit of the quota by for the requester, between 0 and 1
+	// Zero means the limit is not scaled
+	LimitFactor float64
+}
+
+// A cached reputation
+type reputationEntry struct {
+	// The reputation, the previous one while a lookup is pending
+	reputation Reputation
+	// The time the reputation expires
+	expires time.Time
+	// Closed when the lookup is done
+	done chan struct{}
+}
+
+// Check if the lookup for the reputation is done
+func (e *reputationEntry) isDone() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// A cache for reputations, looking up reputations asynchronously
+type reputationCache struct {
+	*sync.Mutex
+	provider  ReputationProvider
+	clock     Clock
+	ttl       time.Duration
+	timeout   time.Duration
+	entries   map[string]*reputationEntry
+	lastSweep time.Time
+}
+
+// Get the reputation for the given ip. Waits for a pending lookup until the
+// timeout is reached, then returns the previous reputation if any
+func (c *reputationCache) Get(ip string) Reputation {
+	now := c.clock.Now()
+
+	c.Lock()
+	c.sweep(now)
+	e, ok := c.entries[ip]
+	if !ok || (e.isDone() && now.After(e.expires)) {
+		e = c.lookup(ip, e)
+	}
+	c.Unlock()
+
+	select {
+	case <-e.done:
+	case <-time.After(c.timeout):
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return e.reputation
+}
+
+// Start a lookup for the given ip, keeping the reputation of the previous entry
+// while the lookup is pending. Has to be called with the lock held
+func (c *reputationCache) lookup(ip string, previous *reputationEntry) *reputationEntry {
+	e := &reputationEntry{
+		done: make(chan struct{}),
+	}
+	if previous != nil {
+		e.reputation = previous.reputation
+	}
+	c.entries[ip] = e
+
+	go func() {
+		reputation, err := c.provider.Reputation(ip)
+
+		c.Lock()
+		if err == nil {
+			e.reputation = reputation
+		}
+		e.expires = c.clock.Now().Add(c.ttl)
+		close(e.done)
+		c.Unlock()
+	}()
+
+	return e
+}
+
+// Remove expired reputations, at most once per ttl. Has to be called with the lock held
+func (c *reputationCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+
+	for ip, e := range c.entries {
+		if e.isDone() && now.After(e.expires) {
+			delete(c.entries, ip)
+		}
+	}
+	c.lastSweep = now
+}
+
+// Return a new reputation cache for the given provider, expiring
+// reputations by the given clock
+func newReputationCache(provider ReputationProvider, clock Clock, ttl time.Duration, timeout time.Duration) *reputationCache {
+	return &reputationCache{
+		&sync.Mutex{},
+		provider,
+		clock,
+		ttl,
+		timeout,
+		make(map[string]*reputationEntry),
+		clock.Now(),
+	}
+}
@@ -0,0 +1,104 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testReputationProvider struct {
+	delay       time.Duration
+	reputations map[string]Reputation
+}
+
+func (p *testReputationProvider) Reputation(ip string) (Reputation, error) {
+	time.Sleep(p.delay)
+	return p.reputations[ip], nil
+}
+
+func TestReputationDeny(t *testing.T) {
+	m := setupMartiniWithPolicy(2, 20*time.Millisecond, &Options{
+		ReputationProvider: &testReputationProvider{
+			reputations: map[string]Reputation{
+				"1.2.3.4": Reputation{Deny: true},
+			},
+		},
+	})
+
+	// the reputation is looked up for the connection, which a forwarded
+	// address does not escape
+	testResponses(t, m, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	})
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "1.2.3.4:5000"
+	req.Header.Set("X-Forwarded-For", "2.3.4.5")
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, req)
+	expectStatusCode(t, StatusTooManyRequests, recorder.Code)
+}
+
+func TestReputationLimitFactor(t *testing.T) {
+	m := setupMartiniWithPolicy(4, 20*time.Millisecond, &Options{
+		ReputationProvider: &testReputationProvider{
+			reputations: map[string]Reputation{
+				"1.2.3.4": Reputation{LimitFactor: 0.5},
+			},
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "1",
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "0",
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "0",
+	})
+}
+
+func TestReputationTimeout(t *testing.T) {
+	m := setupMartiniWithPolicy(2, 50*time.Millisecond, &Options{
+		ReputationProvider: &testReputationProvider{
+			delay: 10 * time.Millisecond,
+			reputations: map[string]Reputation{
+				"1.2.3.4": Reputation{Deny: true},
+			},
+		},
+		ReputationTimeout: time.Millisecond,
+	})
+
+	testResponses(t, m, &Expectation{ // The lookup is still pending
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "1",
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+		Wait:       20 * time.Millisecond,
+	})
+}
+
+func TestReputationCache(t *testing.T) {
+	provider := &testReputationProvider{
+		reputations: map[string]Reputation{
+			"1.2.3.4": Reputation{LimitFactor: 0.5},
+		},
+	}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := newReputationCache(provider, clock, time.Minute, 10*time.Millisecond)
+
+	expectSame(t, cache.Get("1.2.3.4").LimitFactor, 0.5)
+	provider.reputations = map[string]Reputation{}
+	clock.Advance(59 * time.Second)
+	expectSame(t, cache.Get("1.2.3.4").LimitFactor, 0.5)
+
+	clock.Advance(2 * time.Second)
+	expectSame(t, cache.Get("1.2.3.4").LimitFactor, float64(0))
+}
@@ -0,0 +1,82 @@
+package throttle
+
+import "net/http"
+
+// A Reservation reserves a share of the global quota for specific requesters,
+// e.g. internal services, so they always have headroom even when other
+// requesters use up the global quota
+type Reservation struct {
+	// The name of the reservation, will be part of the key
+	Name string
+
+	// The share of the global quota reserved, between 0 and 1
+	Share float64
+
+	// The function deciding if a request is entitled to the reservation
+	Function func(*http.Request) bool
+}
+
+// A pool of capacity of the global quota
+type globalPool struct {
+	controller *controller
+	key        string
+	function   func(*http.Request) bool
+}
+
+// The global quota, split into the reserved pools and a public pool for
+// all other requests
+type globalQuota struct {
+	public   *globalPool
+	reserved []*globalPool
+}
+
+// Return the pool able to take an access of the given cost for the request.
+// Requests entitled to a reservation use the public pool once the reserved
+// pool is used up. Returns false if no pool is able to take the access,
+// together with the public pool
+func (g *globalQuota) pool(req *http.Request, cost uint64) (*globalPool, bool) {
+	for _, pool := range g.reserved {
+		if pool.function(req) && !pool.controller.DeniesAccess(pool.key, cost) {
+			return pool, true
+		}
+	}
+
+	return g.public, !g.public.controller.DeniesAccess(g.public.key, cost)
+}
+
+// Return the global quota for the given options, or nil if there is none
+func newGlobalQuota(prefix string, o *Options) *globalQuota {
+	if o.GlobalQuota == nil {
+		return nil
+	}
+
+	key := makeKey(prefix, o.KeyIdFunction(o.GlobalQuota), "global")
+	g := &globalQuota{}
+	public := 1.0
+
+	for _, reservation := range o.Reservations {
+		quota := *o.GlobalQuota
+		quota.Limit = uint64(float64(o.GlobalQuota.Limit) * reservation.Share)
+		public -= reservation.Share
+
+		g.reserved = append(g.reserved, &globalPool{
+			newController(&quota, o),
+			makeKey(key, reservation.Name),
+			reservation.Function,
+		})
+	}
+
+	quota := *o.GlobalQuota
+	if public > 0 {
+		quota.Limit = uint64(float64(o.GlobalQuota.Limit) * public)
+	} else {
+		quota.Limit = 0
+	}
+	g.public = &globalPool{
+		newController(&quota, o),
+		key,
+		nil,
+	}
+
+	return g
+}
@@ -0,0 +1,68 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func serveWithGlobalQuota(policy func(http.ResponseWriter, *http.Request), ip string, internal bool) int {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = ip
+	if internal {
+		req.Header.Set("X-Internal", "1")
+	}
+	recorder := httptest.NewRecorder()
+	policy(recorder, req)
+
+	return recorder.Code
+}
+
+func TestGlobalQuotaWithReservation(t *testing.T) {
+	policy := Policy(&Quota{Limit: 100, Within: time.Hour}, &Options{
+		GlobalQuota: &Quota{Limit: 10, Within: time.Hour},
+		Reservations: []*Reservation{
+			{
+				Name:  "internal",
+				Share: 0.3,
+				Function: func(req *http.Request) bool {
+					return req.Header.Get("X-Internal") != ""
+				},
+			},
+		},
+	})
+
+	// public requests use up the public share
+	for i := 0; i < 7; i++ {
+		expectStatusCode(t, http.StatusOK, serveWithGlobalQuota(policy, "1.1.1."+strconv.Itoa(i), false))
+	}
+	expectStatusCode(t, StatusTooManyRequests, serveWithGlobalQuota(policy, "2.2.2.2", false))
+
+	// internal requests still have headroom
+	for i := 0; i < 3; i++ {
+		expectStatusCode(t, http.StatusOK, serveWithGlobalQuota(policy, "10.0.0.1", true))
+	}
+	expectStatusCode(t, StatusTooManyRequests, serveWithGlobalQuota(policy, "10.0.0.1", true))
+}
+
+func TestReservationFallsBackToPublicShare(t *testing.T) {
+	policy := Policy(&Quota{Limit: 100, Within: time.Hour}, &Options{
+		GlobalQuota: &Quota{Limit: 10, Within: time.Hour},
+		Reservations: []*Reservation{
+			{
+				Name:  "internal",
+				Share: 0.5,
+				Function: func(req *http.Request) bool {
+					return true
+				},
+			},
+		},
+	})
+
+	for i := 0; i < 10; i++ {
+		expectStatusCode(t, http.StatusOK, serveWithGlobalQuota(policy, "10.0.0.1", true))
+	}
+	expectStatusCode(t, StatusTooManyRequests, serveWithGlobalQuota(policy, "10.0.0.1", true))
+}
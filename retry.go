@@ -0,0 +1,281 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// The default number of retries of a failed store operation
+	defaultStoreRetries = 2
+
+	// The default time to wait before the first retry
+	defaultStoreRetryBackoff = 10 * time.Millisecond
+
+	// The default longest time to wait before a retry
+	defaultStoreRetryMaxBackoff = 100 * time.Millisecond
+
+	// The default time a request may wait for retries in total
+	defaultStoreRetryBudget = 50 * time.Millisecond
+)
+
+// A StoreRetry retries store operations failing with transient errors, e.g.
+// timeouts and connection resets, with exponential backoff and jitter. The
+// time a request waits for retries is limited by the budget, so retries do
+// not blow the latency of requests. Failed reads would otherwise count as
+// missing keys, restarting the time window of the requester
+type StoreRetry struct {
+	// The number of retries of a failed operation
+	// defaults to 2
+	Retries int
+
+	// The time to wait before the first retry, doubled for every retry
+	// Waits are jittered randomly between half and all of the backoff
+	// defaults to 10 milliseconds
+	Backoff time.Duration
+
+	// The longest time to wait before a retry
+	// defaults to 100 milliseconds
+	MaxBackoff time.Duration
+
+	// The time a request may wait for retries in total, counted from the
+	// start of the throttling of the request. Retries waiting beyond the
+	// budget, or the end of the request, are not made
+	// defaults to 50 milliseconds
+	Budget time.Duration
+
+	// The function deciding if an error of the store is transient
+	// defaults to IsTransientStoreError
+	Retryable func(error) bool
+}
+
+// Check if the given error of a store is transient, i.e. a timeout, a reset,
+// aborted or broken connection, or a connection closed unexpectedly
+func IsTransientStoreError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Get the number of retries of a failed operation
+func (r *StoreRetry) retries() int {
+	if r.Retries == 0 {
+		return defaultStoreRetries
+	}
+
+	return r.Retries
+}
+
+// Get the time to wait before the first retry
+func (r *StoreRetry) backoff() time.Duration {
+	if r.Backoff <= 0 {
+		return defaultStoreRetryBackoff
+	}
+
+	return r.Backoff
+}
+
+// Get the longest time to wait before a retry
+func (r *StoreRetry) maxBackoff() time.Duration {
+	if r.MaxBackoff <= 0 {
+		return defaultStoreRetryMaxBackoff
+	}
+
+	return r.MaxBackoff
+}
+
+// Get the time a request may wait for retries in total
+func (r *StoreRetry) budget() time.Duration {
+	if r.Budget <= 0 {
+		return defaultStoreRetryBudget
+	}
+
+	return r.Budget
+}
+
+// Check if the given error is retried
+func (r *StoreRetry) retryable(err error) bool {
+	if r.Retryable == nil {
+		return IsTransientStoreError(err)
+	}
+
+	return r.Retryable(err)
+}
+
+// A view on a store for a single request, retrying failed operations within
+// the retry budget of the request
+type retryStore struct {
+	store  KeyValueStorer
+	retry  *StoreRetry
+	random Random
+	clock  Clock
+	ctx    context.Context
+	// The time after which no more retries are waited for
+	deadline time.Time
+}
+
+// A retrying view on a store able to create keys atomically
+type retryCreatorStore struct {
+	*retryStore
+	creator KeyValueCreator
+}
+
+// A retrying view on a store able to create keys atomically and read keys
+// in batches
+type retryBatchStore struct {
+	*retryCreatorStore
+	getter KeyValueBatchGetter
+}
+
+// A retrying view on a store able to create and update keys atomically
+type retryUpdaterStore struct {
+	*retryCreatorStore
+	updater KeyValueUpdater
+}
+
+// A retrying view on a store able to create and update keys atomically and
+// read keys in batches
+type retryBatchUpdaterStore struct {
+	*retryBatchStore
+	updater KeyValueUpdater
+}
+
+// Run the given operation, retrying it with backoff while it fails with a
+// transient error. Returns the error of the last attempt
+func (s *retryStore) do(operation func() error) error {
+	err := operation()
+	backoff := s.retry.backoff()
+	for i := 0; err != nil && i < s.retry.retries() && s.retry.retryable(err); i++ {
+		if !s.wait(backoff) {
+			return err
+		}
+
+		if backoff *= 2; backoff > s.retry.maxBackoff() {
+			backoff = s.retry.maxBackoff()
+		}
+		err = operation()
+	}
+
+	return err
+}
+
+// Wait for a random time between half and all of the given backoff. Returns
+// false without waiting if the wait exceeds the budget, or if the request
+// ends while waiting
+func (s *retryStore) wait(backoff time.Duration) bool {
+	wait := backoff/2 + time.Duration(s.random.Int63n(int64(backoff-backoff/2)))
+	if s.clock.Now().Add(wait).After(s.deadline) {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// Get a key, retrying transient errors
+func (s *retryStore) Get(key string) (value []byte, err error) {
+	err = s.do(func() error {
+		value, err = s.store.Get(key)
+		return err
+	})
+
+	return value, err
+}
+
+// Set a key, retrying transient errors
+func (s *retryStore) Set(key string, value []byte) error {
+	return s.do(func() error {
+		return s.store.Set(key, value)
+	})
+}
+
+// Get a key, or create it with the initial value, retrying transient errors
+func (s *retryCreatorStore) GetOrCreate(key string, initial []byte, ttl time.Duration) (value []byte, created bool, err error) {
+	err = s.do(func() error {
+		value, created, err = s.creator.GetOrCreate(key, initial, ttl)
+		return err
+	})
+
+	return value, created, err
+}
+
+// Get the values of the given keys, retrying transient errors
+func (s *retryBatchStore) GetBatch(keys []string) (values map[string][]byte, err error) {
+	err = s.do(func() error {
+		values, err = s.getter.GetBatch(keys)
+		return err
+	})
+
+	return values, err
+}
+
+// Update a key atomically, retrying transient errors
+func (s *retryUpdaterStore) Update(key string, update func([]byte) ([]byte, error), ttl time.Duration) (value []byte, err error) {
+	err = s.do(func() error {
+		value, err = s.updater.Update(key, update, ttl)
+		return err
+	})
+
+	return value, err
+}
+
+// Update a key atomically, retrying transient errors
+func (s *retryBatchUpdaterStore) Update(key string, update func([]byte) ([]byte, error), ttl time.Duration) (value []byte, err error) {
+	err = s.do(func() error {
+		value, err = s.updater.Update(key, update, ttl)
+		return err
+	})
+
+	return value, err
+}
+
+// Return a view on the store of the given controller for the given request,
+// retrying failed operations with the StoreRetry option, keeping the
+// optional interfaces of the store. Returns the controller unchanged without
+// the option
+func (p *policy) retrying(controller *controller, req *http.Request) *controller {
+	retry := p.options.StoreRetry
+	if retry == nil {
+		return controller
+	}
+
+	store := &retryStore{controller.store, retry, p.options.Random, p.options.Clock, req.Context(), p.options.Clock.Now().Add(retry.budget())}
+	retrying := *controller
+	retrying.store = store
+	if creator, ok := controller.store.(KeyValueCreator); ok {
+		creating := &retryCreatorStore{store, creator}
+		retrying.store = creating
+		updater, updates := controller.store.(KeyValueUpdater)
+		if getter, ok := controller.store.(KeyValueBatchGetter); ok {
+			batching := &retryBatchStore{creating, getter}
+			retrying.store = batching
+			if updates {
+				retrying.store = &retryBatchUpdaterStore{batching, updater}
+			}
+		} else if updates {
+			retrying.store = &retryUpdaterStore{creating, updater}
+		}
+	}
+
+	return &retrying
+}
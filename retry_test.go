@@ -0,0 +1,146 @@
+package throttle
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A store failing the first attempt of every operation with the given
+// error, or every attempt
+type flakyStore struct {
+	KeyValueStorer
+	*sync.Mutex
+	err    error
+	always bool
+	failed map[string]bool
+}
+
+func newFlakyStore(err error) *flakyStore {
+	return &flakyStore{NewMapStore(nil), &sync.Mutex{}, err, false, make(map[string]bool)}
+}
+
+func (s *flakyStore) fails(operation string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	failed := s.failed[operation]
+	s.failed[operation] = !failed
+	return s.always || !failed
+}
+
+func (s *flakyStore) Get(key string) ([]byte, error) {
+	if s.fails("get " + key) {
+		return nil, s.err
+	}
+
+	return s.KeyValueStorer.Get(key)
+}
+
+func (s *flakyStore) Set(key string, value []byte) error {
+	if s.fails("set " + key) {
+		return s.err
+	}
+
+	return s.KeyValueStorer.Set(key, value)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransientStoreError(t *testing.T) {
+	expectSame(t, IsTransientStoreError(timeoutError{}), true)
+	expectSame(t, IsTransientStoreError(&net.OpError{Op: "read", Err: syscall.ECONNRESET}), true)
+	expectSame(t, IsTransientStoreError(&net.OpError{Op: "write", Err: syscall.EPIPE}), true)
+	expectSame(t, IsTransientStoreError(os.ErrDeadlineExceeded), true)
+	expectSame(t, IsTransientStoreError(MapStoreError("Key does not exist")), false)
+	expectSame(t, IsTransientStoreError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), false)
+}
+
+func TestStoreRetry(t *testing.T) {
+	store := newFlakyStore(&net.OpError{Op: "read", Err: syscall.ECONNRESET})
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store:      store,
+		StoreRetry: &StoreRetry{Backoff: time.Millisecond},
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	// the failed read of the count is retried instead of starting a new window
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+}
+
+func TestStoreRetryBudget(t *testing.T) {
+	store := newFlakyStore(&net.OpError{Op: "write", Err: syscall.ECONNRESET})
+	store.always = true
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store:      store,
+		StoreRetry: &StoreRetry{Backoff: 10 * time.Millisecond, Budget: time.Millisecond},
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a failed write beyond the retry budget to panic")
+		}
+	}()
+	serveMethod(policy, "GET")
+}
+
+func TestStoreRetryBudgetUsesClock(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := &retryStore{newFlakyStore(timeoutError{}), &StoreRetry{}, systemRandom{}, clock, context.Background(), clock.Now().Add(time.Second)}
+
+	expectSame(t, store.wait(time.Millisecond), true)
+	clock.Advance(time.Second)
+	expectSame(t, store.wait(time.Millisecond), false)
+}
+
+func TestStoreRetryOnlyRetryable(t *testing.T) {
+	store := newFlakyStore(&net.OpError{Op: "write", Err: syscall.ECONNRESET})
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store: store,
+		StoreRetry: &StoreRetry{Backoff: time.Millisecond, Retryable: func(err error) bool {
+			return false
+		}},
+	})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a failed write which is not retryable to panic")
+		}
+	}()
+	serveMethod(policy, "GET")
+}
+
+func TestStoreRetryEndsWithRequest(t *testing.T) {
+	store := &retryStore{newFlakyStore(timeoutError{}), &StoreRetry{Backoff: time.Hour}, systemRandom{}, systemClock{}, nil, time.Now().Add(2 * time.Hour)}
+	ctx, cancel := context.WithCancel(context.Background())
+	store.ctx = ctx
+	cancel()
+
+	start := time.Now()
+	_, err := store.Get("key")
+	expectDifferent(t, err, nil)
+	expectSame(t, time.Since(start) < time.Second, true)
+}
+
+func TestRetryingKeepsInterfaces(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	l := newLimiter(&Quota{Limit: 1, Within: time.Hour}, newOptions([]*Options{{StoreRetry: &StoreRetry{}}}))
+	p, _ := l.current()
+
+	_, creates := p.retrying(p.controller, req).store.(KeyValueCreator)
+	expectSame(t, creates, true)
+
+	p.controller.store = newFlakyStore(timeoutError{})
+	_, creates = p.retrying(p.controller, req).store.(KeyValueCreator)
+	expectSame(t, creates, false)
+}
@@ -0,0 +1,82 @@
+package throttle
+
+import "time"
+
+// Check if unused accesses of time windows of the quota carry over into the
+// next time window, for quotas with a rollover using the FixedWindow
+// algorithm and not only counting accesses
+func (q *Quota) rollsOver() bool {
+	return q.Rollover > 0 && q.Algorithm == FixedWindow && !q.CountOnly()
+}
+
+// Get the number of time windows it takes to accumulate the rollover of the
+// quota of the controller with idle time windows
+func (c *controller) rolloverWindows() uint64 {
+	capacity := c.quota.capacity() - c.credit
+	if !c.quota.rollsOver() || capacity == 0 {
+		return 0
+	}
+
+	return (c.quota.Rollover + capacity - 1) / capacity
+}
+
+// Get the credit of the time window at the given time with the given
+// capacity, capped at the given maximum. Fresh counts keep their credit,
+// stale counts carry over the accesses they left unused and the capacity of
+// the idle time windows since. Counts started in the future carry nothing
+func (r *accessCount) CreditAt(now time.Time, capacity uint64, max uint64) uint64 {
+	if r.IsFreshAt(now) {
+		return r.Credit
+	}
+
+	elapsed := now.Sub(r.Start)
+	if r.Duration <= 0 || elapsed < r.Duration {
+		return 0
+	}
+
+	credit := uint64(0)
+	if r.Count < capacity+r.Credit {
+		credit = capacity + r.Credit - r.Count
+	}
+	if idle := uint64(elapsed/r.Duration) - 1; idle > 0 {
+		if idle >= max || credit+idle*capacity >= max {
+			return max
+		}
+		credit += idle * capacity
+	}
+
+	if credit > max {
+		return max
+	}
+
+	return credit
+}
+
+// Carry the credit over into the time window at the given time before
+// counting an access, for quotas rolling over
+func (c *controller) rollOver(counter *accessCount, now time.Time) {
+	if c.quota.rollsOver() {
+		counter.Credit = counter.CreditAt(now, c.quota.capacity()-c.credit, c.quota.Rollover)
+	}
+}
+
+// Return a controller with the limit of the quota raised by the credit the
+// given id carried over into the current time window, or the controller if
+// the quota does not roll over or nothing is carried over
+func (c *controller) rolledOver(id string) *controller {
+	if !c.quota.rollsOver() {
+		return c
+	}
+
+	credit := c.GetAccessCount(id).CreditAt(c.windowStart(c.now()), c.quota.capacity()-c.credit, c.quota.Rollover)
+	if credit == 0 {
+		return c
+	}
+
+	raised := *c.quota
+	raised.Limit += credit
+	derived := c.withQuota(&raised)
+	derived.credit += credit
+
+	return derived
+}
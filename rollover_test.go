@@ -0,0 +1,96 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCreditAt(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	a := &accessCount{1, start, time.Minute, nil, 0, 2, nil}
+
+	expectSame(t, a.CreditAt(start.Add(30*time.Second), 3, 5), uint64(2))
+	// the unused accesses carry over, capped at the maximum
+	expectSame(t, a.CreditAt(start.Add(time.Minute), 3, 5), uint64(4))
+	expectSame(t, a.CreditAt(start.Add(time.Minute), 3, 3), uint64(3))
+	// idle time windows carry over their entire capacity
+	expectSame(t, a.CreditAt(start.Add(2*time.Minute), 3, 10), uint64(7))
+	expectSame(t, a.CreditAt(start.Add(time.Hour), 3, 10), uint64(10))
+
+	exceeded := &accessCount{6, start, time.Minute, nil, 0, 2, nil}
+	expectSame(t, exceeded.CreditAt(start.Add(time.Minute), 3, 5), uint64(0))
+
+	future := &accessCount{1, start.Add(time.Hour), time.Minute, nil, 0, 2, nil}
+	expectSame(t, future.CreditAt(start, 3, 5), uint64(0))
+}
+
+func TestRollover(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	// the key outlives the idle time windows, which the store expires by
+	// the clock
+	policy := Policy(&Quota{Limit: 3, Within: time.Minute, Name: "rollover", Rollover: 5}, &Options{
+		Clock:  clock,
+		KeyTTL: 2 * time.Hour,
+	})
+
+	resp := serveMethod(policy, "GET")
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "3")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "2")
+
+	// the two unused requests raise the limit of the next time window
+	clock.Advance(time.Minute)
+	resp = serveMethod(policy, "GET")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "5")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "4")
+	for i := 0; i < 4; i++ {
+		expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	}
+	resp = serveMethod(policy, "GET")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "5")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+
+	// a used up time window carries nothing over
+	clock.Advance(time.Minute)
+	resp = serveMethod(policy, "GET")
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "3")
+
+	// idle time windows accumulate credit up to the rollover
+	clock.Advance(time.Hour)
+	resp = serveMethod(policy, "GET")
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "8")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "7")
+}
+
+func TestRolloverIgnoredByOtherAlgorithms(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 3, Within: time.Minute, Name: "rollover-log", Rollover: 5, Algorithm: SlidingWindowLog}, &Options{
+		Clock: clock,
+	})
+
+	serveMethod(policy, "GET")
+	clock.Advance(time.Hour)
+	expectSame(t, serveMethod(policy, "GET").Header().Get("X-RateLimit-Limit"), "3")
+}
+
+func TestRolloverStatus(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	limiter := NewLimiter(&Quota{Limit: 3, Within: time.Minute, Rollover: 5}, &Options{
+		Clock: clock,
+	})
+	serveMethod(limiter.ServeHTTP, "GET")
+
+	clock.Advance(time.Minute)
+	status := limiter.Status("1.2.3.4")
+	expectSame(t, status.Credit, uint64(2))
+	expectSame(t, status.Limit, uint64(5))
+	expectSame(t, status.Remaining, uint64(5))
+}
+
+func TestRolloverTTL(t *testing.T) {
+	o := newOptions(nil)
+	expectSame(t, newController(&Quota{Limit: 3, Within: time.Minute, Rollover: 5}, o).ttl(), 3*time.Minute)
+	expectSame(t, newController(&Quota{Limit: 3, Within: time.Minute}, o).ttl(), time.Minute)
+}
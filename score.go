@@ -0,0 +1,229 @@
+package throttle
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// The default score added for every denial
+	defaultScoreDenial = 1
+
+	// The default score added for every malformed request
+	defaultScoreMalformed = 1
+
+	// The default score subtracted for every allowed request
+	defaultScoreAllowed = 0.01
+
+	// The default time after which half of a score has decayed
+	defaultScoreHalfLife = 24 * time.Hour
+
+	// The default score above which the limit is scaled down
+	defaultScoreThreshold = 10
+
+	// The default lowest factor the limit is scaled down by
+	defaultScoreMinFactor = 0.1
+
+	// The score below which scores are negligible and expire
+	negligibleScore = 0.01
+)
+
+// A ReputationScore scores requesters by their behavior over days. The score
+// of a requester is increased by denials and malformed requests, decreased
+// by allowed requests, and decays over time. Requesters with a score above
+// the threshold get a limit scaled down by the threshold divided by their
+// score. Scores are kept in the store of the policy under their own prefix,
+// until they have decayed to a hundredth of a point
+type ReputationScore struct {
+	// The score added for every request denied for exceeding the quota
+	// defaults to 1
+	Denial float64
+
+	// The score added for every rejected malformed identity, counted for the
+	// IP of the connection of the requester as the identity is not usable
+	// defaults to 1
+	Malformed float64
+
+	// The score subtracted for every allowed request. Scores never fall below 0
+	// defaults to 0.01
+	Allowed float64
+
+	// The time after which half of a score has decayed
+	// defaults to 24 hours
+	HalfLife time.Duration
+
+	// The score above which the limit of the quota is scaled down
+	// defaults to 10
+	Threshold float64
+
+	// The lowest factor the limit of the quota is scaled down by
+	// defaults to 0.1
+	MinFactor float64
+}
+
+// The score of a requester, will be stored in the key value store
+type score struct {
+	Score   float64   `json:"score"`
+	Updated time.Time `json:"updated"`
+	// The time the score has decayed to a negligible score
+	Expires time.Time `json:"expires"`
+}
+
+// Return a new score of the given value at the given time, expiring once
+// it has decayed to a negligible score
+func newScore(value float64, now time.Time, halfLife time.Duration) *score {
+	expires := now
+	if value > negligibleScore {
+		decay := time.Duration(math.MaxInt64)
+		if d := float64(halfLife) * math.Log2(value/negligibleScore); d < math.MaxInt64 {
+			decay = time.Duration(d)
+		}
+		expires = now.Add(decay)
+	}
+
+	return &score{value, now, expires}
+}
+
+// Get the score at the given time, decayed since its last update
+func (s *score) At(now time.Time, halfLife time.Duration) float64 {
+	if s.Score == 0 || !now.After(s.Updated) {
+		return s.Score
+	}
+
+	return s.Score * math.Pow(0.5, float64(now.Sub(s.Updated))/float64(halfLife))
+}
+
+// Get the score added for every denial
+func (r *ReputationScore) denial() float64 {
+	if r.Denial == 0 {
+		return defaultScoreDenial
+	}
+
+	return r.Denial
+}
+
+// Get the score added for every malformed request
+func (r *ReputationScore) malformed() float64 {
+	if r.Malformed == 0 {
+		return defaultScoreMalformed
+	}
+
+	return r.Malformed
+}
+
+// Get the score subtracted for every allowed request
+func (r *ReputationScore) allowed() float64 {
+	if r.Allowed == 0 {
+		return defaultScoreAllowed
+	}
+
+	return r.Allowed
+}
+
+// Get the half life of scores
+func (r *ReputationScore) halfLife() time.Duration {
+	if r.HalfLife == 0 {
+		return defaultScoreHalfLife
+	}
+
+	return r.HalfLife
+}
+
+// Get the factor to scale the limit by for the given score
+func (r *ReputationScore) factor(value float64) float64 {
+	threshold := r.Threshold
+	if threshold == 0 {
+		threshold = defaultScoreThreshold
+	}
+	minFactor := r.MinFactor
+	if minFactor == 0 {
+		minFactor = defaultScoreMinFactor
+	}
+
+	if value <= threshold {
+		return 1
+	}
+
+	return math.Max(threshold/value, minFactor)
+}
+
+// Get the key of the score of the requester with the given identity
+func (p *policy) scoreKey(identity string) string {
+	return makeKey(p.prefix, "score", identity)
+}
+
+// Get the identity of the requester with the given key of the controller,
+// as returned by the identification function, prefixed by the name of the
+// identification for identifications of the chain
+func (p *policy) identityOf(controller *controller, id string) string {
+	return strings.TrimPrefix(id, makeKey(p.prefix, p.options.KeyIdFunction(controller.quota), ""))
+}
+
+// Get the score by identity
+func (p *policy) getScore(controller *controller, identity string) *score {
+	s := &score{}
+	if scoreBytes, err := controller.store.Get(p.scoreKey(identity)); err == nil {
+		if err := controller.codec.Decode(scoreBytes, s); err != nil {
+			panic(err.Error())
+		}
+	}
+
+	return s
+}
+
+// Add the given delta to the decayed score of the given identity, will write
+// to the store unless the score stays at 0
+func (p *policy) addScore(controller *controller, identity string, delta float64) {
+	r := p.options.ReputationScore
+
+	controller.Lock()
+	defer controller.Unlock()
+
+	now := controller.now()
+	s := p.getScore(controller, identity)
+	current := s.At(now, r.halfLife())
+	if current == 0 && delta <= 0 {
+		return
+	}
+
+	updated := newScore(math.Max(current+delta, 0), now, r.halfLife())
+	marshalled, err := controller.codec.Encode(updated)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	err = controller.store.Set(p.scoreKey(identity), marshalled)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// Get the factor to scale the limit of the requester with the given
+// identity by for its score
+func (p *policy) scoreFactor(controller *controller, identity string) float64 {
+	r := p.options.ReputationScore
+	return r.factor(p.getScore(controller, identity).At(controller.now(), r.halfLife()))
+}
+
+// Count a malformed request against the IP of the connection of the
+// requester, which unlike the X-Forwarded-For header it cannot choose
+func (p *policy) scoreMalformed(req *http.Request) {
+	if p.options.ReputationScore != nil {
+		p.addScore(p.controller, identifyConnection(req), p.options.ReputationScore.malformed())
+	}
+}
+
+// Get the current reputation score of the requester with the given
+// identity, as returned by the identification function. Returns 0 if the
+// policy does not score requesters
+func (l *Limiter) Score(id string) float64 {
+	p, _ := l.current()
+	r := p.options.ReputationScore
+	if r == nil {
+		return 0
+	}
+
+	return p.getScore(p.controller, id).At(p.controller.now(), r.halfLife())
+}
@@ -0,0 +1,126 @@
+package throttle
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestScoreDecay(t *testing.T) {
+	now := time.Unix(100, 0)
+	s := newScore(8, now, time.Hour)
+
+	expectSame(t, s.At(now, time.Hour), float64(8))
+	expectSame(t, s.At(now.Add(time.Hour), time.Hour), float64(4))
+	expectSame(t, s.At(now.Add(3*time.Hour), time.Hour), float64(1))
+}
+
+func TestScoreExpiry(t *testing.T) {
+	now := time.Unix(100, 0)
+
+	expectSame(t, newScore(0, now, time.Hour).Expires, now)
+	expectSame(t, newScore(negligibleScore, now, time.Hour).Expires, now)
+	expectSame(t, newScore(8*negligibleScore, now, time.Hour).Expires, now.Add(3*time.Hour))
+	if expires := newScore(math.MaxFloat64, now, time.Hour).Expires; !expires.After(now) {
+		t.Errorf("Expected huge scores to expire after %v, got %v", now, expires)
+	}
+}
+
+func TestScoreFactor(t *testing.T) {
+	r := &ReputationScore{}
+
+	expectSame(t, r.factor(0), float64(1))
+	expectSame(t, r.factor(10), float64(1))
+	expectSame(t, r.factor(20), 0.5)
+	expectSame(t, r.factor(1000), 0.1)
+}
+
+func TestPolicyWithReputationScore(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(100, 0)}
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Minute}, &Options{
+		Clock: clock,
+		ReputationScore: &ReputationScore{
+			Denial:    5,
+			Threshold: 10,
+			HalfLife:  time.Hour,
+		},
+	})
+	serve := func() int {
+		return serveMethod(limiter.ServeHTTP, "GET").Code
+	}
+
+	for i := 0; i < 10; i++ {
+		expectStatusCode(t, http.StatusOK, serve())
+	}
+	expectSame(t, limiter.Score("1.2.3.4"), float64(0))
+
+	// denials raise the score
+	for i := 0; i < 4; i++ {
+		expectStatusCode(t, StatusTooManyRequests, serve())
+	}
+	expectSame(t, limiter.Score("1.2.3.4"), float64(20))
+
+	// in the next window, the limit is halved by the score
+	clock.Advance(time.Minute)
+	for i := 0; i < 5; i++ {
+		expectStatusCode(t, http.StatusOK, serve())
+	}
+	expectStatusCode(t, StatusTooManyRequests, serve())
+
+	// the score decays over time
+	clock.Advance(24 * time.Hour)
+	if score := limiter.Score("1.2.3.4"); score > 0.01 {
+		t.Errorf("Expected the score to decay, got %v", score)
+	}
+	for i := 0; i < 10; i++ {
+		expectStatusCode(t, http.StatusOK, serve())
+	}
+}
+
+func TestReputationScoreMalformed(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 10, Within: time.Minute}, &Options{
+		Clock: &fakeClock{now: time.Unix(100, 0)},
+		IdentificationFunction: func(req *http.Request) string {
+			return "too long"
+		},
+		IdentityMaxLength:         3,
+		RejectMalformedIdentities: true,
+		ReputationScore:           &ReputationScore{},
+	})
+
+	expectStatusCode(t, http.StatusBadRequest, serveMethod(limiter.ServeHTTP, "GET").Code)
+	expectSame(t, limiter.Score("1.2.3.4"), float64(1))
+
+	// the score is counted for the connection, not for X-Forwarded-For
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4:1234"
+	req.Header.Set("X-Forwarded-For", "5.6.7.8")
+	limiter.ServeHTTP(httptest.NewRecorder(), req)
+	expectSame(t, limiter.Score("1.2.3.4"), float64(2))
+	expectSame(t, limiter.Score("5.6.7.8"), float64(0))
+}
+
+func TestCleaningEvictsExpiredScores(t *testing.T) {
+	store := NewMapStore(accessCount{}, &MapStoreOptions{
+		CleaningPeriod: time.Hour,
+	})
+	now := time.Now().UTC()
+	for key, value := range map[string]*score{
+		"EXPIRED": newScore(1, now.Add(-time.Hour), time.Minute),
+		"KEPT":    newScore(1, now, time.Minute),
+	} {
+		marshalled, _ := json.Marshal(value)
+		store.Set(key, marshalled)
+	}
+	store.Clean()
+
+	if _, err := store.Get("EXPIRED"); err == nil {
+		t.Errorf("Expected the expired score to be evicted")
+	}
+	if _, err := store.Get("KEPT"); err != nil {
+		t.Errorf("Expected the score to be kept")
+	}
+}
@@ -0,0 +1,73 @@
+package throttle
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The quota used for self tests
+var selfTestQuota = &Quota{
+	Limit:  1,
+	Within: time.Minute,
+}
+
+// A SelfTestReport is the result of a self test
+type SelfTestReport struct {
+	// If the self test passed
+	OK bool `json:"ok"`
+	// If the first synthetic request was allowed
+	Allowed bool `json:"allowed"`
+	// If the second synthetic request was denied
+	Denied bool `json:"denied"`
+	// The time the self test took, spent mostly in the store
+	Latency time.Duration `json:"latency"`
+	// The error which occurred in the store, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Run a synthetic allow and deny cycle against a dedicated test identity
+func runSelfTest(o *Options) (report *SelfTestReport) {
+	report = &SelfTestReport{}
+	c := newController(selfTestQuota, o)
+	id := makeKey(o.KeyPrefix, "selftest", strconv.FormatInt(c.now().UnixNano(), 10))
+	start := time.Now()
+
+	defer func() {
+		report.Latency = time.Since(start)
+		if err := recover(); err != nil {
+			report.OK = false
+			report.Error = fmt.Sprint(err)
+		}
+	}()
+
+	report.Allowed = !c.DeniesAccess(id, 1)
+	c.RegisterAccess(id, 1)
+	report.Denied = c.DeniesAccess(id, 1)
+	report.OK = report.Allowed && report.Denied
+
+	return report
+}
+
+// A self test handler
+// Runs a synthetic allow and deny cycle against the store of the given options
+// on every request and responds with a JSON report, with 503 Service
+// Unavailable if the self test failed. Use it to verify the throttle
+// end-to-end in production
+func SelfTest(options ...*Options) func(resp http.ResponseWriter, req *http.Request) {
+	o := newOptions(options)
+
+	return func(resp http.ResponseWriter, req *http.Request) {
+		report := runSelfTest(o)
+
+		resp.Header().Set("Content-Type", "application/json")
+		if report.OK {
+			resp.WriteHeader(http.StatusOK)
+		} else {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(resp).Encode(report)
+	}
+}
@@ -0,0 +1,44 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveSelfTest(t *testing.T, options *Options) (*httptest.ResponseRecorder, *SelfTestReport) {
+	req, _ := http.NewRequest("GET", "/throttle/selftest", nil)
+	recorder := httptest.NewRecorder()
+	SelfTest(options)(recorder, req)
+
+	report := &SelfTestReport{}
+	if err := json.NewDecoder(recorder.Body).Decode(report); err != nil {
+		t.Error(err)
+	}
+
+	return recorder, report
+}
+
+func TestSelfTest(t *testing.T) {
+	recorder, report := serveSelfTest(t, &Options{})
+
+	expectStatusCode(t, http.StatusOK, recorder.Code)
+	expectSame(t, recorder.Header().Get("Content-Type"), "application/json")
+	expectSame(t, report.OK, true)
+	expectSame(t, report.Allowed, true)
+	expectSame(t, report.Denied, true)
+	expectSame(t, report.Error, "")
+}
+
+func TestSelfTestWithFailingStore(t *testing.T) {
+	recorder, report := serveSelfTest(t, &Options{
+		Store: NewChaosStore(NewMapStore(accessCount{}), &ChaosStoreOptions{
+			ErrorRate: 1,
+		}),
+	})
+
+	expectStatusCode(t, http.StatusServiceUnavailable, recorder.Code)
+	expectSame(t, report.OK, false)
+	expectMatches(t, "Injected error", report.Error)
+}
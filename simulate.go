@@ -0,0 +1,151 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"time"
+)
+
+// A SimulatedRequest is a request of synthetic traffic
+type SimulatedRequest struct {
+	// The time of the request after the start of the simulation
+	At time.Duration
+	// The identity of the requester
+	Id string
+}
+
+// A TrafficPattern is synthetic traffic to simulate a policy with
+type TrafficPattern []SimulatedRequest
+
+// Return a pattern of requests of the given requester in the given
+// interval, starting at the start of the simulation, for the given duration
+func ConstantTraffic(id string, every time.Duration, duration time.Duration) TrafficPattern {
+	pattern := TrafficPattern{}
+	for at := time.Duration(0); at < duration; at += every {
+		pattern = append(pattern, SimulatedRequest{at, id})
+	}
+
+	return pattern
+}
+
+// Return a pattern of the given number of requests of the given requester,
+// all at the given time
+func BurstTraffic(id string, requests int, at time.Duration) TrafficPattern {
+	pattern := make(TrafficPattern, requests)
+	for i := range pattern {
+		pattern[i] = SimulatedRequest{at, id}
+	}
+
+	return pattern
+}
+
+// Return a pattern with the requests of the pattern and the given patterns,
+// ordered by time
+func (t TrafficPattern) With(patterns ...TrafficPattern) TrafficPattern {
+	merged := append(TrafficPattern{}, t...)
+	for _, pattern := range patterns {
+		merged = append(merged, pattern...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].At < merged[j].At
+	})
+
+	return merged
+}
+
+// A SimulatedDecision is the decision of a policy on a simulated request
+type SimulatedDecision struct {
+	SimulatedRequest
+	// The type of the event of the decision
+	Type EventType
+	// If the request was allowed
+	Allowed bool
+	// The status code of the response, 200 for allowed requests
+	StatusCode int
+	// The remaining limit of the requester after the decision
+	Remaining uint64
+}
+
+// A Simulation is the projected timeline of the decisions of a policy on
+// synthetic traffic
+type Simulation struct {
+	// The decisions in the order of the requests
+	Timeline []SimulatedDecision
+	// The number of allowed requests
+	Allowed int
+	// The number of requests not allowed
+	Denied int
+}
+
+// A clock which is set by the simulation
+type simulationClock struct {
+	now time.Time
+}
+
+// Return the time of the simulated request
+func (c *simulationClock) Now() time.Time {
+	return c.now
+}
+
+// Simulate the limiter on the given traffic pattern, e.g. to validate a
+// quota in tests or capacity planning before exposing it to clients. The
+// requests are served in the order of their time by a copy of the policy of
+// the limiter with a clock of its own and an empty MapStore, so no time
+// passes and the counts of the limiter are untouched. Requesters are
+// identified by the Id of the simulated requests instead of the
+// identification function and chain, reputation providers and the OnEvent
+// hook are not called. Disabled limiters are simulated as if enabled
+func Simulate(l *Limiter, pattern TrafficPattern) *Simulation {
+	current, _ := l.current()
+	o := *current.options
+	start := o.Clock.Now()
+	clock := &simulationClock{start}
+
+	var last *Event
+	o.Clock = clock
+	o.Store = newMapStore(nil, o.Codec, clock, nil)
+	o.StorePing = StorePingDisabled
+	o.IdentificationFunction = func(req *http.Request) string {
+		return req.RemoteAddr
+	}
+	o.IdentificationChain = nil
+	o.ReputationProvider = nil
+	o.OnEvent = func(e *Event) {
+		if e.Type != EventRepaired {
+			last = e
+		}
+	}
+	p := newPolicy(current.controller.quota, &o)
+
+	simulation := &Simulation{
+		Timeline: make([]SimulatedDecision, len(pattern)),
+	}
+	for i, request := range pattern.With() {
+		clock.now = start.Add(request.At)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = request.Id
+		resp := httptest.NewRecorder()
+
+		last = nil
+		p.serve(resp, req, nil)
+
+		decision := SimulatedDecision{
+			SimulatedRequest: request,
+			StatusCode:       resp.Code,
+		}
+		if last != nil {
+			decision.Type = last.Type
+			decision.Remaining = last.Remaining
+		}
+		decision.Allowed = decision.Type == EventAllowed || decision.Type == EventBypassed
+		if decision.Allowed {
+			simulation.Allowed++
+		} else {
+			simulation.Denied++
+		}
+		simulation.Timeline[i] = decision
+	}
+
+	return simulation
+}
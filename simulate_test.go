@@ -0,0 +1,59 @@
+package throttle
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConstantTraffic(t *testing.T) {
+	pattern := ConstantTraffic("a", 10*time.Second, time.Minute)
+
+	expectSame(t, len(pattern), 6)
+	expectSame(t, pattern[0], SimulatedRequest{0, "a"})
+	expectSame(t, pattern[5], SimulatedRequest{50 * time.Second, "a"})
+}
+
+func TestTrafficPatternWith(t *testing.T) {
+	pattern := ConstantTraffic("a", time.Minute, 3*time.Minute).With(BurstTraffic("b", 2, 90*time.Second))
+
+	expectSame(t, len(pattern), 5)
+	expectSame(t, pattern[1], SimulatedRequest{time.Minute, "a"})
+	expectSame(t, pattern[2], SimulatedRequest{90 * time.Second, "b"})
+	expectSame(t, pattern[3], SimulatedRequest{90 * time.Second, "b"})
+	expectSame(t, pattern[4], SimulatedRequest{2 * time.Minute, "a"})
+}
+
+func TestSimulate(t *testing.T) {
+	limiter := NewLimiter(&Quota{Limit: 3, Within: time.Minute}, &Options{
+		IdentificationFunction: func(req *http.Request) string {
+			return req.Header.Get("X-API-Key")
+		},
+	})
+
+	simulation := Simulate(limiter, ConstantTraffic("a", 10*time.Second, 2*time.Minute).With(
+		BurstTraffic("b", 2, 0),
+	))
+
+	expectSame(t, simulation.Allowed, 8)
+	expectSame(t, simulation.Denied, 6)
+
+	var allowed []bool
+	for _, decision := range simulation.Timeline {
+		if decision.Id == "a" {
+			allowed = append(allowed, decision.Allowed)
+		}
+	}
+	expectSame(t, fmt.Sprint(allowed), "[true true true false false false true true true false false false]")
+
+	denied := simulation.Timeline[5]
+	expectSame(t, denied.Type, EventDenied)
+	expectSame(t, denied.StatusCode, StatusTooManyRequests)
+	expectSame(t, denied.Remaining, uint64(0))
+	expectSame(t, simulation.Timeline[0].Remaining, uint64(2))
+
+	// the limiter is untouched
+	expectSame(t, limiter.Status("a").Count, uint64(0))
+	expectSame(t, len(limiter.TopConsumers(10)), 0)
+}
@@ -0,0 +1,376 @@
+package throttle
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The kinds of tokens of skip rules
+type ruleTokenKind int
+
+const (
+	ruleIdent ruleTokenKind = iota
+	ruleString
+	ruleSymbol
+	ruleEnd
+)
+
+// A token of a skip rule at its position in the rule
+type ruleToken struct {
+	kind ruleTokenKind
+	text string
+	pos  int
+}
+
+// A value of a request in a skip rule, or a string literal
+type ruleValue func(*http.Request) string
+
+// The fields of requests in skip rules, by name
+var ruleFields = map[string]ruleValue{
+	"path": func(req *http.Request) string {
+		return req.URL.Path
+	},
+	"method": func(req *http.Request) string {
+		return req.Method
+	},
+	"host": func(req *http.Request) string {
+		return req.Host
+	},
+	"ip": defaultIdentify,
+}
+
+// The indexed fields of requests in skip rules, by name
+var ruleIndexedFields = map[string]func(name string) ruleValue{
+	"header": func(name string) ruleValue {
+		return func(req *http.Request) string {
+			return req.Header.Get(name)
+		}
+	},
+	"query": func(name string) ruleValue {
+		return func(req *http.Request) string {
+			return req.URL.Query().Get(name)
+		}
+	},
+	"cookie": func(name string) ruleValue {
+		return func(req *http.Request) string {
+			if cookie, err := req.Cookie(name); err == nil {
+				return cookie.Value
+			}
+			return ""
+		}
+	},
+}
+
+// The methods on values in skip rules, by name
+var ruleMethods = map[string]func(string, string) bool{
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+	"contains":   strings.Contains,
+}
+
+// Split the given skip rule into tokens
+func lexRule(rule string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	for i := 0; i < len(rule); {
+		c := rule[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(rule) && (rule[i] == '_' || rule[i] >= 'a' && rule[i] <= 'z' || rule[i] >= 'A' && rule[i] <= 'Z' || rule[i] >= '0' && rule[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, ruleToken{ruleIdent, rule[start:i], start})
+		case c == '"':
+			start := i
+			for i++; i < len(rule) && rule[i] != '"'; i++ {
+				if rule[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(rule) {
+				return nil, ruleError(rule, start, "unterminated string")
+			}
+			i++
+			value, err := strconv.Unquote(rule[start:i])
+			if err != nil {
+				return nil, ruleError(rule, start, "invalid string")
+			}
+			tokens = append(tokens, ruleToken{ruleString, value, start})
+		case strings.HasPrefix(rule[i:], "&&"), strings.HasPrefix(rule[i:], "||"), strings.HasPrefix(rule[i:], "=="), strings.HasPrefix(rule[i:], "!="):
+			tokens = append(tokens, ruleToken{ruleSymbol, rule[i : i+2], i})
+			i += 2
+		case strings.IndexByte("()[].!", c) >= 0:
+			tokens = append(tokens, ruleToken{ruleSymbol, rule[i : i+1], i})
+			i++
+		default:
+			return nil, ruleError(rule, i, "unexpected "+strconv.QuoteRune(rune(c)))
+		}
+	}
+
+	return append(tokens, ruleToken{ruleEnd, "", len(rule)}), nil
+}
+
+// The error for the given skip rule at the given position
+func ruleError(rule string, pos int, message string) error {
+	return ConfigError("Invalid skip rule " + strconv.Quote(rule) + ": " + message + " at position " + strconv.Itoa(pos+1))
+}
+
+// A parser of a skip rule into a function matching requests
+type ruleParser struct {
+	rule   string
+	tokens []ruleToken
+	next   int
+}
+
+// Get the next token without consuming it
+func (p *ruleParser) peek() ruleToken {
+	return p.tokens[p.next]
+}
+
+// Consume the next token
+func (p *ruleParser) take() ruleToken {
+	token := p.tokens[p.next]
+	if token.kind != ruleEnd {
+		p.next++
+	}
+
+	return token
+}
+
+// Check if the next token is the given symbol, consuming it if it is
+func (p *ruleParser) accept(symbol string) bool {
+	if token := p.peek(); token.kind == ruleSymbol && token.text == symbol {
+		p.next++
+		return true
+	}
+
+	return false
+}
+
+// Consume the next token, which has to be of the given kind, and the given
+// symbol for symbols
+func (p *ruleParser) expect(kind ruleTokenKind, symbol string, expected string) (ruleToken, error) {
+	token := p.take()
+	if token.kind != kind || kind == ruleSymbol && token.text != symbol {
+		return token, p.unexpected(token, expected)
+	}
+
+	return token, nil
+}
+
+// The error for the given unexpected token
+func (p *ruleParser) unexpected(token ruleToken, expected string) error {
+	found := strconv.Quote(token.text)
+	if token.kind == ruleEnd {
+		found = "the end"
+	}
+
+	return ruleError(p.rule, token.pos, "expected "+expected+", found "+found)
+}
+
+// Parse alternatives joined by ||
+func (p *ruleParser) parseOr() (func(*http.Request) bool, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right func(*http.Request) bool
+		if right, err = p.parseAnd(); err == nil {
+			l := left
+			left = func(req *http.Request) bool {
+				return l(req) || right(req)
+			}
+		}
+	}
+
+	return left, err
+}
+
+// Parse conditions joined by &&
+func (p *ruleParser) parseAnd() (func(*http.Request) bool, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right func(*http.Request) bool
+		if right, err = p.parseUnary(); err == nil {
+			l := left
+			left = func(req *http.Request) bool {
+				return l(req) && right(req)
+			}
+		}
+	}
+
+	return left, err
+}
+
+// Parse a negation, a parenthesized rule, a boolean literal or a condition
+func (p *ruleParser) parseUnary() (func(*http.Request) bool, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(req *http.Request) bool {
+			return !operand(req)
+		}, nil
+	}
+
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(ruleSymbol, ")", `")"`); err != nil {
+			return nil, err
+		}
+
+		return inner, nil
+	}
+
+	if token := p.peek(); token.kind == ruleIdent && (token.text == "true" || token.text == "false") {
+		p.take()
+		result := token.text == "true"
+		return func(*http.Request) bool {
+			return result
+		}, nil
+	}
+
+	return p.parseCondition()
+}
+
+// Parse a comparison of two values, or a method call on a value
+func (p *ruleParser) parseCondition() (func(*http.Request) bool, error) {
+	left, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.take()
+	switch {
+	case token.kind == ruleSymbol && (token.text == "==" || token.text == "!="):
+		right, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		equal := token.text == "=="
+		return func(req *http.Request) bool {
+			return (left(req) == right(req)) == equal
+		}, nil
+	case token.kind == ruleSymbol && token.text == ".":
+		return p.parseMethod(left)
+	default:
+		return nil, p.unexpected(token, `"==", "!=" or a method`)
+	}
+}
+
+// Parse a method call with a string argument on the given value
+func (p *ruleParser) parseMethod(value ruleValue) (func(*http.Request) bool, error) {
+	name, err := p.expect(ruleIdent, "", "a method")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(ruleSymbol, "(", `"("`); err != nil {
+		return nil, err
+	}
+	arg, err := p.expect(ruleString, "", "a string")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(ruleSymbol, ")", `")"`); err != nil {
+		return nil, err
+	}
+
+	if name.text == "matches" {
+		pattern, err := regexp.Compile(arg.text)
+		if err != nil {
+			return nil, ruleError(p.rule, arg.pos, "invalid pattern: "+err.Error())
+		}
+
+		return func(req *http.Request) bool {
+			return pattern.MatchString(value(req))
+		}, nil
+	}
+
+	method, ok := ruleMethods[name.text]
+	if !ok {
+		return nil, ruleError(p.rule, name.pos, "unknown method "+strconv.Quote(name.text))
+	}
+
+	return func(req *http.Request) bool {
+		return method(value(req), arg.text)
+	}, nil
+}
+
+// Parse a string literal or a field of the request
+func (p *ruleParser) parseValue() (ruleValue, error) {
+	token := p.take()
+	if token.kind == ruleString {
+		return func(*http.Request) string {
+			return token.text
+		}, nil
+	} else if token.kind != ruleIdent || token.text != "req" {
+		return nil, p.unexpected(token, `a string or "req"`)
+	}
+
+	if _, err := p.expect(ruleSymbol, ".", `"."`); err != nil {
+		return nil, err
+	}
+	field, err := p.expect(ruleIdent, "", "a field")
+	if err != nil {
+		return nil, err
+	}
+
+	if value, ok := ruleFields[field.text]; ok {
+		return value, nil
+	}
+
+	indexed, ok := ruleIndexedFields[field.text]
+	if !ok {
+		return nil, ruleError(p.rule, field.pos, "unknown field "+strconv.Quote(field.text))
+	}
+	if _, err := p.expect(ruleSymbol, "[", `"["`); err != nil {
+		return nil, err
+	}
+	name, err := p.expect(ruleString, "", "a string")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(ruleSymbol, "]", `"]"`); err != nil {
+		return nil, err
+	}
+
+	return indexed(name.text), nil
+}
+
+// Compile a skip rule, a small expression on the request, into a function
+// for the SkipAccessCheck option, so exemptions can be configured without
+// recompiling, e.g. from a config file or THROTTLE_SKIP, see OptionsFromEnv:
+//
+//	req.path.startsWith("/health") || req.header["X-Internal"] == "1"
+//
+// Rules compare values with == and !=, and call startsWith, endsWith,
+// contains and matches, taking a regular expression, on them. Values are
+// string literals and the fields req.path, req.method, req.host, req.ip,
+// req.header["name"], req.query["name"] and req.cookie["name"]. Conditions
+// are combined with &&, || and !, and grouped with parentheses. Returns a
+// ConfigError for invalid rules
+func CompileSkipRule(rule string) (func(*http.Request) bool, error) {
+	tokens, err := lexRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &ruleParser{rule, tokens, 0}
+	skip, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token.kind != ruleEnd {
+		return nil, p.unexpected(token, "the end")
+	}
+
+	return skip, nil
+}
@@ -0,0 +1,76 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCompileSkipRule(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/health/live?probe=k8s", nil)
+	req.Host = "api.example.com"
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Internal", "1")
+	req.AddCookie(&http.Cookie{Name: "role", Value: "staff"})
+
+	for rule, expected := range map[string]bool{
+		`req.path.startsWith("/health") || req.header["X-Internal"] == "1"`: true,
+		`req.path == "/health"`:                                      false,
+		`req.path.endsWith("/live") && req.method == "GET"`:          true,
+		`req.host.contains("example") && !(req.method != "GET")`:     true,
+		`req.ip.matches("^10\\.")`:                                   true,
+		`req.query["probe"] == "k8s"`:                                true,
+		`req.cookie["role"] == "staff" && req.cookie["other"] == ""`: true,
+		`req.header["X-Missing"] != "" || false`:                     false,
+		`true && "a" == "a"`:                                         true,
+		`!req.path.startsWith("/health")`:                            false,
+	} {
+		skip, err := CompileSkipRule(rule)
+		if err != nil {
+			t.Errorf("Expected %s to compile, got %v", rule, err)
+			continue
+		}
+		if skip(req) != expected {
+			t.Errorf("Expected %s to be %v", rule, expected)
+		}
+	}
+}
+
+func TestCompileSkipRuleErrors(t *testing.T) {
+	for rule, message := range map[string]string{
+		`req.path ==`:                  `expected a string or "req", found the end at position 12`,
+		`req.path.startsWith(/health)`: `unexpected '/' at position 21`,
+		`req.url == "/"`:               `unknown field "url" at position 5`,
+		`req.path.equals("/")`:         `unknown method "equals" at position 10`,
+		`req.header == "1"`:            `expected "\[", found "==" at position 12`,
+		`req.path.matches("(")`:        `invalid pattern: .* at position 18`,
+		`"/health`:                     `unterminated string at position 1`,
+		`(req.path == "/"`:             `expected "\)", found the end at position 17`,
+		`req.path == "/" req`:          `expected the end, found "req" at position 17`,
+		`req.path`:                     `expected "==", "!=" or a method, found the end at position 9`,
+	} {
+		_, err := CompileSkipRule(rule)
+		if err == nil {
+			t.Errorf("Expected %s not to compile", rule)
+			continue
+		}
+		expectMatches(t, "^Throttle Config Error: Invalid skip rule .*: "+message+"$", err.Error())
+	}
+}
+
+func TestSkipRulePolicy(t *testing.T) {
+	skip, err := CompileSkipRule(`req.header["X-Internal"] == "1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := setupMartiniWithPolicy(1, time.Hour, &Options{SkipAccessCheck: skip})
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+	}, &Expectation{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"X-Internal": "1"},
+	})
+}
@@ -0,0 +1,106 @@
+package throttle
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// A Snapshot of the counters in a MapStore, used to hand them over from a
+// draining instance to its replacement
+type Snapshot struct {
+	// The time the snapshot was taken
+	Taken time.Time `json:"taken"`
+	// The entries of the store
+	Entries []*SnapshotEntry `json:"entries"`
+}
+
+// A SnapshotEntry is a single key of a MapStore
+type SnapshotEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	// The time the key expires, the zero time if it does not expire
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Export a snapshot of all keys which have not expired
+func (s *MapStore) Export() *Snapshot {
+	s.RLock()
+	defer s.RUnlock()
+
+	snapshot := &Snapshot{
+		Taken:   s.clock.Now(),
+		Entries: make([]*SnapshotEntry, 0, len(s.data)),
+	}
+
+	for key, value := range s.data {
+		if s.isExpired(key) {
+			continue
+		}
+
+		snapshot.Entries = append(snapshot.Entries, &SnapshotEntry{
+			key,
+			value,
+			s.expiries[key],
+		})
+	}
+
+	return snapshot
+}
+
+// Import the keys of a snapshot which have not expired. Keys already in the
+// store are kept, so accesses registered before the import are not lost
+func (s *MapStore) Import(snapshot *Snapshot) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.clock.Now()
+	for _, e := range snapshot.Entries {
+		if !e.Expires.IsZero() && !now.Before(e.Expires) {
+			continue
+		}
+		if _, ok := s.data[e.Key]; ok && !s.isExpired(e.Key) {
+			continue
+		}
+
+		s.data[e.Key] = e.Value
+		if e.Expires.IsZero() {
+			delete(s.expiries, e.Key)
+		} else {
+			s.expiries[e.Key] = e.Expires
+		}
+	}
+}
+
+// Import a snapshot from the snapshot handler of another instance at the
+// given url, e.g. from a draining instance during a blue-green deploy
+func (s *MapStore) ImportFrom(url string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return MapStoreError("Snapshot request to " + url + " responded with " + resp.Status)
+	}
+
+	snapshot := &Snapshot{}
+	if err := json.NewDecoder(resp.Body).Decode(snapshot); err != nil {
+		return err
+	}
+
+	s.Import(snapshot)
+	return nil
+}
+
+// A snapshot handler
+// Responds with a JSON snapshot of the given store, for its replacement to
+// import with ImportFrom. The snapshot contains the counters of all
+// requesters, so the handler should not be reachable publicly
+func SnapshotHandler(store *MapStore) func(resp http.ResponseWriter, req *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(resp).Encode(store.Export())
+	}
+}
@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSnapshotExportImport(t *testing.T) {
+	draining := NewMapStore(accessCount{})
+	draining.Set("KEY", []byte("4"))
+	draining.GetOrCreate("EXPIRING", []byte("5"), time.Hour)
+	draining.GetOrCreate("EXPIRED", []byte("6"), -time.Second)
+
+	replacement := NewMapStore(accessCount{})
+	replacement.Set("KEY", []byte("1"))
+	replacement.Import(draining.Export())
+
+	value, err := replacement.Get("KEY")
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, string(value), "1")
+
+	value, err = replacement.Get("EXPIRING")
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, string(value), "5")
+
+	if _, err := replacement.Get("EXPIRED"); err == nil {
+		t.Errorf("Expected expired keys not to be imported")
+	}
+}
+
+func TestSnapshotImportFrom(t *testing.T) {
+	draining := NewMapStore(accessCount{})
+	draining.Set("KEY", []byte("4"))
+	server := httptest.NewServer(http.HandlerFunc(SnapshotHandler(draining)))
+	defer server.Close()
+
+	replacement := NewMapStore(accessCount{})
+	if err := replacement.ImportFrom(server.URL); err != nil {
+		t.Error(err)
+	}
+
+	value, err := replacement.Get("KEY")
+	if err != nil {
+		t.Error(err)
+	}
+	expectSame(t, string(value), "4")
+}
+
+func TestSnapshotWithoutBinding(t *testing.T) {
+	store := NewMapStore(nil)
+	store.Set("KEY", []byte("4"))
+	store.GetOrCreate("EXPIRED", []byte("6"), -time.Second)
+	store.Clean()
+
+	expectSame(t, len(store.Export().Entries), 1)
+}
@@ -0,0 +1,45 @@
+package throttle
+
+import "math"
+
+// The direction in which enforcement is ramped during a soft start
+type SoftStartDirection int
+
+const (
+	// The limit starts out unlimited and decreases to the quota, avoiding a
+	// wave of denials when clients mid-burst are throttled again
+	SoftStartPermissive SoftStartDirection = iota
+	// The limit starts out at 1 and increases to the quota, avoiding
+	// over-admission when counters were lost, e.g. by a restart
+	SoftStartStrict
+)
+
+// Return a controller with the limit ramped for a soft start, or the
+// controller itself when the soft start is over
+func (p *policy) softStart(c *controller) *controller {
+	o := p.options
+	if o.SoftStart <= 0 {
+		return c
+	}
+
+	progress := float64(o.Clock.Now().Sub(p.started)) / float64(o.SoftStart)
+	if progress >= 1 {
+		return c
+	}
+	if progress < 0 {
+		progress = 0
+	}
+
+	if o.SoftStartDirection == SoftStartStrict {
+		return c.withQuota(c.quota.Scale(progress))
+	}
+
+	limit := uint64(math.MaxInt64)
+	if ramped := float64(c.quota.Limit) / progress; ramped < math.MaxInt64 {
+		limit = uint64(ramped)
+	}
+
+	quota := *c.quota
+	quota.Limit = limit
+	return c.withQuota(&quota)
+}
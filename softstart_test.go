@@ -0,0 +1,65 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func serveSoftStart(policy func(http.ResponseWriter, *http.Request), ip string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = ip
+	recorder := httptest.NewRecorder()
+	policy(recorder, req)
+
+	return recorder
+}
+
+func TestSoftStartPermissive(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	policy := Policy(&Quota{Limit: 2, Within: time.Hour}, &Options{
+		Clock:     clock,
+		SoftStart: 10 * time.Minute,
+	})
+
+	// unlimited at the start
+	for i := 0; i < 5; i++ {
+		expectStatusCode(t, http.StatusOK, serveSoftStart(policy, "1.2.3.4").Code)
+	}
+
+	// twice the limit halfway through
+	clock.Advance(5 * time.Minute)
+	for i := 0; i < 4; i++ {
+		expectStatusCode(t, http.StatusOK, serveSoftStart(policy, "5.6.7.8").Code)
+	}
+	expectStatusCode(t, StatusTooManyRequests, serveSoftStart(policy, "5.6.7.8").Code)
+
+	// the quota after the soft start
+	clock.Advance(5 * time.Minute)
+	expectStatusCode(t, StatusTooManyRequests, serveSoftStart(policy, "1.2.3.4").Code)
+}
+
+func TestSoftStartStrict(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	policy := Policy(&Quota{Limit: 10, Within: time.Hour}, &Options{
+		Clock:              clock,
+		SoftStart:          10 * time.Minute,
+		SoftStartDirection: SoftStartStrict,
+	})
+
+	// a limit of 1 at the start
+	expectStatusCode(t, http.StatusOK, serveSoftStart(policy, "1.2.3.4").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveSoftStart(policy, "1.2.3.4").Code)
+
+	// half the limit halfway through
+	clock.Advance(5 * time.Minute)
+	recorder := serveSoftStart(policy, "5.6.7.8")
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "5")
+
+	// the quota after the soft start
+	clock.Advance(5 * time.Minute)
+	recorder = serveSoftStart(policy, "1.2.3.4")
+	expectStatusCode(t, http.StatusOK, recorder.Code)
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "10")
+}
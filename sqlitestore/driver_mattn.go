@@ -0,0 +1,19 @@
+//go:build mattn
+
+package sqlitestore
+
+import (
+	"strconv"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The name of the github.com/mattn/go-sqlite3 driver
+const driverName = "sqlite3"
+
+// The data source name for the given path, in WAL mode with the given busy timeout
+func dataSourceName(path string, busyTimeout time.Duration) string {
+	return withParameters(path, "_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout="+
+		strconv.FormatInt(int64(busyTimeout/time.Millisecond), 10))
+}
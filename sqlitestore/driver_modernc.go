@@ -0,0 +1,19 @@
+//go:build !mattn
+
+package sqlitestore
+
+import (
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// The name of the modernc.org/sqlite driver
+const driverName = "sqlite"
+
+// The data source name for the given path, in WAL mode with the given busy timeout
+func dataSourceName(path string, busyTimeout time.Duration) string {
+	return withParameters(path, "_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout("+
+		strconv.FormatInt(int64(busyTimeout/time.Millisecond), 10)+")")
+}
@@ -0,0 +1,186 @@
+// Package sqlitestore provides a throttle store backed by SQLite, for embedded
+// and on-premise deployments without any external infrastructure.
+//
+// The pure Go driver modernc.org/sqlite is used by default. Build with the
+// "mattn" tag to use github.com/mattn/go-sqlite3 instead.
+package sqlitestore
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	// The default table name
+	defaultTable = "throttle"
+
+	// The default time to wait for a locked database
+	defaultBusyTimeout = 5 * time.Second
+)
+
+// A Store keeps throttle state in a SQLite table
+type Store struct {
+	db      *sql.DB
+	options *Options
+}
+
+type Options struct {
+	// The table to keep the values in, will be created if it does not exist
+	// defaults to "throttle"
+	Table string
+
+	// The time to live for keys created with Set, existing keys keep their expiry
+	// defaults to no expiry
+	TTL time.Duration
+
+	// The time to wait for a locked database, only used by Open
+	// defaults to 5 seconds
+	BusyTimeout time.Duration
+}
+
+// Error Type for the store
+type StoreError string
+
+// The Error for the Store
+func (err StoreError) Error() string {
+	return "Throttle SQLite Store Error: " + string(err)
+}
+
+// Get a key, will return an error if the key does not exist or has expired
+func (s *Store) Get(key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(`SELECT value FROM `+s.options.Table+` WHERE key = ? AND (expires = 0 OR expires > ?)`, key, now()).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, StoreError("Key " + key + " does not exist")
+	}
+
+	return value, err
+}
+
+// Set a key, keeping the expiry of an existing key, so counts created with
+// GetOrCreate still expire with their time window. New and expired keys
+// expire after the configured TTL
+func (s *Store) Set(key string, value []byte) error {
+	_, err := s.db.Exec(`INSERT INTO `+s.options.Table+` (key, value, expires) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value,
+		expires = CASE WHEN expires != 0 AND expires <= ? THEN excluded.expires ELSE expires END`, key, value, expires(s.options.TTL), now())
+
+	return err
+}
+
+// Get a key, or set it to the initial value expiring after the given ttl if it
+// does not exist or has expired, with a single upsert. Returns true if the initial value was set
+func (s *Store) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	result, err := s.db.Exec(`INSERT INTO `+s.options.Table+` (key, value, expires) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires = excluded.expires
+		WHERE expires != 0 AND expires <= ?`, key, initial, expires(ttl), now())
+	if err != nil {
+		return nil, false, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, false, err
+	} else if affected != 0 {
+		return initial, true, nil
+	}
+
+	value, err := s.Get(key)
+	return value, false, err
+}
+
+// Delete all expired keys
+func (s *Store) DeleteExpired() error {
+	_, err := s.db.Exec(`DELETE FROM `+s.options.Table+` WHERE expires != 0 AND expires <= ?`, now())
+	return err
+}
+
+// Create the table if it does not exist yet
+func (s *Store) createTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS ` + s.options.Table + ` (
+		key TEXT PRIMARY KEY,
+		value BLOB NOT NULL,
+		expires INTEGER NOT NULL DEFAULT 0
+	)`)
+
+	return err
+}
+
+// The current time in unix nanoseconds
+func now() int64 {
+	return time.Now().UnixNano()
+}
+
+// The time in unix nanoseconds a value expires after the given ttl, zero for no expiry
+func expires(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// Returns new options from defaults and given options
+func newOptions(options []*Options) *Options {
+	o := &Options{
+		Table:       defaultTable,
+		BusyTimeout: defaultBusyTimeout,
+	}
+
+	if len(options) == 0 {
+		return o
+	}
+
+	if options[0].Table != "" {
+		o.Table = options[0].Table
+	}
+	if options[0].TTL != 0 {
+		o.TTL = options[0].TTL
+	}
+	if options[0].BusyTimeout != 0 {
+		o.BusyTimeout = options[0].BusyTimeout
+	}
+
+	return o
+}
+
+// Returns a store using the given database, creating the table if needed
+func New(db *sql.DB, options ...*Options) (*Store, error) {
+	s := &Store{
+		db,
+		newOptions(options),
+	}
+
+	if err := s.createTable(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Opens the database at the given path in WAL mode and returns a store using it
+func Open(path string, options ...*Options) (*Store, error) {
+	o := newOptions(options)
+
+	db, err := sql.Open(driverName, dataSourceName(path, o.BusyTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := New(db, o)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Append the given parameters to the query of the given path
+func withParameters(path string, parameters string) string {
+	if strings.Contains(path, "?") {
+		return path + "&" + parameters
+	}
+
+	return path + "?" + parameters
+}
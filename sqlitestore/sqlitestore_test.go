@@ -0,0 +1,117 @@
+package sqlitestore
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, options ...*Options) *Store {
+	store, err := Open(filepath.Join(t.TempDir(), "throttle.db"), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestSetAndGet(t *testing.T) {
+	store := openTestStore(t)
+
+	if _, err := store.Get("KEY"); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+	if err := store.Set("KEY", []byte("4")); err != nil {
+		t.Error(err)
+	}
+	if err := store.Set("KEY", []byte("5")); err != nil {
+		t.Error(err)
+	}
+	if value, err := store.Get("KEY"); err != nil || string(value) != "5" {
+		t.Errorf("Expected the value 5, but got %q, %v", value, err)
+	}
+}
+
+func TestGetOrCreate(t *testing.T) {
+	store := openTestStore(t)
+
+	if value, created, err := store.GetOrCreate("KEY", []byte("1"), 20*time.Millisecond); err != nil || !created || string(value) != "1" {
+		t.Errorf("Expected the key to be created, but got %q, %v, %v", value, created, err)
+	}
+	if value, created, err := store.GetOrCreate("KEY", []byte("2"), 20*time.Millisecond); err != nil || created || string(value) != "1" {
+		t.Errorf("Expected the existing value 1, but got %q, %v, %v", value, created, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if value, created, err := store.GetOrCreate("KEY", []byte("3"), 20*time.Millisecond); err != nil || !created || string(value) != "3" {
+		t.Errorf("Expected the expired key to be created again, but got %q, %v, %v", value, created, err)
+	}
+}
+
+func TestSetKeepsExpiry(t *testing.T) {
+	store := openTestStore(t, &Options{TTL: time.Hour})
+
+	store.GetOrCreate("KEY", []byte("1"), 20*time.Millisecond)
+	if err := store.Set("KEY", []byte("2")); err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := store.Get("KEY"); err == nil {
+		t.Errorf("Expected the key to expire with the ttl it was created with")
+	}
+
+	// expired keys expire after the TTL of the options once set again
+	store.Set("KEY", []byte("3"))
+	if value, err := store.Get("KEY"); err != nil || string(value) != "3" {
+		t.Errorf("Expected the value 3, but got %q, %v", value, err)
+	}
+}
+
+func TestGetOrCreateConcurrently(t *testing.T) {
+	store := openTestStore(t)
+	wg := &sync.WaitGroup{}
+	created := make(chan bool, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, c, err := store.GetOrCreate("KEY", []byte("1"), time.Minute)
+			if err != nil {
+				t.Error(err)
+			}
+			created <- c
+		}()
+	}
+
+	wg.Wait()
+	close(created)
+
+	count := 0
+	for c := range created {
+		if c {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected the key to be created once, but was created %d times", count)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	store := openTestStore(t, &Options{TTL: time.Millisecond})
+	store.Set("KEY", []byte("4"))
+	time.Sleep(2 * time.Millisecond)
+
+	if err := store.DeleteExpired(); err != nil {
+		t.Error(err)
+	}
+
+	var count int
+	store.db.QueryRow(`SELECT COUNT(*) FROM throttle`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no rows, but got %d", count)
+	}
+}
@@ -0,0 +1,62 @@
+package throttle
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A StoreFactory returns a store for the given url
+type StoreFactory func(u *url.URL) (KeyValueStorer, error)
+
+var (
+	storeFactoriesMutex = &sync.RWMutex{}
+	storeFactories      = map[string]StoreFactory{
+		"map": newMapStoreFromURL,
+	}
+)
+
+// Register the factory for stores with the given url scheme. Store adapters
+// register their schemes when imported, e.g. import _ ".../throttle/redisstore"
+func RegisterStoreScheme(scheme string, factory StoreFactory) {
+	storeFactoriesMutex.Lock()
+	storeFactories[scheme] = factory
+	storeFactoriesMutex.Unlock()
+}
+
+// Return the store for the given url, e.g. "map://" or, with the adapter
+// imported, "redis://localhost:6379/0" or "memcached://10.0.0.1:11211,10.0.0.2:11211".
+// Errors never contain the password of the url
+func NewStoreFromURL(rawURL string) (KeyValueStorer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// the error of the url package quotes the url, password included
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, ConfigError("Invalid store url: " + err.Error())
+	}
+
+	storeFactoriesMutex.RLock()
+	factory, ok := storeFactories[u.Scheme]
+	storeFactoriesMutex.RUnlock()
+	if !ok {
+		return nil, ConfigError("Unsupported store url " + u.Redacted() + ", the adapter for " + u.Scheme + " may not be imported")
+	}
+
+	return factory(u)
+}
+
+// Return a map store for an url like "map://?cleaning_period=1m"
+func newMapStoreFromURL(u *url.URL) (KeyValueStorer, error) {
+	o := &MapStoreOptions{}
+	if value := u.Query().Get("cleaning_period"); value != "" {
+		period, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, ConfigError("Invalid cleaning period " + value + ": " + err.Error())
+		}
+		o.CleaningPeriod = period
+	}
+
+	return NewMapStore(accessCount{}, o), nil
+}
@@ -0,0 +1,131 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// The default time to cache tenant quotas for
+	defaultTenantQuotaTTL = time.Minute
+
+	// The most tenants to cache quotas for, so requesters sending made up
+	// tenants can not grow the cache without bounds
+	maxTenantEntries = 10000
+)
+
+// A cached tenant quota
+type tenantEntry struct {
+	// The quota of the tenant, nil if the tenant has no quota of its own
+	quota *Quota
+	// The time the quota expires
+	expires time.Time
+}
+
+// A cache for the quotas of tenants, read from the store
+type tenantCache struct {
+	*sync.Mutex
+	store     KeyValueStorer
+	codec     Codec
+	clock     Clock
+	prefix    string
+	ttl       time.Duration
+	entries   map[string]*tenantEntry
+	lastSweep time.Time
+}
+
+// Get the quota of the given tenant, or nil if the tenant has no quota of its own
+func (c *tenantCache) Get(tenant string) *Quota {
+	now := c.clock.Now()
+
+	c.Lock()
+	e, ok := c.entries[tenant]
+	c.Unlock()
+
+	if !ok || !now.Before(e.expires) {
+		e = &tenantEntry{
+			c.read(tenant),
+			now.Add(c.ttl),
+		}
+
+		c.Lock()
+		c.sweep(now)
+		c.entries[tenant] = e
+		c.Unlock()
+	}
+
+	return e.quota
+}
+
+// Remove expired entries, at most once per ttl, and an arbitrary entry if
+// the cache is full. Has to be called with the lock held
+func (c *tenantCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for tenant, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, tenant)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	for tenant := range c.entries {
+		if len(c.entries) < maxTenantEntries {
+			break
+		}
+		delete(c.entries, tenant)
+	}
+}
+
+// Read the quota of the given tenant from the store
+func (c *tenantCache) read(tenant string) *Quota {
+	quotaBytes, err := c.store.Get(tenantKey(c.prefix, tenant))
+	if err != nil {
+		return nil
+	}
+
+	quota := &Quota{}
+	if err := c.codec.Decode(quotaBytes, quota); err != nil {
+		panic(err.Error())
+	}
+
+	return quota
+}
+
+// The key of the quota of the given tenant
+func tenantKey(prefix string, tenant string) string {
+	return makeKey(prefix, "tenant", tenant)
+}
+
+// Return a new cache for tenant quotas
+func newTenantCache(o *Options) *tenantCache {
+	return &tenantCache{
+		&sync.Mutex{},
+		o.Store,
+		o.Codec,
+		o.Clock,
+		o.KeyPrefix,
+		o.TenantQuotaTTL,
+		make(map[string]*tenantEntry),
+		o.Clock.Now(),
+	}
+}
+
+// Set the quota of the given tenant in the store of the given options, for
+// policies with a TenantFunction. Onboarding a tenant with custom limits is
+// then a data change. Policies pick up the quota once their cached quota
+// of the tenant expires. Returns a ConfigError without a Store, as the
+// quota would be set in a store of its own that no policy reads
+func SetTenantQuota(tenant string, quota *Quota, options ...*Options) error {
+	o := mergeOptions(options)
+	if o.Store == nil {
+		return ConfigError("Setting a tenant quota requires the Store of the policies")
+	}
+
+	marshalled, err := o.Codec.Encode(quota)
+	if err != nil {
+		return err
+	}
+
+	return o.Store.Set(tenantKey(o.KeyPrefix, tenant), marshalled)
+}
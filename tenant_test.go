@@ -0,0 +1,84 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTenantQuota(t *testing.T) {
+	options := &Options{
+		Store: NewMapStore(accessCount{}),
+		TenantFunction: func(req *http.Request) string {
+			return req.Header.Get("X-Tenant")
+		},
+	}
+
+	if err := SetTenantQuota("acme", &Quota{Limit: 3, Within: time.Hour}, options); err != nil {
+		t.Error(err)
+	}
+
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, options)
+	serve := func(tenant string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		req.Header.Set("X-Tenant", tenant)
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("acme")
+	expectStatusCode(t, http.StatusOK, recorder.Code)
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "3")
+
+	// tenants without a quota of their own fall back to the quota of the policy
+	recorder = serve("initech")
+	expectStatusCode(t, http.StatusOK, recorder.Code)
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "1")
+	expectStatusCode(t, StatusTooManyRequests, serve("initech").Code)
+}
+
+func TestSetTenantQuotaWithoutStore(t *testing.T) {
+	err := SetTenantQuota("acme", &Quota{Limit: 3, Within: time.Hour})
+	if _, ok := err.(ConfigError); !ok {
+		t.Errorf("Expected a ConfigError, got %v", err)
+	}
+}
+
+func TestTenantQuotaIsCached(t *testing.T) {
+	store := NewMapStore(accessCount{})
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := newTenantCache(newOptions([]*Options{{Store: store, Clock: clock}}))
+
+	if cache.Get("acme") != nil {
+		t.Errorf("Expected no quota for an unknown tenant")
+	}
+
+	SetTenantQuota("acme", &Quota{Limit: 3, Within: time.Hour}, &Options{Store: store})
+	if cache.Get("acme") != nil {
+		t.Errorf("Expected the missing quota to be cached")
+	}
+
+	clock.Advance(defaultTenantQuotaTTL)
+	expectSame(t, cache.Get("acme").Limit, uint64(3))
+}
+
+func TestTenantCacheIsBounded(t *testing.T) {
+	cache := newTenantCache(newOptions([]*Options{{Store: NewMapStore(accessCount{})}}))
+
+	for i := 0; i < maxTenantEntries+10; i++ {
+		cache.Get(strconv.Itoa(i))
+	}
+	expectSame(t, len(cache.entries), maxTenantEntries)
+
+	// expired entries are swept
+	cache.lastSweep = time.Now().Add(-time.Hour)
+	for _, e := range cache.entries {
+		e.expires = time.Now()
+	}
+	cache.Get("acme")
+	expectSame(t, len(cache.entries), 1)
+}
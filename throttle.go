@@ -2,13 +2,16 @@ package throttle
 
 import (
 	"bytes"
-	"encoding/json"
-	"net"
+	"io"
+	"log"
 	"net/http"
+	"os"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 )
 
@@ -28,8 +31,42 @@ const (
 	// The header name to retrieve an IP address under a proxy
 	forwardedForHeader = "X-FORWARDED-FOR"
 
+	// The names of the response headers, in canonical form so they are set
+	// without canonicalizing them on every request
+	limitHeader         = "X-Ratelimit-Limit"
+	resetHeader         = "X-Ratelimit-Reset"
+	remainingHeader     = "X-Ratelimit-Remaining"
+	policyHeader        = "X-Ratelimit-Policy"
+	retryAfterHeader    = "Retry-After"
+	retryAfterMsHeader  = "Retry-After-Ms"
+	contentTypeHeader   = "Content-Type"
+	contentLengthHeader = "Content-Length"
+
+	// The message to return for rejected malformed identities
+	malformedIdentityMessage = "Malformed Identity"
+
+	// The message to return for denied empty identities
+	missingIdentityMessage = "Missing Identity"
+
 	// The default for the disabled setting
 	defaultDisabled = false
+
+	// The default scope of the counters
+	defaultScope = GlobalScope
+)
+
+// The Scope of the counters of a policy
+type Scope int
+
+const (
+	// Counters are shared by all instances using the same store
+	GlobalScope Scope = iota
+	// Counters are local to the instance, even when using a store shared by
+	// multiple instances
+	LocalScope
+	// Counters are shared by the instances of the same region, e.g. a
+	// datacenter, even when the store is replicated across regions
+	RegionScope
 )
 
 type Options struct {
@@ -40,21 +77,386 @@ type Options struct {
 	// The message to be returned as the body of throttled requests
 	Message string
 
+	// The format of the X-RateLimit-Reset header, e.g. ResetDeltaSeconds for
+	// quotas with sub-second time windows. With a sub-second format, a
+	// Retry-After-Ms header is added with every Retry-After header
+	// defaults to ResetUnixSeconds
+	ResetFormat ResetFormat
+
+	// If a Retry-After header is added to throttled requests
+	// defaults to false
+	RetryAfter bool
+
+	// The status code to be returned for banned requests
+	// Defaults to the StatusCode
+	BanStatusCode int
+
+	// The message to be returned as the body of banned requests
+	// Defaults to the Message
+	BanMessage string
+
+	// If a Retry-After header is added to banned requests
+	// defaults to false
+	BanRetryAfter bool
+
+	// The maximum random delay of responses to throttled, banned and frozen
+	// requesters, so requesters probing for the limit and the boundaries of
+	// time windows get noisier timings, e.g. 50 * time.Millisecond. The
+	// delay ends early when the request is done
+	// defaults to no delay
+	DenialJitter time.Duration
+
+	// If throttled, banned and rejected requests are answered without a
+	// body for all methods. Responses to HEAD requests never have a body
+	// defaults to false
+	OmitBody bool
+
+	// The formatter writing the messages of throttled, banned and rejected
+	// requests, e.g. JSONFormatter or HTMLFormatter
+	// defaults to the PlainTextFormatter
+	Formatter Formatter
+
+	// The Content-Type header of responses with a message
+	// defaults to the content type of the formatter
+	ContentType string
+
+	// The function deciding if a request bypasses the throttle, e.g. for
+	// health checks
+	// defaults to no request bypassing the throttle
+	SkipAccessCheck func(*http.Request) bool
+
+	// If an X-RateLimit-Bypassed header is added to requests bypassing the throttle
+	// defaults to false
+	BypassedHeader bool
+
+	// The function deciding the number of units of the quota a request
+	// consumes, e.g. more for expensive endpoints. Rate limit headers count
+	// units, and requests costing more than the limit are always denied
+	// defaults to a cost of 1 for every request, as does a cost of 0
+	CostFunction func(*http.Request) uint64
+
+	// The rate limit headers of an upstream gateway to merge into the rate
+	// limit headers of the policy, see UpstreamHeaders
+	// defaults to no upstream gateway
+	UpstreamHeaders *UpstreamHeaders
+
+	// The hook to pass every decision of the policy to, e.g. for monitoring
+	// Called synchronously, so it has to be fast
+	// defaults to no hook
+	OnEvent func(*Event)
+
+	// The function anonymizing the keys of requesters, which hold their
+	// identities, before they reach the OnEvent hook, the logger and the
+	// top consumers of a Limiter, e.g. HashIdentities(secret)
+	// defaults to no anonymization
+	AnonymizeFunction func(string) string
+
+	// Requesters with an IP within a network of the list bypass the
+	// throttle, e.g. the networks of partners or of monitoring
+	// defaults to no allow list
+	AllowList *NetworkList
+
+	// Requesters with an IP within a network of the list are denied with
+	// 403 Forbidden, e.g. the networks of a threat intelligence feed. Takes
+	// precedence over the allow list and all other options
+	// defaults to no deny list
+	DenyList *NetworkList
+
 	// The function used to identify the requester
 	// Defaults to IP identification
 	IdentificationFunction func(*http.Request) string
 
+	// The behavior for requesters the identification function returns an
+	// empty identity for
+	// defaults to EmptyIdentityShared, sharing one bucket
+	EmptyIdentity EmptyIdentityMode
+
+	// The hook called for every request the identification function returns
+	// an empty identity for, e.g. to count how often it happens
+	// defaults to no hook
+	OnEmptyIdentity func(*http.Request)
+
+	// A chain of identifications to try before the IdentificationFunction
+	// The first identification to return a non-empty identity is used, together
+	// with its quota. Defaults to no chain
+	IdentificationChain []*Identification
+
+	// The format identities have to match, e.g. the format of API keys
+	// Malformed identities are identified by IP instead, or rejected
+	// defaults to any format
+	IdentityFormat *regexp.Regexp
+
+	// The maximum length of identities
+	// Longer identities are identified by IP instead, or rejected
+	// defaults to any length
+	IdentityMaxLength int
+
+	// If requests with malformed identities are rejected with 400 Bad Request
+	// defaults to false, identifying requests with malformed identities by IP
+	RejectMalformedIdentities bool
+
+	// The guard against more distinct identities than expected, e.g. spoofed
+	// identities, see CardinalityGuard
+	// defaults to no guard
+	CardinalityGuard *CardinalityGuard
+
+	// The merge of the IPv4 and IPv6 identities of dual-stack clients into
+	// one bucket, see DualStackMerge
+	// defaults to no merging
+	DualStackMerge *DualStackMerge
+
+	// The compression of long identities into short hashes in the keys of
+	// the store, see IdentityCompression
+	// defaults to identities as they are
+	IdentityCompression *IdentityCompression
+
+	// If requesters are counted per host of the request, so every domain of an
+	// app serving multiple hosts gets independent quotas. Use HostIdentity to
+	// act on requesters of a host with a Limiter
+	// defaults to false, counting requests to all hosts together
+	KeyByHost bool
+
+	// The name of the policy, sent in the X-RateLimit-Policy header so
+	// stacked policies can be told apart
+	// defaults to the name of the quota, no header is sent without a name
+	PolicyName string
+
+	// The hook called with a ConflictError when the policy shares its
+	// counters with a policy created before, i.e. both have the same store,
+	// key prefix and quota id, e.g. stacked policies with quotas of the same
+	// limit and time window but no name
+	// defaults to logging the conflict
+	OnConflict func(error)
+
 	// The key prefix to use in any key value store
 	// defaults to "throttle"
 	KeyPrefix string
 
+	// The function used to make the id of a quota in keys. Use LegacyKeyId
+	// to keep the keys of previous versions
+	// defaults to HashedKeyId
+	KeyIdFunction func(*Quota) string
+
 	// The store to use
-	// defaults to a simple concurrent-safe map[string]string
+	// defaults to a simple concurrent-safe map[string]string per policy,
+	// cleaned together with the stores of all other policies, see
+	// SetDefaultMapStoreOptions
 	Store KeyValueStorer
 
+	// The TTL of access counts in stores supporting TTLs, see
+	// KeyValueCreator, e.g. to keep counters around for analytics. TTLs
+	// shorter than the time window of the quota are raised to the window
+	// defaults to the time window of the quota
+	KeyTTL time.Duration
+
+	// The behavior when the store does not answer a ping on creation of the
+	// policy. Only stores implementing StorePinger are pinged
+	// defaults to StorePingDisabled
+	StorePing StorePingMode
+
+	// The interval to retry pings in the background with StorePingRetry
+	// defaults to 5 seconds
+	StorePingInterval time.Duration
+
+	// The retries of store operations failing with transient errors, see
+	// StoreRetry
+	// defaults to no retries
+	StoreRetry *StoreRetry
+
+	// The logger for errors not surfaced in responses, e.g. failed store pings
+	// defaults to a logger writing to stdout
+	Logger *log.Logger
+
+	// The scope of the counters. Counters in a MapStore are always local to the
+	// instance, counters in other stores are shared by all instances using the
+	// store unless the scope is local or region
+	// defaults to GlobalScope
+	Scope Scope
+
+	// The region or datacenter of the instance, used to namespace keys in
+	// region scope. Without a region, counters in region scope are global
+	// defaults to no region
+	Region string
+
+	// The id of the instance, used to namespace keys in local scope
+	// defaults to the hostname and process id
+	InstanceID string
+
 	// If the throttle is disabled or not
 	// defaults to false
 	Disabled bool
+
+	// The exemption relaxing the limits for requesters with a signed cookie,
+	// issued with Exemption.Issue
+	// defaults to no exemptions
+	Exemption *Exemption
+
+	// The function used to find the tenant of the requester. Tenants with a
+	// quota in the store, see SetTenantQuota, are throttled with their quota
+	// instead of the quota of the policy
+	// defaults to no tenants
+	TenantFunction func(*http.Request) string
+
+	// The time to cache the quotas of tenants for
+	// defaults to 1 minute
+	TenantQuotaTTL time.Duration
+
+	// The function used to find the class of the requester, e.g.
+	// ClassifyUserAgent. Requesters of a class in Classes are throttled with
+	// the quota of the class instead of the quota of the policy
+	// defaults to no classes
+	ClassifyFunction func(*http.Request) string
+
+	// The quotas of the classes returned by the classify function
+	Classes map[string]*Quota
+
+	// Further quotas evaluated together with the quota of the policy, e.g.
+	// 1000 per hour next to 10 per second. Access is denied if any quota
+	// denies it, and the rate limit headers are those of the strictest quota
+	// defaults to no further quotas
+	Windows []*Quota
+
+	// A quota for the requests of all requesters combined
+	// defaults to no global quota
+	GlobalQuota *Quota
+
+	// The shares of the global quota reserved for specific requesters
+	// defaults to no reservations
+	Reservations []*Reservation
+
+	// The handler invoked instead of denying access after ChallengeAfter
+	// denials, e.g. to respond with a CAPTCHA page or a redirect. On success,
+	// the application resets the requester with Limiter.Reset or issues an
+	// exemption with Exemption.Issue
+	// defaults to no challenges
+	ChallengeHandler func(http.ResponseWriter, *http.Request)
+
+	// The number of denials before the challenge handler is invoked
+	// defaults to 0, invoking the challenge handler on every denial
+	ChallengeAfter uint64
+
+	// The time bucket to reuse decisions on access for the same requester in,
+	// e.g. time.Millisecond. Collapses bursts of checks into one store read,
+	// while every access is still registered. Requesters may exceed the
+	// limit by the accesses within one bucket
+	// defaults to no caching of decisions
+	DecisionCache time.Duration
+
+	// The maximum number of accesses a requester may exceed the limit by on
+	// this instance due to cached decisions. An allowed decision is reused
+	// for accesses of at most this cost before the store is read again.
+	// Without a DecisionCache, decisions are cached for the time the quota
+	// allows this many accesses in on average
+	// defaults to no bound within a bucket
+	MaxOvershoot uint64
+
+	// The maximum overshoot as a percentage of the limit, e.g. 1 for one
+	// percent, used when MaxOvershoot is not set
+	// defaults to no bound within a bucket
+	MaxOvershootPercent float64
+
+	// The duration to ramp enforcement over after the policy is created,
+	// usually at process start
+	// defaults to no soft start
+	SoftStart time.Duration
+
+	// The direction to ramp enforcement in during a soft start
+	// defaults to SoftStartPermissive
+	SoftStartDirection SoftStartDirection
+
+	// The controller tightening the limit of the quota while the backend is
+	// unhealthy, see AdaptiveController. May be shared by policies
+	// defaults to no adaptive limit
+	AdaptiveController *AdaptiveController
+
+	// The handling of requests exceeding the quota, e.g. Delay to hold them
+	// until the quota frees up instead of denying them
+	// defaults to Reject
+	Mode Mode
+
+	// The longest time a request is held for in the Delay mode. Requests for
+	// which the quota frees up later are denied right away
+	// defaults to 1 second
+	MaxWait time.Duration
+
+	// The most requests held at once in the Delay mode by the policy on this
+	// instance. Further requests exceeding the quota are denied
+	// defaults to 100
+	QueueDepth int
+
+	// The number of previous time windows of the FixedWindow algorithm to
+	// keep the counts of per requester, see Limiter.History. The counts are
+	// stored in the access count, which is then kept for these windows
+	// defaults to no history
+	History int
+
+	// The penalty policy deciding on bans for requesters violating the quota
+	// defaults to no bans
+	PenaltyPolicy PenaltyPolicy
+
+	// The time violations are kept after the last violation or the end of
+	// the ban, after which they are forgotten. Keep it above the Forgive time
+	// of an ExponentialPenalty
+	// defaults to 24 hours
+	ViolationsTTL time.Duration
+
+	// The behavior for denied requests, e.g. DeniedRetryExtendsLockout to
+	// extend the lockout with every denied attempt
+	// defaults to DeniedRetryIgnored
+	DeniedRetry DeniedRetryMode
+
+	// The provider to look up the reputation of the IP of the connection of
+	// requesters with. Requesters with a bad reputation can be denied access
+	// or given a lower limit
+	// defaults to no reputation lookups
+	ReputationProvider ReputationProvider
+
+	// The time to cache reputations for
+	// defaults to 5 minutes
+	ReputationTTL time.Duration
+
+	// The scoring of requesters by their behavior over days. Requesters with
+	// a high score, e.g. from repeated denials, get a lower limit
+	// defaults to no scores
+	ReputationScore *ReputationScore
+
+	// The time to wait for a reputation lookup before continuing without it
+	// defaults to 50 milliseconds
+	ReputationTimeout time.Duration
+
+	// The codec to encode and decode values in the store with
+	// defaults to stringified JSON
+	Codec Codec
+
+	// If access counts failing to decode, e.g. after partial writes or a
+	// codec migration, are replaced by a new time window instead of
+	// panicking. Repairs are logged and passed to the OnEvent hook as
+	// EventRepaired
+	// defaults to false
+	RepairCorrupt bool
+
+	// The clock to tell the time with
+	// defaults to the system clock
+	Clock Clock
+
+	// The random source for all randomness of the policy
+	// defaults to math/rand
+	Random Random
+}
+
+// An Identification is a named strategy to identify the requester within an
+// identification chain, optionally with its own quota
+type Identification struct {
+	// The name of the identification, will be part of the key
+	Name string
+
+	// The function used to identify the requester. Returns an empty string
+	// if the requester can not be identified by it
+	Function func(*http.Request) string
+
+	// The quota for requesters identified by this identification
+	// defaults to the quota of the policy
+	Quota *Quota
 }
 
 // KeyValueStorer is the required interface for the Store Option
@@ -67,16 +469,42 @@ type KeyValueStorer interface {
 	Set(key string, value []byte) error
 }
 
-// The Quota is Request Rates per Time for a given policy
-type Quota struct {
-	// The Request Limit
-	Limit uint64
-	// The time window for the request Limit
-	Within time.Duration
+// KeyValueCreator is an optional interface for the Store Option
+// Stores shared by multiple instances should implement it, so that only one of
+// the instances can start a new time window, without losing any counts
+type KeyValueCreator interface {
+	// Get the value of the key, or set it to the initial value if the key does
+	// not exist or has expired, atomically. Returns true if the initial value
+	// was set. Values set by this function expire after the given ttl
+	GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error)
+}
+
+// KeyValueUpdater is an optional interface for the Store Option
+// Stores supporting transactions should implement it together with
+// KeyValueCreator, so that access counts are incremented atomically and
+// the increments of other instances are never lost
+type KeyValueUpdater interface {
+	// Set the key to the value the given function returns for its value, or
+	// for nil if the key does not exist or has expired, atomically. The
+	// function may be called again if the key changes concurrently. Keys
+	// which did not exist expire after the given ttl, others keep their
+	// expiry. Returns the value set
+	Update(key string, update func(value []byte) ([]byte, error), ttl time.Duration) ([]byte, error)
+}
+
+// Clock is the interface for the Clock Option
+// This allows for tests to control the time
+type Clock interface {
+	// Return the current time
+	Now() time.Time
 }
 
-func (q *Quota) KeyId() string {
-	return strconv.FormatInt(int64(q.Within)/int64(q.Limit), 10)
+// The system clock, the default clock
+type systemClock struct{}
+
+// Return the current system time
+func (c systemClock) Now() time.Time {
+	return time.Now()
 }
 
 // An access message to return to the user
@@ -85,14 +513,90 @@ type accessMessage struct {
 	StatusCode int
 	// The given message
 	Message string
+	// The template for the message, if the message contains template actions
+	Template *template.Template
+}
+
+// The MessageData is available to messages containing template actions,
+// e.g. "Try again in {{.RetryAfter}} seconds"
+type MessageData struct {
+	// The limit of the quota
+	Limit uint64
+	// The remaining limit
+	Remaining uint64
+	// The seconds until access is allowed again, rounded up
+	RetryAfter int64
+	// The time at which access is allowed again
+	ResetAt time.Time
 }
 
-// Return a new access message with the properties given
-func newAccessMessage(statusCode int, message string) *accessMessage {
-	return &accessMessage{
+// Return a new access message with the properties given. Returns a
+// ConfigError if the message contains template actions which do not parse
+// or fail to render sample data
+func newAccessMessage(statusCode int, message string) (*accessMessage, error) {
+	msg := &accessMessage{
 		StatusCode: statusCode,
 		Message:    message,
 	}
+
+	if !strings.Contains(message, "{{") {
+		return msg, nil
+	}
+
+	t, err := template.New("message").Parse(message)
+	if err == nil {
+		err = t.Execute(io.Discard, &MessageData{1, 0, 1, time.Unix(0, 0).UTC()})
+	}
+	if err != nil {
+		return nil, ConfigError("Invalid message " + strconv.Quote(message) + ": " + err.Error())
+	}
+
+	msg.Template = t
+	return msg, nil
+}
+
+// Return the message data for the given controller and id
+func messageData(controller *controller, id string) *MessageData {
+	remaining, resetAt := controller.limits(id)
+
+	return &MessageData{
+		Limit:      controller.quota.capacity(),
+		Remaining:  remaining,
+		RetryAfter: secondsUntil(resetAt, controller.now()),
+		ResetAt:    resetAt,
+	}
+}
+
+// Render the message of the access message with the given data. Falls back
+// to the text of the status code if the template fails to render
+func (m *accessMessage) Render(data *MessageData) string {
+	if m.Template == nil {
+		return m.Message
+	}
+
+	var rendered bytes.Buffer
+	if err := m.Template.Execute(&rendered, data); err != nil {
+		if text := http.StatusText(m.StatusCode); text != "" {
+			return text
+		}
+		return defaultMessage
+	}
+
+	return rendered.String()
+}
+
+// Return the access message for banned requests, falling back to the
+// status code and message for throttled requests
+func newBanMessage(o *Options) (*accessMessage, error) {
+	statusCode, message := o.BanStatusCode, o.BanMessage
+	if statusCode == 0 {
+		statusCode = o.StatusCode
+	}
+	if message == "" {
+		message = o.Message
+	}
+
+	return newAccessMessage(statusCode, message)
 }
 
 // An access count for a single identified user.
@@ -101,56 +605,147 @@ type accessCount struct {
 	Count    uint64        `json:"count"`
 	Start    time.Time     `json:"start"`
 	Duration time.Duration `json:"duration"`
+	// The accesses within the time window, for the SlidingWindowLog algorithm
+	Log []loggedAccess `json:"log,omitempty"`
+	// The time to refill a token, for the TokenBucket and LeakyBucket
+	// algorithms. The count is then the number of tokens taken from the
+	// bucket at the start, the time of the last refill
+	Refill time.Duration `json:"refill,omitempty"`
+	// The unused accesses carried over from earlier time windows, for
+	// quotas with a Rollover
+	Credit uint64 `json:"credit,omitempty"`
+	// The counts of the previous time windows, oldest first, with the
+	// History option
+	History []windowCount `json:"history,omitempty"`
 }
 
 // Determine if the count is still fresh
 func (r accessCount) IsFresh() bool {
-	return time.Now().UTC().Sub(r.Start) < r.Duration
+	return r.IsFreshAt(time.Now().UTC())
+}
+
+// Determine if the count is still fresh at the given time. A count started
+// more than its duration after the given time is stale, so a clock going
+// backwards does not keep counts fresh for longer than the time window
+func (r accessCount) IsFreshAt(now time.Time) bool {
+	if r.logged() {
+		return r.loggedCount(now) > 0
+	} else if r.bucket() {
+		return r.refilled(now) < r.Count && now.Sub(r.Start) > -r.Duration
+	}
+
+	elapsed := now.Sub(r.Start)
+	return elapsed < r.Duration && elapsed > -r.Duration
+}
+
+// Determine if cleaning a MapStore evicts the count. The store of a policy
+// also keeps violations, bans, freezes, scores and other values, which are
+// evicted by their own expiry, or decode into a count without a duration and
+// are kept. Counts carrying credit or history are kept until their key expires
+func (r accessCount) evictableAt(now time.Time) bool {
+	return r.Duration != 0 && r.Credit == 0 && len(r.History) == 0 && !r.IsFreshAt(now)
 }
 
 // Increment the count when fresh, or reset and then increment when stale
-func (r *accessCount) Increment() {
-	if r.IsFresh() {
-		r.Count++
+func (r *accessCount) Increment(now time.Time) {
+	r.IncrementBy(now, 1)
+}
+
+// Increment the count by the given cost when fresh, or reset and then
+// increment when stale
+func (r *accessCount) IncrementBy(now time.Time, cost uint64) {
+	if r.IsFreshAt(now) {
+		r.Count += cost
 	} else {
-		r.Count = 1
-		r.Start = time.Now().UTC()
+		r.Count = cost
+		r.Start = now
 	}
 }
 
-// Get the count
-func (r *accessCount) GetCount() uint64 {
-	if r.IsFresh() {
+// Get the count at the given time
+func (r *accessCount) GetCount(now time.Time) uint64 {
+	if r.logged() {
+		return r.loggedCount(now)
+	} else if r.bucket() && r.IsFreshAt(now) {
+		return r.Count - r.refilled(now)
+	} else if r.IsFreshAt(now) {
 		return r.Count
 	} else {
 		return 0
 	}
 }
 
-// Return a new access count with the given duration
-func newAccessCount(duration time.Duration) *accessCount {
+// Return a new access count with the given duration, starting at the given time
+func newAccessCount(duration time.Duration, now time.Time) *accessCount {
 	return &accessCount{
 		0,
-		time.Now().UTC(),
+		now,
 		duration,
+		nil,
+		0,
+		0,
+		nil,
 	}
 }
 
-// Unmarshal a stringified JSON respresentation of an access count
-func accessCountFromBytes(accessCountBytes []byte) *accessCount {
-	byteBufferString := bytes.NewBuffer(accessCountBytes)
-	a := &accessCount{}
-	if err := json.NewDecoder(byteBufferString).Decode(a); err != nil {
-		panic(err.Error())
-	}
-	return a
-}
-
 // The controller, stores the allowed quota and has access to the store
 type controller struct {
 	*sync.Mutex
-	quota *Quota
-	store KeyValueStorer
+	quota   *Quota
+	store   KeyValueStorer
+	penalty PenaltyPolicy
+	codec   Codec
+	clock   Clock
+	// If violations are tracked without a penalty policy, e.g. for challenges
+	tracking bool
+	// The limit of the quota, formatted for the X-RateLimit-Limit header
+	limit string
+	// The credit carried over into the time window the limit of the quota
+	// is raised by, see Quota.Rollover
+	credit uint64
+	// The number of previous time windows to keep the counts of
+	history int
+	// The TTL of new access counts in stores supporting TTLs, at least the
+	// time window of the quota
+	keyTTL time.Duration
+	// The counts incremented together with the access count of the requester
+	linked []linkedCount
+	// Called with the key of a corrupt access count before it is repaired,
+	// nil if corrupt access counts panic
+	repair func(key string, err error)
+	// The prefix of the keys of the policy, see sideKey
+	prefix string
+	// The time violations are kept for, see Options.ViolationsTTL
+	violationsTTL time.Duration
+}
+
+// A count incremented together with the access count of a requester, e.g.
+// the total access count of all requesters
+type linkedCount struct {
+	controller *controller
+	key        string
+	// If the count is a window of the policy, considered for the rate
+	// limit headers
+	window bool
+}
+
+// Get the current time of the clock in UTC
+func (c *controller) now() time.Time {
+	return c.clock.Now().UTC()
+}
+
+// Get the start of a time window beginning at the given time. Fixed windows
+// of quotas aligned to the window start at the last boundary of their
+// duration on the wall clock of the time zone of the quota
+func (c *controller) windowStart(now time.Time) time.Time {
+	if c.quota.AlignToWindow && c.quota.Within > 0 && c.quota.Algorithm == FixedWindow {
+		location, _ := c.quota.alignLocation()
+		_, offset := now.In(location).Zone()
+		shift := time.Duration(offset) * time.Second
+		return now.Add(shift).Truncate(c.quota.Within).Add(-shift)
+	}
+
+	return now
 }
 
 // Get an access count by id
@@ -158,17 +753,35 @@ func (c *controller) GetAccessCount(id string) (a *accessCount) {
 	accessCountBytes, err := c.store.Get(id)
 
 	if err == nil {
-		a = accessCountFromBytes(accessCountBytes)
+		a = c.decodeAccessCount(id, accessCountBytes)
 	} else {
-		a = newAccessCount(c.quota.Within)
+		a = newAccessCount(c.quota.Within, c.windowStart(c.now()))
 	}
 
 	return a
 }
 
+// Decode the access count of the given id. A corrupt access count panics,
+// or is overwritten by a new time window when repairing corrupt access counts
+func (c *controller) decodeAccessCount(id string, accessCountBytes []byte) *accessCount {
+	a := &accessCount{}
+	err := c.codec.Decode(accessCountBytes, a)
+	if err == nil {
+		return a
+	} else if c.repair == nil {
+		panic(err.Error())
+	}
+
+	c.repair(id, err)
+	a = newAccessCount(c.quota.Within, c.windowStart(c.now()))
+	c.SetAccessCount(id, a)
+
+	return a
+}
+
 // Set an access count by id, will write to the store
 func (c *controller) SetAccessCount(id string, a *accessCount) {
-	marshalled, err := json.Marshal(a)
+	marshalled, err := c.codec.Encode(a)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -179,43 +792,342 @@ func (c *controller) SetAccessCount(id string, a *accessCount) {
 	}
 }
 
-// Gets the access count, increments it and writes it back to the store
-func (c *controller) RegisterAccess(id string) {
+// Gets the access count, increments it by the cost of the access and writes
+// it back to the store. With a store able to create keys atomically, a new
+// time window is created atomically as well. Linked counts are incremented
+// as well
+func (c *controller) RegisterAccess(id string, cost uint64) {
 	c.Lock()
-	defer c.Unlock()
+	c.increment(id, cost)
+	c.Unlock()
+
+	for _, l := range c.linked {
+		l.controller.RegisterAccess(l.key, cost)
+	}
+}
+
+// Increment the access count of the given key by the given cost
+// Has to be called with the lock held
+func (c *controller) increment(id string, cost uint64) {
+	if updater, ok := c.store.(KeyValueUpdater); ok && c.updateAccessCount(updater, id, cost) {
+		return
+	}
+
+	if creator, ok := c.store.(KeyValueCreator); ok && c.createAccessCount(creator, id, cost) {
+		return
+	}
 
 	counter := c.GetAccessCount(id)
-	counter.Increment()
+	c.count(counter, c.windowStart(c.now()), cost)
 	c.SetAccessCount(id, counter)
 }
 
-// Check if the controller denies access for the given id based on
-// the quota and used access
-func (c *controller) DeniesAccess(id string) bool {
-	counter := c.GetAccessCount(id)
-	return counter.GetCount() >= c.quota.Limit
+// Create an access count with a count of the cost if no fresh access count
+// exists, or increment the existing one. Returns false if the store failed
+func (c *controller) createAccessCount(creator KeyValueCreator, id string, cost uint64) bool {
+	now := c.windowStart(c.now())
+	initial := newAccessCount(c.quota.Within, now)
+	c.count(initial, now, cost)
+	marshalled, err := c.codec.Encode(initial)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	existing, created, err := creator.GetOrCreate(id, marshalled, c.ttl())
+	if err != nil {
+		return false
+	}
+
+	if !created {
+		counter := c.decodeAccessCount(id, existing)
+		c.count(counter, now, cost)
+		c.SetAccessCount(id, counter)
+	}
+
+	return true
 }
 
-// Get a time for the given id when the quota time window will be reset
-func (c *controller) RetryAt(id string) time.Time {
+// Increment the access count of the given key by the given cost in one
+// atomic update, starting a new time window if no fresh access count exists.
+// Returns false if the store failed
+func (c *controller) updateAccessCount(updater KeyValueUpdater, id string, cost uint64) bool {
+	now := c.windowStart(c.now())
+	_, err := updater.Update(id, func(value []byte) ([]byte, error) {
+		counter := &accessCount{}
+		if value == nil {
+			counter = newAccessCount(c.quota.Within, now)
+		} else if err := c.codec.Decode(value, counter); err != nil {
+			if c.repair == nil {
+				panic(err.Error())
+			}
+			c.repair(id, err)
+			counter = newAccessCount(c.quota.Within, now)
+		}
+
+		c.count(counter, now, cost)
+		return c.codec.Encode(counter)
+	}, c.ttl())
+
+	return err == nil
+}
+
+// Get the TTL of new access counts, the KeyTTL option or the time window of
+// the quota if it is longer. Keys expiring within the window would lose counts.
+// Buckets of the TokenBucket and LeakyBucket algorithms take the time to
+// refill them entirely. Quotas rolling over or keeping a history take the
+// time windows to accumulate the rollover or of the history after the
+// current one
+func (c *controller) ttl() time.Duration {
+	within := c.quota.Within
+	if refill := c.quota.refill(); refill > 0 {
+		within = time.Duration(c.quota.capacity()) * refill
+	} else if windows := c.retainedWindows(); windows > 0 {
+		within *= time.Duration(1 + windows)
+	}
+
+	if c.keyTTL > within {
+		return c.keyTTL
+	}
+
+	return within
+}
+
+// Get the key of the record of the given kind the given id keeps besides its
+// access count, e.g. its violations. The kind follows the prefix, in place
+// of the quota id, so no identity makes a key of a requester equal to it
+func (c *controller) sideKey(id string, kind string) string {
+	return makeKey(c.prefix, kind, strings.TrimPrefix(id, c.prefix+keySeparator))
+}
+
+// Get the violations by id. Expired violations are forgotten
+func (c *controller) GetViolations(id string) *Violations {
+	v := &Violations{}
+	if violationsBytes, err := c.store.Get(c.sideKey(id, "violations")); err == nil {
+		if err := c.codec.Decode(violationsBytes, v); err != nil {
+			panic(err.Error())
+		}
+	}
+
+	if !v.Expires.IsZero() && !c.now().Before(v.Expires) {
+		return &Violations{}
+	}
+
+	return v
+}
+
+// Set the violations by id, expiring after the TTL of violations from the
+// last violation or the end of the ban, will write to the store
+func (c *controller) SetViolations(id string, v *Violations) {
+	v.Expires = v.Last
+	if v.BannedUntil.After(v.Expires) {
+		v.Expires = v.BannedUntil
+	}
+	v.Expires = v.Expires.Add(c.violationsTTL)
+
+	marshalled, err := c.codec.Encode(v)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	err = c.store.Set(c.sideKey(id, "violations"), marshalled)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// Registers a violation of the quota, and bans the id if the penalty policy
+// decides so. Returns the violations, or nil if violations are not tracked
+func (c *controller) RegisterViolation(id string) *Violations {
+	if c.penalty == nil && !c.tracking {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	violations := c.GetViolations(id)
+	violations.Count++
+	if c.penalty != nil {
+		if ban := c.penalty.Penalize(id, violations, now); ban > 0 {
+			violations.BannedUntil = now.Add(ban)
+		}
+	}
+	violations.Last = now
+	c.SetViolations(id, violations)
+
+	return violations
+}
+
+// Reset the access count and violations of the given id
+func (c *controller) Reset(id string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.SetAccessCount(id, newAccessCount(c.quota.Within, c.windowStart(c.now())))
+	if c.penalty != nil || c.tracking {
+		c.SetViolations(id, &Violations{})
+	}
+}
+
+// Get the time the ban for the given id ends. Returns the zero time when
+// the id has never been banned
+func (c *controller) BannedUntil(id string) time.Time {
+	if c.penalty == nil {
+		return time.Time{}
+	}
+
+	return c.GetViolations(id).BannedUntil
+}
+
+// Check if the given id is banned
+func (c *controller) IsBanned(id string) bool {
+	return c.now().Before(c.BannedUntil(id))
+}
+
+// A freeze of a requester, will be stored in the key value store
+type freeze struct {
+	Until time.Time `json:"until"`
+	// The time the freeze is removed from the store, when it ends or at
+	// once for removed freezes
+	Expires time.Time `json:"expires"`
+}
+
+// Get the time the freeze of the given id ends. Returns the zero time when
+// the id is not frozen
+func (c *controller) FrozenUntil(id string) time.Time {
+	f := &freeze{}
+	if frozenBytes, err := c.store.Get(c.sideKey(id, "frozen")); err == nil {
+		if err := c.codec.Decode(frozenBytes, f); err != nil {
+			panic(err.Error())
+		}
+	}
+
+	return f.Until
+}
+
+// Freeze the given id until the given time, will write to the store. The
+// zero time unfreezes the id
+func (c *controller) SetFrozenUntil(id string, until time.Time) {
+	expires := c.now()
+	if until.After(expires) {
+		expires = until.UTC()
+	}
+
+	marshalled, err := c.codec.Encode(&freeze{until.UTC(), expires})
+	if err != nil {
+		panic(err.Error())
+	}
+
+	err = c.store.Set(c.sideKey(id, "frozen"), marshalled)
+	if err != nil {
+		panic(err.Error())
+	}
+}
+
+// Check if the controller denies an access of the given cost for the given
+// id based on the quota and used access
+func (c *controller) DeniesAccess(id string, cost uint64) bool {
 	counter := c.GetAccessCount(id)
+	return counter.GetCount(c.now())+cost > c.quota.capacity()
+}
 
-	return counter.Start.Add(c.quota.Within)
+// Get a time for the given id when the quota time window will be reset,
+// or when the ban ends for banned ids
+func (c *controller) RetryAt(id string) time.Time {
+	_, retryAt := c.limits(id)
+	return retryAt
 }
 
 // Get the remaining limit for the given id
 func (c *controller) RemainingLimit(id string) uint64 {
+	remaining, _ := c.limits(id)
+	return remaining
+}
+
+// Get the remaining limit and the retry time for the given id, reading the
+// access count and violations once
+func (c *controller) limits(id string) (uint64, time.Time) {
+	now := c.now()
 	counter := c.GetAccessCount(id)
+	retryAt := counter.StartAt(now).Add(c.quota.Within)
+	if counter.bucket() {
+		retryAt = counter.refillAt(c.quota.capacity())
+	}
+
+	bannedUntil := c.BannedUntil(id)
+	if bannedUntil.After(retryAt) {
+		retryAt = bannedUntil
+	}
+
+	if now.Before(bannedUntil) {
+		return 0, retryAt
+	}
+
+	if count := counter.GetCount(now); count < c.quota.capacity() {
+		return c.quota.capacity() - count, retryAt
+	}
+
+	return 0, retryAt
+}
+
+// Return a controller for the same store and keys with the limit of the
+// quota scaled by the given factor
+func (c *controller) scaled(factor float64) *controller {
+	if factor <= 0 || factor >= 1 {
+		return c
+	}
 
-	return c.quota.Limit - counter.GetCount()
+	return c.withQuota(c.quota.Scale(factor))
 }
 
-// Return a new controller with the given quota and store
-func newController(quota *Quota, store KeyValueStorer) *controller {
+// Return a controller counting the total accesses of all requesters in the
+// given key, with the limit of the quota raised to its share of the total
+// accesses within the time window
+func (c *controller) shared(total string) *controller {
+	count := c.GetAccessCount(total).GetCount(c.now())
+
+	return c.withQuota(c.quota.shareOf(count)).linkedWith(c, total)
+}
+
+// Return a copy of the controller incrementing the count of the given key
+// of the given controller together with the access count of the requester
+func (c *controller) linkedWith(controller *controller, key string) *controller {
+	linked := *c
+	linked.linked = append(append([]linkedCount{}, c.linked...), linkedCount{controller, key, false})
+
+	return &linked
+}
+
+// Return a controller for the same store with the given quota, sharing
+// the lock of the controller
+func (c *controller) withQuota(quota *Quota) *controller {
+	derived := *c
+	derived.quota = quota
+	derived.limit = strconv.FormatUint(quota.capacity(), 10)
+
+	return &derived
+}
+
+// Return a new controller with the given quota, using the store, penalty
+// policy, codec and clock of the given options
+func newController(quota *Quota, o *Options) *controller {
 	return &controller{
 		&sync.Mutex{},
 		quota,
-		store,
+		o.Store,
+		o.PenaltyPolicy,
+		o.Codec,
+		o.Clock,
+		o.ChallengeHandler != nil,
+		strconv.FormatUint(quota.capacity(), 10),
+		0,
+		o.History,
+		o.KeyTTL,
+		nil,
+		o.repairHook(),
+		keyPrefix(o),
+		o.ViolationsTTL,
 	}
 }
 
@@ -224,6 +1136,195 @@ func (o *Options) Identify(req *http.Request) string {
 	return o.IdentificationFunction(req)
 }
 
+// Check if the identity matches the identity format and maximum length
+func (o *Options) isWellFormedIdentity(identity string) bool {
+	if o.IdentityMaxLength != 0 && len(identity) > o.IdentityMaxLength {
+		return false
+	}
+
+	return o.IdentityFormat == nil || o.IdentityFormat.MatchString(identity)
+}
+
+// A policy holds the options and a controller for every quota in use
+type policy struct {
+	options     *Options
+	prefix      string
+	denyMessage *accessMessage
+	banMessage  *accessMessage
+	controller  *controller
+	chain       []*controller
+	reputations *reputationCache
+	global      *globalQuota
+	tenants     *tenantCache
+	decisions   *decisionCache
+	cardinality *cardinalityTracker
+	windows     []*controller
+	store       *storeStatus
+	started     time.Time
+	// The slots of the requests held in the Delay mode, nil in the Reject
+	// mode
+	queue chan struct{}
+	// If the events of requests not admitted are mapped as denials
+	denials bool
+}
+
+// Return a new policy for the given quota and options
+func newPolicy(quota *Quota, o *Options) *policy {
+	if _, err := quota.alignLocation(); err != nil {
+		panic(err.Error())
+	}
+
+	if o.Exemption != nil && len(o.Exemption.Secret) == 0 {
+		panic(ConfigError("An Exemption requires a Secret to sign cookies with").Error())
+	}
+
+	denyMessage, err := newAccessMessage(o.StatusCode, o.Message)
+	if err != nil {
+		panic(err.Error())
+	}
+	banMessage, err := newBanMessage(o)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	p := &policy{
+		options:     o,
+		prefix:      keyPrefix(o),
+		denyMessage: denyMessage,
+		banMessage:  banMessage,
+		controller:  newController(copyQuota(quota), o),
+		chain:       make([]*controller, len(o.IdentificationChain)),
+		global:      newGlobalQuota(keyPrefix(o), o),
+		windows:     newWindows(o),
+		store:       pingStore(o),
+		started:     o.Clock.Now(),
+	}
+
+	for i, identification := range o.IdentificationChain {
+		if identification.Quota != nil {
+			p.chain[i] = newController(identification.Quota, o)
+		} else {
+			p.chain[i] = p.controller
+		}
+	}
+
+	if o.ReputationProvider != nil {
+		p.reputations = newReputationCache(o.ReputationProvider, o.Clock, o.ReputationTTL, o.ReputationTimeout)
+	}
+
+	if o.TenantFunction != nil {
+		p.tenants = newTenantCache(o)
+	}
+
+	if bucket := decisionBucket(quota, o); bucket > 0 {
+		p.decisions = newDecisionCache(bucket)
+	}
+
+	if o.CardinalityGuard != nil {
+		p.cardinality = newCardinalityTracker(o.CardinalityGuard, quota)
+	}
+
+	if o.Mode == Delay {
+		p.queue = make(chan struct{}, o.QueueDepth)
+	}
+
+	return p
+}
+
+// Return a copy of the policy with the given quota, sharing the caches of
+// the policy. Identifications without a quota of their own use the quota
+func (p *policy) withQuota(quota *Quota) *policy {
+	derived := *p
+	derived.controller = p.controller.withQuota(copyQuota(quota))
+	derived.chain = make([]*controller, len(p.chain))
+	for i, c := range p.chain {
+		if c == p.controller {
+			c = derived.controller
+		}
+		derived.chain[i] = c
+	}
+
+	return &derived
+}
+
+// The outcome of the identification of a requester
+type identityStatus int
+
+const (
+	// The requester was identified
+	identityFound identityStatus = iota
+	// The requester has to be rejected for a malformed identity
+	identityMalformed
+	// The requester has to be denied for an empty identity
+	identityMissing
+	// The requester bypasses the throttle for an empty identity
+	identityBypassed
+)
+
+// Identify the requester, returns the controller in charge of the requester
+// and the key to use in the store. A non-nil quota replaces the quota of the
+// controller. Returns a status other than identityFound if the requester
+// was not identified
+func (p *policy) identify(req *http.Request, quota *Quota) (*controller, string, identityStatus) {
+	o := p.options
+	for i, identification := range o.IdentificationChain {
+		if identity := identification.Function(req); identity != "" {
+			if !o.isWellFormedIdentity(identity) {
+				if o.RejectMalformedIdentities {
+					return nil, "", identityMalformed
+				}
+				continue
+			}
+
+			identity = p.compress(p.guardCardinality(req, identity))
+			if o.KeyByHost {
+				identity = HostIdentity(req.Host, identity)
+			}
+
+			c := p.chain[i]
+			if quota != nil {
+				c = c.withQuota(quota)
+			}
+			return c, makeKey(p.prefix, o.KeyIdFunction(c.quota), identification.Name, identity), identityFound
+		}
+	}
+
+	identity := o.Identify(req)
+	if identity == "" {
+		if o.OnEmptyIdentity != nil {
+			o.OnEmptyIdentity(req)
+		}
+
+		switch o.EmptyIdentity {
+		case EmptyIdentityBypass:
+			return nil, "", identityBypassed
+		case EmptyIdentityDeny:
+			return nil, "", identityMissing
+		case EmptyIdentityConnection:
+			identity = req.RemoteAddr
+		}
+	}
+
+	if !o.isWellFormedIdentity(identity) {
+		if o.RejectMalformedIdentities {
+			return nil, "", identityMalformed
+		}
+		identity = defaultIdentify(req)
+	}
+
+	identity = p.mergeDualStack(req, identity)
+	identity = p.compress(p.guardCardinality(req, identity))
+	if o.KeyByHost {
+		identity = HostIdentity(req.Host, identity)
+	}
+
+	c := p.controller
+	if quota != nil {
+		c = c.withQuota(quota)
+	}
+	return c, makeKey(p.prefix, o.KeyIdFunction(c.quota), identity), identityFound
+}
+
 // A throttling Policy
 // Takes two arguments, one required:
 // First is a Quota (A Limit with an associated time). When the given Limit
@@ -237,67 +1338,429 @@ func Policy(quota *Quota, options ...*Options) func(resp http.ResponseWriter, re
 		return func(resp http.ResponseWriter, req *http.Request) {}
 	}
 
-	controller := newController(quota, o.Store)
+	return newLimiter(quota, o).ServeHTTP
+}
 
-	return func(resp http.ResponseWriter, req *http.Request) {
-		id := makeKey(o.KeyPrefix, quota.KeyId(), o.Identify(req))
+// Throttle the request, adjusted by the given override if it is not nil.
+// Returns the event of the request if access was not admitted
+func (p *policy) serve(resp http.ResponseWriter, req *http.Request, override *Override) *Event {
+	quota, cost, e := p.precheck(resp, req, override)
+	if e != nil {
+		return e
+	}
 
-		if controller.DeniesAccess(id) {
-			msg := newAccessMessage(o.StatusCode, o.Message)
-			setRateLimitHeaders(resp, controller, id)
-			resp.WriteHeader(msg.StatusCode)
-			resp.Write([]byte(msg.Message))
-			return
-		} else {
-			controller.RegisterAccess(id)
-			setRateLimitHeaders(resp, controller, id)
+	controller, id, e := p.admit(resp, req, quota, cost)
+	if e != nil {
+		return e
+	}
+
+	controller.RegisterAccess(id, cost)
+	p.setHeaders(resp, req, controller, id)
+	p.emit(EventAllowed, req, controller, id)
+
+	return nil
+}
+
+// Check the lists, the skips and the quotas of the request before it is
+// admitted, adjusted by the given override if it is not nil. Returns the
+// quota to use instead of the quota of the policy, nil for the quota of the
+// policy, and the cost of the access, or the event of the request if it is
+// blocked or bypasses the throttle and the response has been written
+func (p *policy) precheck(resp http.ResponseWriter, req *http.Request, override *Override) (*Quota, uint64, *Event) {
+	var quota *Quota
+	cost := uint64(1)
+	if p.options.DenyList != nil && p.options.DenyList.ContainsRequest(req) {
+		return nil, 0, p.block(resp, req)
+	}
+
+	if p.options.AllowList != nil && p.options.AllowList.ContainsRequest(req) {
+		return nil, 0, p.bypass(resp, req)
+	}
+
+	if p.options.SkipAccessCheck != nil && p.options.SkipAccessCheck(req) {
+		return nil, 0, p.bypass(resp, req)
+	}
+
+	if p.options.CostFunction != nil {
+		if c := p.options.CostFunction(req); c != 0 {
+			cost = c
+		}
+	}
+
+	if override != nil {
+		if override.Skip {
+			return nil, 0, p.bypass(resp, req)
+		}
+		quota = override.Quota
+		if override.Cost != 0 {
+			cost = override.Cost
 		}
+	}
+
+	if quota == nil && p.options.Exemption != nil && p.options.Exemption.isExemptAt(req, p.controller.now()) {
+		if p.options.Exemption.Quota == nil {
+			return nil, 0, p.bypass(resp, req)
+		}
+		quota = p.options.Exemption.Quota
+	}
 
+	if quota == nil && p.tenants != nil {
+		if tenant := p.options.TenantFunction(req); tenant != "" {
+			quota = p.tenants.Get(tenant)
+		}
+	}
+
+	if quota == nil {
+		quota = p.classQuota(req)
 	}
+
+	return quota, cost, nil
 }
 
-// Set Rate Limit Headers helper function
-func setRateLimitHeaders(resp http.ResponseWriter, controller *controller, id string) {
+// Check if an access of the given cost is admitted, using the given quota
+// instead of the quota of the policy if it is not nil. Returns the controller
+// in charge and the key of the requester, or the event of the request if
+// access was not admitted and the response has been written
+func (p *policy) admit(resp http.ResponseWriter, req *http.Request, quota *Quota, cost uint64) (*controller, string, *Event) {
+	if !p.storeAvailable() {
+		return nil, "", p.bypass(resp, req)
+	}
+
+	controller, id, status := p.identify(req, quota)
+	switch status {
+	case identityMalformed:
+		p.scoreMalformed(req)
+		p.writeBody(resp, req, http.StatusBadRequest, malformedIdentityMessage, nil)
+		return nil, "", p.emit(EventRejected, req, nil, "")
+	case identityMissing:
+		p.writeBody(resp, req, http.StatusBadRequest, missingIdentityMessage, nil)
+		return nil, "", p.emit(EventRejected, req, nil, "")
+	case identityBypassed:
+		return nil, "", p.bypass(resp, req)
+	}
+
+	keys := controller.requesterKeys(id)
+	windows := p.windowKeys(controller, id)
+	identity := ""
+	if p.options.ReputationScore != nil {
+		identity = p.identityOf(controller, id)
+		keys = append(keys, p.scoreKey(identity))
+	}
+
+	total := ""
+	if controller.quota.Share > 0 {
+		total = makeKey(p.prefix, "total", p.options.KeyIdFunction(controller.quota))
+		keys = append(keys, total)
+	}
+
+	controller = p.retrying(controller, req).batched(keys...)
+	if total != "" {
+		controller = controller.shared(total)
+	}
+
+	if p.reputations != nil {
+		reputation := p.reputations.Get(identifyConnection(req))
+		if reputation.Deny {
+			p.ban(resp, req, controller, id)
+			return nil, "", p.emit(EventBanned, req, controller, id)
+		}
+		controller = controller.scaled(reputation.LimitFactor)
+	}
+
+	if p.options.ReputationScore != nil {
+		controller = controller.scaled(p.scoreFactor(controller, identity))
+	}
+
+	controller = p.softStart(controller)
+	if p.options.AdaptiveController != nil {
+		controller = controller.scaled(p.options.AdaptiveController.Factor())
+	}
+	controller = controller.granted(id).rolledOver(id)
+
+	if controller.IsBanned(id) {
+		if p.options.DeniedRetry == DeniedRetryExtendsLockout {
+			controller.ExtendBan(id)
+		}
+		p.ban(resp, req, controller, id)
+		return nil, "", p.emit(EventBanned, req, controller, id)
+	} else if until := controller.FrozenUntil(id); controller.now().Before(until) {
+		p.freeze(resp, req, controller, until)
+		return nil, "", p.emit(EventDenied, req, controller, id)
+	} else if denier, key := p.held(req, controller, id, windows, cost); denier != nil {
+		violations := controller.RegisterViolation(id)
+		if p.options.DeniedRetry == DeniedRetryExtendsLockout {
+			controller.ExtendLockout(id)
+		}
+		if p.options.ReputationScore != nil {
+			p.addScore(controller, identity, p.options.ReputationScore.denial())
+		}
+		if p.options.ChallengeHandler != nil && violations.Count > p.options.ChallengeAfter {
+			p.challenge(resp, req, controller, id)
+			return nil, "", p.emit(EventChallenged, req, controller, id)
+		}
+		p.deny(resp, req, denier, key)
+		return nil, "", p.emit(EventDenied, req, controller, id)
+	}
+
+	controller = controller.windowedWith(p.windows, windows)
+	if p.global != nil {
+		pool, ok := p.global.pool(req, cost)
+		if !ok {
+			p.deny(resp, req, pool.controller, pool.key)
+			return nil, "", p.emit(EventDenied, req, controller, id)
+		}
+		controller = controller.linkedWith(pool.controller, pool.key)
+	}
+
+	if p.options.ReputationScore != nil {
+		p.addScore(controller, identity, -p.options.ReputationScore.allowed())
+	}
+
+	return controller, id, nil
+}
+
+// Deny access, writes the access message and headers
+func (p *policy) deny(resp http.ResponseWriter, req *http.Request, controller *controller, id string) {
+	p.writeAccessMessage(resp, req, p.denyMessage, p.options.RetryAfter, controller, messageData(controller, id))
+}
+
+// Deny access to a frozen requester regardless of the access count, writes
+// the access message and headers
+func (p *policy) freeze(resp http.ResponseWriter, req *http.Request, controller *controller, until time.Time) {
+	p.writeAccessMessage(resp, req, p.denyMessage, p.options.RetryAfter, controller, &MessageData{
+		Limit:      controller.quota.capacity(),
+		RetryAfter: secondsUntil(until, controller.now()),
+		ResetAt:    until,
+	})
+}
+
+// Deny access with a challenge, writes the headers and hands the response
+// over to the challenge handler
+func (p *policy) challenge(resp http.ResponseWriter, req *http.Request, controller *controller, id string) {
+	p.setHeaders(resp, req, controller, id)
+	p.options.ChallengeHandler(resp, req)
+}
+
+// Deny access to a banned requester, writes the ban message and headers
+func (p *policy) ban(resp http.ResponseWriter, req *http.Request, controller *controller, id string) {
+	p.writeAccessMessage(resp, req, p.banMessage, p.options.BanRetryAfter, controller, messageData(controller, id))
+}
+
+// Write an access message with the policy and rate limit headers, and
+// optionally a Retry-After header
+func (p *policy) writeAccessMessage(resp http.ResponseWriter, req *http.Request, msg *accessMessage, retryAfter bool, controller *controller, data *MessageData) {
+	headers := resp.Header()
+	p.setPolicyHeader(headers, controller)
+	p.setRateLimitHeaders(headers, req, controller, data.Remaining, data.ResetAt)
+	if retryAfter {
+		headers[retryAfterHeader] = []string{strconv.FormatInt(data.RetryAfter, 10)}
+		if p.options.ResetFormat != ResetUnixSeconds {
+			headers[retryAfterMsHeader] = []string{strconv.FormatInt(millisecondsUntil(data.ResetAt, controller.now()), 10)}
+		}
+	}
+	p.delayDenial(req)
+	p.writeBody(resp, req, msg.StatusCode, msg.Render(data), data)
+}
+
+// Delay the response to a denied request by a random duration of up to the
+// DenialJitter option, or until the request is done
+func (p *policy) delayDenial(req *http.Request) {
+	if p.options.DenialJitter <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(time.Duration(p.options.Random.Int63n(int64(p.options.DenialJitter))))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-req.Context().Done():
+	}
+}
+
+// Write the given message formatted by the formatter as the body with the
+// status code, and the Content-Type and Content-Length headers. Responses to
+// HEAD requests carry the headers of the body without the body, and with the
+// OmitBody option no body is written at all
+func (p *policy) writeBody(resp http.ResponseWriter, req *http.Request, statusCode int, message string, data *MessageData) {
+	headers := resp.Header()
+	var body []byte
+	if !p.options.OmitBody {
+		body = p.options.Formatter.Format(message, data)
+		headers[contentTypeHeader] = []string{p.contentType()}
+	}
+	headers[contentLengthHeader] = []string{strconv.Itoa(len(body))}
+	resp.WriteHeader(statusCode)
+
+	if req.Method != "HEAD" {
+		resp.Write(body)
+	}
+}
+
+// Get the seconds from now until the given time, rounded up
+func secondsUntil(at time.Time, now time.Time) int64 {
+	wait := at.Sub(now)
+	seconds := int64(wait / time.Second)
+	if wait%time.Second > 0 {
+		seconds++
+	}
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	return seconds
+}
+
+// The format of the X-RateLimit-Reset header
+type ResetFormat int
+
+const (
+	// The Unix time of the reset in seconds
+	ResetUnixSeconds ResetFormat = iota
+	// The Unix time of the reset in milliseconds
+	ResetUnixMilliseconds
+	// The seconds until the reset with millisecond precision, e.g. "0.250"
+	ResetDeltaSeconds
+)
+
+// Get the milliseconds from now until the given time, rounded up
+func millisecondsUntil(at time.Time, now time.Time) int64 {
+	wait := at.Sub(now)
+	milliseconds := int64(wait / time.Millisecond)
+	if wait%time.Millisecond > 0 {
+		milliseconds++
+	}
+	if milliseconds < 0 {
+		milliseconds = 0
+	}
+
+	return milliseconds
+}
+
+// Format the given reset at the given time for the X-RateLimit-Reset header
+func (p *policy) formatReset(resetAt time.Time, now time.Time) string {
+	switch p.options.ResetFormat {
+	case ResetUnixMilliseconds:
+		return strconv.FormatInt(now.UnixNano()/int64(time.Millisecond)+millisecondsUntil(resetAt, now), 10)
+	case ResetDeltaSeconds:
+		return strconv.FormatFloat(float64(millisecondsUntil(resetAt, now))/1000, 'f', 3, 64)
+	default:
+		return strconv.FormatInt(resetAt.Unix(), 10)
+	}
+}
+
+// Set the policy and rate limit headers for the given controller and id
+func (p *policy) setHeaders(resp http.ResponseWriter, req *http.Request, controller *controller, id string) {
 	headers := resp.Header()
-	headers.Set("X-RateLimit-Limit", strconv.FormatUint(controller.quota.Limit, 10))
-	headers.Set("X-RateLimit-Reset", strconv.FormatInt(controller.RetryAt(id).Unix(), 10))
-	headers.Set("X-RateLimit-Remaining", strconv.FormatUint(controller.RemainingLimit(id), 10))
+	strictest, remaining, retryAt := controller.strictest(id)
+	p.setPolicyHeader(headers, strictest)
+	p.setRateLimitHeaders(headers, req, strictest, remaining, retryAt)
 }
 
-// The default identifier function. Identifies a client by IP
-func defaultIdentify(req *http.Request) string {
-	if forwardedFor := req.Header.Get(forwardedForHeader); forwardedFor != "" {
-		if ipParsed := net.ParseIP(forwardedFor); ipParsed != nil {
-			return ipParsed.String()
+// Set the X-RateLimit-Policy header to the name of the policy, or the name
+// of the quota of the controller if the policy has no name
+func (p *policy) setPolicyHeader(headers http.Header, controller *controller) {
+	name := p.options.PolicyName
+	if name == "" {
+		name = controller.quota.Name
+	}
+
+	if name != "" {
+		headers[policyHeader] = []string{name}
+	}
+}
+
+// Set the rate limit headers to the given remaining limit and reset of the
+// controller, or to the limit of the upstream gateway if less of it remains.
+// Quotas only counting accesses have no remaining limit
+func (p *policy) setRateLimitHeaders(headers http.Header, req *http.Request, controller *controller, remaining uint64, resetAt time.Time) {
+	limit := controller.limit
+	limited := !controller.quota.CountOnly()
+	if u := p.options.UpstreamHeaders; u != nil {
+		upstream, ok := u.read(req, controller.now())
+		if ok && (!limited || upstream.remaining < remaining || upstream.remaining == remaining && upstream.resetAt.After(resetAt)) {
+			limit = strconv.FormatUint(upstream.limit, 10)
+			remaining = upstream.remaining
+			resetAt = upstream.resetAt
+			limited = true
 		}
 	}
 
-	ip, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		panic(err.Error())
+	headers[limitHeader] = []string{limit}
+	headers[resetHeader] = []string{p.formatReset(resetAt, controller.now())}
+	if limited {
+		headers[remainingHeader] = []string{strconv.FormatUint(remaining, 10)}
 	}
-	return ip
 }
 
 // Make a key from various parts for use in the key value store
 func makeKey(parts ...string) string {
-	return strings.Join(parts, "_")
+	return strings.Join(parts, keySeparator)
+}
+
+// The prefix of all keys of a policy. In local scope, keys in a store shared
+// by multiple instances are namespaced by the instance id, in region scope
+// by the region
+func keyPrefix(o *Options) string {
+	if _, isMapStore := o.Store.(*MapStore); o.Scope == LocalScope && !isMapStore {
+		return makeKey(o.KeyPrefix, o.InstanceID)
+	} else if o.Scope == RegionScope && o.Region != "" && !isMapStore {
+		return makeKey(o.KeyPrefix, o.Region)
+	}
+
+	return o.KeyPrefix
+}
+
+// The default instance id, made of the hostname and process id
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return hostname + "-" + strconv.Itoa(os.Getpid())
 }
 
-// Creates new default options and assigns any given options
+// Creates new default options and assigns any given options, with a default
+// store when none is given
 func newOptions(options []*Options) *Options {
+	o := mergeOptions(options)
+	if o.Store == nil {
+		o.Store = defaultStores.add(o.Codec, o.Clock)
+	}
+
+	return o
+}
+
+// Creates new default options and assigns any given options, without a
+// default store, for options whose store is inherited or not used
+func mergeOptions(options []*Options) *Options {
 	o := Options{
 		StatusCode:             defaultStatusCode,
 		Message:                defaultMessage,
 		IdentificationFunction: defaultIdentify,
 		KeyPrefix:              defaultKeyPrefix,
+		KeyIdFunction:          HashedKeyId,
 		Store:                  nil,
 		Disabled:               defaultDisabled,
+		Scope:                  defaultScope,
+		InstanceID:             defaultInstanceID(),
+		ReputationTTL:          defaultReputationTTL,
+		ReputationTimeout:      defaultReputationTimeout,
+		TenantQuotaTTL:         defaultTenantQuotaTTL,
+		ViolationsTTL:          defaultViolationsTTL,
+		StorePingInterval:      defaultStorePingInterval,
+		MaxWait:                defaultMaxWait,
+		QueueDepth:             defaultQueueDepth,
+		Logger:                 log.New(os.Stdout, "[throttle] ", 0),
+		Codec:                  JSONCodec{},
+		Formatter:              PlainTextFormatter{},
+		Clock:                  systemClock{},
+		Random:                 systemRandom{},
 	}
 
 	// when all defaults, return it
 	if len(options) == 0 {
-		o.Store = NewMapStore(accessCount{})
 		return &o
 	}
 
@@ -312,11 +1775,97 @@ func newOptions(options []*Options) *Options {
 		}
 	}
 
-	if o.Store == nil {
-		o.Store = NewMapStore(accessCount{})
+	o.copyReferences()
+	return &o
+}
+
+// Replace the quotas, identifications, reservations and exemption referenced
+// by the options with copies, so later changes by the caller do not race
+// with requests
+func (o *Options) copyReferences() {
+	if o.IdentificationChain != nil {
+		chain := make([]*Identification, len(o.IdentificationChain))
+		for i, identification := range o.IdentificationChain {
+			copied := *identification
+			copied.Quota = copyQuota(identification.Quota)
+			chain[i] = &copied
+		}
+		o.IdentificationChain = chain
 	}
 
-	return &o
+	if o.Reservations != nil {
+		reservations := make([]*Reservation, len(o.Reservations))
+		for i, reservation := range o.Reservations {
+			copied := *reservation
+			reservations[i] = &copied
+		}
+		o.Reservations = reservations
+	}
+
+	if o.CardinalityGuard != nil {
+		cardinalityGuard := *o.CardinalityGuard
+		o.CardinalityGuard = &cardinalityGuard
+	}
+
+	if o.IdentityCompression != nil {
+		identityCompression := *o.IdentityCompression
+		o.IdentityCompression = &identityCompression
+	}
+
+	if o.DualStackMerge != nil {
+		dualStackMerge := *o.DualStackMerge
+		o.DualStackMerge = &dualStackMerge
+	}
+
+	if o.StoreRetry != nil {
+		storeRetry := *o.StoreRetry
+		o.StoreRetry = &storeRetry
+	}
+
+	if o.UpstreamHeaders != nil {
+		upstreamHeaders := *o.UpstreamHeaders
+		o.UpstreamHeaders = &upstreamHeaders
+	}
+
+	if o.Exemption != nil {
+		exemption := *o.Exemption
+		exemption.Secret = append([]byte{}, o.Exemption.Secret...)
+		exemption.Quota = copyQuota(o.Exemption.Quota)
+		o.Exemption = &exemption
+	}
+
+	if o.Classes != nil {
+		classes := make(map[string]*Quota, len(o.Classes))
+		for class, quota := range o.Classes {
+			classes[class] = copyQuota(quota)
+		}
+		o.Classes = classes
+	}
+
+	if o.ReputationScore != nil {
+		reputationScore := *o.ReputationScore
+		o.ReputationScore = &reputationScore
+	}
+
+	if o.Windows != nil {
+		windows := make([]*Quota, len(o.Windows))
+		for i, window := range o.Windows {
+			windows[i] = copyQuota(window)
+		}
+		o.Windows = windows
+	}
+
+	o.GlobalQuota = copyQuota(o.GlobalQuota)
+}
+
+// Return a copy of the given quota, or nil if the quota is nil
+func copyQuota(quota *Quota) *Quota {
+	if quota == nil {
+		return nil
+	}
+
+	copied := *quota
+	return &copied
 }
 
 // Check if an option is assigned
@@ -332,6 +1881,8 @@ func isNonEmptyOption(v reflect.Value) bool {
 		return v.Uint() != 0
 	case reflect.Float32, reflect.Float64:
 		return v.Float() != 0
+	case reflect.Slice, reflect.Map:
+		return v.Len() != 0
 	case reflect.Interface, reflect.Ptr, reflect.Func:
 		return !v.IsNil()
 	}
@@ -1,6 +1,10 @@
 package throttle
 
 import (
+	"bytes"
+	"context"
+	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -10,6 +14,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/go-martini/martini"
@@ -27,6 +32,12 @@ func expectSame(t *testing.T, a interface{}, b interface{}) {
 	}
 }
 
+func expectDifferent(t *testing.T, a interface{}, b interface{}) {
+	if a == b {
+		t.Errorf("Expected %T: %v to be different from %T: %v", b, b, a, a)
+	}
+}
+
 func expectEmpty(t *testing.T, a []string) {
 	if len(a) != 0 {
 		t.Errorf("Expected %T: %v to be empty", a, a)
@@ -56,14 +67,34 @@ func utcTimestamp() int64 {
 	return time.Now().Unix()
 }
 
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
 type Expectation struct {
 	StatusCode         int
 	Body               string
 	RateLimitLimit     string
 	RateLimitRemaining string
 	RateLimitReset     int64
+	RetryAfter         string
 	Wait               time.Duration
 	ForwardedFor       string
+	Headers            map[string]string
+	ResponseHeaders    map[string]string
 	Concurrent         bool
 }
 
@@ -109,6 +140,10 @@ func testResponseToExpectation(t *testing.T, m *martini.ClassicMartini, expectat
 		reflect.ValueOf(req).Elem().FieldByName("RemoteAddr").SetString("1.2.3.4:5000")
 	}
 
+	for name, value := range expectation.Headers {
+		req.Header.Set(name, value)
+	}
+
 	if err != nil {
 		t.Error(err)
 	}
@@ -135,10 +170,18 @@ func testResponseToExpectation(t *testing.T, m *martini.ClassicMartini, expectat
 		expectSame(t, rateLimitRemaining[0], expectation.RateLimitRemaining)
 	}
 
+	if expectation.RetryAfter != "" {
+		expectSame(t, header.Get("Retry-After"), expectation.RetryAfter)
+	}
+
+	for name, value := range expectation.ResponseHeaders {
+		expectSame(t, header.Get(name), value)
+	}
+
 	if expectation.RateLimitReset != 0 {
 		resetTime, err := strconv.ParseInt(rateLimitReset[0], 10, 64)
 		if err != nil {
-			t.Errorf(err.Error())
+			t.Error(err)
 		}
 		expectApproximateTimestamp(t, resetTime, expectation.RateLimitReset)
 	}
@@ -210,6 +253,19 @@ func TestTimeLimitWhenForwarded(t *testing.T) {
 	})
 }
 
+func TestTimeLimitWithRetryAfter(t *testing.T) {
+	m := setupMartiniWithPolicy(1, 1500*time.Millisecond, &Options{
+		RetryAfter: true,
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+		RetryAfter: "2",
+	})
+}
+
 func TestTimeLimitWithOptions(t *testing.T) {
 	m := setupMartiniWithPolicy(1, 10*time.Millisecond, &Options{
 		StatusCode: http.StatusBadRequest,
@@ -378,3 +434,516 @@ func TestRateLimitWithConcurrentRequests(t *testing.T) {
 		Wait:               20 * time.Millisecond,
 	})
 }
+
+func TestCostFunction(t *testing.T) {
+	m := setupMartiniWithPolicy(5, time.Hour, &Options{
+		CostFunction: func(req *http.Request) uint64 {
+			cost, _ := strconv.ParseUint(req.Header.Get("X-Cost"), 10, 64)
+			return cost
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "5",
+		RateLimitRemaining: "2",
+		Headers:            map[string]string{"X-Cost": "3"},
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitRemaining: "2",
+		Headers:            map[string]string{"X-Cost": "3"},
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitRemaining: "1",
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitRemaining: "1",
+		Headers:            map[string]string{"X-Cost": "6"},
+	})
+}
+
+func TestIdentificationChain(t *testing.T) {
+	m := setupMartiniWithPolicy(1, 20*time.Millisecond, &Options{
+		IdentificationChain: []*Identification{
+			&Identification{
+				Name: "key",
+				Function: func(req *http.Request) string {
+					return req.Header.Get("X-API-Key")
+				},
+				Quota: &Quota{
+					Limit:  2,
+					Within: 20 * time.Millisecond,
+				},
+			},
+		},
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "1",
+		Headers:            map[string]string{"X-API-Key": "secret"},
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "0",
+		Headers:            map[string]string{"X-API-Key": "secret"},
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "0",
+		Headers:            map[string]string{"X-API-Key": "secret"},
+	}, &Expectation{ // Anonymous requests fall back to the policy quota
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+	}, &Expectation{
+		StatusCode:         StatusTooManyRequests,
+		RateLimitLimit:     "1",
+		RateLimitRemaining: "0",
+	}, &Expectation{
+		StatusCode:         http.StatusOK,
+		RateLimitLimit:     "2",
+		RateLimitRemaining: "1",
+		Headers:            map[string]string{"X-API-Key": "other"},
+	})
+}
+
+func TestScope(t *testing.T) {
+	shared := NewChaosStore(NewMapStore(accessCount{}))
+	quota := &Quota{Limit: 1, Within: time.Minute}
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.RemoteAddr = "1.2.3.4:5000"
+
+	serve := func(policy func(http.ResponseWriter, *http.Request)) int {
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder.Code
+	}
+
+	localA := Policy(quota, &Options{Store: shared, Scope: LocalScope, InstanceID: "a"})
+	localB := Policy(quota, &Options{Store: shared, Scope: LocalScope, InstanceID: "b"})
+	expectStatusCode(t, http.StatusOK, serve(localA))
+	expectStatusCode(t, http.StatusOK, serve(localB))
+	expectStatusCode(t, StatusTooManyRequests, serve(localA))
+
+	globalA := Policy(quota, &Options{Store: shared, InstanceID: "a"})
+	globalB := Policy(quota, &Options{Store: shared, InstanceID: "b"})
+	expectStatusCode(t, http.StatusOK, serve(globalA))
+	expectStatusCode(t, StatusTooManyRequests, serve(globalB))
+}
+
+func TestRegionScope(t *testing.T) {
+	shared := NewChaosStore(NewMapStore(accessCount{}))
+	quota := &Quota{Limit: 1, Within: time.Minute}
+
+	euA := Policy(quota, &Options{Store: shared, Scope: RegionScope, Region: "eu", InstanceID: "a"})
+	euB := Policy(quota, &Options{Store: shared, Scope: RegionScope, Region: "eu", InstanceID: "b"})
+	us := Policy(quota, &Options{Store: shared, Scope: RegionScope, Region: "us"})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(euA, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(euB, "GET").Code)
+	expectStatusCode(t, http.StatusOK, serveMethod(us, "GET").Code)
+
+	// without a region, counters in region scope are global
+	global := Policy(quota, &Options{Store: shared})
+	noRegion := Policy(quota, &Options{Store: shared, Scope: RegionScope})
+	expectStatusCode(t, http.StatusOK, serveMethod(global, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(noRegion, "GET").Code)
+}
+
+func TestMessageTemplate(t *testing.T) {
+	m := setupMartiniWithPolicy(1, 1500*time.Millisecond, &Options{
+		Message: "Limit of {{.Limit}} exceeded, try again in {{.RetryAfter}} seconds",
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode: http.StatusOK,
+	}, &Expectation{
+		StatusCode: StatusTooManyRequests,
+		Body:       "Limit of 1 exceeded, try again in 2 seconds",
+	})
+}
+
+func TestPolicyHeader(t *testing.T) {
+	m := martini.Classic()
+	m.Use(Policy(&Quota{
+		Limit:  2,
+		Within: time.Hour,
+		Name:   "hourly",
+	}))
+	m.Use(Policy(&Quota{
+		Limit:  1,
+		Within: time.Minute,
+	}, &Options{
+		PolicyName: "burst",
+	}))
+	m.Any("/test", func() int {
+		return http.StatusOK
+	})
+
+	testResponses(t, m, &Expectation{
+		StatusCode:      http.StatusOK,
+		ResponseHeaders: map[string]string{"X-RateLimit-Policy": "burst"},
+	}, &Expectation{
+		StatusCode:      StatusTooManyRequests,
+		ResponseHeaders: map[string]string{"X-RateLimit-Policy": "burst"},
+	}, &Expectation{
+		StatusCode:      StatusTooManyRequests,
+		ResponseHeaders: map[string]string{"X-RateLimit-Policy": "hourly"},
+	})
+}
+
+func TestNoPolicyHeaderWithoutName(t *testing.T) {
+	m := setupMartiniWithPolicy(1, time.Hour)
+
+	testResponses(t, m, &Expectation{
+		StatusCode:      http.StatusOK,
+		ResponseHeaders: map[string]string{"X-RateLimit-Policy": ""},
+	})
+}
+
+type ttlStore struct {
+	*MapStore
+	ttl time.Duration
+}
+
+func (s *ttlStore) GetOrCreate(key string, initial []byte, ttl time.Duration) ([]byte, bool, error) {
+	s.ttl = ttl
+	return s.MapStore.GetOrCreate(key, initial, ttl)
+}
+
+func TestKeyTTL(t *testing.T) {
+	for _, e := range []struct {
+		keyTTL   time.Duration
+		expected time.Duration
+	}{
+		{0, time.Hour},
+		{2 * time.Hour, 2 * time.Hour},
+		{time.Minute, time.Hour},
+	} {
+		store := &ttlStore{MapStore: NewMapStore(accessCount{})}
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		Policy(&Quota{
+			Limit:  1,
+			Within: time.Hour,
+		}, &Options{
+			Store:  store,
+			KeyTTL: e.keyTTL,
+		})(httptest.NewRecorder(), req)
+
+		expectSame(t, store.ttl, e.expected)
+	}
+}
+
+type updaterStore struct {
+	*MapStore
+	sync.Mutex
+	updates int
+}
+
+func (s *updaterStore) Update(key string, update func([]byte) ([]byte, error), ttl time.Duration) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.updates++
+	existing, err := s.MapStore.Get(key)
+	if err != nil {
+		existing = nil
+	}
+	value, err := update(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, s.MapStore.Set(key, value)
+}
+
+func TestUpdaterStore(t *testing.T) {
+	for _, retry := range []*StoreRetry{nil, {}} {
+		store := &updaterStore{MapStore: NewMapStore(accessCount{})}
+		policy := Policy(&Quota{Limit: 2, Within: time.Hour}, &Options{
+			Store:      store,
+			StoreRetry: retry,
+		})
+
+		expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+		resp := serveMethod(policy, "GET")
+		expectStatusCode(t, http.StatusOK, resp.Code)
+		expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+		expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+		expectSame(t, store.updates, 2)
+	}
+}
+
+func TestCanonicalHeaders(t *testing.T) {
+	for _, header := range []string{limitHeader, resetHeader, remainingHeader, policyHeader, retryAfterHeader, contentTypeHeader, contentLengthHeader} {
+		expectSame(t, http.CanonicalHeaderKey(header), header)
+	}
+}
+
+func serveMethod(policy func(http.ResponseWriter, *http.Request), method string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+	recorder := httptest.NewRecorder()
+	policy(recorder, req)
+	return recorder
+}
+
+func TestDenialBody(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour})
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Body.String(), defaultMessage)
+	expectSame(t, resp.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	expectSame(t, resp.Header().Get("Content-Length"), strconv.Itoa(len(defaultMessage)))
+
+	// HEAD requests get the headers of the body without the body
+	resp = serveMethod(policy, "HEAD")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Body.String(), "")
+	expectSame(t, resp.Header().Get("Content-Length"), strconv.Itoa(len(defaultMessage)))
+}
+
+func TestOmitBody(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		OmitBody: true,
+	})
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	for _, method := range []string{"GET", "POST", "HEAD"} {
+		resp := serveMethod(policy, method)
+		expectStatusCode(t, StatusTooManyRequests, resp.Code)
+		expectSame(t, resp.Body.String(), "")
+		expectSame(t, resp.Header().Get("Content-Type"), "")
+		expectSame(t, resp.Header().Get("Content-Length"), "0")
+	}
+}
+
+func benchmarkPolicy(b *testing.B, options *Options) {
+	policy := Policy(&Quota{
+		Limit:  uint64(b.N) + 1,
+		Within: time.Hour,
+		Name:   "hourly",
+	}, options)
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		policy(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkPolicy(b *testing.B) {
+	benchmarkPolicy(b, &Options{})
+}
+
+func BenchmarkPolicyWithPenalty(b *testing.B) {
+	benchmarkPolicy(b, &Options{
+		PenaltyPolicy: &ExponentialPenalty{Ban: time.Second, MaxBan: time.Hour},
+	})
+}
+
+func BenchmarkPolicyParallel(b *testing.B) {
+	policy := Policy(&Quota{
+		Limit:  math.MaxUint64 - 1,
+		Within: time.Hour,
+	})
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		for pb.Next() {
+			policy(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+func BenchmarkSetHeaders(b *testing.B) {
+	p := newPolicy(&Quota{
+		Limit:  100,
+		Within: time.Hour,
+		Name:   "hourly",
+	}, newOptions(nil))
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.setHeaders(recorder, req, p.controller, "1.2.3.4")
+	}
+}
+
+func TestRepairCorrupt(t *testing.T) {
+	quota := &Quota{Limit: 2, Within: time.Hour}
+	key := makeKey(defaultKeyPrefix, HashedKeyId(quota), "1.2.3.4")
+	store := NewMapStore(nil)
+	var events []*Event
+	var logged bytes.Buffer
+	policy := Policy(quota, &Options{
+		Store:         store,
+		RepairCorrupt: true,
+		Logger:        log.New(&logged, "", 0),
+		OnEvent: func(e *Event) {
+			events = append(events, e)
+		},
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	store.Set(key, []byte("{\"count\":"))
+
+	// the corrupt count is replaced once, counting the request as the first
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "1")
+	expectSame(t, len(events), 3)
+	expectSame(t, events[1].Type, EventRepaired)
+	expectSame(t, events[1].Key, key)
+	expectSame(t, events[2].Type, EventAllowed)
+	expectMatches(t, "^Repairing corrupt access count "+key, logged.String())
+}
+
+func TestCorruptPanics(t *testing.T) {
+	quota := &Quota{Limit: 2, Within: time.Hour}
+	store := NewMapStore(nil)
+	store.Set(makeKey(defaultKeyPrefix, HashedKeyId(quota), "1.2.3.4"), []byte("{\"count\":"))
+	policy := Policy(quota, &Options{Store: store})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a corrupt access count to panic without repairs")
+		}
+	}()
+	serveMethod(policy, "GET")
+}
+
+func TestAccessCountClockRegression(t *testing.T) {
+	start := time.Unix(1000, 0)
+	a := &accessCount{1, start, time.Minute, nil, 0, 0, nil}
+
+	expectSame(t, a.IsFreshAt(start.Add(-30*time.Second)), true)
+	expectSame(t, a.IsFreshAt(start.Add(-time.Minute)), false)
+
+	// a small regression keeps the count and its window
+	a.IncrementBy(start.Add(-30*time.Second), 1)
+	expectSame(t, a.Start, start)
+	expectSame(t, a.Count, uint64(2))
+
+	// a large regression starts a new window
+	a.IncrementBy(start.Add(-time.Hour), 1)
+	expectSame(t, a.Start, start.Add(-time.Hour))
+	expectSame(t, a.Count, uint64(1))
+}
+
+func TestPolicyUnderClockRegression(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 1, Within: time.Minute}, &Options{
+		Clock:      clock,
+		RetryAfter: true,
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	// the count survives the clock going back, until the end of its window
+	clock.Advance(-30 * time.Second)
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("Retry-After"), "90")
+	clock.Advance(90 * time.Second)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	// the count is not fresh until the clock catches up after a long regression
+	clock.Advance(-time.Hour)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+}
+
+func TestResetFormat(t *testing.T) {
+	for format, reset := range map[ResetFormat]string{
+		ResetUnixSeconds:      "1000",
+		ResetUnixMilliseconds: "1000500",
+		ResetDeltaSeconds:     "0.250",
+	} {
+		clock := &fakeClock{now: time.Unix(1000, 0)}
+		policy := Policy(&Quota{Limit: 1, Within: 500 * time.Millisecond}, &Options{
+			Clock:       clock,
+			RetryAfter:  true,
+			ResetFormat: format,
+		})
+
+		expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+		clock.Advance(250 * time.Millisecond)
+		resp := serveMethod(policy, "GET")
+		expectStatusCode(t, StatusTooManyRequests, resp.Code)
+
+		expectSame(t, resp.Header().Get("X-RateLimit-Reset"), reset)
+		if format == ResetUnixSeconds {
+			expectSame(t, resp.Header().Get("Retry-After-Ms"), "")
+		} else {
+			expectSame(t, resp.Header().Get("Retry-After-Ms"), "250")
+		}
+	}
+}
+
+func TestMillisecondsUntil(t *testing.T) {
+	now := time.Unix(1000, 0)
+	expectSame(t, millisecondsUntil(now.Add(1500*time.Microsecond), now), int64(2))
+	expectSame(t, millisecondsUntil(now.Add(time.Second), now), int64(1000))
+	expectSame(t, millisecondsUntil(now.Add(-time.Second), now), int64(0))
+}
+
+func TestDenialJitter(t *testing.T) {
+	jitter := 50 * time.Millisecond
+	delay := time.Duration(NewSeededRandom(1).Int63n(int64(jitter)))
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store:        NewMapStore(nil),
+		DenialJitter: jitter,
+		Random:       NewSeededRandom(1),
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	start := time.Now()
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("Expected the denial to be delayed by %v, but it took %v", delay, elapsed)
+	}
+}
+
+func TestDenialJitterEndsWithRequest(t *testing.T) {
+	policy := Policy(&Quota{Limit: 1, Within: time.Hour}, &Options{
+		Store:        NewMapStore(nil),
+		DenialJitter: time.Hour,
+	})
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+	resp := httptest.NewRecorder()
+	policy(resp, req.WithContext(ctx))
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+}
+
+func TestInvalidMessageTemplate(t *testing.T) {
+	_, err := newAccessMessage(StatusTooManyRequests, "Try again at {{.ResetAt.Missing}}")
+	if _, ok := err.(ConfigError); !ok {
+		t.Errorf("Expected a ConfigError for a template failing to render, got %v", err)
+	}
+	_, err = newAccessMessage(StatusTooManyRequests, "Try again in {{.RetryAfter")
+	if _, ok := err.(ConfigError); !ok {
+		t.Errorf("Expected a ConfigError for a template failing to parse, got %v", err)
+	}
+
+	// templates failing on other data fall back to the text of the status
+	msg := &accessMessage{StatusTooManyRequests, "", template.Must(template.New("message").Parse("{{.Missing}}"))}
+	expectSame(t, msg.Render(&MessageData{}), "Too Many Requests")
+}
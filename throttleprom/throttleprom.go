@@ -0,0 +1,221 @@
+// Package throttleprom exposes the decisions of throttle policies as
+// Prometheus metrics, fed by the OnEvent hook of the policies:
+//
+//	metrics := throttleprom.New()
+//
+//	m.Use(throttle.Policy(quota, &throttle.Options{
+//		PolicyName: "api",
+//		Store:      store,
+//		OnEvent:    metrics.OnEvent("redis"),
+//	}))
+//	m.Get("/metrics", metrics.Handler().ServeHTTP)
+//
+// Metrics are labeled by policy name, decision and store backend only.
+// Identities are never used as labels, so the number of series is bounded
+// by the number of policies.
+package throttleprom
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/martini-contrib/throttle"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// The default namespace of the metrics
+	defaultNamespace = "throttle"
+	// The default maximum number of distinct policy labels
+	defaultMaxPolicies = 64
+	// The policy label of policies beyond the maximum number of policies
+	otherPolicy = "other"
+)
+
+// The default buckets of the remaining quota fraction, fine grained close to
+// zero to tell limits brushing zero apart from generous limits
+var defaultRemainingBuckets = []float64{0, 0.01, 0.05, 0.1, 0.25, 0.5, 0.75, 1}
+
+// The decisions of policies
+const (
+	// The request was allowed
+	DecisionAllow = "allow"
+	// The request was denied, banned, challenged, blocked or shed
+	DecisionDeny = "deny"
+	// The request bypassed the throttle
+	DecisionBypass = "bypass"
+	// The request was rejected, e.g. for a malformed identity
+	DecisionError = "error"
+)
+
+// The decisions of the event types
+var decisions = map[throttle.EventType]string{
+	throttle.EventAllowed:    DecisionAllow,
+	throttle.EventDenied:     DecisionDeny,
+	throttle.EventBanned:     DecisionDeny,
+	throttle.EventChallenged: DecisionDeny,
+	throttle.EventRejected:   DecisionError,
+	throttle.EventBypassed:   DecisionBypass,
+	throttle.EventBlocked:    DecisionDeny,
+	throttle.EventShed:       DecisionDeny,
+}
+
+// Metrics count the decisions of throttle policies
+type Metrics struct {
+	*sync.Mutex
+	options   *Options
+	requests  *prometheus.CounterVec
+	remaining *prometheus.HistogramVec
+	repairs   *prometheus.CounterVec
+	exceeded  *prometheus.CounterVec
+	policies  map[string]bool
+}
+
+type Options struct {
+	// The namespace of the metrics
+	// defaults to "throttle"
+	Namespace string
+
+	// The registry to register the metrics with and to serve them from
+	// defaults to the default prometheus registry
+	Registry *prometheus.Registry
+
+	// The maximum number of distinct policy names used as labels, further
+	// policies are counted as "other"
+	// defaults to 64
+	MaxPolicies int
+
+	// The buckets of the histogram of the remaining quota fraction
+	// defaults to buckets from 0 to 1, fine grained close to zero
+	RemainingBuckets []float64
+}
+
+// Return the hook counting the events of a policy, to use as the OnEvent
+// option. The store is the name of the store backend of the policy, e.g.
+// "redis"
+func (m *Metrics) OnEvent(store string) func(*throttle.Event) {
+	return func(e *throttle.Event) {
+		policy := m.policy(e.Policy)
+		if e.Type == throttle.EventRepaired {
+			m.repairs.WithLabelValues(policy, store).Inc()
+			return
+		}
+		if e.Type == throttle.EventCardinalityExceeded {
+			m.exceeded.WithLabelValues(policy).Inc()
+			return
+		}
+
+		m.requests.WithLabelValues(policy, decisions[e.Type], e.Type.String(), store).Inc()
+		if e.Limit > 0 {
+			m.remaining.WithLabelValues(policy).Observe(float64(e.Remaining) / float64(e.Limit))
+		}
+	}
+}
+
+// Return a handler serving the metrics of the registry, e.g. on /metrics
+func (m *Metrics) Handler() http.Handler {
+	if m.options.Registry == nil {
+		return promhttp.Handler()
+	}
+
+	return promhttp.HandlerFor(m.options.Registry, promhttp.HandlerOpts{})
+}
+
+// Return the policy label for the given policy name, bounding the number of
+// distinct labels
+func (m *Metrics) policy(name string) string {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.policies[name] {
+		return name
+	}
+
+	if len(m.policies) >= m.options.MaxPolicies {
+		return otherPolicy
+	}
+
+	m.policies[name] = true
+	return name
+}
+
+// Return new metrics registered with the registry of the options. Panics if
+// the metrics are already registered
+func New(options ...*Options) *Metrics {
+	o := newOptions(options)
+
+	m := &Metrics{
+		&sync.Mutex{},
+		o,
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.Namespace,
+			Name:      "requests_total",
+			Help:      "The number of requests seen by throttle policies, by policy, decision, event and store backend.",
+		}, []string{"policy", "decision", "event", "store"}),
+		prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.Namespace,
+			Name:      "remaining_ratio",
+			Help:      "The fraction of the quota remaining to the requester after each decision of throttle policies, by policy.",
+			Buckets:   o.RemainingBuckets,
+		}, []string{"policy"}),
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.Namespace,
+			Name:      "repairs_total",
+			Help:      "The number of corrupt access counts replaced by throttle policies, by policy and store backend.",
+		}, []string{"policy", "store"}),
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.Namespace,
+			Name:      "cardinality_exceeded_total",
+			Help:      "The number of time windows in which the distinct identities of throttle policies exceeded the threshold of their cardinality guard, by policy.",
+		}, []string{"policy"}),
+		make(map[string]bool),
+	}
+
+	m.register(m.requests)
+	m.register(m.remaining)
+	m.register(m.repairs)
+	m.register(m.exceeded)
+
+	return m
+}
+
+// Register the given collector with the registry of the options
+func (m *Metrics) register(collector prometheus.Collector) {
+	if m.options.Registry == nil {
+		prometheus.MustRegister(collector)
+	} else {
+		m.options.Registry.MustRegister(collector)
+	}
+}
+
+// Returns new options from defaults and given options
+func newOptions(options []*Options) *Options {
+	o := &Options{
+		Namespace:        defaultNamespace,
+		MaxPolicies:      defaultMaxPolicies,
+		RemainingBuckets: defaultRemainingBuckets,
+	}
+
+	if len(options) == 0 {
+		return o
+	}
+
+	if options[0].Namespace != "" {
+		o.Namespace = options[0].Namespace
+	}
+
+	if options[0].Registry != nil {
+		o.Registry = options[0].Registry
+	}
+
+	if options[0].MaxPolicies != 0 {
+		o.MaxPolicies = options[0].MaxPolicies
+	}
+
+	if len(options[0].RemainingBuckets) != 0 {
+		o.RemainingBuckets = options[0].RemainingBuckets
+	}
+
+	return o
+}
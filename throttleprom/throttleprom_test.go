@@ -0,0 +1,149 @@
+package throttleprom
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martini-contrib/throttle"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func serve(policy func(http.ResponseWriter, *http.Request), remoteAddr string) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	policy(httptest.NewRecorder(), req)
+}
+
+func TestDecisions(t *testing.T) {
+	metrics := New(&Options{Registry: prometheus.NewRegistry()})
+	policy := throttle.Policy(&throttle.Quota{
+		Limit:  1,
+		Within: time.Hour,
+	}, &throttle.Options{
+		PolicyName: "api",
+		OnEvent:    metrics.OnEvent("map"),
+	})
+
+	serve(policy, "1.2.3.4")
+	serve(policy, "1.2.3.4")
+	serve(policy, "1.2.3.4")
+
+	if count := testutil.ToFloat64(metrics.requests.WithLabelValues("api", DecisionAllow, "allowed", "map")); count != 1 {
+		t.Errorf("Expected 1 allowed request, but got %v", count)
+	}
+
+	if count := testutil.ToFloat64(metrics.requests.WithLabelValues("api", DecisionDeny, "denied", "map")); count != 2 {
+		t.Errorf("Expected 2 denied requests, but got %v", count)
+	}
+}
+
+func TestShedDecisions(t *testing.T) {
+	metrics := New(&Options{Registry: prometheus.NewRegistry()})
+	policy := throttle.LoadSheddingPolicy(&throttle.LoadShedding{
+		Limits: []*throttle.LoadLimit{{Sampler: throttle.GoroutineSampler, Max: 0}},
+	}, &throttle.Options{
+		PolicyName: "api",
+		OnEvent:    metrics.OnEvent("map"),
+	})
+
+	serve(policy, "1.2.3.4")
+
+	if count := testutil.ToFloat64(metrics.requests.WithLabelValues("api", DecisionDeny, "shed", "map")); count != 1 {
+		t.Errorf("Expected 1 shed request, but got %v", count)
+	}
+}
+
+func TestMaxPolicies(t *testing.T) {
+	metrics := New(&Options{
+		Registry:    prometheus.NewRegistry(),
+		MaxPolicies: 1,
+	})
+
+	expectPolicy := func(name string, expected string) {
+		if policy := metrics.policy(name); policy != expected {
+			t.Errorf("Expected policy label %s, but got %s", expected, policy)
+		}
+	}
+
+	expectPolicy("first", "first")
+	expectPolicy("second", otherPolicy)
+	expectPolicy("first", "first")
+}
+
+func TestHandler(t *testing.T) {
+	metrics := New(&Options{Registry: prometheus.NewRegistry()})
+	metrics.OnEvent("redis")(&throttle.Event{Type: throttle.EventBypassed, Policy: "api"})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	metrics.Handler().ServeHTTP(recorder, req)
+
+	expected := `throttle_requests_total{decision="bypass",event="bypassed",policy="api",store="redis"} 1`
+	if !strings.Contains(recorder.Body.String(), expected) {
+		t.Errorf("Expected the metrics to contain %s, but got %s", expected, recorder.Body.String())
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	metrics := New(&Options{Registry: prometheus.NewRegistry()})
+	policy := throttle.Policy(&throttle.Quota{
+		Limit:  4,
+		Within: time.Hour,
+	}, &throttle.Options{
+		PolicyName: "api",
+		OnEvent:    metrics.OnEvent("map"),
+	})
+
+	serve(policy, "1.2.3.4")
+	serve(policy, "1.2.3.4")
+	metrics.OnEvent("map")(&throttle.Event{Type: throttle.EventBypassed, Policy: "api"})
+
+	expected := `
+# HELP throttle_remaining_ratio The fraction of the quota remaining to the requester after each decision of throttle policies, by policy.
+# TYPE throttle_remaining_ratio histogram
+throttle_remaining_ratio_bucket{policy="api",le="0"} 0
+throttle_remaining_ratio_bucket{policy="api",le="0.01"} 0
+throttle_remaining_ratio_bucket{policy="api",le="0.05"} 0
+throttle_remaining_ratio_bucket{policy="api",le="0.1"} 0
+throttle_remaining_ratio_bucket{policy="api",le="0.25"} 0
+throttle_remaining_ratio_bucket{policy="api",le="0.5"} 1
+throttle_remaining_ratio_bucket{policy="api",le="0.75"} 2
+throttle_remaining_ratio_bucket{policy="api",le="1"} 2
+throttle_remaining_ratio_bucket{policy="api",le="+Inf"} 2
+throttle_remaining_ratio_sum{policy="api"} 1.25
+throttle_remaining_ratio_count{policy="api"} 2
+`
+	if err := testutil.CollectAndCompare(metrics.remaining, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRepairs(t *testing.T) {
+	metrics := New(&Options{Registry: prometheus.NewRegistry()})
+	metrics.OnEvent("redis")(&throttle.Event{Type: throttle.EventRepaired, Policy: "api"})
+
+	if count := testutil.ToFloat64(metrics.repairs.WithLabelValues("api", "redis")); count != 1 {
+		t.Errorf("Expected 1 repair, but got %v", count)
+	}
+
+	if count := testutil.CollectAndCount(metrics.requests); count != 0 {
+		t.Errorf("Expected repairs not to count as requests, but got %v", count)
+	}
+}
+
+func TestCardinalityExceeded(t *testing.T) {
+	metrics := New(&Options{Registry: prometheus.NewRegistry()})
+	metrics.OnEvent("redis")(&throttle.Event{Type: throttle.EventCardinalityExceeded, Policy: "api"})
+
+	if count := testutil.ToFloat64(metrics.exceeded.WithLabelValues("api")); count != 1 {
+		t.Errorf("Expected 1 exceeded window, but got %v", count)
+	}
+
+	if count := testutil.CollectAndCount(metrics.requests); count != 0 {
+		t.Errorf("Expected exceeded windows not to count as requests, but got %v", count)
+	}
+}
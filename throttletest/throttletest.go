@@ -0,0 +1,199 @@
+// Package throttletest provides utilities to test throttle configurations
+// without waiting for real time to pass.
+//
+// A policy under test uses a fake Clock and an inspectable Store:
+//
+//	clock := throttletest.NewClock(time.Now())
+//	store := throttletest.NewStore()
+//	policy := throttle.Policy(&throttle.Quota{
+//		Limit:  1,
+//		Within: time.Minute,
+//	}, &throttle.Options{
+//		Clock: clock,
+//		Store: store,
+//	})
+//
+//	req := throttletest.NewRequest("1.2.3.4")
+//	throttletest.AssertAllowed(t, policy, req)
+//	throttletest.AssertDenied(t, policy, req)
+//	clock.Advance(time.Minute)
+//	throttletest.AssertAllowed(t, policy, req)
+package throttletest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A Clock is a fake clock which only moves when told to
+type Clock struct {
+	*sync.Mutex
+	now time.Time
+}
+
+// Return the current time of the clock
+func (c *Clock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+// Move the clock forward by the given duration
+func (c *Clock) Advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	c.Unlock()
+}
+
+// Set the clock to the given time
+func (c *Clock) Set(now time.Time) {
+	c.Lock()
+	c.now = now
+	c.Unlock()
+}
+
+// Returns a fake clock set to the given time
+func NewClock(now time.Time) *Clock {
+	return &Clock{
+		&sync.Mutex{},
+		now,
+	}
+}
+
+// Error Type for the store
+type StoreError string
+
+// The Error for the Store
+func (err StoreError) Error() string {
+	return "Throttle Test Store Error: " + string(err)
+}
+
+// A Store is an in-memory key value store which allows to inspect its contents.
+// Unlike the throttle.MapStore, it never cleans up
+type Store struct {
+	*sync.RWMutex
+	data map[string][]byte
+}
+
+// Set a key
+func (s *Store) Set(key string, value []byte) error {
+	s.Lock()
+	s.data[key] = value
+	s.Unlock()
+
+	return nil
+}
+
+// Get a key, will return an error if the key does not exist
+func (s *Store) Get(key string) ([]byte, error) {
+	s.RLock()
+	value, ok := s.data[key]
+	s.RUnlock()
+	if !ok {
+		return nil, StoreError("Key " + key + " does not exist")
+	}
+
+	return value, nil
+}
+
+// Return the keys in the store, sorted
+func (s *Store) Keys() []string {
+	s.RLock()
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+	s.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Return the number of keys in the store
+func (s *Store) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.data)
+}
+
+// Remove all keys from the store
+func (s *Store) Reset() {
+	s.Lock()
+	s.data = make(map[string][]byte)
+	s.Unlock()
+}
+
+// Returns an empty store
+func NewStore() *Store {
+	return &Store{
+		&sync.RWMutex{},
+		make(map[string][]byte),
+	}
+}
+
+// Returns a GET request to "/" from the given IP
+func NewRequest(ip string) *http.Request {
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		panic(err.Error())
+	}
+	req.RemoteAddr = ip + ":5000"
+
+	return req
+}
+
+// Serve the request with the given handler and return the recorded response
+func Do(handler func(http.ResponseWriter, *http.Request), req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+
+	return recorder
+}
+
+// Check if the recorded response denies access
+func Denied(recorder *httptest.ResponseRecorder) bool {
+	return recorder.Code != http.StatusOK
+}
+
+// Assert the handler allows access to the request
+func AssertAllowed(t testing.TB, handler func(http.ResponseWriter, *http.Request), req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := Do(handler, req)
+	if Denied(recorder) {
+		t.Errorf("Expected access to be allowed, but was denied with status code %d", recorder.Code)
+	}
+
+	return recorder
+}
+
+// Assert the handler denies access to the request
+func AssertDenied(t testing.TB, handler func(http.ResponseWriter, *http.Request), req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := Do(handler, req)
+	if !Denied(recorder) {
+		t.Errorf("Expected access to be denied, but was allowed")
+	}
+
+	return recorder
+}
+
+// Assert the handler responds to the request with the given remaining limit
+func AssertRemaining(t testing.TB, handler func(http.ResponseWriter, *http.Request), req *http.Request, remaining uint64) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := Do(handler, req)
+	if actual := recorder.Header().Get("X-RateLimit-Remaining"); actual != strconv.FormatUint(remaining, 10) {
+		t.Errorf("Expected %d requests to be remaining, but was %q", remaining, actual)
+	}
+
+	return recorder
+}
@@ -0,0 +1,61 @@
+package throttletest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/martini-contrib/throttle"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	clock.Advance(time.Minute)
+
+	if !clock.Now().Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the clock to be at %v, but was at %v", start.Add(time.Minute), clock.Now())
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore()
+	store.Set("B", []byte("2"))
+	store.Set("A", []byte("1"))
+
+	if keys := store.Keys(); len(keys) != 2 || keys[0] != "A" || keys[1] != "B" {
+		t.Errorf("Expected keys [A B], but got %v", keys)
+	}
+
+	store.Reset()
+	if store.Len() != 0 {
+		t.Errorf("Expected the store to be empty, but had %d keys", store.Len())
+	}
+
+	if _, err := store.Get("A"); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+}
+
+func TestPolicyWithFakeClock(t *testing.T) {
+	clock := NewClock(time.Now())
+	store := NewStore()
+	policy := throttle.Policy(&throttle.Quota{
+		Limit:  2,
+		Within: time.Hour,
+	}, &throttle.Options{
+		Clock: clock,
+		Store: store,
+	})
+
+	req := NewRequest("1.2.3.4")
+	AssertRemaining(t, policy, req, 1)
+	AssertAllowed(t, policy, req)
+	AssertDenied(t, policy, req)
+
+	clock.Advance(time.Hour)
+	AssertRemaining(t, policy, req, 1)
+
+	if store.Len() != 1 {
+		t.Errorf("Expected one key in the store, but got %v", store.Keys())
+	}
+}
@@ -0,0 +1,100 @@
+package throttle
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpstreamHeaders are the rate limit headers an upstream gateway, e.g. Kong,
+// adds to the requests it forwards. The policy enforces its own limit only,
+// as the gateway already enforces its limit, and sends the headers of
+// whichever limit has less remaining, so clients see one coherent limit
+type UpstreamHeaders struct {
+	// The header with the limit of the gateway
+	// defaults to "X-RateLimit-Limit"
+	Limit string
+
+	// The header with the remaining limit of the gateway
+	// defaults to "X-RateLimit-Remaining"
+	Remaining string
+
+	// The header with the reset of the limit of the gateway
+	// defaults to "X-RateLimit-Reset"
+	Reset string
+
+	// If the reset header holds the seconds until the reset, as the
+	// RateLimit-Reset header of the IETF draft, instead of a Unix timestamp
+	// defaults to false
+	ResetSeconds bool
+}
+
+// The limit of the gateway as told by its headers
+type upstreamLimit struct {
+	limit     uint64
+	remaining uint64
+	resetAt   time.Time
+}
+
+// The header with the limit
+func (u *UpstreamHeaders) limitHeader() string {
+	if u.Limit == "" {
+		return limitHeader
+	}
+
+	return u.Limit
+}
+
+// The header with the remaining limit
+func (u *UpstreamHeaders) remainingHeader() string {
+	if u.Remaining == "" {
+		return remainingHeader
+	}
+
+	return u.Remaining
+}
+
+// The header with the reset
+func (u *UpstreamHeaders) resetHeader() string {
+	if u.Reset == "" {
+		return resetHeader
+	}
+
+	return u.Reset
+}
+
+// Read the limit of the gateway from the headers of the request at the given
+// time. Returns false if a header is missing or malformed
+func (u *UpstreamHeaders) read(req *http.Request, now time.Time) (*upstreamLimit, bool) {
+	limit, ok := upstreamValue(req.Header.Get(u.limitHeader()))
+	if !ok {
+		return nil, false
+	}
+	remaining, ok := upstreamValue(req.Header.Get(u.remainingHeader()))
+	if !ok {
+		return nil, false
+	}
+	reset, ok := upstreamValue(req.Header.Get(u.resetHeader()))
+	if !ok {
+		return nil, false
+	}
+
+	resetAt := time.Unix(int64(reset), 0)
+	if u.ResetSeconds {
+		resetAt = now.Add(time.Duration(reset) * time.Second)
+	}
+
+	return &upstreamLimit{limit, remaining, resetAt}, true
+}
+
+// Parse the first value of a rate limit header, ignoring further limits and
+// parameters, e.g. "100" of "100, 1000;w=3600"
+func upstreamValue(header string) (uint64, bool) {
+	if i := strings.IndexAny(header, ",;"); i != -1 {
+		header = header[:i]
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(header), 10, 64)
+	return value, err == nil
+}
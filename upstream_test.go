@@ -0,0 +1,91 @@
+package throttle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUpstreamValue(t *testing.T) {
+	for header, expected := range map[string]uint64{"100": 100, " 7 ": 7, "100, 1000;w=3600": 100, "10;w=60": 10} {
+		value, ok := upstreamValue(header)
+		expectSame(t, ok, true)
+		expectSame(t, value, expected)
+	}
+
+	for _, header := range []string{"", "-1", "many"} {
+		_, ok := upstreamValue(header)
+		expectSame(t, ok, false)
+	}
+}
+
+func TestUpstreamHeaders(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 5, Within: time.Minute}, &Options{
+		Clock:           clock,
+		UpstreamHeaders: &UpstreamHeaders{},
+	})
+	serve := func(remaining string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "1.2.3.4"
+		req.Header.Set("X-RateLimit-Limit", "1000")
+		req.Header.Set("X-RateLimit-Remaining", remaining)
+		req.Header.Set("X-RateLimit-Reset", "4600")
+		recorder := httptest.NewRecorder()
+		policy(recorder, req)
+		return recorder
+	}
+
+	// the local limit has less remaining
+	resp := serve("500")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "5")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "4")
+	expectSame(t, resp.Header().Get("X-RateLimit-Reset"), "1060")
+
+	// the gateway has less remaining, its headers are kept
+	resp = serve("2")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "1000")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "2")
+	expectSame(t, resp.Header().Get("X-RateLimit-Reset"), "4600")
+
+	// only the local limit is enforced
+	serve("0")
+	serve("0")
+	expectStatusCode(t, http.StatusOK, serve("0").Code)
+	resp = serve("400")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "5")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+
+	// malformed headers of the gateway are ignored
+	resp = serve("unknown")
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "5")
+}
+
+func TestUpstreamHeadersWithResetSeconds(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 5, Within: time.Minute}, &Options{
+		Clock: clock,
+		UpstreamHeaders: &UpstreamHeaders{
+			Limit:        "RateLimit-Limit",
+			Remaining:    "RateLimit-Remaining",
+			Reset:        "RateLimit-Reset",
+			ResetSeconds: true,
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4"
+	req.Header.Set("RateLimit-Limit", "100, 100;w=3600")
+	req.Header.Set("RateLimit-Remaining", "1")
+	req.Header.Set("RateLimit-Reset", "30")
+	recorder := httptest.NewRecorder()
+	policy(recorder, req)
+
+	expectSame(t, recorder.Header().Get("X-RateLimit-Limit"), "100")
+	expectSame(t, recorder.Header().Get("X-RateLimit-Remaining"), "1")
+	expectSame(t, recorder.Header().Get("X-RateLimit-Reset"), "1030")
+}
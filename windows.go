@@ -0,0 +1,99 @@
+package throttle
+
+import "time"
+
+// Return the controllers of the windows of the given options
+func newWindows(o *Options) []*controller {
+	windows := make([]*controller, len(o.Windows))
+	for i, window := range o.Windows {
+		windows[i] = newController(window, o)
+	}
+
+	return windows
+}
+
+// Get the keys of the requester with the given key of the given controller
+// for the windows of the policy
+func (p *policy) windowKeys(controller *controller, id string) []string {
+	if len(p.windows) == 0 {
+		return nil
+	}
+
+	identity := p.identityOf(controller, id)
+	keys := make([]string, len(p.windows))
+	for i, window := range p.windows {
+		keys[i] = makeKey(p.prefix, p.options.KeyIdFunction(window.quota), identity)
+	}
+
+	return keys
+}
+
+// Get the controller and key of the quota denying an access of the given
+// cost, of the given controller and key or of the windows with the given
+// keys. Of several quotas denying access, the one resetting last is the
+// strictest. Returns a nil controller if access is not denied
+func (p *policy) deniedBy(c *controller, id string, keys []string, cost uint64) (*controller, string) {
+	var denier *controller
+	key := ""
+	if p.deniesAccess(c, id, cost) {
+		denier, key = c, id
+	}
+
+	retryAt := time.Time{}
+	for i, window := range p.windows {
+		if window.quota.CountOnly() {
+			continue
+		}
+		if window = window.rolledOver(keys[i]); !window.DeniesAccess(keys[i], cost) {
+			continue
+		}
+
+		if denier != nil && retryAt.IsZero() {
+			retryAt = denier.RetryAt(key)
+		}
+		if at := window.RetryAt(keys[i]); denier == nil || at.After(retryAt) {
+			denier, key, retryAt = window, keys[i], at
+		}
+	}
+
+	return denier, key
+}
+
+// Return a copy of the controller incrementing the counts of the windows
+// with the given keys together with the access count of the requester, and
+// considering them for the rate limit headers
+func (c *controller) windowedWith(windows []*controller, keys []string) *controller {
+	if len(windows) == 0 {
+		return c
+	}
+
+	windowed := *c
+	windowed.linked = append([]linkedCount{}, c.linked...)
+	for i, window := range windows {
+		windowed.linked = append(windowed.linked, linkedCount{window, keys[i], true})
+	}
+
+	return &windowed
+}
+
+// Get the controller of the strictest quota for the rate limit headers of
+// the given key, the quota or window with the least remaining limit, and of
+// those the one resetting last. Returns the remaining limit and reset of the
+// strictest quota as well
+func (c *controller) strictest(id string) (*controller, uint64, time.Time) {
+	strictest := c
+	remaining, retryAt := c.limits(id)
+	for _, l := range c.linked {
+		if !l.window || l.controller.quota.CountOnly() {
+			continue
+		}
+
+		window := l.controller.rolledOver(l.key)
+		r, at := window.limits(l.key)
+		if r < remaining || r == remaining && at.After(retryAt) {
+			strictest, remaining, retryAt = window, r, at
+		}
+	}
+
+	return strictest, remaining, retryAt
+}
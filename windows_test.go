@@ -0,0 +1,77 @@
+package throttle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWindows(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 2, Within: time.Second, Name: "second"}, &Options{
+		Clock:   clock,
+		Windows: []*Quota{{Limit: 3, Within: time.Hour, Name: "hour"}},
+	})
+
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "2")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "1")
+	expectSame(t, resp.Header().Get("X-RateLimit-Policy"), "second")
+
+	// the hourly window is the strictest once less of it remains
+	clock.Advance(time.Second)
+	resp = serveMethod(policy, "GET")
+	expectStatusCode(t, http.StatusOK, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "3")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "1")
+	expectSame(t, resp.Header().Get("X-RateLimit-Policy"), "hour")
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	resp = serveMethod(policy, "GET")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Limit"), "3")
+	expectSame(t, resp.Header().Get("X-RateLimit-Remaining"), "0")
+	expectSame(t, resp.Header().Get("X-RateLimit-Policy"), "hour")
+
+	// the window denies access after the quota of the policy resets
+	clock.Advance(time.Second)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(policy, "GET").Code)
+	clock.Advance(time.Hour)
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+}
+
+func TestWindowsDeniedByQuota(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	policy := Policy(&Quota{Limit: 1, Within: time.Second, Name: "burst"}, &Options{
+		Clock:   clock,
+		Windows: []*Quota{{Limit: 10, Within: time.Hour, Name: "hourly"}},
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(policy, "GET").Code)
+	resp := serveMethod(policy, "GET")
+	expectStatusCode(t, StatusTooManyRequests, resp.Code)
+	expectSame(t, resp.Header().Get("X-RateLimit-Policy"), "burst")
+
+	// denied requests are not counted by the windows
+	clock.Advance(time.Second)
+	expectSame(t, serveMethod(policy, "GET").Header().Get("X-RateLimit-Remaining"), "0")
+}
+
+func TestWindowsReset(t *testing.T) {
+	store := NewMapStore(nil)
+	limiter := NewLimiter(&Quota{Limit: 5, Within: time.Second, Name: "reset"}, &Options{
+		Store:   store,
+		Windows: []*Quota{{Limit: 1, Within: time.Hour, Name: "reset-hour"}},
+	})
+
+	expectStatusCode(t, http.StatusOK, serveMethod(limiter.ServeHTTP, "GET").Code)
+	expectStatusCode(t, StatusTooManyRequests, serveMethod(limiter.ServeHTTP, "GET").Code)
+	limiter.Reset("1.2.3.4")
+	expectStatusCode(t, http.StatusOK, serveMethod(limiter.ServeHTTP, "GET").Code)
+
+	schema := limiter.KeySchema()
+	if _, err := store.Get(schema.Key("window:" + HashedKeyId(&Quota{Limit: 1, Within: time.Hour, Name: "reset-hour"})).Key("1.2.3.4")); err != nil {
+		t.Errorf("Expected the count of the window in the store")
+	}
+}